@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2016 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"pkg.deepin.io/lib/pulse"
+)
+
+const (
+	// the applications not seen for appConfigMaxAge are forgotten, and at most
+	// appConfigMaxCount applications are remembered
+	appConfigMaxAge   = 90 * 24 * time.Hour
+	appConfigMaxCount = 100
+
+	sinkInputSaveDelay = 5 * time.Second
+)
+
+// appConfig is the stream state remembered for an application
+type appConfig struct {
+	Volume float64
+	Mute   bool
+	// name of the preferred sink, empty means the default sink
+	Sink string
+	// unix time of the day the application is last seen playing, in days so
+	// that the config is not changed by every save
+	Seen int64
+}
+
+func (ac *appConfig) equal(b *appConfig) bool {
+	if ac == nil || b == nil {
+		return ac == b
+	}
+	return *ac == *b
+}
+
+func appConfigsEqual(a, b map[string]*appConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !v.equal(w) {
+			return false
+		}
+	}
+	return true
+}
+
+// getSinkInputAppKey returns the key of the application in config.Apps
+func getSinkInputAppKey(props map[string]string) string {
+	if bin := props[PropAppProcessBinary]; bin != "" {
+		return bin
+	}
+	return props[PropAppName]
+}
+
+func getAppConfig(key string) *appConfig {
+	if key == "" {
+		return nil
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning(err)
+		}
+		return nil
+	}
+	return cfg.Apps[key]
+}
+
+// restoreAppConfig restores the volume, mute and preferred sink remembered for
+// the application of the sink-input.
+func (a *Audio) restoreAppConfig(sinkInput *SinkInput, sinkInputInfo *pulse.SinkInput) {
+	if !sinkInput.visible {
+		return
+	}
+	key := getSinkInputAppKey(sinkInputInfo.PropList)
+	ac := getAppConfig(key)
+	if ac == nil {
+		return
+	}
+	logger.Debugf("restore sink-input #%d app config %s: %+v", sinkInputInfo.Index, key, ac)
+
+	cv := sinkInputInfo.Volume.SetAvg(ac.Volume)
+	a.ctx.SetSinkInputVolume(sinkInputInfo.Index, cv)
+	if sinkInputInfo.Mute != ac.Mute {
+		a.ctx.SetSinkInputMute(sinkInputInfo.Index, ac.Mute)
+	}
+
+	if ac.Sink == "" {
+		return
+	}
+	sinkInfo := a.getSinkInfoByName(ac.Sink)
+	if sinkInfo == nil {
+		logger.Debugf("preferred sink %s of %s not found", ac.Sink, key)
+		return
+	}
+	sinkInput.PropsMu.Lock()
+	sinkInput.preferredSink = ac.Sink
+	sinkInput.PropsMu.Unlock()
+	if sinkInfo.Index != sinkInputInfo.Sink {
+		a.ctx.MoveSinkInputsByIndex([]uint32{sinkInputInfo.Index}, sinkInfo.Index)
+	}
+}
+
+func getAppConfigSeen(now time.Time) int64 {
+	return now.Truncate(24 * time.Hour).Unix()
+}
+
+// getAppConfigs collects the stream state of all visible sink-inputs, the
+// applications not playing keep the old state until they are pruned.
+func (a *Audio) getAppConfigs(ctx *pulse.Context, old map[string]*appConfig) map[string]*appConfig {
+	now := time.Now()
+	seen := getAppConfigSeen(now)
+	result := make(map[string]*appConfig, len(old))
+	for key, ac := range old {
+		result[key] = ac
+	}
+	for _, sinkInputInfo := range ctx.GetSinkInputList() {
+		sinkInput := a.getSinkInput(sinkInputInfo.Index)
+		if sinkInput == nil || !sinkInput.visible {
+			continue
+		}
+		key := getSinkInputAppKey(sinkInputInfo.PropList)
+		if key == "" {
+			continue
+		}
+		result[key] = &appConfig{
+			Volume: floatPrecision(sinkInputInfo.Volume.Avg()),
+			Mute:   sinkInputInfo.Mute,
+			Sink:   sinkInput.getPreferredSink(),
+			Seen:   seen,
+		}
+	}
+	pruneAppConfigs(result, now)
+	return result
+}
+
+// pruneAppConfigs removes the applications not seen for appConfigMaxAge, and
+// the least recently seen ones if there are more than appConfigMaxCount.
+func pruneAppConfigs(apps map[string]*appConfig, now time.Time) {
+	oldest := getAppConfigSeen(now.Add(-appConfigMaxAge))
+	keys := make([]string, 0, len(apps))
+	for key, ac := range apps {
+		if ac.Seen < oldest {
+			logger.Debugf("forget app config %s", key)
+			delete(apps, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) <= appConfigMaxCount {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return apps[keys[i]].Seen > apps[keys[j]].Seen
+	})
+	for _, key := range keys[appConfigMaxCount:] {
+		logger.Debugf("forget app config %s", key)
+		delete(apps, key)
+	}
+}
@@ -0,0 +1,67 @@
+package audio
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneAppConfigs(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	today := getAppConfigSeen(now)
+	apps := map[string]*appConfig{
+		"today":     {Seen: today},
+		"89 days":   {Seen: getAppConfigSeen(now.Add(-89 * 24 * time.Hour))},
+		"90 days":   {Seen: getAppConfigSeen(now.Add(-appConfigMaxAge))},
+		"91 days":   {Seen: getAppConfigSeen(now.Add(-91 * 24 * time.Hour))},
+		"never set": {},
+	}
+	pruneAppConfigs(apps, now)
+	assert.Len(t, apps, 3)
+	assert.Contains(t, apps, "today")
+	assert.Contains(t, apps, "89 days")
+	assert.Contains(t, apps, "90 days")
+
+	// the least recently seen ones are removed
+	apps = make(map[string]*appConfig)
+	for i := 0; i < appConfigMaxCount+10; i++ {
+		apps["app"+strconv.Itoa(i)] = &appConfig{
+			Seen: getAppConfigSeen(now.Add(-time.Duration(i) * 24 * time.Hour / 2)),
+		}
+	}
+	pruneAppConfigs(apps, now)
+	assert.Len(t, apps, appConfigMaxCount)
+	assert.Contains(t, apps, "app0")
+	assert.NotContains(t, apps, "app"+strconv.Itoa(appConfigMaxCount+9))
+}
+
+func TestGetSinkInputAppKey(t *testing.T) {
+	assert.Equal(t, "vlc", getSinkInputAppKey(map[string]string{
+		PropAppProcessBinary: "vlc",
+		PropAppName:          "VLC media player",
+	}))
+	assert.Equal(t, "VLC media player", getSinkInputAppKey(map[string]string{
+		PropAppName: "VLC media player",
+	}))
+	assert.Equal(t, "", getSinkInputAppKey(nil))
+}
+
+func TestPreferredSink(t *testing.T) {
+	assert.Equal(t, "", getPreferredSinkName("speaker", "speaker"))
+	assert.Equal(t, "hdmi", getPreferredSinkName("hdmi", "speaker"))
+
+	sinkInputs := map[uint32]*SinkInput{
+		// on the new default sink already
+		1: {index: 1, SinkIndex: 10},
+		// follows the default sink
+		2: {index: 2, SinkIndex: 11},
+		// stays on the preferred sink
+		3: {index: 3, SinkIndex: 11, preferredSink: "hdmi"},
+		// the preferred sink is gone, follows the default sink
+		4: {index: 4, SinkIndex: 12, preferredSink: "bluez_sink.AA_BB"},
+	}
+	sinkNames := map[string]bool{"speaker": true, "hdmi": true}
+	assert.Equal(t, []uint32{2, 4}, getSinkInputsToMove(sinkInputs, sinkNames, 10))
+}
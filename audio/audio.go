@@ -26,6 +26,7 @@ import (
 	"os"
 	"os/exec"
 	"pkg.deepin.io/lib/dbusutil/gsprop"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -124,8 +125,11 @@ type Audio struct {
 	quit              chan struct{}
 
 	cards CardList
+	// name of the bluetooth card switched to the headset profile for recording
+	headsetCard string
 
 	isSaving    bool
+	saveTimer   *time.Timer
 	saverLocker sync.Mutex
 
 	portLocker sync.Mutex
@@ -387,6 +391,7 @@ func (a *Audio) destroyCtxRelated() {
 }
 
 func (a *Audio) destroy() {
+	a.stopSaveConfigDelayed()
 	a.settings.Unref()
 	a.sessionSigLoop.Stop()
 	a.syncConfig.Destroy()
@@ -612,14 +617,13 @@ func (a *Audio) moveSinkInputsToSink(sinkId uint32) {
 		a.mu.Unlock()
 		return
 	}
-	var list []uint32
-	for _, sinkInput := range a.sinkInputs {
-		if sinkInput.getPropSinkIndex() == sinkId {
-			continue
-		}
-
-		list = append(list, sinkInput.index)
+	sinkNames := make(map[string]bool, len(a.sinks))
+	for _, sink := range a.sinks {
+		sink.PropsMu.RLock()
+		sinkNames[sink.Name] = true
+		sink.PropsMu.RUnlock()
 	}
+	list := getSinkInputsToMove(a.sinkInputs, sinkNames, sinkId)
 	a.mu.Unlock()
 	if len(list) == 0 {
 		return
@@ -628,6 +632,28 @@ func (a *Audio) moveSinkInputsToSink(sinkId uint32) {
 	a.ctx.MoveSinkInputsByIndex(list, sinkId)
 }
 
+// getSinkInputsToMove returns the sink-inputs which should follow the new
+// default sink, the ones on their existing preferred sinks are kept.
+func getSinkInputsToMove(sinkInputs map[uint32]*SinkInput, sinkNames map[string]bool,
+	sinkId uint32) []uint32 {
+	var list []uint32
+	for _, sinkInput := range sinkInputs {
+		if sinkInput.getPropSinkIndex() == sinkId {
+			continue
+		}
+		// keep the stream on the sink chosen by the user
+		if preferredSink := sinkInput.getPreferredSink(); sinkNames[preferredSink] {
+			continue
+		}
+
+		list = append(list, sinkInput.index)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i] < list[j]
+	})
+	return list
+}
+
 func isPortExists(name string, ports []pulse.PortInfo) bool {
 	for _, port := range ports {
 		if port.Name == name {
@@ -762,6 +788,17 @@ func (a *Audio) getDefaultSinkName() string {
 	return v
 }
 
+func (a *Audio) getSinkByPath(path dbus.ObjectPath) *Sink {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, sink := range a.sinks {
+		if sink.getPath() == path {
+			return sink
+		}
+	}
+	return nil
+}
+
 func (a *Audio) getSinkInfoByName(sinkName string) *pulse.Sink {
 	for _, sinkInfo := range a.ctx.GetSinkList() {
 		if sinkInfo.Name == sinkName {
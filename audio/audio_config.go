@@ -75,9 +75,12 @@ func (a *Audio) applyConfig() {
 
 			if s.ActivePort.Name != cfg.SinkPort {
 				a.ctx.SetSinkPortByIndex(s.Index, cfg.SinkPort)
+				s.ActivePort = *port
+			}
+			if !a.restoreSinkPortConfig(s) {
+				cv := s.Volume.SetAvg(cfg.SinkVolume)
+				a.ctx.SetSinkVolumeByIndex(s.Index, cv)
 			}
-			cv := s.Volume.SetAvg(cfg.SinkVolume)
-			a.ctx.SetSinkVolumeByIndex(s.Index, cv)
 			break
 		}
 	}
@@ -100,9 +103,12 @@ func (a *Audio) applyConfig() {
 			}
 			if s.ActivePort.Name != cfg.SourcePort {
 				a.ctx.SetSourcePortByIndex(s.Index, cfg.SourcePort)
+				s.ActivePort = *port
+			}
+			if !a.restoreSourcePortConfig(s) {
+				cv := s.Volume.SetAvg(cfg.SourceVolume)
+				a.ctx.SetSourceVolumeByIndex(s.Index, cv)
 			}
-			cv := s.Volume.SetAvg(cfg.SourceVolume)
-			a.ctx.SetSourceVolumeByIndex(s.Index, cv)
 			break
 		}
 	}
@@ -166,6 +172,27 @@ func (a *Audio) saveConfig() {
 	})
 }
 
+// saveConfigDelayed saves the config after the events stop for
+// sinkInputSaveDelay, the sink-input events are very frequent while playing.
+func (a *Audio) saveConfigDelayed() {
+	a.saverLocker.Lock()
+	if a.saveTimer == nil {
+		a.saveTimer = time.AfterFunc(sinkInputSaveDelay, a.saveConfig)
+	} else {
+		a.saveTimer.Reset(sinkInputSaveDelay)
+	}
+	a.saverLocker.Unlock()
+}
+
+func (a *Audio) stopSaveConfigDelayed() {
+	a.saverLocker.Lock()
+	if a.saveTimer != nil {
+		a.saveTimer.Stop()
+		a.saveTimer = nil
+	}
+	a.saverLocker.Unlock()
+}
+
 func (a *Audio) doSaveConfig() {
 	var info = config{
 		Profiles: make(map[string]string),
@@ -203,10 +230,19 @@ func (a *Audio) doSaveConfig() {
 		break
 	}
 
-	_, err := readConfig()
+	oldInfo, err := readConfig()
 	if err != nil && !os.IsNotExist(err) {
 		logger.Warning(err)
 	}
+	var oldPorts map[string]*portConfig
+	var oldApps map[string]*appConfig
+	if oldInfo != nil {
+		oldPorts = oldInfo.Ports
+		oldApps = oldInfo.Apps
+	}
+	info.Ports = a.getPortConfigs(ctx, oldPorts)
+	info.Apps = a.getAppConfigs(ctx, oldApps)
+
 	err = saveConfig(&info)
 	if err != nil {
 		logger.Warning("Save config file failed:", info.string(), err)
@@ -47,6 +47,12 @@ func (a *Audio) handleEvent() {
 				a.saveConfig()
 			case pulse.FacilitySinkInput:
 				a.handleSinkInputEvent(event.Type, event.Index)
+				// only the changes of volume, mute and sink are remembered
+				if event.Type == pulse.EventTypeChange {
+					a.saveConfigDelayed()
+				}
+			case pulse.FacilitySourceOutput:
+				a.handleSourceOutputEvent(event.Type, event.Index)
 			}
 
 		case <-a.quit:
@@ -174,6 +180,7 @@ func (a *Audio) handleSinkEvent(eventType int, idx uint32) {
 			return
 		}
 		a.addSink(sinkInfo)
+		a.restoreSinkPortConfig(sinkInfo)
 
 	case pulse.EventTypeRemove:
 		logger.Debugf("[Event] sink #%d removed", idx)
@@ -208,7 +215,13 @@ func (a *Audio) handleSinkEvent(eventType int, idx uint32) {
 			a.addSink(sinkInfo)
 			return
 		}
+		sink.PropsMu.RLock()
+		oldActivePort := sink.ActivePort.Name
+		sink.PropsMu.RUnlock()
 		sink.update(sinkInfo)
+		if oldActivePort != "" && oldActivePort != sinkInfo.ActivePort.Name {
+			a.restoreSinkPortConfig(sinkInfo)
+		}
 	}
 }
 
@@ -281,7 +294,7 @@ func (a *Audio) updatePropSinkInputs() {
 	a.updateObjPathsProp("SinkInput", ids, a.setPropSinkInputs)
 }
 
-func (a *Audio) addSinkInput(sinkInputInfo *pulse.SinkInput) {
+func (a *Audio) addSinkInput(sinkInputInfo *pulse.SinkInput) *SinkInput {
 	sinkInput := newSinkInput(sinkInputInfo, a)
 	a.mu.Lock()
 	a.sinkInputs[sinkInputInfo.Index] = sinkInput
@@ -293,13 +306,14 @@ func (a *Audio) addSinkInput(sinkInputInfo *pulse.SinkInput) {
 		err := a.service.Export(sinkInputPath, sinkInput)
 		if err != nil {
 			logger.Warning(err)
-			return
+			return sinkInput
 		}
 	}
 	a.updatePropSinkInputs()
 
 	logger.Debugf("sink-input #%d play with sink #%d", sinkInputInfo.Index,
 		sinkInputInfo.Sink)
+	return sinkInput
 }
 
 func (a *Audio) handleSinkInputAdded(idx uint32) {
@@ -316,7 +330,8 @@ func (a *Audio) handleSinkInputAdded(idx uint32) {
 		return
 	}
 
-	a.addSinkInput(sinkInputInfo)
+	sinkInput := a.addSinkInput(sinkInputInfo)
+	a.restoreAppConfig(sinkInput, sinkInputInfo)
 }
 
 func (a *Audio) handleSinkInputRemoved(idx uint32) {
@@ -380,6 +395,8 @@ func (a *Audio) handleSourceEvent(eventType int, idx uint32) {
 			return
 		}
 		a.addSource(sourceInfo)
+		a.restoreSourcePortConfig(sourceInfo)
+		a.setBluezHeadsetDefaultSource(sourceInfo)
 
 	case pulse.EventTypeRemove:
 		logger.Debugf("[Event] source #%d removed", idx)
@@ -416,7 +433,13 @@ func (a *Audio) handleSourceEvent(eventType int, idx uint32) {
 			a.addSource(sourceInfo)
 			return
 		}
+		source.PropsMu.RLock()
+		oldActivePort := source.ActivePort.Name
+		source.PropsMu.RUnlock()
 		source.update(sourceInfo)
+		if oldActivePort != "" && oldActivePort != sourceInfo.ActivePort.Name {
+			a.restoreSourcePortConfig(sourceInfo)
+		}
 	}
 }
 
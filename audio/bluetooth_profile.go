@@ -0,0 +1,166 @@
+/*
+ * Copyright (C) 2016 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"pkg.deepin.io/dde/daemon/common/bluezaudio"
+	"pkg.deepin.io/lib/pulse"
+)
+
+func (a *Audio) handleSourceOutputEvent(eventType int, idx uint32) {
+	switch eventType {
+	case pulse.EventTypeNew:
+		logger.Debugf("[Event] source output #%d added", idx)
+		a.updateBluezCardProfile()
+	case pulse.EventTypeRemove:
+		logger.Debugf("[Event] source output #%d removed", idx)
+		a.updateBluezCardProfile()
+	}
+}
+
+// isRecording returns true if any application is recording from the sources
+// accepted by filter, the meters of the daemon itself are ignored.
+func isRecording(ctx *pulse.Context, filter func(source *pulse.Source) bool) bool {
+	sources := make(map[uint32]bool)
+	for _, source := range ctx.GetSourceList() {
+		if filter(source) {
+			sources[source.Index] = true
+		}
+	}
+	pid := strconv.Itoa(os.Getpid())
+	for _, sourceOutput := range ctx.GetSourceOutputList() {
+		if !sources[sourceOutput.Source] ||
+			sourceOutput.PropList["application.process.id"] == pid {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func isMonitorSource(source *pulse.Source) bool {
+	return strings.HasSuffix(source.Name, ".monitor")
+}
+
+// getDefaultSinkBluezCard returns the bluetooth card of the default sink.
+func (a *Audio) getDefaultSinkBluezCard(ctx *pulse.Context) *pulse.Card {
+	defaultSink := a.getDefaultSinkName()
+	for _, sinkInfo := range ctx.GetSinkList() {
+		if sinkInfo.Name != defaultSink {
+			continue
+		}
+		card, err := ctx.GetCard(sinkInfo.Card)
+		if err != nil || !isBluetoothCard(card) {
+			return nil
+		}
+		return card
+	}
+	return nil
+}
+
+// updateBluezCardProfile switches the bluetooth headset in use to the
+// headset profile when an application starts recording from it, so that the
+// microphone of the headset can be used, and switches it back to a2dp when
+// all recordings from it end. The recordings from other cards, e.g. the
+// builtin microphone, keep the headset in a2dp.
+func (a *Audio) updateBluezCardProfile() {
+	ctx := a.context()
+	if ctx == nil {
+		return
+	}
+
+	a.mu.Lock()
+	headsetCard := a.headsetCard
+	a.mu.Unlock()
+
+	if headsetCard == "" {
+		card := a.getDefaultSinkBluezCard(ctx)
+		if card == nil || !bluezaudio.IsA2DPProfile(card.ActiveProfile.Name) {
+			return
+		}
+		// the card has no microphone in a2dp profile, so pulseaudio chooses
+		// the monitor of it as the default source if there is no other one
+		defaultSource := a.getDefaultSourceName()
+		recording := isRecording(ctx, func(source *pulse.Source) bool {
+			return source.Card == card.Index &&
+				(!isMonitorSource(source) || source.Name == defaultSource)
+		})
+		if !recording {
+			return
+		}
+		profile := bluezaudio.GetHeadsetProfile(card)
+		if profile == "" {
+			return
+		}
+		logger.Debugf("recording started, switch card %s to profile %s", card.Name, profile)
+		a.mu.Lock()
+		a.headsetCard = card.Name
+		a.mu.Unlock()
+		card.SetProfile(profile)
+		return
+	}
+
+	// the recording may be moved to another source when the profile is
+	// switched, so wait for all recordings to end
+	recording := isRecording(ctx, func(source *pulse.Source) bool {
+		return !isMonitorSource(source)
+	})
+	if recording {
+		return
+	}
+	a.mu.Lock()
+	a.headsetCard = ""
+	a.mu.Unlock()
+	for _, card := range ctx.GetCardList() {
+		if card.Name != headsetCard {
+			continue
+		}
+		// the profile may be changed by user during recording
+		if !bluezaudio.IsHeadsetProfile(card.ActiveProfile.Name) {
+			return
+		}
+		profile := bluezaudio.GetA2DPProfile(card)
+		if profile == "" {
+			return
+		}
+		logger.Debugf("recording ended, switch card %s to profile %s", card.Name, profile)
+		card.SetProfile(profile)
+		return
+	}
+}
+
+// setBluezHeadsetDefaultSource makes the source of the headset switched to
+// by updateBluezCardProfile the default source, so that the recording uses
+// the microphone of the headset.
+func (a *Audio) setBluezHeadsetDefaultSource(sourceInfo *pulse.Source) {
+	a.mu.Lock()
+	headsetCard := a.headsetCard
+	a.mu.Unlock()
+	if headsetCard == "" || a.getCardName(sourceInfo.Card) != headsetCard ||
+		isMonitorSource(sourceInfo) {
+		return
+	}
+	logger.Debug("set bluetooth headset source as default:", sourceInfo.Name)
+	a.ctx.SetDefaultSource(sourceInfo.Name)
+}
@@ -44,6 +44,11 @@ type config struct {
 
 	SinkVolume   float64
 	SourceVolume float64
+
+	// Ports[getPortConfigKey(cardName, portName, props)] = volume state
+	Ports map[string]*portConfig
+	// Apps[getSinkInputAppKey(props)] = stream state
+	Apps map[string]*appConfig
 }
 
 func (c *config) string() string {
@@ -64,7 +69,9 @@ func (c *config) equal(b *config) bool {
 		c.SourcePort == b.SourcePort &&
 		c.SinkVolume == b.SinkVolume &&
 		c.SourceVolume == b.SourceVolume &&
-		mapStrStrEqual(c.Profiles, b.Profiles)
+		mapStrStrEqual(c.Profiles, b.Profiles) &&
+		portConfigsEqual(c.Ports, b.Ports) &&
+		appConfigsEqual(c.Apps, b.Apps)
 }
 
 func readConfig() (*config, error) {
@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2016 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+import (
+	"os"
+	"strings"
+
+	"pkg.deepin.io/lib/pulse"
+)
+
+// portConfig is the volume state remembered for a port
+type portConfig struct {
+	Volume  float64
+	Mute    bool
+	Balance float64
+}
+
+func (pc *portConfig) equal(b *portConfig) bool {
+	if pc == nil || b == nil {
+		return pc == b
+	}
+	return *pc == *b
+}
+
+func portConfigsEqual(a, b map[string]*portConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !v.equal(w) {
+			return false
+		}
+	}
+	return true
+}
+
+// the prefix of the keys of bluetooth ports in config.Ports
+const portConfigKeyBluezPrefix = "bluez/"
+
+// getPortConfigKey returns the key of the port in config.Ports. Bluetooth
+// devices are identified by the MAC address, because the card name changes
+// with the profile and other devices of the same model may be used.
+func getPortConfigKey(cardName, portName string, props map[string]string) string {
+	if props["device.api"] == "bluez" && props["device.string"] != "" {
+		return portConfigKeyBluezPrefix + props["device.string"] + "/" + portName
+	}
+	return cardName + "/" + portName
+}
+
+// isBluezPortConfigKey returns true if the key is of a bluetooth port, which
+// is the same device on every computer.
+func isBluezPortConfigKey(key string) bool {
+	return strings.HasPrefix(key, portConfigKeyBluezPrefix)
+}
+
+func (a *Audio) getCardName(cardId uint32) string {
+	a.mu.Lock()
+	card, _ := a.cards.get(cardId)
+	a.mu.Unlock()
+	if card == nil || card.core == nil {
+		return ""
+	}
+	return card.core.Name
+}
+
+func (a *Audio) getSinkPortConfigKey(sinkInfo *pulse.Sink) string {
+	if sinkInfo.ActivePort.Name == "" {
+		return ""
+	}
+	return getPortConfigKey(a.getCardName(sinkInfo.Card), sinkInfo.ActivePort.Name,
+		sinkInfo.PropList)
+}
+
+func (a *Audio) getSourcePortConfigKey(sourceInfo *pulse.Source) string {
+	if sourceInfo.ActivePort.Name == "" {
+		return ""
+	}
+	return getPortConfigKey(a.getCardName(sourceInfo.Card), sourceInfo.ActivePort.Name,
+		sourceInfo.PropList)
+}
+
+func getPortConfig(key string) *portConfig {
+	if key == "" {
+		return nil
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning(err)
+		}
+		return nil
+	}
+	return cfg.Ports[key]
+}
+
+// restoreSinkPortConfig restores the volume, mute and balance remembered for
+// the active port of the sink.
+func (a *Audio) restoreSinkPortConfig(sinkInfo *pulse.Sink) bool {
+	key := a.getSinkPortConfigKey(sinkInfo)
+	pc := getPortConfig(key)
+	if pc == nil {
+		return false
+	}
+	logger.Debugf("restore sink #%d port config %s: %+v", sinkInfo.Index, key, pc)
+	cv := sinkInfo.Volume.SetAvg(pc.Volume).SetBalance(sinkInfo.ChannelMap, pc.Balance)
+	a.ctx.SetSinkVolumeByIndex(sinkInfo.Index, cv)
+	if sinkInfo.Mute != pc.Mute {
+		a.ctx.SetSinkMuteByIndex(sinkInfo.Index, pc.Mute)
+	}
+	return true
+}
+
+// restoreSourcePortConfig restores the volume, mute and balance remembered
+// for the active port of the source.
+func (a *Audio) restoreSourcePortConfig(sourceInfo *pulse.Source) bool {
+	key := a.getSourcePortConfigKey(sourceInfo)
+	pc := getPortConfig(key)
+	if pc == nil {
+		return false
+	}
+	logger.Debugf("restore source #%d port config %s: %+v", sourceInfo.Index, key, pc)
+	cv := sourceInfo.Volume.SetAvg(pc.Volume).SetBalance(sourceInfo.ChannelMap, pc.Balance)
+	a.ctx.SetSourceVolumeByIndex(sourceInfo.Index, cv)
+	if sourceInfo.Mute != pc.Mute {
+		a.ctx.SetSourceMuteByIndex(sourceInfo.Index, pc.Mute)
+	}
+	return true
+}
+
+// restorePortConfigs restores the remembered config of all active ports
+func (a *Audio) restorePortConfigs() {
+	ctx := a.context()
+	if ctx == nil {
+		return
+	}
+	for _, sinkInfo := range ctx.GetSinkList() {
+		a.restoreSinkPortConfig(sinkInfo)
+	}
+	for _, sourceInfo := range ctx.GetSourceList() {
+		a.restoreSourcePortConfig(sourceInfo)
+	}
+}
+
+// getPortConfigs collects the volume state of the active ports of all sinks
+// and sources, the ports not active keep the old state.
+func (a *Audio) getPortConfigs(ctx *pulse.Context, old map[string]*portConfig) map[string]*portConfig {
+	result := make(map[string]*portConfig, len(old))
+	for key, pc := range old {
+		result[key] = pc
+	}
+	for _, sinkInfo := range ctx.GetSinkList() {
+		key := a.getSinkPortConfigKey(sinkInfo)
+		if key == "" {
+			continue
+		}
+		result[key] = &portConfig{
+			Volume:  floatPrecision(sinkInfo.Volume.Avg()),
+			Mute:    sinkInfo.Mute,
+			Balance: floatPrecision(sinkInfo.Volume.Balance(sinkInfo.ChannelMap)),
+		}
+	}
+	for _, sourceInfo := range ctx.GetSourceList() {
+		key := a.getSourcePortConfigKey(sourceInfo)
+		if key == "" {
+			continue
+		}
+		result[key] = &portConfig{
+			Volume:  floatPrecision(sourceInfo.Volume.Avg()),
+			Mute:    sourceInfo.Mute,
+			Balance: floatPrecision(sourceInfo.Volume.Balance(sourceInfo.ChannelMap)),
+		}
+	}
+	return result
+}
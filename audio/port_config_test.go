@@ -0,0 +1,102 @@
+package audio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useTestConfigFile makes readConfig and saveConfig use a temporary file
+func useTestConfigFile(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "audio")
+	require.NoError(t, err)
+	oldConfigFile, oldMaxUIVolume := configFile, gMaxUIVolume
+	configFile = filepath.Join(dir, "audio.json")
+	configCache = nil
+	gMaxUIVolume = 1.5
+	return func() {
+		configFile, gMaxUIVolume = oldConfigFile, oldMaxUIVolume
+		configCache = nil
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetPortConfigKey(t *testing.T) {
+	assert.Equal(t, "alsa_card.pci-0000_00_1f.3/analog-output-speaker",
+		getPortConfigKey("alsa_card.pci-0000_00_1f.3", "analog-output-speaker", map[string]string{
+			"device.api": "alsa",
+		}))
+	// the card name of bluetooth devices changes with the profile
+	props := map[string]string{
+		"device.api":    "bluez",
+		"device.string": "AA:BB:CC:DD:EE:FF",
+	}
+	key := getPortConfigKey("bluez_card.AA_BB_CC_DD_EE_FF", "headset-output", props)
+	assert.Equal(t, "bluez/AA:BB:CC:DD:EE:FF/headset-output", key)
+	assert.True(t, isBluezPortConfigKey(key))
+	assert.False(t, isBluezPortConfigKey("alsa_card.pci-0000_00_1f.3/analog-output-speaker"))
+
+	// bluez device without address
+	assert.Equal(t, "bluez_card.x/headset-output",
+		getPortConfigKey("bluez_card.x", "headset-output", map[string]string{"device.api": "bluez"}))
+}
+
+func TestGetPortConfig(t *testing.T) {
+	defer useTestConfigFile(t)()
+
+	assert.Nil(t, getPortConfig("bluez/AA:BB:CC:DD:EE:FF/headset-output"))
+
+	pc := &portConfig{Volume: 0.6, Mute: true, Balance: -0.5}
+	require.NoError(t, saveConfig(&config{
+		Ports: map[string]*portConfig{"bluez/AA:BB:CC:DD:EE:FF/headset-output": pc},
+	}))
+	configCache = nil
+	assert.Equal(t, pc, getPortConfig("bluez/AA:BB:CC:DD:EE:FF/headset-output"))
+	assert.Nil(t, getPortConfig("alsa_card.pci-0000_00_1f.3/analog-output-speaker"))
+	assert.Nil(t, getPortConfig(""))
+}
+
+func TestSyncPortConfigs(t *testing.T) {
+	defer useTestConfigFile(t)()
+
+	const (
+		speaker = "alsa_card.pci-0000_00_1f.3/analog-output-speaker"
+		headset = "bluez/AA:BB:CC:DD:EE:FF/headset-output"
+		a2dp    = "bluez/AA:BB:CC:DD:EE:01/a2dp-output"
+	)
+	require.NoError(t, saveConfig(&config{
+		Ports: map[string]*portConfig{
+			speaker: {Volume: 0.3},
+			headset: {Volume: 0.5},
+		},
+	}))
+
+	// only the bluetooth ports are uploaded
+	assert.Equal(t, map[string]*syncPortConfig{
+		headset: {Volume: 0.5},
+	}, getSyncPortConfigs())
+
+	// the ports of other cards and invalid configs are ignored
+	require.NoError(t, setSyncPortConfigs(map[string]*syncPortConfig{
+		speaker:                               {Volume: 1},
+		headset:                               {Volume: 0.8, Mute: true},
+		a2dp:                                  {Volume: 2},
+		"bluez/AA:BB:CC:DD:EE:02/a2dp-output": {Volume: 0.5, Balance: 1.5},
+	}))
+	cfg, err := readConfig()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*portConfig{
+		speaker: {Volume: 0.3},
+		headset: {Volume: 0.8, Mute: true},
+	}, cfg.Ports)
+
+	require.NoError(t, setSyncPortConfigs(map[string]*syncPortConfig{
+		a2dp: {Volume: 1, Balance: -0.2},
+	}))
+	assert.Equal(t, &portConfig{Volume: 1, Balance: -0.2}, getPortConfig(a2dp))
+	assert.Equal(t, &portConfig{Volume: 0.8, Mute: true}, getPortConfig(headset))
+}
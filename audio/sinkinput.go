@@ -48,6 +48,8 @@ type SinkInput struct {
 	visible           bool
 	cVolume           pulse.CVolume
 	channelMap        pulse.ChannelMap
+	// name of the sink chosen by MoveToSink, not moved with the default sink
+	preferredSink string
 	// Name process name
 	Name           string
 	Icon           string
@@ -64,6 +66,7 @@ type SinkInput struct {
 		SetBalance func() `in:"value,isPlay"`
 		SetFade    func() `in:"value"`
 		SetMute    func() `in:"value"`
+		MoveToSink func() `in:"sinkPath"`
 	}
 }
 
@@ -165,6 +168,44 @@ func (s *SinkInput) SetMute(v bool) *dbus.Error {
 	return nil
 }
 
+// MoveToSink moves the stream to the sink, the sink is remembered as the
+// preferred output of the application.
+func (s *SinkInput) MoveToSink(sinkPath dbus.ObjectPath) *dbus.Error {
+	sink := s.audio.getSinkByPath(sinkPath)
+	if sink == nil {
+		return dbusutil.ToError(fmt.Errorf("invalid sink path: %s", sinkPath))
+	}
+
+	sink.PropsMu.RLock()
+	sinkName := sink.Name
+	sink.PropsMu.RUnlock()
+	preferredSink := getPreferredSinkName(sinkName, s.audio.getDefaultSinkName())
+	s.PropsMu.Lock()
+	s.preferredSink = preferredSink
+	s.PropsMu.Unlock()
+
+	logger.Debugf("move sink-input #%d to sink #%d", s.index, sink.index)
+	s.audio.context().MoveSinkInputsByIndex([]uint32{s.index}, sink.index)
+	s.audio.saveConfig()
+	return nil
+}
+
+// getPreferredSinkName returns the sink remembered for the application, the
+// default sink is not remembered, so that the stream follows the default sink.
+func getPreferredSinkName(sinkName, defaultSinkName string) string {
+	if sinkName == defaultSinkName {
+		return ""
+	}
+	return sinkName
+}
+
+func (s *SinkInput) getPreferredSink() string {
+	s.PropsMu.RLock()
+	v := s.preferredSink
+	s.PropsMu.RUnlock()
+	return v
+}
+
 func (s *SinkInput) getPath() dbus.ObjectPath {
 	return dbus.ObjectPath(dbusPath + "/SinkInput" + strconv.Itoa(int(s.index)))
 }
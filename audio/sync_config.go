@@ -2,6 +2,7 @@ package audio
 
 import (
 	"encoding/json"
+	"os"
 
 	"github.com/linuxdeepin/go-dbus-factory/com.deepin.api.soundthemeplayer"
 	"pkg.deepin.io/gir/gio-2.0"
@@ -57,9 +58,18 @@ type syncSoundEffect struct {
 	XDeepinAppSentToDesktop bool `json:"x_deepin_app_sent_to_desktop"`
 }
 
+type syncPortConfig struct {
+	Volume  float64 `json:"volume"`
+	Mute    bool    `json:"mute"`
+	Balance float64 `json:"balance"`
+}
+
 type syncData struct {
 	Version     string           `json:"version"`
 	SoundEffect *syncSoundEffect `json:"soundeffect"`
+	// only the bluetooth ports are synchronized, the names of the other cards
+	// and ports are meaningless on other computers
+	Ports map[string]*syncPortConfig `json:"ports,omitempty"`
 }
 
 type syncConfig struct {
@@ -75,6 +85,7 @@ func (sc *syncConfig) Get() (interface{}, error) {
 	defer s.Unref()
 	return &syncData{
 		Version: syncVersion,
+		Ports:   getSyncPortConfigs(),
 		SoundEffect: &syncSoundEffect{
 			Enabled:                 s.GetBoolean(gsKeyEnabled),
 			AudioVolumeChange:       s.GetBoolean(gsKeyAudioVolumeChange),
@@ -136,9 +147,108 @@ func (sc *syncConfig) Set(data []byte) error {
 		s.SetBoolean(gsKeyXDeepinAppSentToDesktop, soundEffect.XDeepinAppSentToDesktop)
 		s.Unref()
 	}
+	if len(info.Ports) > 0 {
+		err = setSyncPortConfigs(info.Ports)
+		if err != nil {
+			return err
+		}
+		sc.a.restorePortConfigs()
+	}
 	return nil
 }
 
+func getSyncPortConfigs() map[string]*syncPortConfig {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil
+	}
+	result := make(map[string]*syncPortConfig)
+	for key, pc := range cfg.Ports {
+		if !isBluezPortConfigKey(key) {
+			continue
+		}
+		result[key] = &syncPortConfig{
+			Volume:  pc.Volume,
+			Mute:    pc.Mute,
+			Balance: pc.Balance,
+		}
+	}
+	return result
+}
+
+func setSyncPortConfigs(ports map[string]*syncPortConfig) error {
+	cfg, err := readConfig()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var info config
+	if cfg != nil {
+		info = *cfg
+	}
+	oldPorts := info.Ports
+	info.Ports = make(map[string]*portConfig, len(oldPorts)+len(ports))
+	for key, pc := range oldPorts {
+		info.Ports[key] = pc
+	}
+	for key, pc := range ports {
+		if !isBluezPortConfigKey(key) || pc == nil || !isVolumeValid(pc.Volume) ||
+			pc.Balance < -1 || pc.Balance > 1 {
+			continue
+		}
+		info.Ports[key] = &portConfig{
+			Volume:  pc.Volume,
+			Mute:    pc.Mute,
+			Balance: pc.Balance,
+		}
+	}
+	return saveConfig(&info)
+}
+
+// MergeKey merges the port configs changed on both devices port by port, a
+// port changed locally since the last synchronization keeps the local
+// config. The newer value wins for the other keys.
+func (sc *syncConfig) MergeKey(key string, base, local, remote json.RawMessage) (json.RawMessage, error) {
+	if key != "ports" {
+		return nil, nil
+	}
+	var basePorts, localPorts, remotePorts map[string]*syncPortConfig
+	if base != nil {
+		err := json.Unmarshal(base, &basePorts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := json.Unmarshal(local, &localPorts)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(remote, &remotePorts)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeSyncPortConfigs(basePorts, localPorts, remotePorts))
+}
+
+func isSyncPortConfigEqual(a, b *syncPortConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func mergeSyncPortConfigs(base, local, remote map[string]*syncPortConfig) map[string]*syncPortConfig {
+	result := make(map[string]*syncPortConfig, len(remote))
+	for key, pc := range remote {
+		result[key] = pc
+	}
+	for key, pc := range local {
+		if !isSyncPortConfigEqual(pc, base[key]) {
+			result[key] = pc
+		}
+	}
+	return result
+}
+
 func (sc *syncConfig) syncConfigToSoundThemePlayer(enabled bool) error {
 	sysBus, err := dbus.SystemBus()
 	if err != nil {
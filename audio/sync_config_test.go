@@ -0,0 +1,53 @@
+package audio
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSyncPortConfigs(t *testing.T) {
+	base := map[string]*syncPortConfig{
+		"bluez/A/headset-output": {Volume: 0.5, Balance: 0},
+		"bluez/B/a2dp-output":    {Volume: 0.5, Balance: 0},
+	}
+	local := map[string]*syncPortConfig{
+		"bluez/A/headset-output": {Volume: 0.8, Balance: 0},
+		"bluez/B/a2dp-output":    {Volume: 0.5, Balance: 0},
+		"bluez/C/a2dp-output":    {Volume: 0.3, Balance: 0},
+	}
+	remote := map[string]*syncPortConfig{
+		"bluez/A/headset-output": {Volume: 0.5, Balance: 0},
+		"bluez/B/a2dp-output":    {Volume: 0.5, Mute: true, Balance: 0.2},
+	}
+	assert.Equal(t, map[string]*syncPortConfig{
+		"bluez/A/headset-output": {Volume: 0.8, Balance: 0},
+		"bluez/B/a2dp-output":    {Volume: 0.5, Mute: true, Balance: 0.2},
+		"bluez/C/a2dp-output":    {Volume: 0.3, Balance: 0},
+	}, mergeSyncPortConfigs(base, local, remote))
+
+	// the same port changed on both devices keeps the local config
+	local["bluez/B/a2dp-output"] = &syncPortConfig{Volume: 1}
+	assert.Equal(t, &syncPortConfig{Volume: 1},
+		mergeSyncPortConfigs(base, local, remote)["bluez/B/a2dp-output"])
+}
+
+func TestSyncConfigMergeKey(t *testing.T) {
+	sc := &syncConfig{}
+	value, err := sc.MergeKey("soundeffect", nil, json.RawMessage(`{}`), json.RawMessage(`{}`))
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+
+	value, err = sc.MergeKey("ports", nil,
+		json.RawMessage(`{"bluez/A/headset-output":{"volume":0.8,"mute":false,"balance":0}}`),
+		json.RawMessage(`{"bluez/B/a2dp-output":{"volume":0.5,"mute":true,"balance":0}}`))
+	require.NoError(t, err)
+	var ports map[string]*syncPortConfig
+	require.NoError(t, json.Unmarshal(value, &ports))
+	assert.Len(t, ports, 2)
+
+	_, err = sc.MergeKey("ports", nil, json.RawMessage(`[]`), json.RawMessage(`{}`))
+	assert.Error(t, err)
+}
@@ -20,6 +20,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -85,7 +86,10 @@ type SessionDaemon struct {
 	cpuWriter *os.File
 
 	methods *struct {
-		CallTrace func() `in:"times,seconds"`
+		CallTrace     func() `in:"times,seconds"`
+		ListModules   func() `out:"modules"`
+		RestartModule func() `in:"name"`
+		DisableModule func() `in:"name"`
 	}
 }
 
@@ -264,6 +268,39 @@ func (s *SessionDaemon) StartPart2() *dbus.Error {
 	return dbusutil.ToError(err)
 }
 
+func (s *SessionDaemon) ListModules() (string, *dbus.Error) {
+	data, err := json.Marshal(loader.ModuleStatuses())
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return string(data), nil
+}
+
+func (s *SessionDaemon) RestartModule(name string) *dbus.Error {
+	moduleLocker.Lock()
+	defer moduleLocker.Unlock()
+
+	s.log.Info("restart module", name)
+	err := loader.RestartModule(name)
+	if err != nil {
+		s.log.Warning(err)
+	}
+	return dbusutil.ToError(err)
+}
+
+func (s *SessionDaemon) DisableModule(name string) *dbus.Error {
+	moduleLocker.Lock()
+	defer moduleLocker.Unlock()
+
+	names, err := loader.DisableModule(name)
+	if err != nil {
+		s.log.Warning(err)
+		return dbusutil.ToError(err)
+	}
+	s.log.Info("disabled modules", names)
+	return nil
+}
+
 func filterList(origin, condition []string) []string {
 	if len(condition) == 0 {
 		return origin
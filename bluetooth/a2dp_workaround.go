@@ -19,18 +19,57 @@
 
 package bluetooth
 
-func (b *Bluetooth) disconnectA2DPDeviceExcept(d *device) {
+import (
+	"sort"
+)
+
+func (d *device) isA2DPSink() bool {
+	return isStringInArray(A2DP_SINK_UUID, d.UUIDs)
+}
+
+// getConnectedA2DPSinks returns the connected A2DP sink devices except d,
+// the device of the lowest priority and connected earliest comes first.
+func (b *Bluetooth) getConnectedA2DPSinks(d *device) []*device {
+	var result []*device
+	b.devicesLock.Lock()
 	for _, devices := range b.devices {
 		for _, device := range devices {
-			if device.Path == d.Path {
-				continue
-			}
-			for _, uuid := range device.UUIDs {
-				if uuid == A2DP_SINK_UUID {
-					logger.Infof("disconnect A2DP %s", device)
-					device.Disconnect()
-				}
+			if device.Path != d.Path && device.connected && device.isA2DPSink() {
+				result = append(result, device)
 			}
 		}
 	}
+	b.devicesLock.Unlock()
+
+	sort.SliceStable(result, func(i, j int) bool {
+		pi := b.config.getDeviceConfigPriority(result[i].getAddress())
+		pj := b.config.getDeviceConfigPriority(result[j].getAddress())
+		if pi != pj {
+			return pi < pj
+		}
+		return result[i].connectedTime.Before(result[j].connectedTime)
+	})
+	return result
+}
+
+// disconnectA2DPDeviceExcept disconnects the A2DP sink devices except d,
+// to keep the number of connected A2DP sink devices within the limit.
+func (b *Bluetooth) disconnectA2DPDeviceExcept(d *device) {
+	connected := b.getConnectedA2DPSinks(d)
+	n := len(connected) + 1 - b.config.getMaxA2DPSinks()
+	for i := 0; i < n; i++ {
+		logger.Infof("disconnect A2DP %s", connected[i])
+		connected[i].Disconnect()
+	}
+}
+
+// canAutoConnectA2DPSink returns false if the limit of A2DP sink devices is
+// reached, and d does not have higher priority than the connected ones.
+func (b *Bluetooth) canAutoConnectA2DPSink(d *device) bool {
+	connected := b.getConnectedA2DPSinks(d)
+	if len(connected) < b.config.getMaxA2DPSinks() {
+		return true
+	}
+	return b.config.getDeviceConfigPriority(d.getAddress()) >
+		b.config.getDeviceConfigPriority(connected[0].getAddress())
 }
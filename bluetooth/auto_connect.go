@@ -0,0 +1,215 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package bluetooth
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	dbus "pkg.deepin.io/lib/dbus1"
+)
+
+const (
+	autoConnectMaxRetry  = 5
+	autoConnectBaseDelay = 5 * time.Second
+	autoConnectMaxDelay  = 2 * time.Minute
+)
+
+// autoConnector connects the paired devices when the adapter is powered on
+// or the system wakes up, and retries the failed devices with backoff.
+type autoConnector struct {
+	b  *Bluetooth
+	mu sync.Mutex
+	// key is device path
+	retries map[dbus.ObjectPath]*autoConnectRetry
+}
+
+type autoConnectRetry struct {
+	count int
+	timer *time.Timer
+}
+
+func newAutoConnector(b *Bluetooth) *autoConnector {
+	return &autoConnector{
+		b:       b,
+		retries: make(map[dbus.ObjectPath]*autoConnectRetry),
+	}
+}
+
+func getAutoConnectDelay(count int) time.Duration {
+	delay := autoConnectBaseDelay
+	for i := 1; i < count; i++ {
+		delay *= 2
+		if delay >= autoConnectMaxDelay {
+			return autoConnectMaxDelay
+		}
+	}
+	return delay
+}
+
+func (ac *autoConnector) connect(d *device) {
+	// if device using LE mode, will suspend, try connect should be failed, filter it.
+	if !ac.b.isBREDRDevice(d) {
+		return
+	}
+	if !ac.b.config.getDeviceConfigAutoConnect(d.getAddress()) {
+		logger.Debugf("%s skip auto connect, disabled by user", d)
+		ac.reset(d.Path)
+		return
+	}
+	if d.isA2DPSink() && !ac.b.canAutoConnectA2DPSink(d) {
+		logger.Debugf("%s skip auto connect, too many A2DP sink devices", d)
+		ac.reset(d.Path)
+		return
+	}
+
+	logger.Debug("Will auto connect device:", d.String(), d.adapter.address, d.Address)
+	err := d.doConnect(false)
+	if err != nil {
+		logger.Debug("failed to connect:", d.String(), err)
+		ac.scheduleRetry(d.Path)
+		return
+	}
+	ac.reset(d.Path)
+}
+
+func (ac *autoConnector) scheduleRetry(devPath dbus.ObjectPath) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	retry, ok := ac.retries[devPath]
+	if !ok {
+		retry = &autoConnectRetry{}
+		ac.retries[devPath] = retry
+	}
+	if retry.timer != nil {
+		retry.timer.Stop()
+	}
+	retry.count++
+	if retry.count > autoConnectMaxRetry {
+		logger.Debugf("give up auto connecting %s", devPath)
+		delete(ac.retries, devPath)
+		return
+	}
+
+	delay := getAutoConnectDelay(retry.count)
+	logger.Debugf("retry auto connecting %s after %v", devPath, delay)
+	retry.timer = time.AfterFunc(delay, func() {
+		d, err := ac.b.getDevice(devPath)
+		if err != nil {
+			ac.reset(devPath)
+			return
+		}
+		if d.connected || !d.adapter.Powered || !d.Paired {
+			ac.reset(devPath)
+			return
+		}
+		ac.connect(d)
+	})
+}
+
+func (ac *autoConnector) reset(devPath dbus.ObjectPath) {
+	ac.mu.Lock()
+	if retry, ok := ac.retries[devPath]; ok {
+		if retry.timer != nil {
+			retry.timer.Stop()
+		}
+		delete(ac.retries, devPath)
+	}
+	ac.mu.Unlock()
+}
+
+func (ac *autoConnector) resetAll() {
+	ac.mu.Lock()
+	for devPath, retry := range ac.retries {
+		if retry.timer != nil {
+			retry.timer.Stop()
+		}
+		delete(ac.retries, devPath)
+	}
+	ac.mu.Unlock()
+}
+
+func (ac *autoConnector) isRetrying(devPath dbus.ObjectPath) bool {
+	ac.mu.Lock()
+	_, ok := ac.retries[devPath]
+	ac.mu.Unlock()
+	return ok
+}
+
+// getAutoConnectDevices returns the paired devices to connect, sorted by the
+// priority, and the devices connected last time come first.
+func (b *Bluetooth) getAutoConnectDevices() []*device {
+	var devList []*device
+	for _, d := range b.getPairedDeviceList() {
+		if !b.config.getDeviceConfigAutoConnect(d.getAddress()) {
+			continue
+		}
+		devList = append(devList, d)
+	}
+
+	sort.SliceStable(devList, func(i, j int) bool {
+		pi := b.config.getDeviceConfigPriority(devList[i].getAddress())
+		pj := b.config.getDeviceConfigPriority(devList[j].getAddress())
+		if pi != pj {
+			return pi > pj
+		}
+		ci := b.config.getDeviceConfigConnected(devList[i].getAddress())
+		cj := b.config.getDeviceConfigConnected(devList[j].getAddress())
+		return ci && !cj
+	})
+	return devList
+}
+
+// handleDeviceAppeared connects the target devices of the rules whose trigger
+// is the device. It is called when the device is added by bluez, comes into
+// range or is connected, not for the devices loaded at startup.
+func (b *Bluetooth) handleDeviceAppeared(d *device) {
+	targets := b.config.getConnectRuleTargets(d.getAddress())
+	for _, target := range targets {
+		targetDevice := b.getDeviceByConfigAddress(target)
+		if targetDevice == nil {
+			logger.Debugf("target %s of %s not found", target, d)
+			continue
+		}
+		if targetDevice.connected || !targetDevice.adapter.Powered || !targetDevice.Paired {
+			continue
+		}
+		if b.autoConnector.isRetrying(targetDevice.Path) {
+			continue
+		}
+		logger.Infof("%s appeared, connect %s", d, targetDevice)
+		b.autoConnector.connect(targetDevice)
+	}
+}
+
+func (b *Bluetooth) getDeviceByConfigAddress(address string) *device {
+	b.devicesLock.Lock()
+	defer b.devicesLock.Unlock()
+	for _, devices := range b.devices {
+		for _, d := range devices {
+			if d.getAddress() == address {
+				return d
+			}
+		}
+	}
+	return nil
+}
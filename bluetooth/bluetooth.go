@@ -68,6 +68,8 @@ type Bluetooth struct {
 	sysDBusDaemon *ofdbus.DBus
 	apiDevice     *apidevice.Device
 	agent         *agent
+	obexAgent     *obexAgent
+	autoConnector *autoConnector
 
 	// adapter
 	adaptersLock sync.Mutex
@@ -98,6 +100,18 @@ type Bluetooth struct {
 		SetAdapterDiscoverable        func() `in:"adapter,discoverable"`
 		SetAdapterDiscovering         func() `in:"adapter,discovering"`
 		SetAdapterDiscoverableTimeout func() `in:"adapter,timeout"`
+		SendFiles                     func() `in:"device,files" out:"sessionPath"`
+		CancelTransferSession         func() `in:"sessionPath"`
+		GetReceiveFileDir             func() `out:"dir"`
+		SetReceiveFileDir             func() `in:"dir"`
+		SetDevicePriority             func() `in:"device,priority"`
+		SetDeviceAutoConnect          func() `in:"device,autoConnect"`
+		GetConnectRules               func() `out:"rulesJSON"`
+		AddConnectRule                func() `in:"trigger,target"`
+		RemoveConnectRule             func() `in:"trigger,target"`
+		GetMaxA2DPSinks               func() `out:"n"`
+		SetMaxA2DPSinks               func() `in:"n"`
+		SetDeviceAudioProfile         func() `in:"device,profile"`
 	}
 
 	signals *struct {
@@ -145,6 +159,28 @@ type Bluetooth struct {
 		Cancelled struct {
 			device dbus.ObjectPath
 		}
+
+		// obex file transfer signals
+		ObexSessionCreated, ObexSessionRemoved struct {
+			sessionPath dbus.ObjectPath
+		}
+		TransferCreated struct {
+			file         string
+			transferPath dbus.ObjectPath
+			sessionPath  dbus.ObjectPath
+		}
+		TransferProgress struct {
+			transferPath dbus.ObjectPath
+			transferred  uint64
+			size         uint64
+		}
+		// done is false if the transfer failed or was cancelled
+		TransferRemoved struct {
+			file         string
+			transferPath dbus.ObjectPath
+			sessionPath  dbus.ObjectPath
+			done         bool
+		}
 	}
 }
 
@@ -160,11 +196,14 @@ func newBluetooth(service *dbusutil.Service) (b *Bluetooth) {
 		systemSigLoop: dbusutil.NewSignalLoop(systemConn, 10),
 	}
 	b.adapters = make(map[dbus.ObjectPath]*adapter)
+	b.autoConnector = newAutoConnector(b)
 	return
 }
 
 func (b *Bluetooth) destroy() {
 	b.agent.destroy()
+	b.obexAgent.destroy()
+	b.autoConnector.resetAll()
 
 	b.objectManager.RemoveHandler(proxy.RemoveAllHandlers)
 	b.sysDBusDaemon.RemoveHandler(proxy.RemoveAllHandlers)
@@ -215,6 +254,7 @@ func (b *Bluetooth) init() {
 	b.objectManager.ConnectInterfacesRemoved(b.handleInterfacesRemoved)
 
 	b.agent.init()
+	b.obexAgent.init()
 	b.loadObjects()
 
 	b.config.clearSpareConfig(b)
@@ -289,7 +329,16 @@ func (b *Bluetooth) handleInterfacesAdded(path dbus.ObjectPath, data map[string]
 		b.addAdapter(path)
 	}
 	if _, ok := data[bluezDeviceDBusInterface]; ok {
+		exists := b.isDeviceExists(path)
 		b.addDevice(path)
+		if d, err := b.getDevice(path); err == nil && !exists {
+			go b.handleDeviceAppeared(d)
+		}
+	}
+	if _, ok := data[bluezBatteryDBusInterface]; ok {
+		if d, err := b.getDevice(path); err == nil {
+			d.updateBattery()
+		}
 	}
 }
 
@@ -299,6 +348,10 @@ func (b *Bluetooth) handleInterfacesRemoved(path dbus.ObjectPath, interfaces []s
 	}
 	if isStringInArray(bluezDeviceDBusInterface, interfaces) {
 		b.removeDevice(path)
+	} else if isStringInArray(bluezBatteryDBusInterface, interfaces) {
+		if d, err := b.getDevice(path); err == nil {
+			d.setBattery(-1)
+		}
 	}
 }
 
@@ -339,11 +392,14 @@ func (b *Bluetooth) addDevice(dpath dbus.ObjectPath) {
 
 	b.config.addDeviceConfig(d.getAddress())
 
+	d.Priority = b.config.getDeviceConfigPriority(d.getAddress())
+	d.AutoConnect = b.config.getDeviceConfigAutoConnect(d.getAddress())
+
 	b.devicesLock.Lock()
 	b.devices[d.AdapterPath] = append(b.devices[d.AdapterPath], d)
 	b.devicesLock.Unlock()
 
-	connected := b.config.getDeviceConfigConnected(d.getAddress())
+	connected := b.config.getDeviceConfigConnected(d.getAddress()) && d.AutoConnect
 	if connected {
 		time.AfterFunc(25*time.Second, func() {
 			d, _ := b.getDevice(dpath)
@@ -588,24 +644,13 @@ func (b *Bluetooth) updateState() {
 }
 
 func (b *Bluetooth) tryConnectPairedDevices() {
-	var devList = b.getPairedDeviceList()
+	var devList = b.getAutoConnectDevices()
 	for _, dev := range devList {
 		logger.Info("[DEBUG] Auto connect device:", dev.Path)
-		obj, err := b.getDevice(dev.Path)
-		if err != nil {
-			logger.Warning("failed to get device for auto connect:", dev.String(), err)
-			continue
-		}
-
-		// if device using LE mode, will suspend, try connect should be failed, filter it.
-		if !b.isBREDRDevice(dev) {
+		if b.autoConnector.isRetrying(dev.Path) {
 			continue
 		}
-		logger.Debug("Will auto connect device:", obj.String(), obj.adapter.address, obj.Address)
-		err = obj.doConnect(false)
-		if err != nil {
-			logger.Debug("failed to connect:", obj.String(), err)
-		}
+		b.autoConnector.connect(dev)
 	}
 }
 
@@ -1,7 +1,10 @@
 package bluetooth
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	dbus "pkg.deepin.io/lib/dbus1"
 	"pkg.deepin.io/lib/dbusutil"
@@ -289,3 +292,169 @@ func (b *Bluetooth) ClearUnpairedDevice() *dbus.Error {
 	}
 	return nil
 }
+
+// SendFiles sends files to the device by OBEX object push, the transfers
+// are reported by the TransferCreated, TransferProgress and TransferRemoved
+// signals.
+func (b *Bluetooth) SendFiles(devPath dbus.ObjectPath, files []string) (dbus.ObjectPath, *dbus.Error) {
+	logger.Infof("SendFiles %q %v", devPath, files)
+	if len(files) == 0 {
+		return "", dbusutil.ToError(errors.New("files is empty"))
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", dbusutil.ToError(err)
+		}
+		if info.IsDir() {
+			return "", dbusutil.ToError(fmt.Errorf("%q is a directory", file))
+		}
+	}
+
+	d, err := b.getDevice(devPath)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	sessionPath, err := b.obexAgent.createSession(d)
+	if err != nil {
+		logger.Warning("failed to create obex session:", err)
+		return "", dbusutil.ToError(err)
+	}
+	b.emitObexSessionCreated(sessionPath)
+
+	go b.obexAgent.sendFiles(sessionPath, files)
+	return sessionPath, nil
+}
+
+// CancelTransferSession cancels the session created by SendFiles.
+func (b *Bluetooth) CancelTransferSession(sessionPath dbus.ObjectPath) *dbus.Error {
+	logger.Info("CancelTransferSession", sessionPath)
+	err := b.obexAgent.cancelSession(sessionPath)
+	return dbusutil.ToError(err)
+}
+
+// GetReceiveFileDir returns the directory to save the received files.
+func (b *Bluetooth) GetReceiveFileDir() (string, *dbus.Error) {
+	return b.config.getReceiveFileDir(), nil
+}
+
+func (b *Bluetooth) SetReceiveFileDir(dir string) *dbus.Error {
+	if !filepath.IsAbs(dir) {
+		return dbusutil.ToError(fmt.Errorf("%q is not an absolute path", dir))
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if !info.IsDir() {
+		return dbusutil.ToError(fmt.Errorf("%q is not a directory", dir))
+	}
+	b.config.setReceiveFileDir(dir)
+	return nil
+}
+
+// SetDevicePriority sets the priority of auto connection, devices of higher
+// priority are connected first, and disconnected last when the number of
+// A2DP sink devices exceeds the limit.
+func (b *Bluetooth) SetDevicePriority(devPath dbus.ObjectPath, priority int32) *dbus.Error {
+	d, err := b.getDevice(devPath)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	b.config.setDeviceConfigPriority(d.getAddress(), int(priority))
+	d.Priority = int(priority)
+	d.notifyDevicePropertiesChanged()
+	return nil
+}
+
+func (b *Bluetooth) SetDeviceAutoConnect(devPath dbus.ObjectPath, autoConnect bool) *dbus.Error {
+	d, err := b.getDevice(devPath)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	b.config.setDeviceConfigAutoConnect(d.getAddress(), autoConnect)
+	d.AutoConnect = autoConnect
+	if !autoConnect {
+		b.autoConnector.reset(devPath)
+	}
+	d.notifyDevicePropertiesChanged()
+	return nil
+}
+
+type connectRuleInfo struct {
+	Trigger string
+	Target  string
+}
+
+// GetConnectRules returns the rules that connect the target device when the
+// trigger device appears, devices are identified by "adapter address/device
+// address".
+func (b *Bluetooth) GetConnectRules() (string, *dbus.Error) {
+	rules := b.config.getConnectRules()
+	result := make([]connectRuleInfo, len(rules))
+	for idx, rule := range rules {
+		result[idx] = connectRuleInfo{Trigger: rule.Trigger, Target: rule.Target}
+	}
+	return marshalJSON(result), nil
+}
+
+// AddConnectRule connects the target device when the trigger device is added
+// by bluez, comes into range while discovering, or is connected.
+func (b *Bluetooth) AddConnectRule(trigger, target dbus.ObjectPath) *dbus.Error {
+	triggerDevice, err := b.getDevice(trigger)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	targetDevice, err := b.getDevice(target)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if trigger == target {
+		return dbusutil.ToError(errors.New("trigger and target are the same device"))
+	}
+	b.config.addConnectRule(triggerDevice.getAddress(), targetDevice.getAddress())
+	return nil
+}
+
+func (b *Bluetooth) RemoveConnectRule(trigger, target dbus.ObjectPath) *dbus.Error {
+	triggerDevice, err := b.getDevice(trigger)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	targetDevice, err := b.getDevice(target)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if !b.config.removeConnectRule(triggerDevice.getAddress(), targetDevice.getAddress()) {
+		return dbusutil.ToError(errors.New("rule not found"))
+	}
+	return nil
+}
+
+func (b *Bluetooth) GetMaxA2DPSinks() (int32, *dbus.Error) {
+	return int32(b.config.getMaxA2DPSinks()), nil
+}
+
+// SetMaxA2DPSinks sets the max number of connected A2DP sink devices.
+func (b *Bluetooth) SetMaxA2DPSinks(n int32) *dbus.Error {
+	if n < 1 {
+		return dbusutil.ToError(fmt.Errorf("invalid number: %d", n))
+	}
+	b.config.setMaxA2DPSinks(int(n))
+	return nil
+}
+
+// SetDeviceAudioProfile switches the connected audio device to the profile,
+// which is one of the AvailableAudioProfiles of the device: "a2dp" for high
+// quality playback, "hfp" for headset mode with microphone.
+func (b *Bluetooth) SetDeviceAudioProfile(devPath dbus.ObjectPath, profile string) *dbus.Error {
+	d, err := b.getDevice(devPath)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = d.setAudioProfile(profile)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
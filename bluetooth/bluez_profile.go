@@ -26,6 +26,14 @@ type profile struct {
 }
 
 var profiles = []profile{
+	profile{A2DP_SOURCE_UUID, Tr("Audio source")},
+	profile{A2DP_SINK_UUID, Tr("Audio sink")},
+	profile{AVRCP_REMOTE_UUID, Tr("Remote control")},
+	profile{AVRCP_TARGET_UUID, Tr("Remote control target")},
+	profile{HSP_HS_UUID, Tr("Headset")},
+	profile{HID_UUID, Tr("Human interface device")},
+	profile{PANU_UUID, Tr("Personal area network user")},
+	profile{NAP_UUID, Tr("Network access point")},
 	profile{SPP_UUID, Tr("Serial port")},
 	profile{DUN_GW_UUID, Tr("Dial-Up networking")},
 	profile{HFP_HS_UUID, Tr("Hands-Free device")},
@@ -39,3 +47,14 @@ var profiles = []profile{
 	profile{OBEX_MAS_UUID, Tr("Message access")},
 	profile{OBEX_MNS_UUID, Tr("Message notification")},
 }
+
+// getProfileNames returns the names of the known profiles in uuids
+func getProfileNames(uuids []string) []string {
+	var names []string
+	for _, p := range profiles {
+		if isStringInArray(p.uuid, uuids) {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
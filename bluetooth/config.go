@@ -23,6 +23,7 @@ import (
 	"strings"
 
 	"pkg.deepin.io/lib/utils"
+	"pkg.deepin.io/lib/xdg/userdir"
 )
 
 type config struct {
@@ -32,6 +33,14 @@ type config struct {
 	Devices  map[string]*deviceConfig  // use adapter address/device address as key
 
 	Discoverable bool `json:"discoverable"`
+
+	// directory to save the files received by OBEX object push
+	ReceiveFileDir string `json:"receiveFileDir"`
+
+	// connect the target device when the trigger device appears
+	ConnectRules []*connectRule `json:"connectRules"`
+	// max number of the connected A2DP sink devices
+	MaxA2DPSinks int `json:"maxA2DPSinks"`
 }
 
 type adapterConfig struct {
@@ -40,6 +49,16 @@ type adapterConfig struct {
 
 type deviceConfig struct {
 	Connected bool
+	// devices of higher priority are connected first, and disconnected last
+	// when there are too many A2DP sink devices
+	Priority int
+	// do not connect the device automatically
+	DisableAutoConnect bool
+}
+
+type connectRule struct {
+	Trigger string // use adapter address/device address
+	Target  string // use adapter address/device address
 }
 
 func newConfig() (c *config) {
@@ -49,6 +68,7 @@ func newConfig() (c *config) {
 	c.Adapters = make(map[string]*adapterConfig)
 	c.Devices = make(map[string]*deviceConfig)
 	c.Discoverable = true
+	c.MaxA2DPSinks = 1
 	c.load()
 	return
 }
@@ -114,6 +134,17 @@ func (c *config) clearSpareConfig(b *Bluetooth) {
 		}
 	}
 
+	// remove rules of spare devices
+	var rules []*connectRule
+	for _, rule := range c.ConnectRules {
+		_, triggerOk := c.Devices[rule.Trigger]
+		_, targetOk := c.Devices[rule.Target]
+		if triggerOk && targetOk {
+			rules = append(rules, rule)
+		}
+	}
+	c.ConnectRules = rules
+
 	c.core.Unlock()
 }
 
@@ -228,3 +259,131 @@ func (c *config) setDeviceConfigConnected(address string, connected bool) {
 	c.save()
 	return
 }
+
+func (c *config) getReceiveFileDir() string {
+	c.core.Lock()
+	dir := c.ReceiveFileDir
+	c.core.Unlock()
+	if dir == "" {
+		dir = userdir.Get(userdir.Download)
+	}
+	return dir
+}
+
+func (c *config) setReceiveFileDir(dir string) {
+	c.core.Lock()
+	c.ReceiveFileDir = dir
+	c.core.Unlock()
+	c.save()
+}
+
+func (c *config) getDeviceConfigPriority(address string) int {
+	dc, ok := c.getDeviceConfig(address)
+	if !ok {
+		return 0
+	}
+
+	c.core.Lock()
+	defer c.core.Unlock()
+	return dc.Priority
+}
+
+func (c *config) setDeviceConfigPriority(address string, priority int) {
+	dc, ok := c.getDeviceConfig(address)
+	if !ok {
+		return
+	}
+
+	c.core.Lock()
+	dc.Priority = priority
+	c.core.Unlock()
+	c.save()
+}
+
+func (c *config) getDeviceConfigAutoConnect(address string) bool {
+	dc, ok := c.getDeviceConfig(address)
+	if !ok {
+		return true
+	}
+
+	c.core.Lock()
+	defer c.core.Unlock()
+	return !dc.DisableAutoConnect
+}
+
+func (c *config) setDeviceConfigAutoConnect(address string, autoConnect bool) {
+	dc, ok := c.getDeviceConfig(address)
+	if !ok {
+		return
+	}
+
+	c.core.Lock()
+	dc.DisableAutoConnect = !autoConnect
+	c.core.Unlock()
+	c.save()
+}
+
+func (c *config) getConnectRules() []connectRule {
+	c.core.Lock()
+	defer c.core.Unlock()
+	rules := make([]connectRule, len(c.ConnectRules))
+	for idx, rule := range c.ConnectRules {
+		rules[idx] = *rule
+	}
+	return rules
+}
+
+func (c *config) getConnectRuleTargets(trigger string) []string {
+	c.core.Lock()
+	defer c.core.Unlock()
+	var targets []string
+	for _, rule := range c.ConnectRules {
+		if rule.Trigger == trigger {
+			targets = append(targets, rule.Target)
+		}
+	}
+	return targets
+}
+
+func (c *config) addConnectRule(trigger, target string) {
+	c.core.Lock()
+	for _, rule := range c.ConnectRules {
+		if rule.Trigger == trigger && rule.Target == target {
+			c.core.Unlock()
+			return
+		}
+	}
+	c.ConnectRules = append(c.ConnectRules, &connectRule{Trigger: trigger, Target: target})
+	c.core.Unlock()
+	c.save()
+}
+
+func (c *config) removeConnectRule(trigger, target string) bool {
+	c.core.Lock()
+	for idx, rule := range c.ConnectRules {
+		if rule.Trigger == trigger && rule.Target == target {
+			c.ConnectRules = append(c.ConnectRules[:idx], c.ConnectRules[idx+1:]...)
+			c.core.Unlock()
+			c.save()
+			return true
+		}
+	}
+	c.core.Unlock()
+	return false
+}
+
+func (c *config) getMaxA2DPSinks() int {
+	c.core.Lock()
+	defer c.core.Unlock()
+	if c.MaxA2DPSinks < 1 {
+		return 1
+	}
+	return c.MaxA2DPSinks
+}
+
+func (c *config) setMaxA2DPSinks(n int) {
+	c.core.Lock()
+	c.MaxA2DPSinks = n
+	c.core.Unlock()
+	c.save()
+}
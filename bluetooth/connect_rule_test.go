@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package bluetooth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	dbus "pkg.deepin.io/lib/dbus1"
+)
+
+func newTestConfig(t *testing.T) (*config, func()) {
+	dir, err := ioutil.TempDir("", "bluetooth")
+	require.NoError(t, err)
+	c := &config{
+		Adapters: make(map[string]*adapterConfig),
+		Devices:  make(map[string]*deviceConfig),
+	}
+	c.core.SetConfigFile(filepath.Join(dir, "bluetooth.json"))
+	return c, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConnectRules(t *testing.T) {
+	c, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	const (
+		dock     = "00:11:22:33:44:55/AA:AA:AA:AA:AA:01"
+		headset  = "00:11:22:33:44:55/AA:AA:AA:AA:AA:02"
+		keyboard = "00:11:22:33:44:55/AA:AA:AA:AA:AA:03"
+	)
+	c.addConnectRule(dock, headset)
+	c.addConnectRule(dock, keyboard)
+	c.addConnectRule(headset, keyboard)
+	// the same rule is added only once
+	c.addConnectRule(dock, headset)
+
+	assert.Len(t, c.getConnectRules(), 3)
+	assert.Equal(t, []string{headset, keyboard}, c.getConnectRuleTargets(dock))
+	assert.Equal(t, []string{keyboard}, c.getConnectRuleTargets(headset))
+	assert.Empty(t, c.getConnectRuleTargets(keyboard))
+
+	assert.True(t, c.removeConnectRule(dock, headset))
+	assert.False(t, c.removeConnectRule(dock, headset))
+	assert.Equal(t, []string{keyboard}, c.getConnectRuleTargets(dock))
+
+	// the rules are saved
+	c1 := &config{}
+	c1.core.SetConfigFile(c.core.GetConfigFile())
+	c1.load()
+	assert.Equal(t, c.getConnectRules(), c1.getConnectRules())
+}
+
+func TestGetDeviceByConfigAddress(t *testing.T) {
+	a0 := &adapter{address: "00:11:22:33:44:55", Path: "/org/bluez/hci0"}
+	a1 := &adapter{address: "00:11:22:33:44:66", Path: "/org/bluez/hci1"}
+	// the same device is seen by both adapters
+	d0 := &device{adapter: a0, Address: "AA:AA:AA:AA:AA:01"}
+	d1 := &device{adapter: a1, Address: "AA:AA:AA:AA:AA:01"}
+	b := &Bluetooth{
+		devices: map[dbus.ObjectPath][]*device{
+			a0.Path: {d0},
+			a1.Path: {d1},
+		},
+	}
+
+	assert.Equal(t, d0, b.getDeviceByConfigAddress("00:11:22:33:44:55/AA:AA:AA:AA:AA:01"))
+	assert.Equal(t, d1, b.getDeviceByConfigAddress("00:11:22:33:44:66/AA:AA:AA:AA:AA:01"))
+	assert.Nil(t, b.getDeviceByConfigAddress("00:11:22:33:44:55/AA:AA:AA:AA:AA:02"))
+}
+
+func TestGetAutoConnectDelay(t *testing.T) {
+	assert.Equal(t, autoConnectBaseDelay, getAutoConnectDelay(1))
+	assert.Equal(t, 2*autoConnectBaseDelay, getAutoConnectDelay(2))
+	assert.Equal(t, 4*autoConnectBaseDelay, getAutoConnectDelay(3))
+	assert.Equal(t, autoConnectMaxDelay, getAutoConnectDelay(10))
+	for count := 1; count < 20; count++ {
+		assert.True(t, getAutoConnectDelay(count) <= autoConnectMaxDelay)
+	}
+}
+
+func TestAutoConnectorRetry(t *testing.T) {
+	const devPath = dbus.ObjectPath("/org/bluez/hci0/dev_AA_AA_AA_AA_AA_01")
+	ac := newAutoConnector(nil)
+
+	for i := 1; i <= autoConnectMaxRetry; i++ {
+		ac.scheduleRetry(devPath)
+		assert.True(t, ac.isRetrying(devPath))
+		assert.Equal(t, i, ac.retries[devPath].count)
+	}
+	// give up after too many retries
+	ac.scheduleRetry(devPath)
+	assert.False(t, ac.isRetrying(devPath))
+
+	ac.scheduleRetry(devPath)
+	assert.True(t, ac.isRetrying(devPath))
+	ac.reset(devPath)
+	assert.False(t, ac.isRetrying(devPath))
+
+	ac.scheduleRetry(devPath)
+	ac.scheduleRetry("/org/bluez/hci0/dev_AA_AA_AA_AA_AA_02")
+	ac.resetAll()
+	assert.Empty(t, ac.retries)
+}
@@ -56,6 +56,15 @@ var (
 	errInvalidDevicePath = fmt.Errorf("invalid device path")
 )
 
+// at most rssiHistoryMaxLen RSSI samples are kept for each device
+const rssiHistoryMaxLen = 20
+
+// RSSISample is the RSSI of the device and the unix time when it is reported.
+type RSSISample struct {
+	Time int64
+	RSSI int16
+}
+
 type device struct {
 	core    *bluez.Device
 	adapter *adapter
@@ -69,11 +78,28 @@ type device struct {
 	State            deviceState
 	ServicesResolved bool
 	// optional
-	UUIDs   []string
-	Name    string
-	Icon    string
-	RSSI    int16
-	Address string
+	UUIDs []string
+	Name  string
+	Icon  string
+	RSSI  int16
+	// the latest RSSI samples, oldest first
+	RSSIHistory []RSSISample
+	Address     string
+	Class       uint32
+	// names of the supported profiles
+	Profiles []string
+	// audio profiles which can be switched to, "a2dp" and "hfp"
+	AvailableAudioProfiles []string
+	// battery percentage, -1 if the device does not report it
+	Battery int
+	// auto connection policy
+	Priority    int
+	AutoConnect bool
+
+	sigLoop            *dbusutil.SignalLoop
+	batteryRule        dbusutil.MatchRule
+	batteryHandlerId   dbusutil.SignalHandlerId
+	lowBatteryNotified bool
 
 	connected         bool
 	connectedTime     time.Time
@@ -178,7 +204,7 @@ func (d *device) String() string {
 }
 
 func newDevice(systemSigLoop *dbusutil.SignalLoop, dpath dbus.ObjectPath) (d *device) {
-	d = &device{Path: dpath}
+	d = &device{Path: dpath, sigLoop: systemSigLoop}
 	systemConn := systemSigLoop.Conn()
 	d.core, _ = bluez.NewDevice(systemConn, dpath)
 	d.AdapterPath, _ = d.core.Adapter().Get(0)
@@ -189,18 +215,38 @@ func newDevice(systemSigLoop *dbusutil.SignalLoop, dpath dbus.ObjectPath) (d *de
 	d.Paired, _ = d.core.Paired().Get(0)
 	d.connected, _ = d.core.Connected().Get(0)
 	d.UUIDs, _ = d.core.UUIDs().Get(0)
+	d.Profiles = getProfileNames(d.UUIDs)
+	d.AvailableAudioProfiles = getAudioProfiles(d.UUIDs)
+	d.Class, _ = d.core.Class().Get(0)
 	d.ServicesResolved, _ = d.core.ServicesResolved().Get(0)
 	d.Icon, _ = d.core.Icon().Get(0)
 	d.RSSI, _ = d.core.RSSI().Get(0)
+	if d.RSSI != 0 {
+		d.addRSSISample(d.RSSI, time.Now())
+	}
 	d.updateState()
 	d.disconnectChan = make(chan struct{})
 	d.core.InitSignalExt(systemSigLoop, true)
 	d.connectProperties()
+	d.initBattery()
 	return
 }
 
+func (d *device) addRSSISample(rssi int16, now time.Time) {
+	history := append(d.RSSIHistory, RSSISample{Time: now.Unix(), RSSI: rssi})
+	if len(history) > rssiHistoryMaxLen {
+		history = history[len(history)-rssiHistoryMaxLen:]
+	}
+	d.RSSIHistory = history
+}
+
 func (d *device) destroy() {
 	d.core.RemoveHandler(proxy.RemoveAllHandlers)
+	d.sigLoop.RemoveHandler(d.batteryHandlerId)
+	err := d.batteryRule.RemoveFrom(d.sigLoop.Conn())
+	if err != nil {
+		logger.Warning(err)
+	}
 }
 
 func (d *device) notifyDeviceAdded() {
@@ -244,12 +290,18 @@ func (d *device) connectProperties() {
 			return
 		}
 		logger.Debugf("%s Connected: %v", d, connected)
+		wasConnected := d.connected
 		d.connected = connected
 
 		needNotify := true
 
 		if connected {
 			d.connectedTime = time.Now()
+			globalBluetooth.autoConnector.reset(d.Path)
+			// a paired device which reconnects by itself also triggers the rules
+			if !wasConnected {
+				go globalBluetooth.handleDeviceAppeared(d)
+			}
 		} else {
 			// when disconnected quickly after connecting, automatically try to connect
 			sinceConnected := time.Since(d.connectedTime)
@@ -354,6 +406,8 @@ func (d *device) connectProperties() {
 			return
 		}
 		d.UUIDs = value
+		d.Profiles = getProfileNames(value)
+		d.AvailableAudioProfiles = getAudioProfiles(value)
 		logger.Debugf("%s UUIDs: %v", d, value)
 		d.notifyDevicePropertiesChanged()
 	})
@@ -363,9 +417,24 @@ func (d *device) connectProperties() {
 			d.RSSI = 0
 			logger.Debugf("%s RSSI invalidated", d)
 		} else {
+			// the RSSI becomes valid when the device comes into range
+			appeared := d.RSSI == 0
 			d.RSSI = value
+			d.addRSSISample(value, time.Now())
 			logger.Debugf("%s RSSI: %v", d, value)
+			if appeared {
+				go globalBluetooth.handleDeviceAppeared(d)
+			}
+		}
+		d.notifyDevicePropertiesChanged()
+	})
+
+	_ = d.core.Class().ConnectChanged(func(hasValue bool, value uint32) {
+		if !hasValue {
+			return
 		}
+		d.Class = value
+		logger.Debugf("%s Class: %#x", d, value)
 		d.notifyDevicePropertiesChanged()
 	})
 
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package bluetooth
+
+import (
+	"errors"
+	"fmt"
+
+	"pkg.deepin.io/dde/daemon/common/bluezaudio"
+	"pkg.deepin.io/lib/pulse"
+)
+
+const (
+	audioProfileA2DP = "a2dp"
+	audioProfileHFP  = "hfp"
+)
+
+// getAudioProfiles returns the audio profiles which can be switched to by
+// SetDeviceAudioProfile, "a2dp" for high quality playback and "hfp" for
+// headset mode with microphone.
+func getAudioProfiles(uuids []string) []string {
+	var result []string
+	if isStringInArray(A2DP_SINK_UUID, uuids) {
+		result = append(result, audioProfileA2DP)
+	}
+	if isStringInArray(HFP_HS_UUID, uuids) || isStringInArray(HSP_HS_UUID, uuids) {
+		result = append(result, audioProfileHFP)
+	}
+	return result
+}
+
+// getCardProfile returns the profile of the pulseaudio card matching the
+// audio profile.
+func getCardProfile(card *pulse.Card, audioProfile string) string {
+	switch audioProfile {
+	case audioProfileA2DP:
+		return bluezaudio.GetA2DPProfile(card)
+	case audioProfileHFP:
+		return bluezaudio.GetHeadsetProfile(card)
+	}
+	return ""
+}
+
+func (d *device) setAudioProfile(audioProfile string) error {
+	if !isStringInArray(audioProfile, d.AvailableAudioProfiles) {
+		return fmt.Errorf("audio profile %q is not available", audioProfile)
+	}
+	if !d.connected {
+		return errors.New("device is not connected")
+	}
+
+	ctx := pulse.GetContext()
+	if ctx == nil {
+		return errors.New("failed to connect pulseaudio server")
+	}
+	for _, card := range ctx.GetCardList() {
+		if card.PropList["bluez.path"] != string(d.Path) {
+			continue
+		}
+		name := getCardProfile(card, audioProfile)
+		if name == "" {
+			return fmt.Errorf("no card profile for audio profile %q", audioProfile)
+		}
+		logger.Debugf("%s set card %s profile %s", d, card.Name, name)
+		card.SetProfile(name)
+		return nil
+	}
+	return errors.New("audio card of device not found")
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package bluetooth
+
+import (
+	"strings"
+
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+)
+
+const (
+	bluezBatteryDBusInterface = "org.bluez.Battery1"
+
+	lowBatteryLevel = 20
+)
+
+func (d *device) initBattery() {
+	d.Battery = d.getBattery()
+
+	// Battery1 properties of this device only
+	d.batteryRule = dbusutil.NewMatchRuleBuilder().
+		ExtPropertiesChanged(string(d.Path), bluezBatteryDBusInterface).
+		Sender(bluezDBusServiceName).Build()
+	err := d.batteryRule.AddTo(d.sigLoop.Conn())
+	if err != nil {
+		logger.Warning(err)
+	}
+	d.batteryHandlerId = d.sigLoop.AddHandler(&dbusutil.SignalRule{
+		Path: d.Path,
+		Name: "org.freedesktop.DBus.Properties.PropertiesChanged",
+	}, func(sig *dbus.Signal) {
+		if len(sig.Body) != 3 {
+			return
+		}
+		ifc, _ := sig.Body[0].(string)
+		if ifc != bluezBatteryDBusInterface {
+			return
+		}
+		props, _ := sig.Body[1].(map[string]dbus.Variant)
+		if v, ok := props["Percentage"]; ok {
+			percentage, _ := v.Value().(byte)
+			d.setBattery(int(percentage))
+		}
+	})
+}
+
+// getBattery reads the battery percentage, the Battery1 interface only
+// exists when the device supports it and is connected.
+func (d *device) getBattery() int {
+	var v dbus.Variant
+	obj := d.sigLoop.Conn().Object(bluezDBusServiceName, d.Path)
+	err := obj.Call("org.freedesktop.DBus.Properties.Get", 0,
+		bluezBatteryDBusInterface, "Percentage").Store(&v)
+	if err != nil {
+		return -1
+	}
+	percentage, _ := v.Value().(byte)
+	return int(percentage)
+}
+
+func (d *device) updateBattery() {
+	d.setBattery(d.getBattery())
+}
+
+func (d *device) setBattery(value int) {
+	d.mu.Lock()
+	if d.Battery == value {
+		d.mu.Unlock()
+		return
+	}
+	d.Battery = value
+	notifyLow := false
+	if value < 0 || value > lowBatteryLevel {
+		d.lowBatteryNotified = false
+	} else if d.connected && !d.lowBatteryNotified && d.isAudioOrInputDevice() {
+		d.lowBatteryNotified = true
+		notifyLow = true
+	}
+	d.mu.Unlock()
+
+	logger.Debugf("%s Battery: %d", d, value)
+	d.notifyDevicePropertiesChanged()
+	if notifyLow {
+		notifyLowBattery(d.Alias, value)
+	}
+}
+
+func (d *device) isAudioOrInputDevice() bool {
+	return strings.HasPrefix(d.Icon, "audio-") || strings.HasPrefix(d.Icon, "input-")
+}
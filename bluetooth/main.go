@@ -99,6 +99,14 @@ func (*daemon) Start() error {
 		return err
 	}
 
+	globalBluetooth.obexAgent = newObexAgent(service)
+	globalBluetooth.obexAgent.b = globalBluetooth
+	err = service.Export(obexAgentDBusPath, globalBluetooth.obexAgent)
+	if err != nil {
+		logger.Warning("failed to export obex agent:", err)
+		return err
+	}
+
 	err = initNotifications()
 	if err != nil {
 		return err
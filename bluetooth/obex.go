@@ -0,0 +1,624 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package bluetooth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ofdbus "github.com/linuxdeepin/go-dbus-factory/org.freedesktop.dbus"
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+	"pkg.deepin.io/lib/dbusutil/proxy"
+	. "pkg.deepin.io/lib/gettext"
+)
+
+const (
+	obexDBusServiceName           = "org.bluez.obex"
+	obexDBusPath                  = "/org/bluez/obex"
+	obexClientDBusInterface       = obexDBusServiceName + ".Client1"
+	obexAgentManagerDBusInterface = obexDBusServiceName + ".AgentManager1"
+	obexSessionDBusInterface      = obexDBusServiceName + ".Session1"
+	obexObjectPushDBusInterface   = obexDBusServiceName + ".ObjectPush1"
+	obexTransferDBusInterface     = obexDBusServiceName + ".Transfer1"
+
+	obexAgentDBusPath      = dbusPath + "/ObexAgent"
+	obexAgentDBusInterface = obexDBusServiceName + ".Agent1"
+
+	transferStatusComplete = "complete"
+	transferStatusError    = "error"
+
+	// obexd waits 60 seconds for the reply of AuthorizePush, the request is
+	// rejected earlier so that the reply is not dropped by obexd
+	obexAgentTimeout   = 60 * time.Second
+	receiveFileTimeout = obexAgentTimeout - 5*time.Second
+)
+
+var (
+	errObexRejected = errors.New("org.bluez.obex.Error.Rejected")
+)
+
+type obexTransfer struct {
+	path        dbus.ObjectPath
+	sessionPath dbus.ObjectPath
+	// local file name
+	file   string
+	size   uint64
+	doneCh chan bool
+}
+
+func newObexTransfer(path, sessionPath dbus.ObjectPath, file string, size uint64) *obexTransfer {
+	return &obexTransfer{
+		path:        path,
+		sessionPath: sessionPath,
+		file:        file,
+		size:        size,
+		doneCh:      make(chan bool, 1),
+	}
+}
+
+func (t *obexTransfer) finish(done bool) {
+	select {
+	case t.doneCh <- done:
+	default:
+	}
+}
+
+type obexSession struct {
+	canceled bool
+	current  *obexTransfer
+	// status of the transfers finished before being watched
+	finished map[dbus.ObjectPath]bool
+}
+
+// obexAgent sends files through obexd and handles the incoming object push
+// requests.
+type obexAgent struct {
+	b          *Bluetooth
+	service    *dbusutil.Service
+	sigLoop    *dbusutil.SignalLoop
+	dbusDaemon *ofdbus.DBus
+	// closed on destroy to stop the goroutines waiting for transfers
+	quit chan struct{}
+
+	mu        sync.Mutex
+	transfers map[dbus.ObjectPath]*obexTransfer
+	sessions  map[dbus.ObjectPath]*obexSession
+	// notification id => response of the incoming request
+	requests map[uint32]chan bool
+
+	methods *struct {
+		AuthorizePush func() `in:"transfer" out:"filename"`
+	}
+}
+
+func newObexAgent(service *dbusutil.Service) *obexAgent {
+	return &obexAgent{
+		service:   service,
+		transfers: make(map[dbus.ObjectPath]*obexTransfer),
+		sessions:  make(map[dbus.ObjectPath]*obexSession),
+		requests:  make(map[uint32]chan bool),
+		quit:      make(chan struct{}),
+	}
+}
+
+func (*obexAgent) GetInterfaceName() string {
+	return obexAgentDBusInterface
+}
+
+func (a *obexAgent) init() {
+	sessionBus := a.service.Conn()
+	a.sigLoop = dbusutil.NewSignalLoop(sessionBus, 10)
+	a.sigLoop.Start()
+
+	err := dbusutil.NewMatchRuleBuilder().
+		Type("signal").
+		Sender(obexDBusServiceName).
+		Interface("org.freedesktop.DBus.Properties").
+		Member("PropertiesChanged").Build().
+		AddTo(sessionBus)
+	if err != nil {
+		logger.Warning(err)
+	}
+	a.sigLoop.AddHandler(&dbusutil.SignalRule{
+		Name: "org.freedesktop.DBus.Properties.PropertiesChanged",
+	}, a.handlePropertiesChanged)
+
+	globalNotifications.InitSignalExt(a.sigLoop, true)
+	_, err = globalNotifications.ConnectActionInvoked(func(id uint32, actionKey string) {
+		a.replyRequest(id, actionKey == "accept")
+	})
+	if err != nil {
+		logger.Warning(err)
+	}
+	_, err = globalNotifications.ConnectNotificationClosed(func(id uint32, reason uint32) {
+		a.replyRequest(id, false)
+	})
+	if err != nil {
+		logger.Warning(err)
+	}
+
+	a.dbusDaemon = ofdbus.NewDBus(sessionBus)
+	a.dbusDaemon.InitSignalExt(a.sigLoop, true)
+	_, err = a.dbusDaemon.ConnectNameOwnerChanged(a.handleDBusNameOwnerChanged)
+	if err != nil {
+		logger.Warning(err)
+	}
+
+	a.registerAgent()
+}
+
+func (a *obexAgent) handleDBusNameOwnerChanged(name, oldOwner, newOwner string) {
+	if name != obexDBusServiceName {
+		return
+	}
+	if newOwner != "" {
+		// the agent registered to the previous obexd is gone
+		logger.Info("obexd started, register obex agent")
+		go a.registerAgent()
+		return
+	}
+
+	// the transfers of obexd are gone, finish them so that the waiters return
+	logger.Info("obexd stopped")
+	a.mu.Lock()
+	transfers := a.transfers
+	a.transfers = make(map[dbus.ObjectPath]*obexTransfer)
+	for _, session := range a.sessions {
+		session.canceled = true
+		if session.current != nil {
+			transfers[session.current.path] = session.current
+		}
+	}
+	a.mu.Unlock()
+	for _, t := range transfers {
+		t.finish(false)
+	}
+}
+
+func (a *obexAgent) registerAgent() {
+	obj := a.service.Conn().Object(obexDBusServiceName, obexDBusPath)
+	err := obj.Call(obexAgentManagerDBusInterface+".RegisterAgent", 0,
+		dbus.ObjectPath(obexAgentDBusPath)).Err
+	if err != nil {
+		logger.Warning("failed to register obex agent:", err)
+	}
+}
+
+func (a *obexAgent) destroy() {
+	obj := a.service.Conn().Object(obexDBusServiceName, obexDBusPath)
+	err := obj.Call(obexAgentManagerDBusInterface+".UnregisterAgent", 0,
+		dbus.ObjectPath(obexAgentDBusPath)).Err
+	if err != nil {
+		logger.Warning(err)
+	}
+
+	close(a.quit)
+	if a.sigLoop != nil {
+		globalNotifications.RemoveHandler(proxy.RemoveAllHandlers)
+		a.dbusDaemon.RemoveHandler(proxy.RemoveAllHandlers)
+		a.sigLoop.Stop()
+	}
+
+	err = a.service.StopExport(a)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+/*****************************************************************************/
+
+// Release method gets called when obexd unregisters the agent.
+func (a *obexAgent) Release() *dbus.Error {
+	logger.Info("obex agent Release()")
+	return nil
+}
+
+// AuthorizePush method gets called when obexd needs to accept or reject an
+// object push request. It returns the full path of the file to be saved.
+// Possible errors: org.bluez.obex.Error.Rejected
+func (a *obexAgent) AuthorizePush(transferPath dbus.ObjectPath) (string, *dbus.Error) {
+	logger.Info("AuthorizePush()", transferPath)
+	deadline := time.Now().Add(receiveFileTimeout)
+
+	conn := a.service.Conn()
+	transferObj := conn.Object(obexDBusServiceName, transferPath)
+	var name string
+	var size uint64
+	var sessionPath dbus.ObjectPath
+	err := getObexProperty(transferObj, obexTransferDBusInterface, "Name", &name)
+	if err == nil {
+		err = getObexProperty(transferObj, obexTransferDBusInterface, "Size", &size)
+	}
+	if err == nil {
+		err = getObexProperty(transferObj, obexTransferDBusInterface, "Session", &sessionPath)
+	}
+	if err != nil {
+		logger.Warning(err)
+		return "", dbusutil.ToError(err)
+	}
+
+	var address string
+	sessionObj := conn.Object(obexDBusServiceName, sessionPath)
+	err = getObexProperty(sessionObj, obexSessionDBusInterface, "Destination", &address)
+	if err != nil {
+		logger.Warning(err)
+	}
+	alias := a.b.getDeviceAliasByAddress(address)
+
+	if !a.requestReceive(alias, name, deadline) {
+		logger.Infof("reject file %q from %s", name, alias)
+		return "", dbusutil.ToError(errObexRejected)
+	}
+
+	dir := a.b.config.getReceiveFileDir()
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		logger.Warning(err)
+		return "", dbusutil.ToError(err)
+	}
+	filename := getUniqueFilename(dir, name)
+
+	t := newObexTransfer(transferPath, sessionPath, filename, size)
+	a.mu.Lock()
+	a.transfers[transferPath] = t
+	a.mu.Unlock()
+	a.b.emitTransferCreated(filename, transferPath, sessionPath)
+
+	go func() {
+		select {
+		case done := <-t.doneCh:
+			a.b.emitTransferRemoved(filename, transferPath, sessionPath, done)
+			notifyReceiveFileFinished(alias, filepath.Base(filename), done)
+		case <-a.quit:
+		}
+	}()
+	return filename, nil
+}
+
+// Cancel method gets called to indicate that the agent request failed before
+// a reply was returned.
+func (a *obexAgent) Cancel() *dbus.Error {
+	logger.Info("obex agent Cancel()")
+	a.mu.Lock()
+	for _, ch := range a.requests {
+		select {
+		case ch <- false:
+		default:
+		}
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+/*****************************************************************************/
+
+func getObexProperty(obj dbus.BusObject, ifc, name string, value interface{}) error {
+	var v dbus.Variant
+	err := obj.Call("org.freedesktop.DBus.Properties.Get", 0, ifc, name).Store(&v)
+	if err != nil {
+		return err
+	}
+	return dbus.Store([]interface{}{v.Value()}, value)
+}
+
+// getUniqueFilename returns a file path in dir which does not exist, the
+// name is sent by the remote device, so only the base name is used.
+func getUniqueFilename(dir, name string) string {
+	name = filepath.Base(name)
+	if name == "." || name == "/" || name == ".." {
+		name = "file"
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	filename := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		_, err := os.Lstat(filename)
+		if os.IsNotExist(err) {
+			return filename
+		}
+		filename = filepath.Join(dir, fmt.Sprintf("%s(%d)%s", base, i, ext))
+	}
+}
+
+// requestReceive asks the user whether to receive the file through the
+// notification, the request is rejected if the user does not answer before
+// deadline.
+func (a *obexAgent) requestReceive(alias, name string, deadline time.Time) bool {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return false
+	}
+	ch := make(chan bool, 1)
+	actions := []string{"accept", Tr("Receive"), "reject", Tr("Decline")}
+	body := fmt.Sprintf(Tr("%q wants to send %q to you"), alias, name)
+	nid, err := globalNotifications.Notify(0, "dde-control-center", 0,
+		notifyIconBluetoothConnected, Tr("Bluetooth File Transfer"), body,
+		actions, nil, int32(timeout/time.Millisecond))
+	if err != nil {
+		logger.Warning(err)
+		return false
+	}
+
+	a.mu.Lock()
+	a.requests[nid] = ch
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.requests, nid)
+		a.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case accept := <-ch:
+		return accept
+	case <-timer.C:
+		logger.Info("receive request timeout")
+		err = globalNotifications.CloseNotification(0, nid)
+		if err != nil {
+			logger.Warning(err)
+		}
+		return false
+	case <-a.quit:
+		return false
+	}
+}
+
+func (a *obexAgent) replyRequest(nid uint32, accept bool) {
+	a.mu.Lock()
+	ch, ok := a.requests[nid]
+	a.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- accept:
+	default:
+	}
+}
+
+func (a *obexAgent) handlePropertiesChanged(sig *dbus.Signal) {
+	if len(sig.Body) != 3 {
+		return
+	}
+	ifc, _ := sig.Body[0].(string)
+	if ifc != obexTransferDBusInterface {
+		return
+	}
+	props, _ := sig.Body[1].(map[string]dbus.Variant)
+
+	var status string
+	if v, ok := props["Status"]; ok {
+		status, _ = v.Value().(string)
+	}
+	finished := status == transferStatusComplete || status == transferStatusError
+
+	a.mu.Lock()
+	t, ok := a.transfers[sig.Path]
+	if !ok {
+		// the transfer of our session may finish before SendFile returns
+		if finished {
+			for sessionPath, session := range a.sessions {
+				if strings.HasPrefix(string(sig.Path), string(sessionPath)+"/") {
+					session.finished[sig.Path] = status == transferStatusComplete
+					break
+				}
+			}
+		}
+		a.mu.Unlock()
+		return
+	}
+	if finished {
+		delete(a.transfers, sig.Path)
+	}
+	a.mu.Unlock()
+
+	if v, ok := props["Transferred"]; ok {
+		transferred, _ := v.Value().(uint64)
+		a.b.emitTransferProgress(t.path, transferred, t.size)
+	}
+	if finished {
+		logger.Debugf("transfer %s finished, status: %s", t.path, status)
+		t.finish(status == transferStatusComplete)
+	}
+}
+
+func (a *obexAgent) createSession(d *device) (dbus.ObjectPath, error) {
+	args := map[string]dbus.Variant{
+		"Target": dbus.MakeVariant("opp"),
+		"Source": dbus.MakeVariant(d.adapter.address),
+	}
+	var sessionPath dbus.ObjectPath
+	obj := a.service.Conn().Object(obexDBusServiceName, obexDBusPath)
+	err := obj.Call(obexClientDBusInterface+".CreateSession", 0, d.Address, args).
+		Store(&sessionPath)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.sessions[sessionPath] = &obexSession{
+		finished: make(map[dbus.ObjectPath]bool),
+	}
+	a.mu.Unlock()
+	return sessionPath, nil
+}
+
+func (a *obexAgent) removeSession(sessionPath dbus.ObjectPath) {
+	a.mu.Lock()
+	delete(a.sessions, sessionPath)
+	a.mu.Unlock()
+
+	obj := a.service.Conn().Object(obexDBusServiceName, obexDBusPath)
+	err := obj.Call(obexClientDBusInterface+".RemoveSession", 0, sessionPath).Err
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+func (a *obexAgent) cancelSession(sessionPath dbus.ObjectPath) error {
+	a.mu.Lock()
+	session, ok := a.sessions[sessionPath]
+	if !ok {
+		a.mu.Unlock()
+		return fmt.Errorf("invalid session path: %s", sessionPath)
+	}
+	session.canceled = true
+	current := session.current
+	a.mu.Unlock()
+
+	if current == nil {
+		return nil
+	}
+	obj := a.service.Conn().Object(obexDBusServiceName, current.path)
+	err := obj.Call(obexTransferDBusInterface+".Cancel", 0).Err
+	if err != nil {
+		return err
+	}
+	current.finish(false)
+	return nil
+}
+
+func (a *obexAgent) pushFile(sessionPath dbus.ObjectPath, file string) (*obexTransfer, error) {
+	var transferPath dbus.ObjectPath
+	var props map[string]dbus.Variant
+	obj := a.service.Conn().Object(obexDBusServiceName, sessionPath)
+	err := obj.Call(obexObjectPushDBusInterface+".SendFile", 0, file).
+		Store(&transferPath, &props)
+	if err != nil {
+		return nil, err
+	}
+	var size uint64
+	if v, ok := props["Size"]; ok {
+		size, _ = v.Value().(uint64)
+	}
+	t := newObexTransfer(transferPath, sessionPath, file, size)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	session, ok := a.sessions[sessionPath]
+	if !ok {
+		return nil, errors.New("session removed")
+	}
+	session.current = t
+	if done, ok := session.finished[transferPath]; ok {
+		delete(session.finished, transferPath)
+		t.finish(done)
+	} else {
+		a.transfers[transferPath] = t
+	}
+	return t, nil
+}
+
+func (a *obexAgent) isSessionCanceled(sessionPath dbus.ObjectPath) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	session, ok := a.sessions[sessionPath]
+	return !ok || session.canceled
+}
+
+func (a *obexAgent) sendFiles(sessionPath dbus.ObjectPath, files []string) {
+	defer func() {
+		a.removeSession(sessionPath)
+		a.b.emitObexSessionRemoved(sessionPath)
+	}()
+
+	for _, file := range files {
+		if a.isSessionCanceled(sessionPath) {
+			return
+		}
+		t, err := a.pushFile(sessionPath, file)
+		if err != nil {
+			logger.Warningf("failed to send file %q: %v", file, err)
+			a.b.emitTransferRemoved(file, "", sessionPath, false)
+			return
+		}
+		a.b.emitTransferCreated(file, t.path, sessionPath)
+		var done bool
+		select {
+		case done = <-t.doneCh:
+		case <-a.quit:
+			return
+		}
+
+		a.mu.Lock()
+		delete(a.transfers, t.path)
+		a.mu.Unlock()
+		a.b.emitTransferRemoved(file, t.path, sessionPath, done)
+		if !done {
+			return
+		}
+	}
+}
+
+func (b *Bluetooth) emitObexSessionCreated(sessionPath dbus.ObjectPath) {
+	err := b.service.Emit(b, "ObexSessionCreated", sessionPath)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+func (b *Bluetooth) emitObexSessionRemoved(sessionPath dbus.ObjectPath) {
+	err := b.service.Emit(b, "ObexSessionRemoved", sessionPath)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+func (b *Bluetooth) emitTransferCreated(file string, transferPath, sessionPath dbus.ObjectPath) {
+	err := b.service.Emit(b, "TransferCreated", file, transferPath, sessionPath)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+func (b *Bluetooth) emitTransferProgress(transferPath dbus.ObjectPath, transferred, size uint64) {
+	err := b.service.Emit(b, "TransferProgress", transferPath, transferred, size)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+func (b *Bluetooth) emitTransferRemoved(file string, transferPath, sessionPath dbus.ObjectPath, done bool) {
+	err := b.service.Emit(b, "TransferRemoved", file, transferPath, sessionPath, done)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+func (b *Bluetooth) getDeviceAliasByAddress(address string) string {
+	b.devicesLock.Lock()
+	defer b.devicesLock.Unlock()
+	for _, devices := range b.devices {
+		for _, d := range devices {
+			if d.Address == address {
+				return d.Alias
+			}
+		}
+	}
+	return address
+}
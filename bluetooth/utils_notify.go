@@ -32,6 +32,7 @@ const (
 	notifyIconBluetoothConnected     = "notification-bluetooth-connected"
 	notifyIconBluetoothDisconnected  = "notification-bluetooth-disconnected"
 	notifyIconBluetoothConnectFailed = "notification-bluetooth-error"
+	notifyIconBluetoothLowBattery    = "notification-battery-low"
 )
 
 var globalNotifications *notifications.Notifications
@@ -81,3 +82,20 @@ func notifyConnectFailedHostDown(alias string) {
 func notifyConnectFailedAux(alias, format string) {
 	notify(notifyIconBluetoothConnectFailed, Tr("Bluetooth connection failed"), fmt.Sprintf(format, alias))
 }
+
+func notifyReceiveFileFinished(alias, name string, done bool) {
+	if done {
+		format := Tr("Received %q from %q")
+		notify(notifyIconBluetoothConnected, Tr("Bluetooth File Transfer"),
+			fmt.Sprintf(format, name, alias))
+		return
+	}
+	format := Tr("Failed to receive %q from %q")
+	notify(notifyIconBluetoothConnectFailed, Tr("Bluetooth File Transfer"),
+		fmt.Sprintf(format, name, alias))
+}
+
+func notifyLowBattery(alias string, percentage int) {
+	format := Tr("%q battery is low (%d%%)")
+	notify(notifyIconBluetoothLowBattery, Tr("Low battery"), fmt.Sprintf(format, alias, percentage))
+}
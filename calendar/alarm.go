@@ -0,0 +1,125 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	alarmRelatedStart = "start"
+	alarmRelatedEnd   = "end"
+)
+
+// 日程的一个提醒。Remind 是第一个提醒，其他的提醒保存在 Job.Alarms 中。
+type JobAlarm struct {
+	Trigger string    // 相对时间，格式同 Remind
+	Related string    // 相对于开始(start)还是结束(end)，默认为开始
+	Time    time.Time // 绝对时间，不为零时忽略 Trigger 和 Related
+}
+
+func (a *JobAlarm) isAbsolute() bool {
+	return !a.Time.IsZero()
+}
+
+// 绝对时间的提醒最早为开始前 7 天，和 parseRemind 的限制一致。
+const maxAlarmAdvance = 7 * 24 * time.Hour
+
+func (j *Job) validateAlarm(a *JobAlarm) error {
+	if a.isAbsolute() {
+		if a.Time.Before(j.Start.Add(-maxAlarmAdvance)) || a.Time.After(j.End) {
+			return errors.New("alarm time out of range")
+		}
+		return nil
+	}
+	if a.Trigger == "" {
+		return errors.New("trigger is empty")
+	}
+	switch a.Related {
+	case "", alarmRelatedStart, alarmRelatedEnd:
+	default:
+		return fmt.Errorf("invalid related %q", a.Related)
+	}
+	_, err := j.getAlarmTime(a)
+	return err
+}
+
+func (j *Job) validateAlarms() error {
+	alarms, err := j.getExtraAlarms()
+	if err != nil {
+		return err
+	}
+	for idx := range alarms {
+		err = j.validateAlarm(&alarms[idx])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Job) getExtraAlarms() (result []JobAlarm, err error) {
+	if j.Alarms == "" {
+		return nil, nil
+	}
+	err = fromJson(j.Alarms, &result)
+	return
+}
+
+func (j *Job) setExtraAlarms(alarms []JobAlarm) error {
+	v, err := toJson(alarms)
+	if err != nil {
+		return err
+	}
+	j.Alarms = v
+	return nil
+}
+
+// 返回所有的提醒，包括 Remind
+func (j *Job) getAlarms() ([]JobAlarm, error) {
+	extra, err := j.getExtraAlarms()
+	if err != nil {
+		return nil, err
+	}
+	if j.Remind == "" {
+		return extra, nil
+	}
+	return append([]JobAlarm{{Trigger: j.Remind}}, extra...), nil
+}
+
+// 提醒的相对时间的参考时间，全天日程为开始或结束那天的零点。
+func (j *Job) alarmRefTime(related string) time.Time {
+	ref := j.Start
+	if related == alarmRelatedEnd {
+		ref = j.End
+	}
+	if j.AllDay {
+		ref = setClock(ref, Clock{})
+	}
+	return ref
+}
+
+func (j *Job) getAlarmTime(alarm *JobAlarm) (time.Time, error) {
+	if alarm.isAbsolute() {
+		return alarm.Time, nil
+	}
+	return parseRemind(j.alarmRefTime(alarm.Related), alarm.Trigger)
+}
+
+// 单独修改某一次重复或者拆分重复日程时，新日程继承的提醒。绝对时间的提醒只属于原来的日程。
+func (j *Job) inheritAlarms() (string, error) {
+	alarms, err := j.getExtraAlarms()
+	if err != nil {
+		return "", err
+	}
+	var result []JobAlarm
+	for _, a := range alarms {
+		if !a.isAbsolute() {
+			result = append(result, a)
+		}
+	}
+	if len(result) == 0 {
+		return "", nil
+	}
+	return toJson(result)
+}
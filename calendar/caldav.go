@@ -0,0 +1,266 @@
+package calendar
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 最小的 CalDAV (RFC 4791) 客户端，只实现同步需要的请求。
+
+const (
+	caldavRequestTimeout = 30 * time.Second
+	caldavContentType    = "text/calendar; charset=utf-8"
+)
+
+var (
+	errPreconditionFailed = errors.New("precondition failed")
+	errNotFound           = errors.New("not found")
+)
+
+type caldavClient struct {
+	collection *url.URL
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+func newCalDAVClient(collectionURL, username, password string) (*caldavClient, error) {
+	u, err := url.Parse(collectionURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &caldavClient{
+		collection: u,
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: caldavRequestTimeout},
+	}, nil
+}
+
+type davMultiStatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	PropStats []davPropStat `xml:"DAV: propstat"`
+}
+
+type davPropStat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davProp struct {
+	ETag         string          `xml:"DAV: getetag"`
+	CTag         string          `xml:"http://calendarserver.org/ns/ getctag"`
+	ResourceType davResourceType `xml:"DAV: resourcetype"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getetag/>
+    <cs:getctag/>
+  </d:prop>
+</d:propfind>`
+
+func (c *caldavClient) resolve(href string) string {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return c.collection.ResolveReference(ref).String()
+}
+
+func (c *caldavClient) newRequest(method, href string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.resolve(href), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return req, nil
+}
+
+func (c *caldavClient) do(req *http.Request, okStatus ...int) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range okStatus {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPreconditionFailed:
+		return nil, errPreconditionFailed
+	case http.StatusNotFound:
+		return nil, errNotFound
+	}
+	return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+}
+
+func (c *caldavClient) propfind(depth string) ([]davResponse, error) {
+	req, err := c.newRequest("PROPFIND", c.collection.Path, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := c.do(req, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms davMultiStatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		return nil, err
+	}
+	return ms.Responses, nil
+}
+
+func (r *davResponse) okProp() *davProp {
+	for idx := range r.PropStats {
+		ps := &r.PropStats[idx]
+		if strings.Contains(ps.Status, " 200 ") {
+			return &ps.Prop
+		}
+	}
+	return nil
+}
+
+// 获取集合的 ctag，集合中任何资源改变后 ctag 都会改变。
+func (c *caldavClient) getCTag() (string, error) {
+	responses, err := c.propfind("0")
+	if err != nil {
+		return "", err
+	}
+	for _, r := range responses {
+		prop := r.okProp()
+		if prop != nil && prop.CTag != "" {
+			return prop.CTag, nil
+		}
+	}
+	return "", nil
+}
+
+// 列出集合中所有的日历资源，返回 href 到 etag 的映射。
+func (c *caldavClient) listETags() (map[string]string, error) {
+	responses, err := c.propfind("1")
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for _, r := range responses {
+		prop := r.okProp()
+		if prop == nil || prop.ResourceType.Collection != nil {
+			continue
+		}
+		result[r.Href] = prop.ETag
+	}
+	return result, nil
+}
+
+func (c *caldavClient) get(href string) (data []byte, etag string, err error) {
+	req, err := c.newRequest(http.MethodGet, href, nil)
+	if err != nil {
+		return
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	etag = resp.Header.Get("ETag")
+	return
+}
+
+// 上传资源，create 为 true 表示创建新资源，已存在时失败。修改资源时 etag
+// 为空表示不知道服务器上的 etag，不检查是否被修改。
+// 返回新的 etag，服务器可以不返回，这时再用 HEAD 获取，仍然没有时为空。
+func (c *caldavClient) put(href string, data []byte, etag string, create bool) (string, error) {
+	req, err := c.newRequest(http.MethodPut, href, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", caldavContentType)
+	if create {
+		req.Header.Set("If-None-Match", "*")
+	} else if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := c.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	newETag := resp.Header.Get("ETag")
+	if newETag == "" {
+		newETag, err = c.getETag(href)
+		if err != nil {
+			logger.Debugf("failed to get etag of %s: %v", href, err)
+			return "", nil
+		}
+	}
+	return newETag, nil
+}
+
+func (c *caldavClient) getETag(href string) (string, error) {
+	req, err := c.newRequest(http.MethodHead, href, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(req, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *caldavClient) delete(href string, etag string) error {
+	req, err := c.newRequest(http.MethodDelete, href, nil)
+	if err != nil {
+		return err
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := c.do(req, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// 新资源的 href，使用 UID 作为文件名
+func (c *caldavClient) newHref(uid string) string {
+	return c.collection.Path + url.PathEscape(uid) + ".ics"
+}
@@ -0,0 +1,153 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	secrets "github.com/linuxdeepin/go-dbus-factory/org.freedesktop.secrets"
+	dbus "pkg.deepin.io/lib/dbus1"
+)
+
+// CalDAV 的密码保存在密钥环中，不保存在数据库里。
+
+const (
+	keyringAttrApplication = "application"
+	keyringAttrCalDAVType  = "caldav-type-id"
+	keyringApplication     = "dde-calendar"
+)
+
+type passwordStore interface {
+	getPassword(typeId uint) (string, error)
+	setPassword(typeId uint, label, password string) error
+	deletePassword(typeId uint) error
+}
+
+// 通过 org.freedesktop.secrets 访问默认的密钥环
+type keyringPasswordStore struct {
+	conn    *dbus.Conn
+	service *secrets.Service
+
+	mu          sync.Mutex
+	sessionPath dbus.ObjectPath
+	collection  *secrets.Collection
+}
+
+func newKeyringPasswordStore(conn *dbus.Conn) *keyringPasswordStore {
+	return &keyringPasswordStore{
+		conn:    conn,
+		service: secrets.NewService(conn),
+	}
+}
+
+func (ks *keyringPasswordStore) init() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.collection != nil {
+		return nil
+	}
+
+	_, sessionPath, err := ks.service.OpenSession(0, "plain", dbus.MakeVariant(""))
+	if err != nil {
+		return err
+	}
+	collectionPath, err := ks.service.ReadAlias(0, "default")
+	if err != nil {
+		return err
+	}
+	if collectionPath == "/" {
+		return errors.New("failed to get default collection path")
+	}
+	collection, err := secrets.NewCollection(ks.conn, collectionPath)
+	if err != nil {
+		return err
+	}
+	ks.sessionPath = sessionPath
+	ks.collection = collection
+	return nil
+}
+
+func getKeyringAttributes(typeId uint) map[string]string {
+	return map[string]string{
+		keyringAttrApplication: keyringApplication,
+		keyringAttrCalDAVType:  strconv.FormatUint(uint64(typeId), 10),
+	}
+}
+
+func (ks *keyringPasswordStore) searchItems(typeId uint) ([]dbus.ObjectPath, error) {
+	err := ks.init()
+	if err != nil {
+		return nil, err
+	}
+	return ks.collection.SearchItems(0, getKeyringAttributes(typeId))
+}
+
+func (ks *keyringPasswordStore) getPassword(typeId uint) (string, error) {
+	items, err := ks.searchItems(typeId)
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", fmt.Errorf("no password of type %d in keyring", typeId)
+	}
+
+	result, err := ks.service.GetSecrets(0, items[:1], ks.sessionPath)
+	if err != nil {
+		return "", err
+	}
+	secret, ok := result[items[0]]
+	if !ok {
+		return "", fmt.Errorf("no password of type %d in keyring", typeId)
+	}
+	return string(secret.Value), nil
+}
+
+func (ks *keyringPasswordStore) setPassword(typeId uint, label, password string) error {
+	err := ks.init()
+	if err != nil {
+		return err
+	}
+	secret := secrets.Secret{
+		Session:     ks.sessionPath,
+		Value:       []byte(password),
+		ContentType: "text/plain",
+	}
+	properties := map[string]dbus.Variant{
+		"org.freedesktop.Secret.Item.Label":      dbus.MakeVariant(label),
+		"org.freedesktop.Secret.Item.Type":       dbus.MakeVariant("org.freedesktop.Secret.Generic"),
+		"org.freedesktop.Secret.Item.Attributes": dbus.MakeVariant(getKeyringAttributes(typeId)),
+	}
+	_, _, err = ks.collection.CreateItem(0, properties, secret, true)
+	return err
+}
+
+func (ks *keyringPasswordStore) deletePassword(typeId uint) error {
+	items, err := ks.searchItems(typeId)
+	if err != nil {
+		return err
+	}
+	for _, itemPath := range items {
+		item, err := secrets.NewItem(ks.conn, itemPath)
+		if err != nil {
+			return err
+		}
+		_, err = item.Delete(0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getCalDAVPasswordLabel(url, username string) string {
+	return fmt.Sprintf("CalDAV password for %s at %s", username, url)
+}
+
+func (s *Scheduler) getCalDAVPassword(b *CalDAVBinding) (string, error) {
+	if b.Username == "" {
+		return "", nil
+	}
+	return s.passwords.getPassword(b.TypeID)
+}
@@ -0,0 +1,583 @@
+package calendar
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const caldavSyncInterval = 15 * time.Minute
+
+// 日程类型和 CalDAV 日历集合的绑定
+type CalDAVBinding struct {
+	gorm.Model
+
+	TypeID    uint `gorm:"unique_index"`
+	URL       string
+	Username  string
+	CTag      string `gorm:"column:ctag"`
+	LastSync  time.Time
+	LastError string
+}
+
+type CalDAVBindingJSON struct {
+	TypeID    uint
+	URL       string
+	Username  string
+	LastSync  time.Time
+	LastError string
+}
+
+func (b *CalDAVBinding) toCalDAVBindingJSON() *CalDAVBindingJSON {
+	return &CalDAVBindingJSON{
+		TypeID:    b.TypeID,
+		URL:       b.URL,
+		Username:  b.Username,
+		LastSync:  b.LastSync,
+		LastError: b.LastError,
+	}
+}
+
+// 本地删除的已同步日程，下次同步时从服务器上删除。
+type CalDAVTombstone struct {
+	gorm.Model
+
+	TypeID uint
+	Href   string
+	ETag   string `gorm:"column:etag"`
+}
+
+// 本地和服务器同时修改了同一个日程时，以服务器为准，本地的数据保存在这里。
+type CalDAVConflict struct {
+	gorm.Model
+
+	TypeID    uint
+	JobID     uint
+	Href      string
+	LocalData string // iCalendar 格式
+}
+
+type CalDAVConflictJSON struct {
+	ID        uint
+	TypeID    uint
+	JobID     uint
+	Time      time.Time
+	LocalData string
+}
+
+func (c *CalDAVConflict) toCalDAVConflictJSON() *CalDAVConflictJSON {
+	return &CalDAVConflictJSON{
+		ID:        c.ID,
+		TypeID:    c.TypeID,
+		JobID:     c.JobID,
+		Time:      c.CreatedAt,
+		LocalData: c.LocalData,
+	}
+}
+
+// 同步时需要更新的所有字段
+func (j *Job) syncFields() map[string]interface{} {
+	return map[string]interface{}{
+		"Type":        j.Type,
+		"Title":       j.Title,
+		"Description": j.Description,
+		"AllDay":      j.AllDay,
+		"Start":       j.Start,
+		"End":         j.End,
+		"RRule":       j.RRule,
+		"Remind":      j.Remind,
+		"Alarms":      j.Alarms,
+		"Ignore":      j.Ignore,
+		"TZID":        j.TZID,
+		"UID":         j.UID,
+		"Href":        j.Href,
+		"ETag":        j.ETag,
+		"Dirty":       false,
+	}
+}
+
+func (s *Scheduler) markJobDirty(id uint) error {
+	return s.db.Model(&Job{}).Where("id = ?", id).Update("Dirty", true).Error
+}
+
+func (s *Scheduler) bindCalDAV(typeId uint, url, username, password string) error {
+	_, err := s.getType(typeId)
+	if err != nil {
+		return err
+	}
+	_, err = newCalDAVClient(url, username, password)
+	if err != nil {
+		return err
+	}
+
+	if username != "" {
+		err = s.passwords.setPassword(typeId, getCalDAVPasswordLabel(url, username), password)
+		if err != nil {
+			return err
+		}
+	}
+
+	var binding CalDAVBinding
+	err = s.db.Where("type_id = ?", typeId).First(&binding).Error
+	if err == nil {
+		return s.db.Model(&binding).Updates(map[string]interface{}{
+			"URL":       url,
+			"Username":  username,
+			"Password":  "",
+			"CTag":      "",
+			"LastError": "",
+		}).Error
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+
+	binding = CalDAVBinding{
+		TypeID:   typeId,
+		URL:      url,
+		Username: username,
+	}
+	return s.db.Create(&binding).Error
+}
+
+func (s *Scheduler) unbindCalDAV(typeId uint) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	err := s.passwords.deletePassword(typeId)
+	if err != nil {
+		logger.Warning("failed to delete password from keyring:", err)
+	}
+
+	return s.withTx(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("type_id = ?", typeId).Delete(&CalDAVBinding{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("type_id = ?", typeId).Delete(&CalDAVTombstone{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("type_id = ?", typeId).Delete(&CalDAVConflict{}).Error
+		if err != nil {
+			return err
+		}
+		// 日程保留在本地，但不再和服务器关联
+		return tx.Model(&Job{}).Where("type = ?", typeId).Updates(map[string]interface{}{
+			"Href":  "",
+			"ETag":  "",
+			"Dirty": false,
+		}).Error
+	})
+}
+
+func (s *Scheduler) getCalDAVBindings() ([]*CalDAVBindingJSON, error) {
+	var bindings []CalDAVBinding
+	err := s.db.Find(&bindings).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*CalDAVBindingJSON, len(bindings))
+	for idx := range bindings {
+		result[idx] = bindings[idx].toCalDAVBindingJSON()
+	}
+	return result, nil
+}
+
+func (s *Scheduler) getSyncConflicts(typeId uint) ([]*CalDAVConflictJSON, error) {
+	var conflicts []CalDAVConflict
+	err := s.db.Where("type_id = ?", typeId).Find(&conflicts).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*CalDAVConflictJSON, len(conflicts))
+	for idx := range conflicts {
+		result[idx] = conflicts[idx].toCalDAVConflictJSON()
+	}
+	return result, nil
+}
+
+func (s *Scheduler) deleteSyncConflict(id uint) error {
+	var conflict CalDAVConflict
+	err := s.db.Select("id").First(&conflict, id).Error
+	if err != nil {
+		return err
+	}
+	return s.db.Unscoped().Delete(&conflict).Error
+}
+
+func (s *Scheduler) syncAllCalDAV() {
+	var bindings []CalDAVBinding
+	err := s.db.Select("type_id").Find(&bindings).Error
+	if err != nil {
+		logger.Warning(err)
+		return
+	}
+	for _, b := range bindings {
+		ids, err := s.syncCalDAV(b.TypeID)
+		if err != nil {
+			logger.Warningf("failed to sync type %d: %v", b.TypeID, err)
+		}
+		if len(ids) > 0 {
+			s.notifyJobsChange(ids...)
+			s.emitJobsUpdated(ids...)
+		}
+	}
+}
+
+func (s *Scheduler) startSyncLoop() {
+	ticker := time.NewTicker(caldavSyncInterval)
+	go func() {
+		s.syncAllCalDAV()
+		for {
+			select {
+			case <-ticker.C:
+				s.syncAllCalDAV()
+			case <-s.quitChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// 同步一个日程类型，返回本地改变了的日程的 ID。
+func (s *Scheduler) syncCalDAV(typeId uint) (ids []uint, err error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	var binding CalDAVBinding
+	err = s.db.Where("type_id = ?", typeId).First(&binding).Error
+	if err != nil {
+		return
+	}
+
+	t0 := time.Now()
+	ids, err = s.syncBinding(&binding)
+	logger.Debugf("sync type %d cost %v, changed jobs: %v", typeId, time.Since(t0), ids)
+
+	status := map[string]interface{}{
+		"LastSync":  time.Now(),
+		"LastError": "",
+	}
+	if err != nil {
+		status["LastError"] = err.Error()
+	} else {
+		status["CTag"] = binding.CTag
+	}
+	err1 := s.db.Model(&binding).Updates(status).Error
+	if err1 != nil {
+		logger.Warning(err1)
+	}
+	return
+}
+
+func (s *Scheduler) syncBinding(b *CalDAVBinding) ([]uint, error) {
+	password, err := s.getCalDAVPassword(b)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newCalDAVClient(b.URL, b.Username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先删除服务器上的资源，否则拉取时会把本地删除的日程重新创建出来。
+	deleted, err := s.pushCalDAVDeletions(client, b.TypeID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctag, err := client.getCTag()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	if ctag == "" || ctag != b.CTag || deleted {
+		ids, err = s.pullCalDAV(client, b.TypeID)
+		if err != nil {
+			return ids, err
+		}
+	}
+
+	pushed, pulledIds, err := s.pushCalDAV(client, b.TypeID)
+	ids = append(ids, pulledIds...)
+	if err != nil {
+		return ids, err
+	}
+	if pushed {
+		// 上传后服务器的 ctag 改变了
+		ctag, err = client.getCTag()
+		if err != nil {
+			return ids, err
+		}
+	}
+	b.CTag = ctag
+	return ids, nil
+}
+
+func (s *Scheduler) pushCalDAVDeletions(client *caldavClient, typeId uint) (bool, error) {
+	var tombstones []CalDAVTombstone
+	err := s.db.Where("type_id = ?", typeId).Find(&tombstones).Error
+	if err != nil {
+		return false, err
+	}
+	for idx := range tombstones {
+		t := &tombstones[idx]
+		err = client.delete(t.Href, t.ETag)
+		if err == errPreconditionFailed {
+			// 服务器上的日程在本地删除后又被修改了，保留服务器上的。
+			logger.Debugf("remote resource %s changed, keep it", t.Href)
+		} else if err != nil {
+			return false, err
+		}
+		err = s.db.Unscoped().Delete(t).Error
+		if err != nil {
+			return false, err
+		}
+	}
+	return len(tombstones) > 0, nil
+}
+
+func (s *Scheduler) pullCalDAV(client *caldavClient, typeId uint) ([]uint, error) {
+	remote, err := client.listETags()
+	if err != nil {
+		return nil, err
+	}
+
+	var locals []*Job
+	err = s.db.Where("type = ? AND parent_id = 0 AND href != ''", typeId).Find(&locals).Error
+	if err != nil {
+		return nil, err
+	}
+	localMap := make(map[string]*Job, len(locals))
+	for _, job := range locals {
+		localMap[job.Href] = job
+	}
+
+	var ids []uint
+	for href, etag := range remote {
+		local := localMap[href]
+		if local != nil && etag != "" && local.ETag == etag {
+			continue
+		}
+
+		data, etag1, err := client.get(href)
+		if err != nil {
+			return ids, err
+		}
+		if etag1 != "" {
+			etag = etag1
+		}
+
+		if local != nil && local.Dirty {
+			err = s.recordConflict(typeId, local)
+			if err != nil {
+				return ids, err
+			}
+		}
+
+		changedIds, err := s.applyRemoteResource(typeId, href, etag, data, local)
+		if err != nil {
+			logger.Warningf("failed to apply remote resource %s: %v", href, err)
+			continue
+		}
+		ids = append(ids, changedIds...)
+	}
+
+	for href, local := range localMap {
+		if _, ok := remote[href]; ok {
+			continue
+		}
+		if local.Dirty {
+			// 服务器上已删除，但本地修改过，作为新日程重新上传。
+			err = s.db.Model(local).Updates(map[string]interface{}{
+				"Href": "",
+				"ETag": "",
+			}).Error
+		} else {
+			err = s.withTx(func(tx *gorm.DB) error {
+				err := tx.Unscoped().Where("parent_id = ?", local.ID).Delete(&Job{}).Error
+				if err != nil {
+					return err
+				}
+				return tx.Unscoped().Delete(local).Error
+			})
+			ids = append(ids, local.ID)
+		}
+		if err != nil {
+			return ids, err
+		}
+	}
+	return ids, nil
+}
+
+func (s *Scheduler) recordConflict(typeId uint, local *Job) error {
+	data, err := s.jobToICS(local)
+	if err != nil {
+		return err
+	}
+	conflict := CalDAVConflict{
+		TypeID:    typeId,
+		JobID:     local.ID,
+		Href:      local.Href,
+		LocalData: string(data),
+	}
+	logger.Infof("sync conflict on job %d %q, use the remote one", local.ID, local.Title)
+	return s.db.Create(&conflict).Error
+}
+
+// 用服务器上的资源替换本地的日程，local 为 nil 时创建新的日程。
+func (s *Scheduler) applyRemoteResource(typeId uint, href, etag string, data []byte,
+	local *Job) ([]uint, error) {
+	cal, err := parseICS(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var master *icsComponent
+	var overrides []*icsComponent
+	for _, ev := range cal.getComponents("VEVENT") {
+		if ev.getProp("RECURRENCE-ID") != nil {
+			overrides = append(overrides, ev)
+		} else {
+			master = ev
+		}
+	}
+	if master == nil {
+		return nil, errors.New("no VEVENT")
+	}
+
+	job, err := newJobFromVEvent(master)
+	if err != nil {
+		return nil, err
+	}
+	job.Type = int(typeId)
+	job.Href = href
+	job.ETag = etag
+
+	var ids []uint
+	err = s.withTx(func(tx *gorm.DB) error {
+		if local != nil {
+			job.ID = local.ID
+			err := tx.Model(local).Updates(job.syncFields()).Error
+			if err != nil {
+				return err
+			}
+			// 子日程全部用服务器上的替换
+			err = tx.Unscoped().Where("parent_id = ?", local.ID).Delete(&Job{}).Error
+			if err != nil {
+				return err
+			}
+		} else {
+			err := tx.Create(job).Error
+			if err != nil {
+				return err
+			}
+		}
+		ids = append(ids, job.ID)
+
+		for _, ev := range overrides {
+			child, err := newJobFromVEvent(ev)
+			if err != nil {
+				logger.Warning(err)
+				continue
+			}
+			recurTime, _, err := ev.getProp("RECURRENCE-ID").getTime()
+			if err != nil {
+				logger.Warning(err)
+				continue
+			}
+			child.UID = ""
+			child.RRule = ""
+			child.Ignore = ""
+			child.Type = int(typeId)
+			child.ParentID = job.ID
+			child.RecurrenceID = recurTime
+			err = tx.Create(child).Error
+			if err != nil {
+				return err
+			}
+			ids = append(ids, child.ID)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// 上传本地修改的日程，返回是否上传了，以及因为冲突从服务器重新拉取的日程的 ID。
+func (s *Scheduler) pushCalDAV(client *caldavClient, typeId uint) (bool, []uint, error) {
+	var jobs []*Job
+	err := s.db.Where("type = ? AND parent_id = 0 AND (dirty = ? OR href = '')",
+		typeId, true).Find(&jobs).Error
+	if err != nil {
+		return false, nil, err
+	}
+
+	pushed := false
+	var ids []uint
+	for _, job := range jobs {
+		data, err := s.jobToICS(job)
+		if err != nil {
+			logger.Warningf("failed to convert job %d: %v", job.ID, err)
+			continue
+		}
+
+		href, etag := job.Href, job.ETag
+		create := href == ""
+		if create {
+			href = client.newHref(job.getUID())
+			etag = ""
+		}
+		newETag, err := client.put(href, data, etag, create)
+		if err == errPreconditionFailed {
+			// 服务器上的资源在拉取后又被修改了，立即重新拉取这个资源，
+			// 否则上传后获取的 ctag 已包含这次修改，这次修改再也不会被拉取。
+			logger.Debugf("remote resource %s changed, pull it for job %d", href, job.ID)
+			pulledIds, err := s.pullCalDAVResource(client, typeId, href, job)
+			ids = append(ids, pulledIds...)
+			if err != nil {
+				return pushed, ids, err
+			}
+			continue
+		} else if err != nil {
+			return pushed, ids, err
+		}
+		pushed = true
+
+		err = s.db.Model(job).Updates(map[string]interface{}{
+			"UID":   job.getUID(),
+			"Href":  href,
+			"ETag":  newETag,
+			"Dirty": false,
+		}).Error
+		if err != nil {
+			return pushed, ids, err
+		}
+	}
+	return pushed, ids, nil
+}
+
+// 上传 local 时发生冲突，拉取服务器上的资源，同 pullCalDAV，以服务器为准，
+// 本地的数据作为冲突保存。服务器上已删除时，下次作为新日程上传。
+func (s *Scheduler) pullCalDAVResource(client *caldavClient, typeId uint, href string,
+	local *Job) ([]uint, error) {
+	data, etag, err := client.get(href)
+	if err == errNotFound {
+		return nil, s.db.Model(local).Updates(map[string]interface{}{
+			"Href": "",
+			"ETag": "",
+		}).Error
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = s.recordConflict(typeId, local)
+	if err != nil {
+		return nil, err
+	}
+	return s.applyRemoteResource(typeId, href, etag, data, local)
+}
@@ -0,0 +1,96 @@
+package calendar
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"pkg.deepin.io/lib/xdg/basedir"
+)
+
+var (
+	configLocker sync.Mutex
+	configCache  *config
+	configFile   = filepath.Join(basedir.GetUserConfigDir(), "deepin/dde-daemon/calendar/config.json")
+)
+
+type config struct {
+	SnoozeDurations []int // 稍后提醒的间隔，单位分钟
+}
+
+const maxSnoozeDuration = 24 * 60
+
+func readConfig() (*config, error) {
+	configLocker.Lock()
+	defer configLocker.Unlock()
+
+	if configCache != nil {
+		return configCache, nil
+	}
+
+	var cfg config
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		err = json.Unmarshal(content, &cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configCache = &cfg
+	return configCache, nil
+}
+
+func saveConfig(cfg *config) error {
+	configLocker.Lock()
+	defer configLocker.Unlock()
+
+	content, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(configFile), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(configFile, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	configCache = cfg
+	return nil
+}
+
+func getSnoozeDurations() []int {
+	cfg, err := readConfig()
+	if err != nil {
+		logger.Warning(err)
+		return nil
+	}
+	return cfg.SnoozeDurations
+}
+
+func setSnoozeDurations(durations []int) error {
+	for _, d := range durations {
+		if d <= 0 || d > maxSnoozeDuration {
+			return errors.New("invalid snooze duration")
+		}
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		// 配置文件损坏时直接覆盖
+		logger.Warning(err)
+		cfg = &config{}
+	}
+	newCfg := *cfg
+	newCfg.SnoozeDurations = durations
+	return saveConfig(&newCfg)
+}
@@ -16,7 +16,7 @@ const maxNanoSecs = 999999999
 func (d Date) toTimeRange() TimeRange {
 	return TimeRange{
 		start: newTimeYMDHM(d.Year, d.Month, d.Day, 0, 0),
-		end:   time.Date(d.Year, d.Month, d.Day, 23, 59, 59, maxNanoSecs, time.Local),
+		end:   time.Date(d.Year, d.Month, d.Day, 23, 59, 59, maxNanoSecs, getLocalLocation()),
 	}
 }
 
@@ -0,0 +1,472 @@
+package calendar
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 对 RFC 5545 (iCalendar) 格式的最小实现，只处理日历数据交换需要的部分。
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+type icsComponent struct {
+	name       string
+	props      []*icsProperty
+	components []*icsComponent
+}
+
+const (
+	icsLayoutDateTimeUTC = "20060102T150405Z"
+	icsLayoutDateTime    = "20060102T150405"
+	icsLayoutDate        = "20060102"
+
+	icsMaxLineOctets = 75
+)
+
+func (p *icsProperty) getParam(name string) string {
+	if p.params == nil {
+		return ""
+	}
+	return p.params[name]
+}
+
+func (p *icsProperty) setParam(name, value string) {
+	if p.params == nil {
+		p.params = make(map[string]string)
+	}
+	p.params[name] = value
+}
+
+func (c *icsComponent) getProp(name string) *icsProperty {
+	for _, p := range c.props {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (c *icsComponent) getProps(name string) []*icsProperty {
+	var result []*icsProperty
+	for _, p := range c.props {
+		if p.name == name {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (c *icsComponent) getPropValue(name string) string {
+	p := c.getProp(name)
+	if p == nil {
+		return ""
+	}
+	return p.value
+}
+
+func (c *icsComponent) getComponents(name string) []*icsComponent {
+	var result []*icsComponent
+	for _, sub := range c.components {
+		if sub.name == name {
+			result = append(result, sub)
+		}
+	}
+	return result
+}
+
+func (c *icsComponent) addProp(name, value string) *icsProperty {
+	p := &icsProperty{name: name, value: value}
+	c.props = append(c.props, p)
+	return p
+}
+
+func (c *icsComponent) addTextProp(name, text string) *icsProperty {
+	return c.addProp(name, icsEscapeText(text))
+}
+
+// 读取所有逻辑行，处理行折叠。
+func icsUnfoldLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func parseICSProperty(line string) (*icsProperty, error) {
+	// 找到第一个不在引号中的冒号
+	inQuote := false
+	colonIdx := -1
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		if ch == '"' {
+			inQuote = !inQuote
+		} else if ch == ':' && !inQuote {
+			colonIdx = i
+			break
+		}
+	}
+	if colonIdx == -1 {
+		return nil, fmt.Errorf("invalid content line %q", line)
+	}
+
+	p := &icsProperty{
+		value: line[colonIdx+1:],
+	}
+	parts := icsSplitUnquoted(line[:colonIdx], ';')
+	p.name = strings.ToUpper(parts[0])
+	if p.name == "" {
+		return nil, fmt.Errorf("invalid content line %q", line)
+	}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid property parameter %q", part)
+		}
+		p.setParam(strings.ToUpper(kv[0]), strings.Trim(kv[1], `"`))
+	}
+	return p, nil
+}
+
+func icsSplitUnquoted(s string, sep byte) []string {
+	var result []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == '"' {
+			inQuote = !inQuote
+		} else if ch == sep && !inQuote {
+			result = append(result, s[start:i])
+			start = i + 1
+		}
+	}
+	result = append(result, s[start:])
+	return result
+}
+
+func parseICS(r io.Reader) (*icsComponent, error) {
+	lines, err := icsUnfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *icsComponent
+	var stack []*icsComponent
+	for _, line := range lines {
+		p, err := parseICSProperty(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.name {
+		case "BEGIN":
+			c := &icsComponent{name: strings.ToUpper(p.value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.components = append(parent.components, c)
+			} else if root == nil {
+				root = c
+			} else {
+				return nil, errors.New("multiple root components")
+			}
+			stack = append(stack, c)
+
+		case "END":
+			if len(stack) == 0 ||
+				stack[len(stack)-1].name != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("unexpected END:%s", p.value)
+			}
+			stack = stack[:len(stack)-1]
+
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("property %s outside of component", p.name)
+			}
+			c := stack[len(stack)-1]
+			c.props = append(c.props, p)
+		}
+	}
+
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("component %s not closed", stack[len(stack)-1].name)
+	}
+	if root == nil {
+		return nil, errors.New("no component found")
+	}
+	return root, nil
+}
+
+func (c *icsComponent) writeTo(w io.Writer) error {
+	err := icsWriteLine(w, "BEGIN:"+c.name)
+	if err != nil {
+		return err
+	}
+	for _, p := range c.props {
+		err = icsWriteLine(w, p.String())
+		if err != nil {
+			return err
+		}
+	}
+	for _, sub := range c.components {
+		err = sub.writeTo(w)
+		if err != nil {
+			return err
+		}
+	}
+	return icsWriteLine(w, "END:"+c.name)
+}
+
+func (p *icsProperty) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(p.name)
+	// 参数按固定顺序输出，方便比较。
+	for _, key := range sortedKeys(p.params) {
+		value := p.params[key]
+		buf.WriteByte(';')
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		if strings.ContainsAny(value, ":;,") {
+			value = `"` + value + `"`
+		}
+		buf.WriteString(value)
+	}
+	buf.WriteByte(':')
+	buf.WriteString(p.value)
+	return buf.String()
+}
+
+// 按 75 字节折叠行，不拆分 UTF-8 字符。
+func icsWriteLine(w io.Writer, line string) error {
+	var buf bytes.Buffer
+	limit := icsMaxLineOctets
+	for len(line) > limit {
+		n := limit
+		for n > 0 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		buf.WriteString(line[:n])
+		buf.WriteString("\r\n ")
+		line = line[n:]
+		// 续行的开头有一个空格
+		limit = icsMaxLineOctets - 1
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+var icsTextEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\n", `\n`,
+)
+
+func icsEscapeText(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	return icsTextEscaper.Replace(text)
+}
+
+func icsUnescapeText(text string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if ch == '\\' && i+1 < len(text) {
+			i++
+			switch text[i] {
+			case 'n', 'N':
+				buf.WriteByte('\n')
+			default:
+				buf.WriteByte(text[i])
+			}
+			continue
+		}
+		buf.WriteByte(ch)
+	}
+	return buf.String()
+}
+
+// 解析 DATE 或 DATE-TIME 值，isDate 表示是否为 DATE 类型。
+func parseICSTime(value string, tzid string) (t time.Time, isDate bool, err error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case len(value) == len(icsLayoutDate):
+		t, err = time.ParseInLocation(icsLayoutDate, value, getLocalLocation())
+		isDate = true
+
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse(icsLayoutDateTimeUTC, value)
+		if err == nil {
+			t = t.In(getLocalLocation())
+		}
+
+	default:
+		loc := getLocalLocation()
+		if tzid != "" {
+			loc0, err := loadLocation(tzid)
+			if err != nil {
+				logger.Warningf("unknown TZID %q, fallback to local time", tzid)
+			} else {
+				loc = loc0
+			}
+		}
+		t, err = time.ParseInLocation(icsLayoutDateTime, value, loc)
+		if err == nil {
+			t = t.In(getLocalLocation())
+		}
+	}
+	return
+}
+
+func (p *icsProperty) getTime() (time.Time, bool, error) {
+	return parseICSTime(p.value, p.getParam("TZID"))
+}
+
+// 一个属性中可能有多个以逗号分隔的值，比如 EXDATE。
+func (p *icsProperty) getTimes() ([]time.Time, bool, error) {
+	var result []time.Time
+	var isDate bool
+	for _, value := range strings.Split(p.value, ",") {
+		t, isDate0, err := parseICSTime(value, p.getParam("TZID"))
+		if err != nil {
+			return nil, false, err
+		}
+		isDate = isDate0
+		result = append(result, t)
+	}
+	return result, isDate, nil
+}
+
+func formatICSDateTime(t time.Time) string {
+	return t.UTC().Format(icsLayoutDateTimeUTC)
+}
+
+func formatICSDate(t time.Time) string {
+	return t.In(getLocalLocation()).Format(icsLayoutDate)
+}
+
+// 解析 dur-value，如 -P1DT2H30M, PT15M, P1W。
+func parseICSDuration(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 2 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	s = s[1:]
+
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, ch := range s {
+		switch {
+		case ch >= '0' && ch <= '9':
+			num += string(ch)
+			continue
+		case ch == 'T':
+			inTime = true
+			continue
+		}
+
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		num = ""
+		var unit time.Duration
+		switch {
+		case ch == 'W' && !inTime:
+			unit = 7 * 24 * time.Hour
+		case ch == 'D' && !inTime:
+			unit = 24 * time.Hour
+		case ch == 'H' && inTime:
+			unit = time.Hour
+		case ch == 'M' && inTime:
+			unit = time.Minute
+		case ch == 'S' && inTime:
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		d += time.Duration(n) * unit
+	}
+	if num != "" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+func formatICSDuration(d time.Duration) string {
+	var buf bytes.Buffer
+	if d < 0 {
+		buf.WriteByte('-')
+		d = -d
+	}
+	buf.WriteByte('P')
+
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		buf.WriteString(fmt.Sprintf("%dD", days))
+	}
+	if d > 0 || days == 0 {
+		buf.WriteByte('T')
+		hours := d / time.Hour
+		d -= hours * time.Hour
+		minutes := d / time.Minute
+		d -= minutes * time.Minute
+		seconds := d / time.Second
+		if hours > 0 {
+			buf.WriteString(fmt.Sprintf("%dH", hours))
+		}
+		if minutes > 0 {
+			buf.WriteString(fmt.Sprintf("%dM", minutes))
+		}
+		if seconds > 0 || (hours == 0 && minutes == 0) {
+			buf.WriteString(fmt.Sprintf("%dS", seconds))
+		}
+	}
+	return buf.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -29,11 +29,26 @@ type Job struct {
 
 	RRule  string // 重复规则
 	Remind string // 提醒
+	Alarms string // 其他的提醒，JSON
+
+	TZID string `gorm:"column:tzid"` // 时区，比如 Asia/Shanghai，空表示系统时区
 
 	RecurID int    `gorm:"-"`
 	Ignore  string // 忽略，JSON
 
-	remindTime time.Time
+	UID string // iCalendar UID，导入的日程才有
+
+	// 单独修改过的某一次重复，作为子日程保存，对应 RFC 5545 中的 RECURRENCE-ID
+	ParentID     uint      `gorm:"index"` // 重复日程的 ID
+	RecurrenceID time.Time // 这一次重复原来的开始时间
+
+	// CalDAV 同步
+	Href  string // 服务器上资源的路径
+	ETag  string `gorm:"column:etag"`
+	Dirty bool   // 本地修改过，需要上传
+
+	remindTime    time.Time
+	remindTrigger string // 触发这次提醒的 Remind，绝对时间的提醒为空
 }
 
 type JobJSON struct {
@@ -45,10 +60,16 @@ type JobJSON struct {
 	Start, End  time.Time
 	RRule       string
 	Remind      string
+	Alarms      []JobAlarm // 为 nil 时更新日程不修改其他的提醒
 	RecurID     int
 	Ignore      []time.Time
+	TZID        string
+
+	ParentID     uint
+	RecurrenceID time.Time
 
 	remindLaterCount int
+	remindTrigger    string
 }
 
 func (j *Job) toJobJSON() (*JobJSON, error) {
@@ -59,18 +80,31 @@ func (j *Job) toJobJSON() (*JobJSON, error) {
 	if err != nil {
 		return nil, err
 	}
+	alarms, err := j.getExtraAlarms()
+	if err != nil {
+		return nil, err
+	}
+	if alarms == nil {
+		alarms = []JobAlarm{}
+	}
 	return &JobJSON{
-		ID:          j.ID,
-		Type:        j.Type,
-		Title:       j.Title,
-		Description: j.Description,
-		AllDay:      j.AllDay,
-		Start:       j.Start,
-		End:         j.End,
-		RRule:       j.RRule,
-		Remind:      j.Remind,
-		RecurID:     j.RecurID,
-		Ignore:      ignore,
+		ID:           j.ID,
+		Type:         j.Type,
+		Title:        j.Title,
+		Description:  j.Description,
+		AllDay:       j.AllDay,
+		Start:        j.Start,
+		End:          j.End,
+		RRule:        j.RRule,
+		Remind:       j.Remind,
+		RecurID:      j.RecurID,
+		Ignore:       ignore,
+		TZID:         j.TZID,
+		ParentID:     j.ParentID,
+		RecurrenceID: j.RecurrenceID,
+		Alarms:       alarms,
+
+		remindTrigger: j.remindTrigger,
 	}, nil
 }
 
@@ -85,16 +119,25 @@ func (j *JobJSON) toJob() (*Job, error) {
 	}
 
 	job := &Job{
-		Type:        j.Type,
-		Title:       j.Title,
-		Description: j.Description,
-		AllDay:      j.AllDay,
-		Start:       j.Start,
-		End:         j.End,
-		RRule:       j.RRule,
-		Remind:      j.Remind,
-		RecurID:     j.RecurID,
-		Ignore:      ignore,
+		Type:         j.Type,
+		Title:        j.Title,
+		Description:  j.Description,
+		AllDay:       j.AllDay,
+		Start:        j.Start,
+		End:          j.End,
+		RRule:        j.RRule,
+		Remind:       j.Remind,
+		RecurID:      j.RecurID,
+		Ignore:       ignore,
+		TZID:         j.TZID,
+		ParentID:     j.ParentID,
+		RecurrenceID: j.RecurrenceID,
+	}
+	if j.Alarms != nil {
+		err = job.setExtraAlarms(j.Alarms)
+		if err != nil {
+			return nil, err
+		}
 	}
 	job.ID = j.ID
 	return job, nil
@@ -114,8 +157,15 @@ func (j *Job) validate() error {
 	}
 
 	var err error
+	if j.TZID != "" {
+		_, err = loadLocation(j.TZID)
+		if err != nil {
+			return fmt.Errorf("invalid TZID: %v", err)
+		}
+	}
+
 	if j.RRule != "" {
-		_, err = rrule.StrToROptionInLocation(j.RRule, time.Local)
+		_, err = rrule.StrToROptionInLocation(j.RRule, j.getLocation())
 		if err != nil {
 			return fmt.Errorf("invalid RRule: %v", err)
 		}
@@ -126,9 +176,36 @@ func (j *Job) validate() error {
 		return fmt.Errorf("invalid Remind: %v", err)
 	}
 
+	err = j.validateAlarms()
+	if err != nil {
+		return fmt.Errorf("invalid Alarms: %v", err)
+	}
+
 	return nil
 }
 
+func (j *Job) getLocation() *time.Location {
+	if j.TZID == "" {
+		return getLocalLocation()
+	}
+	loc, err := loadLocation(j.TZID)
+	if err != nil {
+		return getLocalLocation()
+	}
+	return loc
+}
+
+// 把日程中的时间转换为系统时区的时间。全天日程不属于任何时区，保持日期和钟点不变。
+func (j *Job) toLocalTime(t time.Time) time.Time {
+	local := getLocalLocation()
+	if !j.AllDay {
+		return t.In(local)
+	}
+	t = t.In(j.getLocation())
+	return time.Date(t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), local)
+}
+
 func (j *Job) getIgnore() (result []time.Time, err error) {
 	if j.Ignore == "" {
 		return nil, nil
@@ -169,6 +246,7 @@ func getJobsBetween(startDate, endDate libdate.Date, jobs []*Job, extend bool) (
 		date = date.Add(1)
 	}
 
+	overrides := getOverrideTimes(jobs)
 	for _, job := range jobs {
 		interval := job.End.Sub(job.Start)
 		jobTimes, err := job.between(startDate, endDate)
@@ -179,10 +257,16 @@ func getJobsBetween(startDate, endDate libdate.Date, jobs []*Job, extend bool) (
 			continue
 		}
 		for _, jobTime := range jobTimes {
+			if timeSliceContains(overrides[job.ID], jobTime.start) {
+				// 这一次重复被单独修改过，由子日程代替
+				continue
+			}
 
 			var j *Job
-			if jobTime.recurID == 0 {
+			if job.RRule == "" {
 				j = job
+				j.Start = jobTime.start
+				j.End = jobTime.start.Add(interval)
 			} else {
 				j = job.clone(jobTime.start, jobTime.start.Add(interval), jobTime.recurID)
 			}
@@ -222,8 +306,9 @@ func getJobsBetween(startDate, endDate libdate.Date, jobs []*Job, extend bool) (
 const recurrenceLimit = 3650
 
 func (j *Job) between(startDate, endDate libdate.Date) ([]jobTime, error) {
-	jStartDate := libdate.NewAt(j.Start)
-	jEndDate := libdate.NewAt(j.End)
+	localStart := j.toLocalTime(j.Start)
+	jStartDate := libdate.NewAt(localStart)
+	jEndDate := libdate.NewAt(j.toLocalTime(j.End))
 	nDays := jEndDate.Sub(jStartDate)
 	if endDate.Before(jStartDate) {
 		// endDate < jStartDate
@@ -234,26 +319,31 @@ func (j *Job) between(startDate, endDate libdate.Date) ([]jobTime, error) {
 	if err != nil {
 		return nil, err
 	}
+	for idx, t := range ignore {
+		ignore[idx] = j.toLocalTime(t)
+	}
 	// 此处满足条件 jStartDate <= endDate
 	if j.RRule == "" {
 		if (dateRange{startDate, endDate}).overlap(dateRange{jStartDate, jEndDate}) {
-			if timeSliceContains(ignore, j.Start) {
+			if timeSliceContains(ignore, localStart) {
 				// ignore this job
 				return nil, nil
 			}
 			return []jobTime{
-				{start: j.Start},
+				{start: localStart},
 			}, nil
 		}
 
 		return nil, nil
 	}
 
-	rOpt, err := rrule.StrToROptionInLocation(j.RRule, time.Local)
+	// 在日程自己的时区中展开重复，这样在夏令时和系统时区改变后钟点不变。
+	loc := j.getLocation()
+	rOpt, err := rrule.StrToROptionInLocation(j.RRule, loc)
 	if err != nil {
 		return nil, err
 	}
-	rOpt.Dtstart = j.Start
+	rOpt.Dtstart = j.Start.In(loc)
 	rule, err := rrule.NewRRule(*rOpt)
 	if err != nil {
 		return nil, err
@@ -271,6 +361,7 @@ func (j *Job) between(startDate, endDate libdate.Date) ([]jobTime, error) {
 		if !ok {
 			break
 		}
+		start = j.toLocalTime(start)
 		jStartDate := libdate.NewAt(start)
 		if endDate.Before(jStartDate) {
 			// endDate < jStartDate
@@ -371,17 +462,22 @@ func (j *Job) getRemindTime() (time.Time, error) {
 	return parseRemind(start, j.Remind)
 }
 
+// 返回重复日程的某一次重复，RecurrenceID 为这一次的开始时间。
 func (j *Job) clone(start, end time.Time, recurID int) *Job {
 	j1 := &Job{
-		Type:        j.Type,
-		Title:       j.Title,
-		Description: j.Description,
-		AllDay:      j.AllDay,
-		Start:       start,
-		End:         end,
-		RRule:       j.RRule,
-		Remind:      j.Remind,
-		RecurID:     recurID,
+		Type:         j.Type,
+		Title:        j.Title,
+		Description:  j.Description,
+		AllDay:       j.AllDay,
+		Start:        start,
+		End:          end,
+		RRule:        j.RRule,
+		Remind:       j.Remind,
+		Alarms:       j.Alarms,
+		RecurID:      recurID,
+		Ignore:       j.Ignore,
+		TZID:         j.TZID,
+		RecurrenceID: start,
 	}
 	j1.ID = j.ID
 	return j1
@@ -394,6 +490,9 @@ func (j *Job) String() string {
 	if j.RecurID != 0 {
 		idDesc += "/" + strconv.Itoa(j.RecurID)
 	}
+	if j.ParentID != 0 {
+		idDesc += " parent " + strconv.Itoa(int(j.ParentID))
+	}
 
 	buf.WriteString(fmt.Sprintf("job [%s] title: %q\n", idDesc, j.Title))
 	buf.WriteString(fmt.Sprintf("start: %s, end: %s\n",
@@ -0,0 +1,310 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+const icsProdID = "-//Deepin//dde-daemon calendar//EN"
+
+func (j *Job) getUID() string {
+	if j.UID != "" {
+		return j.UID
+	}
+	return fmt.Sprintf("dde-calendar-%d-%d", j.ID, j.CreatedAt.Unix())
+}
+
+func addTimeProp(c *icsComponent, name string, t time.Time, allDay bool, tzid string) {
+	if allDay {
+		p := c.addProp(name, formatICSDate(t))
+		p.setParam("VALUE", "DATE")
+		return
+	}
+
+	loc, err := loadLocation(tzid)
+	if tzid == "" || err != nil {
+		c.addProp(name, formatICSDateTime(t))
+		return
+	}
+	p := c.addProp(name, t.In(loc).Format(icsLayoutDateTime))
+	p.setParam("TZID", tzid)
+}
+
+func addTimesProp(c *icsComponent, name string, times []time.Time, allDay bool, tzid string) {
+	loc, err := loadLocation(tzid)
+	if err != nil {
+		tzid = ""
+	}
+	values := make([]string, len(times))
+	for idx, t := range times {
+		switch {
+		case allDay:
+			values[idx] = formatICSDate(t)
+		case tzid == "":
+			values[idx] = formatICSDateTime(t)
+		default:
+			values[idx] = t.In(loc).Format(icsLayoutDateTime)
+		}
+	}
+	p := c.addProp(name, strings.Join(values, ","))
+	if allDay {
+		p.setParam("VALUE", "DATE")
+	} else if tzid != "" {
+		p.setParam("TZID", tzid)
+	}
+}
+
+// iCalendar 中全天日程的 DTEND 是最后一天的下一天零点，而 Job 中相对结束的提醒
+// 参考的是最后一天的零点。
+func (j *Job) icsAlarmRefTime(related string) time.Time {
+	ref := j.alarmRefTime(related)
+	if j.AllDay && related == alarmRelatedEnd {
+		ref = ref.AddDate(0, 0, 1)
+	}
+	return ref
+}
+
+func (j *Job) toVAlarm(a *JobAlarm) (*icsComponent, error) {
+	alarm := &icsComponent{name: "VALARM"}
+	alarm.addProp("ACTION", "DISPLAY")
+	alarm.addTextProp("DESCRIPTION", j.Title)
+	if a.isAbsolute() {
+		p := alarm.addProp("TRIGGER", formatICSDateTime(a.Time))
+		p.setParam("VALUE", "DATE-TIME")
+		return alarm, nil
+	}
+
+	t, err := j.getAlarmTime(a)
+	if err != nil {
+		return nil, err
+	}
+	p := alarm.addProp("TRIGGER", formatICSDuration(t.Sub(j.icsAlarmRefTime(a.Related))))
+	if a.Related == alarmRelatedEnd {
+		p.setParam("RELATED", "END")
+	}
+	return alarm, nil
+}
+
+// tzid 为日程没有设置时区时使用的时区
+func (j *Job) toVEvent(now time.Time, tzid string) (*icsComponent, error) {
+	if j.TZID != "" {
+		tzid = j.TZID
+	}
+	ev := &icsComponent{name: "VEVENT"}
+	ev.addProp("UID", j.getUID())
+	ev.addProp("DTSTAMP", formatICSDateTime(now))
+	if !j.CreatedAt.IsZero() {
+		ev.addProp("CREATED", formatICSDateTime(j.CreatedAt))
+	}
+	if !j.UpdatedAt.IsZero() {
+		ev.addProp("LAST-MODIFIED", formatICSDateTime(j.UpdatedAt))
+	}
+	ev.addTextProp("SUMMARY", j.Title)
+	if j.Description != "" {
+		ev.addTextProp("DESCRIPTION", j.Description)
+	}
+
+	start := j.toLocalTime(j.Start)
+	addTimeProp(ev, "DTSTART", start, j.AllDay, tzid)
+	if j.AllDay {
+		// DTEND 是不包含在内的，全天日程需要到下一天。
+		endDate := setClock(j.toLocalTime(j.End), Clock{}).AddDate(0, 0, 1)
+		addTimeProp(ev, "DTEND", endDate, true, "")
+	} else {
+		addTimeProp(ev, "DTEND", j.End, false, tzid)
+	}
+
+	if j.RRule != "" {
+		ev.addProp("RRULE", j.RRule)
+		ignore, err := j.getIgnore()
+		if err != nil {
+			return nil, err
+		}
+		for idx, t := range ignore {
+			ignore[idx] = j.toLocalTime(t)
+		}
+		if len(ignore) > 0 {
+			addTimesProp(ev, "EXDATE", ignore, j.AllDay, tzid)
+		}
+	}
+
+	alarms, err := j.getAlarms()
+	if err != nil {
+		return nil, err
+	}
+	for idx := range alarms {
+		alarm, err := j.toVAlarm(&alarms[idx])
+		if err != nil {
+			return nil, err
+		}
+		ev.components = append(ev.components, alarm)
+	}
+	return ev, nil
+}
+
+func newJobFromVEvent(ev *icsComponent) (*Job, error) {
+	startProp := ev.getProp("DTSTART")
+	if startProp == nil {
+		return nil, errors.New("no DTSTART")
+	}
+	start, allDay, err := startProp.getTime()
+	if err != nil {
+		return nil, err
+	}
+
+	tzid := startProp.getParam("TZID")
+	if allDay || tzid == "" {
+		tzid = ""
+	} else if _, err := loadLocation(tzid); err != nil {
+		tzid = ""
+	}
+
+	job := &Job{
+		TZID:        tzid,
+		UID:         ev.getPropValue("UID"),
+		Title:       icsUnescapeText(ev.getPropValue("SUMMARY")),
+		Description: icsUnescapeText(ev.getPropValue("DESCRIPTION")),
+		AllDay:      allDay,
+		Start:       start,
+	}
+
+	var end time.Time
+	if endProp := ev.getProp("DTEND"); endProp != nil {
+		end, _, err = endProp.getTime()
+		if err != nil {
+			return nil, err
+		}
+	} else if durProp := ev.getProp("DURATION"); durProp != nil {
+		d, err := parseICSDuration(durProp.value)
+		if err != nil {
+			return nil, err
+		}
+		end = start.Add(d)
+	} else if allDay {
+		end = start.AddDate(0, 0, 1)
+	} else {
+		end = start
+	}
+
+	if allDay {
+		// 转换为本地全天日程的表示方式：结束时间为最后一天的 23:59。
+		end = end.AddDate(0, 0, -1)
+		if end.Before(start) {
+			end = start
+		}
+		job.End = setClock(end, Clock{Hour: 23, Minute: 59})
+	} else {
+		job.End = end
+	}
+
+	rruleStr := ev.getPropValue("RRULE")
+	if rruleStr != "" {
+		_, err = rrule.StrToROptionInLocation(rruleStr, job.getLocation())
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %q: %v", rruleStr, err)
+		}
+		job.RRule = rruleStr
+
+		var ignore []time.Time
+		for _, p := range ev.getProps("EXDATE") {
+			times, _, err := p.getTimes()
+			if err != nil {
+				return nil, err
+			}
+			ignore = append(ignore, times...)
+		}
+		if len(ignore) > 0 {
+			err = job.setIgnore(ignore)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 第一个相对开始时间的提醒作为 Remind，其他的保存到 Alarms。
+	var extra []JobAlarm
+	for _, valarm := range ev.getComponents("VALARM") {
+		alarm, err := job.alarmFromVAlarm(valarm)
+		if err != nil {
+			logger.Debugf("ignore alarm of event %q: %v", job.Title, err)
+			continue
+		}
+		if job.Remind == "" && !alarm.isAbsolute() && alarm.Related != alarmRelatedEnd {
+			job.Remind = alarm.Trigger
+		} else {
+			extra = append(extra, *alarm)
+		}
+	}
+	if len(extra) > 0 {
+		err = job.setExtraAlarms(extra)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return job, nil
+}
+
+// 把 VALARM 的 TRIGGER 转换为 JobAlarm
+func (j *Job) alarmFromVAlarm(valarm *icsComponent) (*JobAlarm, error) {
+	trigger := valarm.getProp("TRIGGER")
+	if trigger == nil {
+		return nil, errors.New("no TRIGGER")
+	}
+
+	var alarm JobAlarm
+	if trigger.getParam("VALUE") == "DATE-TIME" {
+		t, _, err := trigger.getTime()
+		if err != nil {
+			return nil, err
+		}
+		alarm.Time = t
+	} else {
+		offset, err := parseICSDuration(trigger.value)
+		if err != nil {
+			return nil, err
+		}
+		if trigger.getParam("RELATED") == "END" {
+			alarm.Related = alarmRelatedEnd
+		}
+		offset += j.icsAlarmRefTime(alarm.Related).Sub(j.alarmRefTime(alarm.Related))
+		alarm.Trigger, err = j.remindFromOffset(offset)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported trigger %q: %v", trigger.value, err)
+		}
+	}
+
+	err := j.validateAlarm(&alarm)
+	if err != nil {
+		return nil, err
+	}
+	return &alarm, nil
+}
+
+// 把提醒时间相对于参考时间的偏移转换为 Remind 格式的字符串
+func (j *Job) remindFromOffset(offset time.Duration) (string, error) {
+	offset = offset.Truncate(time.Minute)
+
+	if j.AllDay {
+		const day = 24 * time.Hour
+		nDays := 0
+		if offset < 0 {
+			nDays = int((-offset + day - 1) / day)
+		}
+		clock := offset + time.Duration(nDays)*day
+		if clock >= day {
+			return "", errors.New("after the day")
+		}
+		return fmt.Sprintf("%d;%02d:%02d", nDays,
+			int(clock/time.Hour), int(clock%time.Hour/time.Minute)), nil
+	}
+
+	if offset > 0 {
+		return "", errors.New("after the reference time")
+	}
+	return fmt.Sprint(int(-offset / time.Minute)), nil
+}
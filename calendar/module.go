@@ -77,6 +77,11 @@ func (m *Module) start() error {
 	if err != nil {
 		return err
 	}
+	// 数据库中有日程等个人数据，只允许自己读写
+	err = os.Chmod(dbFile, 0600)
+	if err != nil {
+		logger.Warning(err)
+	}
 	if logger.GetLogLevel() == log.LevelDebug {
 		db = db.Debug()
 	}
@@ -85,6 +90,15 @@ func (m *Module) start() error {
 		logger.Warning(err)
 	}
 
+	// 以前的日程没有记录时区，认为是当前的系统时区
+	tzid := getLocalTZID()
+	if tzid != "" {
+		err = db.Model(&Job{}).Where("tzid IS NULL OR tzid = ''").Update("TZID", tzid).Error
+		if err != nil {
+			logger.Warning(err)
+		}
+	}
+
 	hasJobTypeTable := db.HasTable(&JobType{})
 
 	err = db.AutoMigrate(&JobType{}).Error
@@ -99,6 +113,11 @@ func (m *Module) start() error {
 		}
 	}
 
+	err = db.AutoMigrate(&CalDAVBinding{}, &CalDAVTombstone{}, &CalDAVConflict{}).Error
+	if err != nil {
+		logger.Warning(err)
+	}
+
 	service := loader.GetService()
 	m.scheduler = newScheduler(db, service)
 
@@ -113,6 +132,7 @@ func (m *Module) start() error {
 	}
 
 	m.scheduler.startRemindLoop()
+	m.scheduler.startSyncLoop()
 	return nil
 }
 
@@ -0,0 +1,354 @@
+package calendar
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/teambition/rrule-go"
+)
+
+// UpdateJob 的修改范围
+const (
+	updateModeAll              = iota // 所有重复
+	updateModeThis                    // 仅这一次
+	updateModeThisAndFollowing        // 这一次及以后
+)
+
+// 返回重复日程中被单独修改过的那些重复的原开始时间，key 是重复日程的 ID。
+func getOverrideTimes(jobs []*Job) map[uint][]time.Time {
+	result := make(map[uint][]time.Time)
+	for _, job := range jobs {
+		if job.ParentID != 0 {
+			result[job.ParentID] = append(result[job.ParentID], job.toLocalTime(job.RecurrenceID))
+		}
+	}
+	return result
+}
+
+func removeRRuleParts(rule string, keys ...string) []string {
+	var result []string
+	for _, part := range strings.Split(rule, ";") {
+		key := strings.ToUpper(strings.SplitN(part, "=", 2)[0])
+		skip := false
+		for _, k := range keys {
+			if key == k {
+				skip = true
+				break
+			}
+		}
+		if !skip && part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+func setRRuleUntil(rule string, until time.Time) string {
+	parts := removeRRuleParts(rule, "UNTIL", "COUNT")
+	parts = append(parts, "UNTIL="+formatICSDateTime(until))
+	return strings.Join(parts, ";")
+}
+
+func setRRuleCount(rule string, count int) string {
+	parts := removeRRuleParts(rule, "UNTIL", "COUNT")
+	parts = append(parts, "COUNT="+strconv.Itoa(count))
+	return strings.Join(parts, ";")
+}
+
+// 返回重复日程在 t 之前的重复次数，以及规则中的 COUNT
+func (j *Job) countOccurrencesBefore(t time.Time) (n int, count int, err error) {
+	loc := j.getLocation()
+	rOpt, err := rrule.StrToROptionInLocation(j.RRule, loc)
+	if err != nil {
+		return
+	}
+	count = rOpt.Count
+	rOpt.Dtstart = j.Start.In(loc)
+	rule, err := rrule.NewRRule(*rOpt)
+	if err != nil {
+		return
+	}
+	next := rule.Iterator()
+	for n < recurrenceLimit {
+		start, ok := next()
+		if !ok || !start.Before(t) {
+			break
+		}
+		n++
+	}
+	return
+}
+
+func (s *Scheduler) getOverrideJobs(parentID uint) ([]*Job, error) {
+	var jobs []*Job
+	err := s.db.Where("parent_id = ?", parentID).Find(&jobs).Error
+	return jobs, err
+}
+
+// 保存单独修改的某一次重复，已经存在则更新。
+func (s *Scheduler) saveOverrideJob(job *Job) error {
+	children, err := s.getOverrideJobs(job.ParentID)
+	if err != nil {
+		return err
+	}
+	job.RRule = ""
+	job.Ignore = ""
+	for _, child := range children {
+		if child.RecurrenceID.Equal(job.RecurrenceID) {
+			job.ID = child.ID
+			return s.updateJob(job)
+		}
+	}
+	return s.createJob(job)
+}
+
+// job.Alarms 为空表示不修改提醒，此时使用重复日程的提醒。
+func jobAlarmsOrInherit(master, job *Job) (string, error) {
+	if job.Alarms != "" {
+		return job.Alarms, nil
+	}
+	return master.inheritAlarms()
+}
+
+func (s *Scheduler) updateJobWithMode(job *Job, mode int) (ids []uint, err error) {
+	if mode == updateModeAll && job.ParentID == 0 && job.RecurrenceID.IsZero() {
+		err = s.updateJob(job)
+		return []uint{job.ID}, err
+	}
+
+	masterID := job.ID
+	if job.ParentID != 0 {
+		masterID = job.ParentID
+	}
+	var master Job
+	err = s.db.First(&master, masterID).Error
+	if err != nil {
+		return
+	}
+	if master.RRule == "" {
+		// 不是重复日程，无所谓修改范围
+		job.ID = master.ID
+		job.ParentID = 0
+		err = s.updateJob(job)
+		return []uint{job.ID}, err
+	}
+	if job.RecurrenceID.IsZero() {
+		err = errors.New("job.RecurrenceID is empty")
+		return
+	}
+
+	switch mode {
+	case updateModeThis:
+		return s.updateJobOccurrence(&master, job)
+	case updateModeThisAndFollowing:
+		if job.RecurrenceID.After(master.Start) {
+			return s.splitJob(&master, job)
+		}
+		// 从第一次开始修改，等同于修改所有重复
+		fallthrough
+	case updateModeAll:
+		return s.updateJobSeries(&master, job)
+	default:
+		err = errors.New("invalid update mode")
+		return
+	}
+}
+
+func (s *Scheduler) updateJobOccurrence(master, job *Job) ([]uint, error) {
+	alarms, err := jobAlarmsOrInherit(master, job)
+	if err != nil {
+		return nil, err
+	}
+	override := &Job{
+		Type:         job.Type,
+		Title:        job.Title,
+		Description:  job.Description,
+		AllDay:       job.AllDay,
+		Start:        job.Start,
+		End:          job.End,
+		Remind:       job.Remind,
+		Alarms:       alarms,
+		TZID:         job.TZID,
+		ParentID:     master.ID,
+		RecurrenceID: job.RecurrenceID,
+	}
+	err = s.saveOverrideJob(override)
+	if err != nil {
+		return nil, err
+	}
+	return []uint{master.ID, override.ID}, nil
+}
+
+func (s *Scheduler) updateJobSeries(master, job *Job) ([]uint, error) {
+	// 把对这一次的时间修改应用到重复日程的开始时间上
+	delta := job.Start.Sub(job.RecurrenceID)
+	duration := job.End.Sub(job.Start)
+	job.Start = master.Start.Add(delta)
+	job.End = job.Start.Add(duration)
+	if job.ParentID != 0 {
+		job.RRule = master.RRule
+		job.Ignore = master.Ignore
+	}
+	job.ID = master.ID
+	job.ParentID = 0
+	job.RecurrenceID = time.Time{}
+
+	ids := []uint{master.ID}
+	if delta == 0 {
+		err := s.updateJob(job)
+		if err != nil {
+			return nil, err
+		}
+		return ids, nil
+	}
+
+	// 开始时间变了，忽略的重复和单独修改的重复也要跟着移动，同 splitJob
+	ignore, err := job.getIgnore()
+	if err != nil {
+		return nil, err
+	}
+	if len(ignore) > 0 {
+		for idx := range ignore {
+			ignore[idx] = ignore[idx].Add(delta)
+		}
+		err = job.setIgnore(ignore)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	children, err := s.getOverrideJobs(master.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.withTx(func(tx *gorm.DB) error {
+		err := s.updateJobTx(tx, job)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			err = tx.Model(child).Update("RecurrenceID", child.RecurrenceID.Add(delta)).Error
+			if err != nil {
+				return err
+			}
+			ids = append(ids, child.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// 把重复日程从 job.RecurrenceID 处一分为二，原日程截止到这一次之前，
+// 这一次及以后的重复按照 job 创建新的重复日程。
+func (s *Scheduler) splitJob(master, job *Job) ([]uint, error) {
+	splitTime := job.RecurrenceID
+	n, count, err := master.countOccurrencesBefore(splitTime)
+	if err != nil {
+		return nil, err
+	}
+
+	newRule := job.RRule
+	if job.ParentID != 0 {
+		newRule = master.RRule
+	}
+	if count > 0 && newRule == master.RRule {
+		if count <= n {
+			return nil, errors.New("no occurrence after split time")
+		}
+		newRule = setRRuleCount(newRule, count-n)
+	}
+
+	alarms, err := jobAlarmsOrInherit(master, job)
+	if err != nil {
+		return nil, err
+	}
+	newMaster := &Job{
+		Type:        job.Type,
+		Title:       job.Title,
+		Description: job.Description,
+		AllDay:      job.AllDay,
+		Start:       job.Start,
+		End:         job.End,
+		RRule:       newRule,
+		Remind:      job.Remind,
+		Alarms:      alarms,
+		TZID:        job.TZID,
+		Dirty:       true,
+	}
+	err = newMaster.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	delta := job.Start.Sub(splitTime)
+	ignore, err := master.getIgnore()
+	if err != nil {
+		return nil, err
+	}
+	var oldIgnore, newIgnore []time.Time
+	for _, t := range ignore {
+		if t.Before(splitTime) {
+			oldIgnore = append(oldIgnore, t)
+		} else {
+			newIgnore = append(newIgnore, t.Add(delta))
+		}
+	}
+	err = newMaster.setIgnore(newIgnore)
+	if err != nil {
+		return nil, err
+	}
+	err = master.setIgnore(oldIgnore)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := s.getOverrideJobs(master.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uint{master.ID}
+	err = s.withTx(func(tx *gorm.DB) error {
+		err := tx.Model(master).Updates(map[string]interface{}{
+			"RRule":  setRRuleUntil(master.RRule, splitTime.Add(-time.Second)),
+			"Ignore": master.Ignore,
+			"Dirty":  true,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(newMaster).Error
+		if err != nil {
+			return err
+		}
+		ids = append(ids, newMaster.ID)
+
+		for _, child := range children {
+			if child.RecurrenceID.Before(splitTime) {
+				continue
+			}
+			err = tx.Model(child).Updates(map[string]interface{}{
+				"ParentID":     newMaster.ID,
+				"RecurrenceID": child.RecurrenceID.Add(delta),
+			}).Error
+			if err != nil {
+				return err
+			}
+			ids = append(ids, child.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
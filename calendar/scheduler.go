@@ -2,12 +2,15 @@ package calendar
 
 import (
 	"encoding/json"
+	"errors"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/linuxdeepin/go-dbus-factory/org.freedesktop.notifications"
+	"github.com/linuxdeepin/go-dbus-factory/org.freedesktop.timedate1"
 	libdate "github.com/rickb777/date"
 	dbus "pkg.deepin.io/lib/dbus1"
 	"pkg.deepin.io/lib/dbusutil"
@@ -17,6 +20,8 @@ import (
 
 type Scheduler struct {
 	signalLoop          *dbusutil.SignalLoop
+	systemSigLoop       *dbusutil.SignalLoop
+	timedate            *timedate1.Timedate
 	db                  *gorm.DB
 	service             *dbusutil.Service
 	notifications       *notifications.Notifications
@@ -25,6 +30,8 @@ type Scheduler struct {
 	timerGroup          timerGroup
 	remindLaterTimers   map[uint]*time.Timer // key is job id
 	remindLaterTimersMu sync.Mutex
+	syncMu              sync.Mutex
+	passwords           passwordStore
 
 	changeChan chan []uint
 	quitChan   chan struct{}
@@ -37,12 +44,28 @@ type Scheduler struct {
 		UpdateJob func() `in:"jobInfo"`
 		CreateJob func() `in:"jobInfo" out:"id"`
 
+		UpdateJobWithMode func() `in:"jobInfo,mode"`
+
 		GetTypes   func() `out:"types"`
 		GetType    func() `in:"id" out:"type"`
 		DeleteType func() `in:"id"`
 		UpdateType func() `in:"typeInfo"`
 		CreateType func() `in:"typeInfo" out:"id"`
 
+		ImportICS func() `in:"path" out:"typeId"`
+		ExportICS func() `in:"typeId,path"`
+
+		BindCalDAV         func() `in:"typeId,url,username,password"`
+		UnbindCalDAV       func() `in:"typeId"`
+		GetCalDAVBindings  func() `out:"bindings"`
+		SyncCalDAV         func() `in:"typeId"`
+		GetSyncConflicts   func() `in:"typeId" out:"conflicts"`
+		DeleteSyncConflict func() `in:"id"`
+
+		GetUpcomingReminders func() `in:"window" out:"reminders"`
+		GetSnoozeDurations   func() `out:"durations"`
+		SetSnoozeDurations   func() `in:"durations"`
+
 		DebugRemindJob func() `in:"id"`
 	}
 
@@ -63,9 +86,19 @@ func newScheduler(db *gorm.DB, service *dbusutil.Service) *Scheduler {
 		notifications:     notifications.NewNotifications(sessionBus),
 		notifyJobMap:      make(map[uint32]*JobJSON),
 		remindLaterTimers: make(map[uint]*time.Timer),
+		passwords:         newKeyringPasswordStore(sessionBus),
 	}
 	s.signalLoop = dbusutil.NewSignalLoop(sessionBus, 10)
 	s.signalLoop.Start()
+
+	systemBus, err := dbus.SystemBus()
+	if err != nil {
+		logger.Warning(err)
+	} else {
+		s.timedate = timedate1.NewTimedate(systemBus)
+		s.systemSigLoop = dbusutil.NewSignalLoop(systemBus, 10)
+		s.systemSigLoop.Start()
+	}
 	s.listenDBusSignals()
 	return s
 }
@@ -73,6 +106,10 @@ func newScheduler(db *gorm.DB, service *dbusutil.Service) *Scheduler {
 func (s *Scheduler) destroy() {
 	s.notifications.RemoveAllHandlers()
 	s.signalLoop.Stop()
+	if s.timedate != nil {
+		s.timedate.RemoveAllHandlers()
+		s.systemSigLoop.Stop()
+	}
 	close(s.quitChan)
 }
 
@@ -146,6 +183,32 @@ func (s *Scheduler) listenDBusSignals() {
 			}
 		}
 	})
+
+	s.listenTimezoneChanged()
+}
+
+func (s *Scheduler) listenTimezoneChanged() {
+	if s.timedate == nil {
+		return
+	}
+	s.timedate.InitSignalExt(s.systemSigLoop, true)
+	err := s.timedate.Timezone().ConnectChanged(func(hasValue bool, value string) {
+		if !hasValue {
+			return
+		}
+		logger.Debug("property Timezone changed to", value)
+		loc, err := loadLocation(value)
+		if err != nil {
+			logger.Warning(err)
+			return
+		}
+		setLocalLocation(loc)
+		// 按照新的时区重新计算提醒时间
+		s.notifyJobsChange()
+	})
+	if err != nil {
+		logger.Warning(err)
+	}
 }
 
 func (s *Scheduler) emitJobsUpdated(ids ...uint) {
@@ -235,21 +298,17 @@ func (s *Scheduler) getJob(id uint) (*JobJSON, error) {
 }
 
 func (s *Scheduler) queryJobs(key string, startTime, endTime time.Time) ([]dateJobsWrap, error) {
+	// 需要所有的日程才能正确展开单独修改过的重复，所以在展开后再按关键字过滤。
 	var allJobs []*Job
-	db := s.db
-
-	key = strings.TrimSpace(key)
-	if key != "" {
-		db = db.Where("instr(title, ?)", key)
-	}
-
-	err := db.Find(&allJobs).Error
+	err := s.db.Find(&allJobs).Error
 	if err != nil {
 		return nil, err
 	}
+	key = strings.TrimSpace(key)
 
-	startDate := libdate.NewAt(startTime)
-	endDate := libdate.NewAt(endTime)
+	loc := getLocalLocation()
+	startDate := libdate.NewAt(startTime.In(loc))
+	endDate := libdate.NewAt(endTime.In(loc))
 
 	result := getJobsBetween(startDate, endDate, allJobs, true)
 
@@ -257,15 +316,15 @@ func (s *Scheduler) queryJobs(key string, startTime, endTime time.Time) ([]dateJ
 		start: startTime,
 		end:   endTime,
 	}
-	result = filterDateJobsWrap(result, timeRange)
+	result = filterDateJobsWrap(result, key, timeRange)
 	return result, nil
 }
 
-func filterDateJobsWrap(wraps []dateJobsWrap, timeRange TimeRange) []dateJobsWrap {
+func filterDateJobsWrap(wraps []dateJobsWrap, key string, timeRange TimeRange) []dateJobsWrap {
 	var result []dateJobsWrap
 	for _, wrap := range wraps {
-		wrap.jobs = filterJobs(wrap.jobs, timeRange)
-		wrap.extendJobs = filterJobs(wrap.extendJobs, timeRange)
+		wrap.jobs = filterJobs(wrap.jobs, key, timeRange)
+		wrap.extendJobs = filterJobs(wrap.extendJobs, key, timeRange)
 		if len(wrap.jobs)+len(wrap.extendJobs) > 0 {
 			result = append(result, wrap)
 		}
@@ -273,9 +332,12 @@ func filterDateJobsWrap(wraps []dateJobsWrap, timeRange TimeRange) []dateJobsWra
 	return result
 }
 
-func filterJobs(jobs []*Job, timeRange TimeRange) []*Job {
+func filterJobs(jobs []*Job, key string, timeRange TimeRange) []*Job {
 	var result []*Job
 	for _, job := range jobs {
+		if key != "" && !strings.Contains(job.Title, key) {
+			continue
+		}
 		if job.timeRange().overlap(timeRange) {
 			result = append(result, job)
 		}
@@ -285,21 +347,70 @@ func filterJobs(jobs []*Job, timeRange TimeRange) []*Job {
 
 func (s *Scheduler) deleteJob(id uint) error {
 	var job Job
-	err := s.db.Select("id").First(&job, id).Error
+	err := s.db.Select("id, type, parent_id, recurrence_id, href, etag").First(&job, id).Error
 	if err != nil {
 		return err
 	}
 
-	return s.db.Unscoped().Delete(&job).Error
+	return s.withTx(func(tx *gorm.DB) error {
+		if job.Href != "" {
+			// 已同步到服务器，记录下来，下次同步时从服务器上删除。
+			err := tx.Create(&CalDAVTombstone{
+				TypeID: uint(job.Type),
+				Href:   job.Href,
+				ETag:   job.ETag,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if job.ParentID != 0 {
+			// 删除单独修改过的某一次重复，原重复日程中也要忽略这一次。
+			var parent Job
+			err := tx.First(&parent, job.ParentID).Error
+			if err != nil {
+				return err
+			}
+			ignore, err := parent.getIgnore()
+			if err != nil {
+				return err
+			}
+			if !timeSliceContains(ignore, job.RecurrenceID) {
+				err = parent.setIgnore(append(ignore, job.RecurrenceID))
+				if err != nil {
+					return err
+				}
+			}
+			err = tx.Model(&parent).Updates(map[string]interface{}{
+				"Ignore": parent.Ignore,
+				"Dirty":  true,
+			}).Error
+			if err != nil {
+				return err
+			}
+		} else {
+			err := tx.Unscoped().Where("parent_id = ?", job.ID).Delete(&Job{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&job).Error
+	})
 }
 
 func (s *Scheduler) updateJob(job *Job) error {
+	return s.updateJobTx(s.db, job)
+}
+
+// updateJobTx 同 updateJob，但在 db 中执行，db 可以是事务。
+func (s *Scheduler) updateJobTx(db *gorm.DB, job *Job) error {
 	err := job.validate()
 	if err != nil {
 		return err
 	}
 	var job0 Job
-	err = s.db.Find(&job0, job.ID).Error
+	err = db.Find(&job0, job.ID).Error
 	if err != nil {
 		return err
 	}
@@ -330,12 +441,26 @@ func (s *Scheduler) updateJob(job *Job) error {
 	if job0.Remind != job.Remind {
 		diffMap["Remind"] = job.Remind
 	}
+	if job.Alarms != "" && job0.Alarms != job.Alarms {
+		diffMap["Alarms"] = job.Alarms
+	}
 	if job0.Ignore != job.Ignore {
 		diffMap["Ignore"] = job.Ignore
 	}
+	if job.AllDay {
+		// 全天日程的时间是按照当前系统时区传来的
+		job.TZID = getLocalTZID()
+	}
+	if job.TZID != "" && job0.TZID != job.TZID {
+		diffMap["TZID"] = job.TZID
+	}
 
 	if len(diffMap) > 0 {
-		err = s.db.Model(job).Updates(diffMap).Error
+		diffMap["Dirty"] = true
+		err = db.Model(job).Updates(diffMap).Error
+		if err == nil && job0.ParentID != 0 {
+			err = db.Model(&Job{}).Where("id = ?", job0.ParentID).Update("Dirty", true).Error
+		}
 	}
 	return err
 }
@@ -346,8 +471,15 @@ func (s *Scheduler) createJob(job *Job) error {
 		return err
 	}
 	job.ID = 0
+	job.Dirty = true
+	if job.TZID == "" || job.AllDay {
+		job.TZID = getLocalTZID()
+	}
 
 	err = s.db.Create(job).Error
+	if err == nil && job.ParentID != 0 {
+		err = s.markJobDirty(job.ParentID)
+	}
 	return err
 }
 
@@ -457,21 +589,21 @@ const (
 )
 
 func (s *Scheduler) remindJob(job *JobJSON) {
-	if job.Remind == "" {
-		logger.Warning("job.Remind is empty")
-		return
-	}
+	now := time.Now().In(getLocalLocation())
 
-	now := time.Now()
-
-	nDays, err := getRemindAdvanceDays(job.Remind)
-	if err != nil {
-		logger.Warning(err)
-		return
+	// 绝对时间的提醒没有提前的天数
+	var nDays int
+	if job.remindTrigger != "" {
+		var err error
+		nDays, err = getRemindAdvanceDays(job.remindTrigger)
+		if err != nil {
+			logger.Warning(err)
+			return
+		}
 	}
 
 	var actions []string
-	duration, durationMax := getRemindLaterDuration(job.remindLaterCount + 1)
+	duration, durationMax := getRemindLaterDuration(job.remindLaterCount+1, getSnoozeDurations())
 	if nDays >= 3 && job.remindLaterCount == 1 {
 		actions = []string{
 			notifyActKeyRemind1DayBefore, gettext.Tr("One day before start"),
@@ -523,6 +655,10 @@ func (s *Scheduler) setJobRemind(jj *JobJSON, remind string) error {
 	}
 
 	if job.RRule != "" {
+		alarms, err := job.inheritAlarms()
+		if err != nil {
+			return err
+		}
 		newJob := Job{
 			Type:        jj.Type,
 			Title:       jj.Title,
@@ -531,6 +667,8 @@ func (s *Scheduler) setJobRemind(jj *JobJSON, remind string) error {
 			Start:       jj.Start,
 			End:         jj.End,
 			Remind:      remind,
+			Alarms:      alarms,
+			Dirty:       true,
 		}
 		err = s.withTx(func(tx *gorm.DB) error {
 			ignore, err := job.getIgnore()
@@ -545,7 +683,10 @@ func (s *Scheduler) setJobRemind(jj *JobJSON, remind string) error {
 					return err
 				}
 
-				err = tx.Model(&job).Update("Ignore", job.Ignore).Error
+				err = tx.Model(&job).Updates(map[string]interface{}{
+					"Ignore": job.Ignore,
+					"Dirty":  true,
+				}).Error
 				if err != nil {
 					return err
 				}
@@ -564,7 +705,10 @@ func (s *Scheduler) setJobRemind(jj *JobJSON, remind string) error {
 		s.emitJobsUpdated(job.ID, newJob.ID)
 
 	} else {
-		err = s.db.Model(&job).Update("Remind", remind).Error
+		err = s.db.Model(&job).Updates(map[string]interface{}{
+			"Remind": remind,
+			"Dirty":  true,
+		}).Error
 		if err != nil {
 			return err
 		}
@@ -615,12 +759,27 @@ func (s *Scheduler) setJobRemindTomorrow(jj *JobJSON) error {
 	return s.setJobRemind(jj, remind)
 }
 
-func getRemindLaterDuration(count int) (time.Duration, bool) {
+// durations 为设置的稍后提醒的间隔，单位为分钟，第 count 次使用第 count 个，
+// 超出后一直使用最后一个。没有设置时从 10 分钟开始每次增加 5 分钟，最多 1 小时。
+func getRemindLaterDuration(count int, durations []int) (time.Duration, bool) {
 	max := false
-	duration := time.Duration(10+((count-1)*5)) * time.Minute
-	if duration >= time.Hour {
-		max = true
-		duration = time.Hour
+	var duration time.Duration
+	if len(durations) > 0 {
+		idx := count - 1
+		if idx >= len(durations)-1 {
+			idx = len(durations) - 1
+			max = true
+		}
+		if idx < 0 {
+			idx = 0
+		}
+		duration = time.Duration(durations[idx]) * time.Minute
+	} else {
+		duration = time.Duration(10+((count-1)*5)) * time.Minute
+		if duration >= time.Hour {
+			max = true
+			duration = time.Hour
+		}
 	}
 
 	if logger.GetLogLevel() == log.LevelDebug {
@@ -634,7 +793,7 @@ func getRemindLaterDuration(count int) (time.Duration, bool) {
 }
 
 func (s *Scheduler) remindJobLater(job *JobJSON) {
-	duration, _ := getRemindLaterDuration(job.remindLaterCount)
+	duration, _ := getRemindLaterDuration(job.remindLaterCount, getSnoozeDurations())
 	logger.Debug("remindJobLater duration:", duration)
 	timer := time.AfterFunc(duration, func() {
 		s.remindLaterTimersMu.Lock()
@@ -659,6 +818,7 @@ func (s *Scheduler) DebugRemindJob(id int64) *dbus.Error {
 	if err != nil {
 		return dbusutil.ToError(err)
 	}
+	jj.remindTrigger = job.Remind
 	s.remindJob(jj)
 	return nil
 }
@@ -716,32 +876,99 @@ func (s *Scheduler) startRemindLoop() {
 	}()
 }
 
+// 返回提醒时间在 tr 中的日程，一个日程有多个提醒时返回多个。
 func (s *Scheduler) getRemindJobs(tr TimeRange) ([]*Job, error) {
+	// 需要所有的日程才能正确展开单独修改过的重复
 	var allJobs []*Job
-	err := s.db.Find(&allJobs, "remind IS NOT NULL AND remind != '' ").Error
+	err := s.db.Find(&allJobs).Error
 	if err != nil {
 		return nil, err
 	}
-	startDate := libdate.NewAt(tr.start)
-	endDate := startDate.Add(8)
+	loc := getLocalLocation()
+	startDate := libdate.NewAt(tr.start.In(loc))
+	// 提醒最多提前 7 天
+	endDate := libdate.NewAt(tr.end.In(loc)).Add(8)
 
+	type remindKey struct {
+		id uint
+		t  int64
+	}
 	var result []*Job
+	seen := make(map[remindKey]bool)
 
-	wraps := getJobsBetween(startDate, endDate, allJobs, false)
+	// 相对结束时间的提醒可能属于开始于 startDate 之前的日程，所以需要 extend。
+	wraps := getJobsBetween(startDate, endDate, allJobs, true)
 	for _, wrap := range wraps {
-		for _, job := range wrap.jobs {
-			remindT, err := job.getRemindTime()
+		jobs := append(wrap.jobs[:len(wrap.jobs):len(wrap.jobs)], wrap.extendJobs...)
+		for _, job := range jobs {
+			alarms, err := job.getAlarms()
 			if err != nil {
+				logger.Warning(err)
 				continue
 			}
-			if !tr.start.After(remindT) &&
-				!remindT.After(tr.end) {
+			for idx := range alarms {
+				alarm := &alarms[idx]
+				remindT, err := job.getAlarmTime(alarm)
+				if err != nil {
+					continue
+				}
+				if tr.start.After(remindT) || remindT.After(tr.end) {
+					continue
+				}
 				// tr.start <= remindT <= tr.end
-				job.remindTime = remindT
-				result = append(result, job)
+				key := remindKey{id: job.ID, t: remindT.Unix()}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				j := *job
+				j.remindTime = remindT
+				if !alarm.isAbsolute() {
+					j.remindTrigger = alarm.Trigger
+				}
+				result = append(result, &j)
 			}
 		}
 	}
 
 	return result, nil
 }
+
+type upcomingReminder struct {
+	Job        *JobJSON
+	RemindTime time.Time
+}
+
+// 最多查询 30 天内的提醒
+const maxUpcomingRemindersWindow = 30 * 24 * time.Hour
+
+func (s *Scheduler) getUpcomingReminders(window time.Duration) ([]upcomingReminder, error) {
+	if window <= 0 {
+		return nil, errors.New("invalid window")
+	}
+	if window > maxUpcomingRemindersWindow {
+		window = maxUpcomingRemindersWindow
+	}
+	now := time.Now()
+	jobs, err := s.getRemindJobs(TimeRange{start: now, end: now.Add(window)})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].remindTime.Before(jobs[j].remindTime)
+	})
+
+	result := make([]upcomingReminder, 0, len(jobs))
+	for _, job := range jobs {
+		jj, err := job.toJobJSON()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, upcomingReminder{
+			Job:        jj,
+			RemindTime: job.remindTime,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,247 @@
+package calendar
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const defaultImportTypeColor = "#1e90ff"
+
+func (s *Scheduler) getOrCreateTypeByName(name, color string) (*JobType, error) {
+	var jobType JobType
+	err := s.db.Where("name = ?", name).First(&jobType).Error
+	if err == nil {
+		return &jobType, nil
+	}
+	if !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+
+	jobType = JobType{
+		Name:  name,
+		Color: color,
+	}
+	if jobType.validate() != nil {
+		jobType.Color = defaultImportTypeColor
+	}
+	err = s.createType(&jobType)
+	if err != nil {
+		return nil, err
+	}
+	return &jobType, nil
+}
+
+// 如果导入的类型中已有相同 UID 的日程则更新它，否则创建新日程，
+// 不同类型中 UID 相同的日程互不影响。
+func (s *Scheduler) saveImportedJob(job *Job) error {
+	if job.UID != "" {
+		var job0 Job
+		err := s.db.Select("id").Where("uid = ? AND type = ?", job.UID, job.Type).
+			First(&job0).Error
+		if err == nil {
+			job.ID = job0.ID
+			return s.updateJob(job)
+		}
+		if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+	}
+	return s.createJob(job)
+}
+
+func (s *Scheduler) importICS(filename string) (typeId uint, ids []uint, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	cal, err := parseICS(f)
+	if err != nil {
+		return
+	}
+	if cal.name != "VCALENDAR" {
+		err = errors.New("not a VCALENDAR")
+		return
+	}
+
+	calName := icsUnescapeText(cal.getPropValue("X-WR-CALNAME"))
+	if calName == "" {
+		base := filepath.Base(filename)
+		calName = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	jobType, err := s.getOrCreateTypeByName(calName, cal.getPropValue("X-APPLE-CALENDAR-COLOR"))
+	if err != nil {
+		return
+	}
+	typeId = jobType.ID
+
+	var overrides []*icsComponent
+	masters := make(map[string]*Job)
+	for _, ev := range cal.getComponents("VEVENT") {
+		if ev.getProp("RECURRENCE-ID") != nil {
+			overrides = append(overrides, ev)
+			continue
+		}
+		job, err := newJobFromVEvent(ev)
+		if err != nil {
+			logger.Warningf("failed to import event %q: %v", ev.getPropValue("UID"), err)
+			continue
+		}
+		job.Type = int(typeId)
+		err = s.saveImportedJob(job)
+		if err != nil {
+			logger.Warningf("failed to save imported job %q: %v", job.Title, err)
+			continue
+		}
+		ids = append(ids, job.ID)
+		if job.UID != "" && job.RRule != "" {
+			masters[job.UID] = job
+		}
+	}
+
+	// 重复日程中单独修改过的某一次，保存为子日程。
+	for _, ev := range overrides {
+		master := masters[ev.getPropValue("UID")]
+		if master == nil {
+			logger.Warningf("failed to import event %q: recurring event not found",
+				ev.getPropValue("UID"))
+			continue
+		}
+		job, err := newJobFromVEvent(ev)
+		if err != nil {
+			logger.Warningf("failed to import event %q: %v", ev.getPropValue("UID"), err)
+			continue
+		}
+		recurTime, _, err := ev.getProp("RECURRENCE-ID").getTime()
+		if err != nil {
+			logger.Warning(err)
+			continue
+		}
+		job.UID = ""
+		job.Type = int(typeId)
+		job.ParentID = master.ID
+		job.RecurrenceID = recurTime
+		err = s.saveOverrideJob(job)
+		if err != nil {
+			logger.Warningf("failed to save imported job %q: %v", job.Title, err)
+			continue
+		}
+		ids = append(ids, job.ID)
+	}
+	return
+}
+
+func (s *Scheduler) exportICS(typeId uint, filename string) error {
+	jobType, err := s.getType(typeId)
+	if err != nil {
+		return err
+	}
+
+	var jobs []*Job
+	err = s.db.Where("type = ?", typeId).Find(&jobs).Error
+	if err != nil {
+		return err
+	}
+
+	cal := newVCalendar()
+	cal.addTextProp("X-WR-CALNAME", jobType.Name)
+	cal.addProp("X-APPLE-CALENDAR-COLOR", jobType.Color)
+
+	jobMap := make(map[uint]*Job, len(jobs))
+	for _, job := range jobs {
+		jobMap[job.ID] = job
+	}
+
+	now := time.Now()
+	tzid := getLocalTZID()
+	for _, job := range jobs {
+		var ev *icsComponent
+		if job.ParentID != 0 {
+			parent := jobMap[job.ParentID]
+			if parent == nil {
+				parent = new(Job)
+				err = s.db.First(parent, job.ParentID).Error
+				if err != nil {
+					logger.Warningf("failed to export job %d: %v", job.ID, err)
+					continue
+				}
+			}
+			ev, err = overrideToVEvent(job, parent, now, tzid)
+		} else {
+			ev, err = job.toVEvent(now, tzid)
+		}
+		if err != nil {
+			logger.Warningf("failed to export job %d: %v", job.ID, err)
+			continue
+		}
+		cal.components = append(cal.components, ev)
+	}
+
+	var buf bytes.Buffer
+	err = cal.writeTo(&buf)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+func newVCalendar() *icsComponent {
+	cal := &icsComponent{name: "VCALENDAR"}
+	cal.addProp("VERSION", "2.0")
+	cal.addProp("PRODID", icsProdID)
+	cal.addProp("CALSCALE", "GREGORIAN")
+	return cal
+}
+
+// 子日程和重复日程使用相同的 UID，用 RECURRENCE-ID 区分
+func overrideToVEvent(job, parent *Job, now time.Time, tzid string) (*icsComponent, error) {
+	ev, err := job.toVEvent(now, tzid)
+	if err != nil {
+		return nil, err
+	}
+	if parent.TZID != "" {
+		tzid = parent.TZID
+	}
+	ev.getProp("UID").value = parent.getUID()
+	addTimeProp(ev, "RECURRENCE-ID", parent.toLocalTime(job.RecurrenceID), parent.AllDay, tzid)
+	return ev, nil
+}
+
+// 把重复日程和它的子日程转换为一个 iCalendar 对象
+func (s *Scheduler) jobToICS(job *Job) ([]byte, error) {
+	children, err := s.getOverrideJobs(job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tzid := getLocalTZID()
+	cal := newVCalendar()
+	ev, err := job.toVEvent(now, tzid)
+	if err != nil {
+		return nil, err
+	}
+	cal.components = append(cal.components, ev)
+	for _, child := range children {
+		ev, err = overrideToVEvent(child, job, now, tzid)
+		if err != nil {
+			return nil, err
+		}
+		cal.components = append(cal.components, ev)
+	}
+
+	var buf bytes.Buffer
+	err = cal.writeTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
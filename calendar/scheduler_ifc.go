@@ -81,6 +81,28 @@ func (s *Scheduler) UpdateJob(jobStr string) *dbus.Error {
 	return dbusutil.ToError(err)
 }
 
+// mode 0: 所有重复，1: 仅这一次，2: 这一次及以后。
+// 修改某一次重复时，jobInfo 中的 RecurrenceID 为这一次原来的开始时间。
+func (s *Scheduler) UpdateJobWithMode(jobStr string, mode int32) *dbus.Error {
+	var jj JobJSON
+	err := fromJson(jobStr, &jj)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+
+	job, err := jj.toJob()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	ids, err := s.updateJobWithMode(job, int(mode))
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	s.notifyJobsChange(ids...)
+	s.emitJobsUpdated(ids...)
+	return nil
+}
+
 func (s *Scheduler) CreateJob(jobStr string) (int64, *dbus.Error) {
 	var jj JobJSON
 	err := fromJson(jobStr, &jj)
@@ -149,3 +171,98 @@ func (s *Scheduler) UpdateType(typeStr string) *dbus.Error {
 	err = s.updateType(jt)
 	return dbusutil.ToError(err)
 }
+
+func (s *Scheduler) ImportICS(path string) (int64, *dbus.Error) {
+	typeId, ids, err := s.importICS(path)
+	if err != nil {
+		return 0, dbusutil.ToError(err)
+	}
+	if len(ids) > 0 {
+		s.notifyJobsChange(ids...)
+		s.emitJobsUpdated(ids...)
+	}
+	return int64(typeId), nil
+}
+
+func (s *Scheduler) ExportICS(typeId int64, path string) *dbus.Error {
+	err := s.exportICS(uint(typeId), path)
+	return dbusutil.ToError(err)
+}
+
+func (s *Scheduler) BindCalDAV(typeId int64, url, username, password string) *dbus.Error {
+	err := s.bindCalDAV(uint(typeId), url, username, password)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	go func() {
+		err := s.SyncCalDAV(typeId)
+		if err != nil {
+			logger.Warning(err)
+		}
+	}()
+	return nil
+}
+
+func (s *Scheduler) UnbindCalDAV(typeId int64) *dbus.Error {
+	err := s.unbindCalDAV(uint(typeId))
+	return dbusutil.ToError(err)
+}
+
+func (s *Scheduler) GetCalDAVBindings() (string, *dbus.Error) {
+	bindings, err := s.getCalDAVBindings()
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	result, err := toJson(bindings)
+	return result, dbusutil.ToError(err)
+}
+
+func (s *Scheduler) SyncCalDAV(typeId int64) *dbus.Error {
+	ids, err := s.syncCalDAV(uint(typeId))
+	if len(ids) > 0 {
+		s.notifyJobsChange(ids...)
+		s.emitJobsUpdated(ids...)
+	}
+	return dbusutil.ToError(err)
+}
+
+func (s *Scheduler) GetSyncConflicts(typeId int64) (string, *dbus.Error) {
+	conflicts, err := s.getSyncConflicts(uint(typeId))
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	result, err := toJson(conflicts)
+	return result, dbusutil.ToError(err)
+}
+
+func (s *Scheduler) DeleteSyncConflict(id int64) *dbus.Error {
+	err := s.deleteSyncConflict(uint(id))
+	return dbusutil.ToError(err)
+}
+
+func (s *Scheduler) GetUpcomingReminders(window int64) (string, *dbus.Error) {
+	reminders, err := s.getUpcomingReminders(time.Duration(window) * time.Second)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	result, err := toJson(reminders)
+	return result, dbusutil.ToError(err)
+}
+
+func (s *Scheduler) GetSnoozeDurations() ([]int32, *dbus.Error) {
+	durations := getSnoozeDurations()
+	result := make([]int32, len(durations))
+	for idx, d := range durations {
+		result[idx] = int32(d)
+	}
+	return result, nil
+}
+
+func (s *Scheduler) SetSnoozeDurations(durations []int32) *dbus.Error {
+	values := make([]int, len(durations))
+	for idx, d := range durations {
+		values[idx] = int(d)
+	}
+	err := setSnoozeDurations(values)
+	return dbusutil.ToError(err)
+}
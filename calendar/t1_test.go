@@ -1,9 +1,18 @@
 package calendar
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	libdate "github.com/rickb777/date"
 	"github.com/stretchr/testify/assert"
 )
@@ -304,3 +313,643 @@ func TestGetRemindJobBody(t *testing.T) {
 			"idx %d", idx)
 	}
 }
+
+func TestICSDuration(t *testing.T) {
+	tests := []struct {
+		str      string
+		duration time.Duration
+	}{
+		{"PT0S", 0},
+		{"-PT15M", -15 * time.Minute},
+		{"-P1DT15H", -39 * time.Hour},
+		{"P2D", 48 * time.Hour},
+		{"PT1H30M", 90 * time.Minute},
+	}
+	for idx, test := range tests {
+		d, err := parseICSDuration(test.str)
+		assert.Nil(t, err, "test idx: %d", idx)
+		assert.Equal(t, test.duration, d, "test idx: %d", idx)
+		assert.Equal(t, test.str, formatICSDuration(d), "test idx: %d", idx)
+	}
+
+	d, err := parseICSDuration("P1W")
+	assert.Nil(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+
+	_, err = parseICSDuration("1H")
+	assert.NotNil(t, err)
+	_, err = parseICSDuration("PT1D")
+	assert.NotNil(t, err)
+}
+
+const testICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:test-1\r\n" +
+	"SUMMARY:Weekly\\, meeting\r\n" +
+	"DTSTART:20190902T090000\r\n" +
+	"DTEND:20190902T100000\r\n" +
+	"RRULE:FREQ=WEEKLY\r\n" +
+	"EXDATE:20190909T090000\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:test-2\r\n" +
+	"SUMMARY:Holi\r\n" +
+	" day\r\n" +
+	"DTSTART;VALUE=DATE:20191001\r\n" +
+	"DTEND;VALUE=DATE:20191003\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15H\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestICSToJob(t *testing.T) {
+	cal, err := parseICS(strings.NewReader(testICS))
+	assert.Nil(t, err)
+	events := cal.getComponents("VEVENT")
+	assert.Len(t, events, 2)
+
+	job, err := newJobFromVEvent(events[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "test-1", job.UID)
+	assert.Equal(t, "Weekly, meeting", job.Title)
+	assert.False(t, job.AllDay)
+	assert.Equal(t, newTimeYMDHM(2019, 9, 2, 9, 0), job.Start)
+	assert.Equal(t, newTimeYMDHM(2019, 9, 2, 10, 0), job.End)
+	assert.Equal(t, "FREQ=WEEKLY", job.RRule)
+	assert.Equal(t, "15", job.Remind)
+	ignore, err := job.getIgnore()
+	assert.Nil(t, err)
+	assert.Len(t, ignore, 1)
+	assert.True(t, ignore[0].Equal(newTimeYMDHM(2019, 9, 9, 9, 0)))
+
+	job, err = newJobFromVEvent(events[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "Holiday", job.Title)
+	assert.True(t, job.AllDay)
+	assert.Equal(t, newTimeYMDHM(2019, 10, 1, 0, 0), job.Start)
+	assert.Equal(t, newTimeYMDHM(2019, 10, 2, 23, 59), job.End)
+	assert.Equal(t, "1;09:00", job.Remind)
+
+	// 导出后再导入，日程不变
+	ev, err := job.toVEvent(time.Now(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "20191003", ev.getPropValue("DTEND"))
+	assert.Equal(t, "-PT15H", ev.getComponents("VALARM")[0].getPropValue("TRIGGER"))
+	job1, err := newJobFromVEvent(ev)
+	assert.Nil(t, err)
+	assert.Equal(t, job.Start, job1.Start)
+	assert.Equal(t, job.End, job1.End)
+	assert.Equal(t, job.Remind, job1.Remind)
+}
+
+func TestJobAlarms(t *testing.T) {
+	job := &Job{
+		Title:  "Trip",
+		AllDay: true,
+		Start:  newTimeYMDHM(2019, 10, 1, 0, 0),
+		End:    newTimeYMDHM(2019, 10, 3, 23, 59),
+		Remind: "1;09:00",
+	}
+	absTime := newTimeYMDHM(2019, 9, 30, 20, 0)
+	err := job.setExtraAlarms([]JobAlarm{
+		{Trigger: "0;18:00", Related: alarmRelatedEnd},
+		{Time: absTime},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, job.validate())
+
+	alarms, err := job.getAlarms()
+	assert.Nil(t, err)
+	assert.Len(t, alarms, 3)
+	remindT, err := job.getAlarmTime(&alarms[0])
+	assert.Nil(t, err)
+	assert.Equal(t, newTimeYMDHM(2019, 9, 30, 9, 0), remindT)
+	remindT, err = job.getAlarmTime(&alarms[1])
+	assert.Nil(t, err)
+	assert.Equal(t, newTimeYMDHM(2019, 10, 3, 18, 0), remindT)
+
+	ev, err := job.toVEvent(time.Now(), "")
+	assert.Nil(t, err)
+	valarms := ev.getComponents("VALARM")
+	assert.Len(t, valarms, 3)
+	assert.Equal(t, "-PT6H", valarms[1].getPropValue("TRIGGER"))
+	assert.Equal(t, "END", valarms[1].getProp("TRIGGER").getParam("RELATED"))
+
+	job1, err := newJobFromVEvent(ev)
+	assert.Nil(t, err)
+	assert.Equal(t, job.Remind, job1.Remind)
+	extra, err := job1.getExtraAlarms()
+	assert.Nil(t, err)
+	assert.Len(t, extra, 2)
+	assert.Equal(t, "0;18:00", extra[0].Trigger)
+	assert.Equal(t, alarmRelatedEnd, extra[0].Related)
+	assert.True(t, extra[1].Time.Equal(absTime))
+
+	err = job.setExtraAlarms([]JobAlarm{{Time: newTimeYMDHM(2019, 9, 1, 0, 0)}})
+	assert.Nil(t, err)
+	assert.NotNil(t, job.validate())
+}
+
+func TestGetRemindLaterDuration(t *testing.T) {
+	d, max := getRemindLaterDuration(1, nil)
+	assert.Equal(t, 10*time.Minute, d)
+	assert.False(t, max)
+	d, max = getRemindLaterDuration(11, nil)
+	assert.Equal(t, time.Hour, d)
+	assert.True(t, max)
+
+	durations := []int{5, 15, 30}
+	d, max = getRemindLaterDuration(1, durations)
+	assert.Equal(t, 5*time.Minute, d)
+	assert.False(t, max)
+	d, max = getRemindLaterDuration(3, durations)
+	assert.Equal(t, 30*time.Minute, d)
+	assert.True(t, max)
+	d, _ = getRemindLaterDuration(5, durations)
+	assert.Equal(t, 30*time.Minute, d)
+}
+
+func TestSetRRuleUntil(t *testing.T) {
+	until := time.Date(2019, 9, 30, 8, 59, 59, 0, time.UTC)
+	assert.Equal(t, "FREQ=DAILY;UNTIL=20190930T085959Z",
+		setRRuleUntil("FREQ=DAILY;COUNT=10", until))
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO;UNTIL=20190930T085959Z",
+		setRRuleUntil("FREQ=WEEKLY;UNTIL=20191231T000000Z;BYDAY=MO", until))
+	assert.Equal(t, "FREQ=DAILY;COUNT=3", setRRuleCount("FREQ=DAILY;COUNT=10", 3))
+}
+
+func TestGetJobsBetweenOverride(t *testing.T) {
+	// 每天重复，9 月 3 日那次改到 14:00
+	job := &Job{
+		Start: newTimeYMDHM(2019, 9, 1, 9, 0),
+		End:   newTimeYMDHM(2019, 9, 1, 10, 0),
+		RRule: "FREQ=DAILY",
+	}
+	job.ID = 1
+	override := &Job{
+		Title:        "override",
+		Start:        newTimeYMDHM(2019, 9, 3, 14, 0),
+		End:          newTimeYMDHM(2019, 9, 3, 15, 0),
+		ParentID:     1,
+		RecurrenceID: newTimeYMDHM(2019, 9, 3, 9, 0),
+	}
+	override.ID = 2
+
+	wraps := getJobsBetween(libdate.New(2019, 9, 1), libdate.New(2019, 9, 5),
+		[]*Job{job, override}, false)
+	assert.Len(t, wraps, 5)
+	for idx, wrap := range wraps {
+		assert.Len(t, wrap.jobs, 1, "idx %d", idx)
+	}
+	assert.Equal(t, uint(2), wraps[2].jobs[0].ID)
+	assert.Equal(t, newTimeYMDHM(2019, 9, 3, 14, 0), wraps[2].jobs[0].Start)
+	assert.Equal(t, newTimeYMDHM(2019, 9, 4, 9, 0), wraps[3].jobs[0].RecurrenceID)
+}
+
+func newTestScheduler(t *testing.T) (*Scheduler, func()) {
+	dir, err := ioutil.TempDir("", "calendar-test")
+	assert.Nil(t, err)
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "scheduler.db"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&Job{}, &JobType{}).Error)
+	assert.Nil(t, db.AutoMigrate(&CalDAVBinding{}, &CalDAVTombstone{}, &CalDAVConflict{}).Error)
+	s := &Scheduler{
+		db:        db,
+		passwords: make(testPasswordStore),
+	}
+	return s, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUpdateJobSeries(t *testing.T) {
+	s, cleanup := newTestScheduler(t)
+	defer cleanup()
+
+	// 每天 9:00 重复，9 月 2 日那次删除，9 月 3 日那次改到 14:00
+	master := &Job{
+		Title: "master",
+		Start: newTimeYMDHM(2019, 9, 1, 9, 0),
+		End:   newTimeYMDHM(2019, 9, 1, 10, 0),
+		RRule: "FREQ=DAILY",
+	}
+	assert.Nil(t, master.setIgnore([]time.Time{newTimeYMDHM(2019, 9, 2, 9, 0)}))
+	assert.Nil(t, s.createJob(master))
+	override := &Job{
+		Title:        "override",
+		Start:        newTimeYMDHM(2019, 9, 3, 14, 0),
+		End:          newTimeYMDHM(2019, 9, 3, 15, 0),
+		ParentID:     master.ID,
+		RecurrenceID: newTimeYMDHM(2019, 9, 3, 9, 0),
+	}
+	assert.Nil(t, s.createJob(override))
+
+	// 把所有重复改到 10:00
+	job := *master
+	job.Start = newTimeYMDHM(2019, 9, 4, 10, 0)
+	job.End = newTimeYMDHM(2019, 9, 4, 11, 0)
+	job.RecurrenceID = newTimeYMDHM(2019, 9, 4, 9, 0)
+	ids, err := s.updateJobWithMode(&job, updateModeAll)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{master.ID, override.ID}, ids)
+
+	var jobs []*Job
+	assert.Nil(t, s.db.Order("id").Find(&jobs).Error)
+	assert.Len(t, jobs, 2)
+	assert.True(t, jobs[0].Start.Equal(newTimeYMDHM(2019, 9, 1, 10, 0)))
+	ignore, err := jobs[0].getIgnore()
+	assert.Nil(t, err)
+	assert.Len(t, ignore, 1)
+	assert.True(t, ignore[0].Equal(newTimeYMDHM(2019, 9, 2, 10, 0)))
+	assert.True(t, jobs[1].RecurrenceID.Equal(newTimeYMDHM(2019, 9, 3, 10, 0)))
+
+	// 删除的不再出现，单独修改的不会重复出现
+	wraps := getJobsBetween(libdate.New(2019, 9, 1), libdate.New(2019, 9, 4), jobs, false)
+	var titles []string
+	for _, wrap := range wraps {
+		for _, j := range wrap.jobs {
+			titles = append(titles, j.Title)
+		}
+	}
+	assert.Equal(t, []string{"master", "override", "master"}, titles)
+}
+
+func TestSaveImportedJob(t *testing.T) {
+	s, cleanup := newTestScheduler(t)
+	defer cleanup()
+
+	newJob := func(typeId int, title string) *Job {
+		return &Job{
+			Type:  typeId,
+			Title: title,
+			UID:   "uid-1",
+			Start: newTimeYMDHM(2019, 9, 1, 9, 0),
+			End:   newTimeYMDHM(2019, 9, 1, 10, 0),
+		}
+	}
+	job1 := newJob(1, "a")
+	assert.Nil(t, s.saveImportedJob(job1))
+	// 导入到另一个类型，不影响第一个类型中的日程
+	job2 := newJob(2, "b")
+	assert.Nil(t, s.saveImportedJob(job2))
+	assert.NotEqual(t, job1.ID, job2.ID)
+
+	// 再次导入到第一个类型，更新原来的日程
+	job3 := newJob(1, "c")
+	assert.Nil(t, s.saveImportedJob(job3))
+	assert.Equal(t, job1.ID, job3.ID)
+
+	var jobs []Job
+	assert.Nil(t, s.db.Order("id").Find(&jobs).Error)
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, "c", jobs[0].Title)
+	assert.Equal(t, 1, jobs[0].Type)
+	assert.Equal(t, "b", jobs[1].Title)
+	assert.Equal(t, 2, jobs[1].Type)
+}
+
+// 进程内的 CalDAV 服务器，只实现客户端用到的请求
+type calDAVStub struct {
+	mu        sync.Mutex
+	prefix    string
+	resources map[string][]byte // key is href
+	etags     map[string]string
+	version   int
+	auth      string
+	// 处理 PUT 前调用，用来模拟其他客户端同时修改
+	onPut func(href string)
+	// PUT 的响应中不返回 etag
+	noPutETag bool
+}
+
+func newCalDAVStub(prefix string) *calDAVStub {
+	return &calDAVStub{
+		prefix:    prefix,
+		resources: make(map[string][]byte),
+		etags:     make(map[string]string),
+	}
+}
+
+func (st *calDAVStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	href := r.URL.Path
+	st.auth = r.Header.Get("Authorization")
+	switch r.Method {
+	case "PROPFIND":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">`)
+		fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop>`+
+			`<d:resourcetype><d:collection/></d:resourcetype><cs:getctag>ctag-%d</cs:getctag>`+
+			`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, st.prefix, st.version)
+		if r.Header.Get("Depth") == "1" {
+			for h, etag := range st.etags {
+				fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop>`+
+					`<d:resourcetype/><d:getetag>%s</d:getetag>`+
+					`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, h, etag)
+			}
+		}
+		fmt.Fprint(w, `</d:multistatus>`)
+
+	case http.MethodGet:
+		data, ok := st.resources[href]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", st.etags[href])
+		w.Write(data)
+
+	case http.MethodHead:
+		etag, ok := st.etags[href]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag)
+
+	case http.MethodPut:
+		if st.onPut != nil {
+			st.onPut(href)
+		}
+		etag, exists := st.etags[href]
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		st.setResource(href, data)
+		if !st.noPutETag {
+			w.Header().Set("ETag", st.etags[href])
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		if r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != st.etags[href] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		st.version++
+		delete(st.resources, href)
+		delete(st.etags, href)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (st *calDAVStub) setResource(href string, data []byte) {
+	st.version++
+	st.resources[href] = data
+	st.etags[href] = fmt.Sprintf(`"%d"`, st.version)
+}
+
+// 测试中在请求之外访问 stub 时使用
+func (st *calDAVStub) withLock(fn func()) {
+	st.mu.Lock()
+	fn()
+	st.mu.Unlock()
+}
+
+type testPasswordStore map[uint]string
+
+func (ps testPasswordStore) getPassword(typeId uint) (string, error) {
+	password, ok := ps[typeId]
+	if !ok {
+		return "", fmt.Errorf("no password of type %d", typeId)
+	}
+	return password, nil
+}
+
+func (ps testPasswordStore) setPassword(typeId uint, label, password string) error {
+	ps[typeId] = password
+	return nil
+}
+
+func (ps testPasswordStore) deletePassword(typeId uint) error {
+	delete(ps, typeId)
+	return nil
+}
+
+func newTestEventICS(uid, summary string) []byte {
+	return []byte("BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:" + uid + "\r\n" +
+		"SUMMARY:" + summary + "\r\n" +
+		"DTSTART:20190902T090000\r\n" +
+		"DTEND:20190902T100000\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n")
+}
+
+func TestCalDAVSync(t *testing.T) {
+	s, cleanup := newTestScheduler(t)
+	defer cleanup()
+	stub := newCalDAVStub("/cal/work/")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	jobType := &JobType{Name: "work"}
+	assert.Nil(t, s.db.Create(jobType).Error)
+	typeId := jobType.ID
+	assert.Nil(t, s.bindCalDAV(typeId, server.URL+"/cal/work", "user", "secret"))
+
+	// 密码不保存在数据库中
+	var binding CalDAVBinding
+	assert.Nil(t, s.db.Where("type_id = ?", typeId).First(&binding).Error)
+	assert.False(t, s.db.Dialect().HasColumn(s.db.NewScope(&binding).TableName(), "password"))
+	assert.Equal(t, "secret", s.passwords.(testPasswordStore)[typeId])
+
+	getJob := func(href string) *Job {
+		var job Job
+		assert.Nil(t, s.db.Where("href = ?", href).First(&job).Error)
+		return &job
+	}
+
+	// 拉取服务器上的日程
+	remoteHref := "/cal/work/remote-1.ics"
+	stub.withLock(func() {
+		stub.setResource(remoteHref, newTestEventICS("remote-1", "remote"))
+	})
+	ids, err := s.syncCalDAV(typeId)
+	assert.Nil(t, err)
+	assert.Len(t, ids, 1)
+	job := getJob(remoteHref)
+	assert.Equal(t, "remote", job.Title)
+	assert.False(t, job.Dirty)
+	stub.withLock(func() {
+		assert.Equal(t, stub.etags[remoteHref], job.ETag)
+		assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", stub.auth)
+	})
+
+	// 上传本地新建的日程
+	local := &Job{
+		Title: "local",
+		Type:  int(typeId),
+		Start: newTimeYMDHM(2019, 9, 3, 9, 0),
+		End:   newTimeYMDHM(2019, 9, 3, 10, 0),
+	}
+	assert.Nil(t, s.createJob(local))
+	_, err = s.syncCalDAV(typeId)
+	assert.Nil(t, err)
+	assert.Nil(t, s.db.First(local, local.ID).Error)
+	assert.False(t, local.Dirty)
+	stub.withLock(func() {
+		assert.Len(t, stub.resources, 2)
+		assert.Contains(t, string(stub.resources[local.Href]), "SUMMARY:local")
+	})
+
+	// 拉取之后、上传之前服务器上的日程被修改，上传返回 412，
+	// 这时应立即拉取服务器上的版本，而不是等到 ctag 再次改变。
+	assert.Nil(t, s.db.Model(job).Updates(map[string]interface{}{
+		"Title": "changed locally",
+		"Dirty": true,
+	}).Error)
+	stub.withLock(func() {
+		stub.onPut = func(href string) {
+			if href == remoteHref {
+				stub.setResource(href, newTestEventICS("remote-1", "changed remotely"))
+				stub.onPut = nil
+			}
+		}
+	})
+	ids, err = s.syncCalDAV(typeId)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{job.ID}, ids)
+	job = getJob(remoteHref)
+	assert.Equal(t, "changed remotely", job.Title)
+	assert.False(t, job.Dirty)
+	stub.withLock(func() {
+		assert.Equal(t, stub.etags[remoteHref], job.ETag)
+	})
+
+	conflicts, err := s.getSyncConflicts(typeId)
+	assert.Nil(t, err)
+	assert.Len(t, conflicts, 1)
+
+	// 再次同步不会产生新的冲突，服务器上的修改也不会被覆盖
+	_, err = s.syncCalDAV(typeId)
+	assert.Nil(t, err)
+	conflicts, err = s.getSyncConflicts(typeId)
+	assert.Nil(t, err)
+	assert.Len(t, conflicts, 1)
+	stub.withLock(func() {
+		assert.Contains(t, string(stub.resources[remoteHref]), "SUMMARY:changed remotely")
+	})
+
+	assert.Nil(t, s.unbindCalDAV(typeId))
+	assert.Len(t, s.passwords.(testPasswordStore), 0)
+}
+
+func TestCalDAVClient(t *testing.T) {
+	stub := newCalDAVStub("/cal/work/")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	client, err := newCalDAVClient(server.URL+"/cal/work", "", "")
+	assert.Nil(t, err)
+
+	ctag0, err := client.getCTag()
+	assert.Nil(t, err)
+	assert.Equal(t, "ctag-0", ctag0)
+
+	href := client.newHref("uid-1")
+	assert.Equal(t, "/cal/work/uid-1.ics", href)
+	etag, err := client.put(href, []byte(testICS), "", true)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, etag)
+
+	// 已经存在，不能再创建
+	_, err = client.put(href, []byte(testICS), "", true)
+	assert.Equal(t, errPreconditionFailed, err)
+
+	etags, err := client.listETags()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{href: etag}, etags)
+
+	data, etag1, err := client.get(href)
+	assert.Nil(t, err)
+	assert.Equal(t, etag, etag1)
+	assert.Equal(t, testICS, string(data))
+
+	ctag1, err := client.getCTag()
+	assert.Nil(t, err)
+	assert.NotEqual(t, ctag0, ctag1)
+
+	// etag 过期
+	etag2, err := client.put(href, []byte(testICS), etag, false)
+	assert.Nil(t, err)
+	_, err = client.put(href, []byte(testICS), etag, false)
+	assert.Equal(t, errPreconditionFailed, err)
+	err = client.delete(href, etag)
+	assert.Equal(t, errPreconditionFailed, err)
+
+	// 服务器不返回 etag 时用 HEAD 获取
+	stub.withLock(func() {
+		stub.noPutETag = true
+	})
+	etag3, err := client.put(href, []byte(testICS), etag2, false)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, etag3)
+	assert.NotEqual(t, etag2, etag3)
+	// 不知道 etag 时不检查
+	etag4, err := client.put(href, []byte(testICS), "", false)
+	assert.Nil(t, err)
+	assert.NotEqual(t, etag3, etag4)
+
+	err = client.delete(href, etag4)
+	assert.Nil(t, err)
+
+	etags, err = client.listETags()
+	assert.Nil(t, err)
+	assert.Len(t, etags, 0)
+}
+
+func TestBetweenTZID(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	setLocalLocation(shanghai)
+	defer setLocalLocation(time.Local)
+
+	// 纽约时间每周五 09:00，2019-11-03 夏令时结束
+	job := &Job{
+		Start: time.Date(2019, 10, 25, 9, 0, 0, 0, newYork),
+		End:   time.Date(2019, 10, 25, 10, 0, 0, 0, newYork),
+		RRule: "FREQ=WEEKLY",
+		TZID:  "America/New_York",
+	}
+	jobTimes, err := job.between(libdate.New(2019, 10, 20), libdate.New(2019, 11, 10))
+	assert.Nil(t, err)
+	assert.Len(t, jobTimes, 3)
+	assert.Equal(t, time.Date(2019, 10, 25, 21, 0, 0, 0, shanghai), jobTimes[0].start)
+	assert.Equal(t, time.Date(2019, 11, 1, 21, 0, 0, 0, shanghai), jobTimes[1].start)
+	assert.Equal(t, time.Date(2019, 11, 8, 22, 0, 0, 0, shanghai), jobTimes[2].start)
+
+	// 全天日程在别的时区仍然是同一天
+	job = &Job{
+		Start:  time.Date(2019, 10, 1, 0, 0, 0, 0, newYork),
+		End:    time.Date(2019, 10, 1, 23, 59, 0, 0, newYork),
+		AllDay: true,
+		TZID:   "America/New_York",
+	}
+	jobTimes, err = job.between(libdate.New(2019, 10, 1), libdate.New(2019, 10, 1))
+	assert.Nil(t, err)
+	assert.Len(t, jobTimes, 1)
+	assert.Equal(t, newTimeYMDHM(2019, 10, 1, 0, 0), jobTimes[0].start)
+}
@@ -1,18 +1,86 @@
 package calendar
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"encoding/json"
 	"pkg.deepin.io/lib/libc"
 )
 
+// 进程启动后 time.Local 不会再改变，所以自己记录系统时区，在系统时区改变时更新。
+var (
+	localLoc   = time.Local
+	localLocMu sync.RWMutex
+
+	locCache   = make(map[string]*time.Location)
+	locCacheMu sync.Mutex
+)
+
+func getLocalLocation() *time.Location {
+	localLocMu.RLock()
+	loc := localLoc
+	localLocMu.RUnlock()
+	return loc
+}
+
+func setLocalLocation(loc *time.Location) {
+	localLocMu.Lock()
+	localLoc = loc
+	localLocMu.Unlock()
+}
+
+const localtimeFile = "/etc/localtime"
+
+// 获取系统时区的名称，比如 Asia/Shanghai，获取失败返回空字符串。
+func getLocalTZID() string {
+	loc := getLocalLocation()
+	if loc != time.Local {
+		// 是系统时区改变后加载的
+		return loc.String()
+	}
+
+	tz := os.Getenv("TZ")
+	if tz != "" {
+		return strings.TrimPrefix(tz, ":")
+	}
+
+	target, err := filepath.EvalSymlinks(localtimeFile)
+	if err != nil {
+		return ""
+	}
+	idx := strings.Index(target, "zoneinfo/")
+	if idx == -1 {
+		return ""
+	}
+	return target[idx+len("zoneinfo/"):]
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	locCacheMu.Lock()
+	defer locCacheMu.Unlock()
+
+	loc, ok := locCache[name]
+	if ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locCache[name] = loc
+	return loc, nil
+}
+
 func newTimeYMDHM(y int, m time.Month, d int, h int, min int) time.Time {
-	return time.Date(y, m, d, h, min, 0, 0, time.Local)
+	return time.Date(y, m, d, h, min, 0, 0, getLocalLocation())
 }
 
 func newTimeYMDHMS(y int, m time.Month, d int, h int, min int, s int) time.Time {
-	return time.Date(y, m, d, h, min, s, 0, time.Local)
+	return time.Date(y, m, d, h, min, s, 0, getLocalLocation())
 }
 
 func setClock(t1 time.Time, c Clock) time.Time {
@@ -0,0 +1,54 @@
+// Package bluezaudio picks the pulseaudio card profiles of bluetooth audio
+// devices, it is shared by the audio and bluetooth modules.
+package bluezaudio
+
+import (
+	"strings"
+
+	"pkg.deepin.io/lib/pulse"
+)
+
+const (
+	ProfileHandsfree = "handsfree_head_unit"
+	ProfileHeadset   = "headset_head_unit"
+)
+
+// IsA2DPProfile returns true if the card profile is for high quality
+// playback.
+func IsA2DPProfile(name string) bool {
+	return strings.HasPrefix(name, "a2dp")
+}
+
+// IsHeadsetProfile returns true if the card profile has microphone.
+func IsHeadsetProfile(name string) bool {
+	return name == ProfileHandsfree || name == ProfileHeadset
+}
+
+// GetA2DPProfile returns the a2dp profile of highest priority of the
+// bluetooth card.
+func GetA2DPProfile(card *pulse.Card) string {
+	var name string
+	var priority uint32
+	for _, p := range card.Profiles {
+		if IsA2DPProfile(p.Name) && (name == "" || p.Priority > priority) {
+			name = p.Name
+			priority = p.Priority
+		}
+	}
+	return name
+}
+
+// GetHeadsetProfile returns the profile of the bluetooth card which has
+// microphone, HFP is preferred to HSP.
+func GetHeadsetProfile(card *pulse.Card) string {
+	var name string
+	for _, p := range card.Profiles {
+		switch p.Name {
+		case ProfileHandsfree:
+			return p.Name
+		case ProfileHeadset:
+			name = p.Name
+		}
+	}
+	return name
+}
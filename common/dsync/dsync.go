@@ -1,13 +1,14 @@
 package dsync
 
 import (
-	"encoding/json"
+	"path/filepath"
 
 	ofdbus "github.com/linuxdeepin/go-dbus-factory/org.freedesktop.dbus"
 	"pkg.deepin.io/lib/dbus1"
 	"pkg.deepin.io/lib/dbusutil"
 	"pkg.deepin.io/lib/dbusutil/proxy"
 	"pkg.deepin.io/lib/log"
+	"pkg.deepin.io/lib/xdg/basedir"
 )
 
 type Interface interface {
@@ -18,6 +19,7 @@ type Interface interface {
 type Config struct {
 	name       string
 	core       Interface
+	versioned  *Versioned
 	dbusDaemon *ofdbus.DBus
 	path       dbus.ObjectPath
 	sigLoop    *dbusutil.SignalLoop
@@ -37,6 +39,8 @@ func NewConfig(name string, core Interface, sessionSigLoop *dbusutil.SignalLoop,
 		path:    path,
 		logger:  logger,
 	}
+	c.versioned = NewVersioned(name, core, filepath.Join(basedir.GetUserConfigDir(),
+		"deepin/dde-daemon/dsync", name+".json"))
 
 	sessionBus := sessionSigLoop.Conn()
 	c.dbusDaemon = ofdbus.NewDBus(sessionBus)
@@ -75,20 +79,24 @@ func (*Config) GetInterfaceName() string {
 	return "com.deepin.sync.Config"
 }
 
+// Get is called by the sync daemon to upload the data, there is no reply of
+// the upload, so the data is considered uploaded once returned.
 func (c *Config) Get() ([]byte, *dbus.Error) {
-	v, err := c.core.Get()
+	data, err := c.versioned.Get()
 	if err != nil {
 		return nil, dbusutil.ToError(err)
 	}
-
-	data, err := json.Marshal(v)
+	err = c.versioned.Synced(data)
 	if err != nil {
-		return nil, dbusutil.ToError(err)
+		c.logger.Warning(err)
 	}
 	return data, nil
 }
 
 func (c *Config) Set(data []byte) *dbus.Error {
-	err := c.core.Set(data)
+	err := c.versioned.Set(data)
+	if err != nil {
+		c.logger.Warning(err)
+	}
 	return dbusutil.ToError(err)
 }
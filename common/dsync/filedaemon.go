@@ -0,0 +1,60 @@
+package dsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileDaemon is a stand-in for the sync daemon which keeps the data of each
+// config in a file, so that the merge behavior of modules can be tested
+// offline by pushing and pulling the data of several devices.
+type FileDaemon struct {
+	dir string
+}
+
+func NewFileDaemon(dir string) *FileDaemon {
+	return &FileDaemon{dir: dir}
+}
+
+func (d *FileDaemon) filename(name string) string {
+	return filepath.Join(d.dir, name+".json")
+}
+
+// Push uploads the data of v.
+func (d *FileDaemon) Push(v *Versioned) error {
+	data, err := v.Get()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(d.dir, 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(d.filename(v.Name()), data, 0644)
+	if err != nil {
+		return err
+	}
+	return v.Synced(data)
+}
+
+// Pull downloads the data uploaded by other devices to v.
+func (d *FileDaemon) Pull(v *Versioned) error {
+	data, err := ioutil.ReadFile(d.filename(v.Name()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return v.Set(data)
+}
+
+// Sync pulls and then pushes the data of v, like the sync daemon does.
+func (d *FileDaemon) Sync(v *Versioned) error {
+	err := d.Pull(v)
+	if err != nil {
+		return err
+	}
+	return d.Push(v)
+}
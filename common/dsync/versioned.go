@@ -0,0 +1,365 @@
+package dsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// metaKey is the key of the envelope in the synchronized JSON object. Devices
+// which do not know it just ignore it, so the payload is still compatible
+// with the old format.
+const metaKey = "_dsync"
+
+var ErrNewerSchema = errors.New("data schema is newer than local")
+
+// KeyMerger can be implemented by an Interface to merge the value of a top
+// level key which has been changed both locally and remotely since the last
+// synchronization, base is the value of the last synchronization and may be
+// nil. If it returns a nil value or there is no KeyMerger, the newer value
+// wins.
+type KeyMerger interface {
+	MergeKey(key string, base, local, remote json.RawMessage) (json.RawMessage, error)
+}
+
+// Migrator can be implemented by an Interface whose data format has changed,
+// data of older schema versions are migrated before being merged.
+type Migrator interface {
+	SchemaVersion() int
+	Migrate(fromVersion int, data []byte) ([]byte, error)
+}
+
+type envelope struct {
+	SchemaVersion int              `json:"schema_version"`
+	Timestamps    map[string]int64 `json:"timestamps"`
+}
+
+type versionState struct {
+	// modification time of top level keys, in unix nanoseconds
+	Timestamps map[string]int64
+	// values seen last time, to find out local changes
+	Values   map[string]json.RawMessage
+	SyncedAt int64
+}
+
+// Versioned wraps an Interface, adds a version/timestamp envelope to the data
+// and merges the data from other devices key by key.
+type Versioned struct {
+	name      string
+	core      Interface
+	stateFile string
+	mu        sync.Mutex
+	now       func() time.Time
+}
+
+func NewVersioned(name string, core Interface, stateFile string) *Versioned {
+	return &Versioned{
+		name:      name,
+		core:      core,
+		stateFile: stateFile,
+		now:       time.Now,
+	}
+}
+
+func (v *Versioned) Name() string {
+	return v.name
+}
+
+func (v *Versioned) schemaVersion() int {
+	if m, ok := v.core.(Migrator); ok {
+		return m.SchemaVersion()
+	}
+	return 0
+}
+
+func (v *Versioned) loadState() *versionState {
+	var state versionState
+	content, err := ioutil.ReadFile(v.stateFile)
+	if err == nil {
+		err = json.Unmarshal(content, &state)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		// the state is just a cache, start over
+		state = versionState{}
+	}
+	if state.Timestamps == nil {
+		state.Timestamps = make(map[string]int64)
+	}
+	if state.Values == nil {
+		state.Values = make(map[string]json.RawMessage)
+	}
+	return &state
+}
+
+func (v *Versioned) saveState(state *versionState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(v.stateFile), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(v.stateFile, content, 0644)
+}
+
+func (v *Versioned) getLocalValues() (map[string]json.RawMessage, error) {
+	data, err := v.core.Get()
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	err = json.Unmarshal(content, &values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: data is not a JSON object: %v", v.name, err)
+	}
+	return values, nil
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var bufA, bufB bytes.Buffer
+	if json.Compact(&bufA, a) != nil || json.Compact(&bufB, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
+
+// refresh updates the timestamps of keys changed locally.
+func (state *versionState) refresh(values map[string]json.RawMessage, now int64) {
+	for key, value := range values {
+		old, ok := state.Values[key]
+		if !ok || !jsonEqual(old, value) {
+			state.Values[key] = value
+			state.Timestamps[key] = now
+		}
+	}
+	for key := range state.Values {
+		if _, ok := values[key]; !ok {
+			delete(state.Values, key)
+			delete(state.Timestamps, key)
+		}
+	}
+}
+
+// Get returns the local data with the envelope, the keys changed since the
+// last synchronization are stamped with the current time. It does not change
+// the state, call Synced after the data is uploaded.
+func (v *Versioned) Get() ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	values, err := v.getLocalValues()
+	if err != nil {
+		return nil, err
+	}
+	state := v.loadState()
+	state.refresh(values, v.now().UnixNano())
+
+	meta, err := json.Marshal(&envelope{
+		SchemaVersion: v.schemaVersion(),
+		Timestamps:    state.Timestamps,
+	})
+	if err != nil {
+		return nil, err
+	}
+	values[metaKey] = meta
+	return json.Marshal(values)
+}
+
+// Synced records the data returned by Get as uploaded, the later changes of
+// the local data are local changes.
+func (v *Versioned) Synced(data []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var values map[string]json.RawMessage
+	err := json.Unmarshal(data, &values)
+	if err != nil {
+		return err
+	}
+	var meta envelope
+	err = json.Unmarshal(values[metaKey], &meta)
+	if err != nil {
+		return err
+	}
+	delete(values, metaKey)
+
+	state := v.loadState()
+	state.Values = values
+	state.Timestamps = meta.Timestamps
+	if state.Timestamps == nil {
+		state.Timestamps = make(map[string]int64)
+	}
+	state.SyncedAt = v.now().UnixNano()
+	return v.saveState(state)
+}
+
+func (v *Versioned) Set(data []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var remote map[string]json.RawMessage
+	err := json.Unmarshal(data, &remote)
+	if err != nil {
+		return err
+	}
+	metaData, ok := remote[metaKey]
+	if !ok {
+		// data of old version, the last writer wins
+		err = v.core.Set(data)
+		if err != nil {
+			return err
+		}
+		return v.saveLocalValues(v.loadState())
+	}
+	var meta envelope
+	err = json.Unmarshal(metaData, &meta)
+	if err != nil {
+		return err
+	}
+	delete(remote, metaKey)
+
+	if meta.SchemaVersion != v.schemaVersion() {
+		remote, err = v.migrate(remote, meta.SchemaVersion)
+		if err != nil {
+			return err
+		}
+		// keys may be renamed by migration, use the latest timestamp for them
+		var latest int64
+		for _, ts := range meta.Timestamps {
+			if ts > latest {
+				latest = ts
+			}
+		}
+		for key := range remote {
+			if _, ok := meta.Timestamps[key]; !ok {
+				if meta.Timestamps == nil {
+					meta.Timestamps = make(map[string]int64)
+				}
+				meta.Timestamps[key] = latest
+			}
+		}
+	}
+
+	local, err := v.getLocalValues()
+	if err != nil {
+		return err
+	}
+	state := v.loadState()
+	now := v.now().UnixNano()
+	if state.SyncedAt == 0 {
+		// never synchronized, the remote data wins
+		now = 0
+	}
+	base := make(map[string]json.RawMessage, len(state.Values))
+	for key, value := range state.Values {
+		base[key] = value
+	}
+	state.refresh(local, now)
+
+	merged, changed, err := v.merge(state, base, local, remote, meta.Timestamps)
+	if err != nil {
+		return err
+	}
+	if changed {
+		content, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		err = v.core.Set(content)
+		if err != nil {
+			return err
+		}
+	}
+	return v.saveLocalValues(state)
+}
+
+func (v *Versioned) migrate(values map[string]json.RawMessage,
+	fromVersion int) (map[string]json.RawMessage, error) {
+	if fromVersion > v.schemaVersion() {
+		return nil, ErrNewerSchema
+	}
+
+	content, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	content, err = v.core.(Migrator).Migrate(fromVersion, content)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]json.RawMessage
+	err = json.Unmarshal(content, &result)
+	return result, err
+}
+
+// merge merges remote values into local values, base is the values of the
+// last synchronization. The timestamps in state are updated to the merged
+// values.
+func (v *Versioned) merge(state *versionState, base, local, remote map[string]json.RawMessage,
+	remoteTimestamps map[string]int64) (map[string]json.RawMessage, bool, error) {
+	merger, _ := v.core.(KeyMerger)
+	merged := make(map[string]json.RawMessage, len(local))
+	for key, value := range local {
+		merged[key] = value
+	}
+
+	changed := false
+	for key, remoteValue := range remote {
+		localValue, ok := local[key]
+		if ok && jsonEqual(localValue, remoteValue) {
+			continue
+		}
+		localTs := state.Timestamps[key]
+		remoteTs := remoteTimestamps[key]
+
+		if ok && merger != nil && localTs > state.SyncedAt && remoteTs > state.SyncedAt {
+			value, err := merger.MergeKey(key, base[key], localValue, remoteValue)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: failed to merge key %q: %v", v.name, key, err)
+			}
+			if value != nil {
+				merged[key] = value
+				// the merged value is a new value, it should win on other devices
+				state.Timestamps[key] = v.now().UnixNano()
+				changed = true
+				continue
+			}
+		}
+
+		if !ok || remoteTs > localTs {
+			merged[key] = remoteValue
+			state.Timestamps[key] = remoteTs
+			changed = true
+		}
+	}
+	return merged, changed, nil
+}
+
+// saveLocalValues saves the values of core after being set, so that the
+// changes made by Set are not considered as local changes.
+func (v *Versioned) saveLocalValues(state *versionState) error {
+	values, err := v.getLocalValues()
+	if err != nil {
+		return err
+	}
+	now := v.now().UnixNano()
+	for key, value := range values {
+		if _, ok := state.Timestamps[key]; !ok {
+			state.Timestamps[key] = now
+		}
+		state.Values[key] = value
+	}
+	state.SyncedAt = now
+	return v.saveState(state)
+}
@@ -0,0 +1,259 @@
+package dsync
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testData struct {
+	Version string `json:"version"`
+	A       string `json:"a"`
+	B       string `json:"b"`
+	List    []int  `json:"list"`
+}
+
+type testCore struct {
+	data testData
+}
+
+func (c *testCore) Get() (interface{}, error) {
+	return &c.data, nil
+}
+
+func (c *testCore) Set(data []byte) error {
+	return json.Unmarshal(data, &c.data)
+}
+
+// testMergeCore merges the key list by union, the items removed on one device
+// are not added back. The newer value of the key a wins.
+type testMergeCore struct {
+	testCore
+}
+
+func containsInt(list []int, v int) bool {
+	for _, v0 := range list {
+		if v0 == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *testMergeCore) MergeKey(key string, base, local, remote json.RawMessage) (json.RawMessage, error) {
+	switch key {
+	case "a":
+		return nil, nil
+	case "list":
+	default:
+		return nil, errors.New("unexpected key")
+	}
+	var b, l, r []int
+	if base != nil {
+		err := json.Unmarshal(base, &b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := json.Unmarshal(local, &l)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(remote, &r)
+	if err != nil {
+		return nil, err
+	}
+	// the items removed on either device are removed, the items added on
+	// either device are added
+	var result []int
+	for _, v := range l {
+		if containsInt(r, v) || !containsInt(b, v) {
+			result = append(result, v)
+		}
+	}
+	for _, v := range r {
+		if !containsInt(b, v) && !containsInt(result, v) {
+			result = append(result, v)
+		}
+	}
+	return json.Marshal(result)
+}
+
+func (c *testMergeCore) SchemaVersion() int {
+	return 2
+}
+
+func (c *testMergeCore) Migrate(fromVersion int, data []byte) ([]byte, error) {
+	var values map[string]interface{}
+	err := json.Unmarshal(data, &values)
+	if err != nil {
+		return nil, err
+	}
+	// version 1 calls key b as old_b
+	if fromVersion < 2 {
+		values["b"] = values["old_b"]
+		delete(values, "old_b")
+	}
+	return json.Marshal(values)
+}
+
+func newTestClock() func() time.Time {
+	t := time.Unix(1000, 0)
+	return func() time.Time {
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func newTestDevice(dir, name string, core Interface, now func() time.Time) *Versioned {
+	v := NewVersioned("test", core, filepath.Join(dir, name, "state.json"))
+	v.now = now
+	return v
+}
+
+func TestVersionedMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := newTestClock()
+	daemon := NewFileDaemon(filepath.Join(dir, "daemon"))
+	coreA := &testMergeCore{testCore{data: testData{Version: "1.0", A: "a0", B: "b0"}}}
+	coreB := &testMergeCore{testCore{data: testData{Version: "1.0", A: "x", B: "y"}}}
+	devA := newTestDevice(dir, "a", coreA, now)
+	devB := newTestDevice(dir, "b", coreB, now)
+
+	// B has never synchronized, takes all data of A
+	assert.NoError(t, daemon.Sync(devA))
+	assert.NoError(t, daemon.Sync(devB))
+	assert.Equal(t, coreA.data, coreB.data)
+
+	// different keys changed on both devices are all kept
+	coreA.data.A = "a1"
+	coreB.data.B = "b1"
+	assert.NoError(t, daemon.Sync(devA))
+	assert.NoError(t, daemon.Sync(devB))
+	assert.NoError(t, daemon.Sync(devA))
+	assert.Equal(t, "a1", coreA.data.A)
+	assert.Equal(t, "b1", coreA.data.B)
+	assert.Equal(t, coreA.data, coreB.data)
+
+	// the same key changed on both devices is merged by MergeKey
+	coreA.data.List = []int{1}
+	coreB.data.List = []int{2}
+	assert.NoError(t, daemon.Sync(devA))
+	assert.NoError(t, daemon.Sync(devB))
+	assert.NoError(t, daemon.Sync(devA))
+	assert.Equal(t, []int{2, 1}, coreB.data.List)
+	assert.Equal(t, coreB.data.List, coreA.data.List)
+
+	// MergeKey gets the value of the last synchronization, so that the item
+	// removed on one device is not added back by the other
+	coreA.data.List = []int{2}
+	coreB.data.List = []int{2, 1, 3}
+	assert.NoError(t, daemon.Sync(devA))
+	assert.NoError(t, daemon.Sync(devB))
+	assert.NoError(t, daemon.Sync(devA))
+	assert.Equal(t, []int{2, 3}, coreB.data.List)
+	assert.Equal(t, coreB.data.List, coreA.data.List)
+
+	// MergeKey returns nil for the key a, the newer value wins
+	coreB.data.A = "b2"
+	coreA.data.A = "a2"
+	assert.NoError(t, daemon.Sync(devB))
+	assert.NoError(t, daemon.Sync(devA))
+	assert.NoError(t, daemon.Sync(devB))
+	assert.Equal(t, "a2", coreA.data.A)
+	assert.Equal(t, "a2", coreB.data.A)
+}
+
+func TestVersionedLastWriterWins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := newTestClock()
+	daemon := NewFileDaemon(filepath.Join(dir, "daemon"))
+	coreA := &testCore{data: testData{A: "a0"}}
+	coreB := &testCore{}
+	devA := newTestDevice(dir, "a", coreA, now)
+	devB := newTestDevice(dir, "b", coreB, now)
+	assert.NoError(t, daemon.Sync(devA))
+	assert.NoError(t, daemon.Sync(devB))
+
+	coreB.data.A = "b"
+	coreA.data.A = "a"
+	assert.NoError(t, daemon.Sync(devB))
+	assert.NoError(t, daemon.Sync(devA))
+	assert.Equal(t, "a", coreA.data.A)
+	assert.NoError(t, daemon.Sync(devB))
+	assert.Equal(t, "a", coreB.data.A)
+}
+
+func TestVersionedCompatibility(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	core := &testMergeCore{testCore{data: testData{A: "a", B: "b"}}}
+	dev := newTestDevice(dir, "a", core, newTestClock())
+
+	// data without envelope replaces the local data
+	assert.NoError(t, dev.Set([]byte(`{"version":"1.0","a":"old","b":"old"}`)))
+	assert.Equal(t, "old", core.data.A)
+
+	// data of old schema is migrated
+	assert.NoError(t, dev.Set([]byte(`{"a":"old","old_b":"new",`+
+		`"_dsync":{"schema_version":1,"timestamps":{"old_b":9000000000000}}}`)))
+	assert.Equal(t, "new", core.data.B)
+
+	// data of newer schema is rejected
+	assert.Equal(t, ErrNewerSchema, dev.Set([]byte(`{"_dsync":{"schema_version":3}}`)))
+
+	data, err := dev.Get()
+	assert.NoError(t, err)
+	var values map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(data, &values))
+	assert.Contains(t, values, metaKey)
+	assert.Equal(t, `"new"`, string(values["b"]))
+}
+
+func TestVersionedGetSynced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsync")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	core := &testCore{data: testData{A: "a"}}
+	dev := newTestDevice(dir, "a", core, newTestClock())
+
+	// Get does not save the state
+	data, err := dev.Get()
+	assert.NoError(t, err)
+	_, err = os.Stat(dev.stateFile)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, dev.Synced(data))
+	state := dev.loadState()
+	assert.Equal(t, `"a"`, string(state.Values["a"]))
+	assert.NotZero(t, state.SyncedAt)
+	tsA := state.Timestamps["a"]
+	assert.True(t, tsA < state.SyncedAt)
+
+	// the unchanged key keeps its timestamp, the changed key is stamped again
+	core.data.B = "b"
+	data, err = dev.Get()
+	assert.NoError(t, err)
+	var values map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(data, &values))
+	var meta envelope
+	assert.NoError(t, json.Unmarshal(values[metaKey], &meta))
+	assert.Equal(t, tsA, meta.Timestamps["a"])
+	assert.True(t, meta.Timestamps["b"] > state.SyncedAt)
+	assert.Equal(t, state, dev.loadState())
+}
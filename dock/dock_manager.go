@@ -35,6 +35,7 @@ import (
 	"github.com/linuxdeepin/go-dbus-factory/com.deepin.wmswitcher"
 	"github.com/linuxdeepin/go-x11-client"
 	"pkg.deepin.io/dde/daemon/common/dsync"
+	"pkg.deepin.io/dde/daemon/network/proxychains"
 	"pkg.deepin.io/gir/gio-2.0"
 	"pkg.deepin.io/lib/dbus1"
 	"pkg.deepin.io/lib/dbusutil"
@@ -201,7 +202,21 @@ func (m *Manager) destroy() {
 }
 
 func (m *Manager) launch(desktopFile string, timestamp uint32, files []string) {
-	err := m.startManager.LaunchApp(dbus.FlagNoAutoStart, desktopFile, timestamp, files)
+	// use the proxy set by the rules of network module
+	cmdPrefixes, err := proxychains.GetDesktopAppCmdPrefixes(desktopFile)
+	if err != nil {
+		logger.Warning("failed to get proxy rule:", err)
+	}
+	if len(cmdPrefixes) > 0 {
+		logger.Debugf("launch %q with prefixes %v", desktopFile, cmdPrefixes)
+		options := map[string]dbus.Variant{
+			"cmd-prefixes": dbus.MakeVariant(cmdPrefixes),
+		}
+		err = m.startManager.LaunchAppWithOptions(dbus.FlagNoAutoStart, desktopFile, timestamp,
+			files, options)
+	} else {
+		err = m.startManager.LaunchApp(dbus.FlagNoAutoStart, desktopFile, timestamp, files)
+	}
 	if err != nil {
 		logger.Warningf("launch %q failed: %v", desktopFile, err)
 	}
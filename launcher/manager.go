@@ -142,10 +142,13 @@ type Manager struct {
 		RequestRemoveFromDesktop func() `in:"id" out:"ok"`
 		RequestSendToDesktop     func() `in:"id" out:"ok"`
 		MarkLaunched             func() `in:"id"`
+		LaunchWithTimestamp      func() `in:"id,timestamp"`
 		RequestUninstall         func() `in:"id,purge"`
 		Search                   func() `in:"key"`
 		GetUseProxy              func() `in:"id" out:"value"`
 		SetUseProxy              func() `in:"id,value"`
+		GetProxyProfile          func() `in:"id" out:"profile"`
+		SetProxyProfile          func() `in:"id,profile"`
 		GetDisableScaling        func() `in:"id" out:"value"`
 		SetDisableScaling        func() `in:"id,value"`
 	}
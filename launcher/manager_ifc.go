@@ -27,7 +27,9 @@ import (
 	"strings"
 	"sync/atomic"
 
+	"github.com/linuxdeepin/go-dbus-factory/com.deepin.sessionmanager"
 	"pkg.deepin.io/dde/api/soundutils"
+	"pkg.deepin.io/dde/daemon/network/proxychains"
 	dbus "pkg.deepin.io/lib/dbus1"
 	"pkg.deepin.io/lib/dbusutil"
 	"pkg.deepin.io/lib/keyfile"
@@ -217,10 +219,82 @@ func (m *Manager) GetUseProxy(id string) (bool, *dbus.Error) {
 	return m.getUseFeature(gsKeyAppsUseProxy, id)
 }
 
+// SetUseProxy 设置程序是否使用全局代理，使用全局代理时删除程序的代理规则，
+// 避免程序被 proxychains 包装两次
 func (m *Manager) SetUseProxy(id string, val bool) *dbus.Error {
+	if val {
+		profile, err := proxychains.GetAppProfile(id, "")
+		if err != nil {
+			return dbusutil.ToError(err)
+		}
+		if profile != "" {
+			err = m.setProxyProfile(id, "")
+			if err != nil {
+				return dbusutil.ToError(err)
+			}
+		}
+	}
 	return m.setUseFeature(gsKeyAppsUseProxy, id, val)
 }
 
+// GetProxyProfile returns the proxy profile of the app, which is set by the
+// per-application rules of network module.
+func (m *Manager) GetProxyProfile(id string) (string, *dbus.Error) {
+	item := m.getItemById(id)
+	if item == nil {
+		return "", dbusutil.ToError(errorInvalidID)
+	}
+	profile, err := proxychains.GetAppProfile(id, "")
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return profile, nil
+}
+
+// SetProxyProfile sets the proxy profile of the app, the rule is removed if
+// profile is empty. The app does not use the global proxy any more if the
+// profile is set.
+func (m *Manager) SetProxyProfile(id, profile string) *dbus.Error {
+	item := m.getItemById(id)
+	if item == nil {
+		return dbusutil.ToError(errorInvalidID)
+	}
+	err := m.setProxyProfile(id, profile)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
+
+func (m *Manager) setProxyProfile(id, profile string) error {
+	return m.service.Conn().Object("com.deepin.daemon.Network", proxychains.DBusPath).
+		Call("com.deepin.daemon.Network.ProxyChains.SetAppRule", 0, id, profile).Err
+}
+
+// LaunchWithTimestamp 启动程序，程序设置了代理规则时通过对应的代理启动
+func (m *Manager) LaunchWithTimestamp(id string, timestamp uint32) *dbus.Error {
+	item := m.getItemById(id)
+	if item == nil {
+		return dbusutil.ToError(errorInvalidID)
+	}
+
+	startManager := sessionmanager.NewStartManager(m.service.Conn())
+	cmdPrefixes, err := proxychains.GetDesktopAppCmdPrefixes(item.Path)
+	if err != nil {
+		logger.Warning("failed to get proxy rule:", err)
+	}
+	if len(cmdPrefixes) > 0 {
+		logger.Debugf("launch %q with prefixes %v", item.Path, cmdPrefixes)
+		options := map[string]dbus.Variant{
+			"cmd-prefixes": dbus.MakeVariant(cmdPrefixes),
+		}
+		err = startManager.LaunchAppWithOptions(0, item.Path, timestamp, nil, options)
+	} else {
+		err = startManager.LaunchApp(0, item.Path, timestamp, nil)
+	}
+	return dbusutil.ToError(err)
+}
+
 func (m *Manager) GetDisableScaling(id string) (bool, *dbus.Error) {
 	return m.getUseFeature(gsKeyAppsDisableScaling, id)
 }
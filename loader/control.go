@@ -0,0 +1,211 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package loader
+
+import (
+	"fmt"
+	"sort"
+)
+
+// the states of modules in ModuleStatus
+const (
+	ModuleStateRunning      = "running"
+	ModuleStateStarting     = "starting"
+	ModuleStateStartTimeout = "start timed out"
+	ModuleStateFailed       = "failed"
+	ModuleStateDisabled     = "disabled"
+)
+
+// ModuleStatus describes the runtime state of a module.
+type ModuleStatus struct {
+	Name         string
+	Enabled      bool
+	State        string
+	Dependencies []string
+	LastError    string
+}
+
+// ModuleStatuses does not hold l.lock, which is held while modules are
+// being enabled, so that the starting modules can be reported.
+func (l *Loader) ModuleStatuses() []ModuleStatus {
+	l.stateLock.Lock()
+	modules := append(Modules(nil), l.modules...)
+	l.stateLock.Unlock()
+
+	var result []ModuleStatus
+	for _, module := range modules {
+		status := ModuleStatus{
+			Name:         module.Name(),
+			State:        l.getModuleState(module),
+			Dependencies: module.GetDependencies(),
+		}
+		status.Enabled = status.State == ModuleStateRunning
+		if err := l.getLastError(module.Name()); err != nil {
+			status.LastError = err.Error()
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (l *Loader) getModuleState(module Module) string {
+	name := module.Name()
+	l.stateLock.Lock()
+	_, enabling := l.enabling[name]
+	_, timedOut := l.starting[name]
+	err := l.lastErrors[name]
+	l.stateLock.Unlock()
+
+	switch {
+	case timedOut:
+		return ModuleStateStartTimeout
+	case enabling:
+		return ModuleStateStarting
+	case module.IsEnable():
+		return ModuleStateRunning
+	case err != nil:
+		return ModuleStateFailed
+	}
+	return ModuleStateDisabled
+}
+
+// enabledDependents returns the enabled modules which depend on the module
+// named name, in the order they should be enabled. The DAG is built from
+// the enabled and starting modules only, the dependencies of the others
+// may be missing.
+func (l *Loader) enabledDependents(name string) ([]Module, error) {
+	enabled := []string{name}
+	for _, module := range l.modules {
+		n := module.Name()
+		if n != name && (l.isStarting(n) || module.IsEnable()) {
+			enabled = append(enabled, n)
+		}
+	}
+	builder := NewDAGBuilder(l, enabled, nil, EnableFlagNone)
+	names, err := builder.Dependents(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Module
+	for _, n := range names {
+		if l.isStarting(n) {
+			return nil, &EnableError{ModuleName: n, Code: ErrorStartTimeout,
+				detail: moduleStartTimeout.String()}
+		}
+		module := l.modules.Get(n)
+		if module != nil && module.IsEnable() {
+			result = append(result, module)
+		}
+	}
+	return result, nil
+}
+
+// stopModules disables modules in reverse order.
+func (l *Loader) stopModules(modules []Module) error {
+	for i := len(modules) - 1; i >= 0; i-- {
+		module := modules[i]
+		if !module.IsEnable() {
+			continue
+		}
+		l.log.Info("disable module", module.Name())
+		err := module.Enable(false)
+		if err != nil {
+			return fmt.Errorf("disable module %s failed: %v", module.Name(), err)
+		}
+	}
+	return nil
+}
+
+// DisableModule disables the module named name and the enabled modules
+// depending on it, returns the names of disabled modules.
+func (l *Loader) DisableModule(name string) ([]string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	module := l.modules.Get(name)
+	if module == nil {
+		return nil, &EnableError{ModuleName: name, Code: ErrorMissingModule}
+	}
+	if l.isStarting(name) {
+		return nil, &EnableError{ModuleName: name, Code: ErrorStartTimeout,
+			detail: moduleStartTimeout.String()}
+	}
+
+	dependents, err := l.enabledDependents(name)
+	if err != nil {
+		return nil, err
+	}
+	modules := append([]Module{module}, dependents...)
+	err = l.stopModules(modules)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range modules {
+		names = append(names, m.Name())
+	}
+	return names, nil
+}
+
+// RestartModule disables the module named name and the enabled modules
+// depending on it, then enables them again.
+func (l *Loader) RestartModule(name string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	module := l.modules.Get(name)
+	if module == nil {
+		return &EnableError{ModuleName: name, Code: ErrorMissingModule}
+	}
+	if l.isStarting(name) {
+		return &EnableError{ModuleName: name, Code: ErrorStartTimeout,
+			detail: moduleStartTimeout.String()}
+	}
+
+	for _, dependency := range module.GetDependencies() {
+		dep := l.modules.Get(dependency)
+		if dep == nil || l.isStarting(dependency) || !dep.IsEnable() {
+			return &EnableError{ModuleName: name, Code: ErrorNoDependencies, detail: dependency}
+		}
+	}
+
+	dependents, err := l.enabledDependents(name)
+	if err != nil {
+		return err
+	}
+	modules := append([]Module{module}, dependents...)
+	err = l.stopModules(modules)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range modules {
+		_, err = l.enableModule(m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -130,3 +130,43 @@ func (builder *DAGBuilder) Execute() (*graph.Data, error) {
 
 	return builder.dag, nil
 }
+
+// Dependents returns the modules which depend on the module named name
+// directly or indirectly, in the order they should be enabled.
+func (builder *DAGBuilder) Dependents(name string) ([]string, error) {
+	dag, err := builder.Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, ok := dag.TopologicalDag()
+	if !ok {
+		return nil, &EnableError{Code: ErrorCircleDependencies}
+	}
+
+	node := nodes.Get(name)
+	if node == nil {
+		return nil, &EnableError{ModuleName: name, Code: ErrorMissingModule}
+	}
+
+	dependents := map[string]struct{}{}
+	collectDependents(node, dependents)
+
+	var result []string
+	for _, nd := range nodes {
+		if _, ok := dependents[nd.ID]; ok {
+			result = append(result, nd.ID)
+		}
+	}
+	return result, nil
+}
+
+func collectDependents(node *graph.Node, result map[string]struct{}) {
+	for dependent := range node.WeightTo {
+		if _, ok := result[dependent.ID]; ok {
+			continue
+		}
+		result[dependent.ID] = struct{}{}
+		collectDependents(dependent, result)
+	}
+}
@@ -33,8 +33,11 @@ var getLoader = func() func() *Loader {
 	return func() *Loader {
 		loaderInitializer.Do(func() {
 			loader = &Loader{
-				modules: Modules{},
-				log:     log.NewLogger("daemon/loader"),
+				modules:    Modules{},
+				log:        log.NewLogger("daemon/loader"),
+				lastErrors: make(map[string]error),
+				enabling:   make(map[string]struct{}),
+				starting:   make(map[string]struct{}),
 			}
 		})
 		return loader
@@ -97,3 +100,15 @@ func StopAll() {
 		module.Enable(false)
 	}
 }
+
+func ModuleStatuses() []ModuleStatus {
+	return getLoader().ModuleStatuses()
+}
+
+func RestartModule(name string) error {
+	return getLoader().RestartModule(name)
+}
+
+func DisableModule(name string) ([]string, error) {
+	return getLoader().DisableModule(name)
+}
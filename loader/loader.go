@@ -22,7 +22,6 @@ package loader
 import (
 	"fmt"
 	"sync"
-	"time"
 
 	"pkg.deepin.io/lib/dbusutil"
 	"pkg.deepin.io/lib/log"
@@ -46,6 +45,7 @@ const (
 	ErrorMissingModule
 	ErrorInternalError
 	ErrorConflict
+	ErrorStartTimeout
 )
 
 type EnableError struct {
@@ -67,6 +67,8 @@ func (e *EnableError) Error() string {
 		return fmt.Sprintf("%s started failed: %s", e.ModuleName, e.detail)
 	case ErrorConflict:
 		return fmt.Sprintf("tring to enable disabled module(%s)", e.ModuleName)
+	case ErrorStartTimeout:
+		return fmt.Sprintf("%s started timeout after %s", e.ModuleName, e.detail)
 	}
 	panic("EnableError: Unknown Error, Should not be reached")
 }
@@ -76,6 +78,16 @@ type Loader struct {
 	log     *log.Logger
 	lock    sync.Mutex
 	service *dbusutil.Service
+
+	// key is module name
+	lastErrors map[string]error
+	// modules whose Start has not returned and not timed out
+	enabling map[string]struct{}
+	// modules whose Start timed out and has not returned
+	starting map[string]struct{}
+	// also held when modules is changed, so that the statuses can be
+	// reported while l.lock is held by enabling modules
+	stateLock sync.Mutex
 }
 
 func (l *Loader) SetLogLevel(pri log.Priority) {
@@ -100,13 +112,17 @@ func (l *Loader) AddModule(m Module) {
 	}
 
 	l.log.Debug("Register module:", m.Name())
+	l.stateLock.Lock()
 	l.modules = append(l.modules, m)
+	l.stateLock.Unlock()
 }
 
 func (l *Loader) DeleteModule(name string) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
+	l.stateLock.Lock()
 	l.modules, _ = l.modules.Delete(name)
+	l.stateLock.Unlock()
 }
 
 func (l *Loader) List() []Module {
@@ -142,22 +158,19 @@ func (l *Loader) EnableModules(enablingModules []string, disableModules []string
 		return &EnableError{Code: ErrorCircleDependencies}
 	}
 
+	// nodes 是拓扑排序后的，依赖在前
+	enabling := make(map[string]struct{}, len(enablingModules))
 	for _, name := range enablingModules {
-		node := nodes.Get(name)
-		if node == nil {
+		enabling[name] = struct{}{}
+	}
+	var modules []Module
+	for _, node := range nodes {
+		if _, ok := enabling[node.ID]; !ok {
 			continue
 		}
-		module := l.modules.Get(node.ID)
-		l.log.Info("enable module", node.ID)
-		startTime := time.Now()
-		err := module.Enable(true)
-		endTime := time.Now()
-		duration := endTime.Sub(startTime)
-		if err != nil {
-			l.log.Fatalf("enable module %s failed: %s, cost %s", node.ID, err, duration)
-		}
-		l.log.Info("enable module", node.ID, "done, cost", duration)
+		modules = append(modules, l.modules.Get(node.ID))
 	}
 
+	l.startModules(modules)
 	return nil
 }
@@ -0,0 +1,225 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// moduleStartTimeout is the max time to wait for a module's Start. A module
+// which is still starting after it is reported as failed, and the modules
+// depending on it are not enabled. The module is regarded as starting until
+// its Start returns, it can not be used as a dependency before that.
+var moduleStartTimeout = 15 * time.Second
+
+type startResult struct {
+	name string
+	cost time.Duration
+	err  error
+}
+
+// startModules enables modules concurrently, modules must be in topological
+// order. A module is enabled after all of its dependencies in modules are
+// enabled, dependencies not in modules are considered to be enabled already
+// unless they are still starting.
+func (l *Loader) startModules(modules []Module) {
+	startTime := time.Now()
+	done := make(map[string]chan struct{}, len(modules))
+	for _, module := range modules {
+		done[module.Name()] = make(chan struct{})
+	}
+
+	var (
+		wg        sync.WaitGroup
+		resultsMu sync.Mutex
+		results   []startResult
+		failed    = make(map[string]bool)
+	)
+	isFailed := func(name string) bool {
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+		return failed[name]
+	}
+
+	for _, module := range modules {
+		wg.Add(1)
+		go func(module Module) {
+			name := module.Name()
+			defer wg.Done()
+			defer close(done[name])
+
+			var result startResult
+			if l.isStarting(name) {
+				result.name = name
+				result.err = &EnableError{ModuleName: name, Code: ErrorStartTimeout,
+					detail: moduleStartTimeout.String()}
+				resultsMu.Lock()
+				results = append(results, result)
+				failed[name] = true
+				resultsMu.Unlock()
+				return
+			}
+			if module.IsEnable() {
+				return
+			}
+
+			for _, dependency := range module.GetDependencies() {
+				ch, ok := done[dependency]
+				if !ok {
+					if l.isStarting(dependency) {
+						result.err = &EnableError{ModuleName: name, Code: ErrorNoDependencies,
+							detail: dependency}
+						l.setLastError(name, result.err)
+						break
+					}
+					continue
+				}
+				<-ch
+				if isFailed(dependency) {
+					result.err = &EnableError{ModuleName: name, Code: ErrorNoDependencies,
+						detail: dependency}
+					l.setLastError(name, result.err)
+					break
+				}
+			}
+			if result.err == nil {
+				result.cost, result.err = l.enableModule(module)
+			}
+			result.name = name
+
+			resultsMu.Lock()
+			results = append(results, result)
+			if result.err != nil {
+				failed[name] = true
+			}
+			resultsMu.Unlock()
+		}(module)
+	}
+	wg.Wait()
+
+	l.logStartReport(time.Since(startTime), results)
+}
+
+// enableModule enables module with timeout and records the error for health
+// reporting.
+func (l *Loader) enableModule(module Module) (time.Duration, error) {
+	name := module.Name()
+	l.log.Info("enable module", name)
+	startTime := time.Now()
+
+	l.stateLock.Lock()
+	l.enabling[name] = struct{}{}
+	l.stateLock.Unlock()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- module.Enable(true)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+		if err != nil {
+			err = &EnableError{ModuleName: name, Code: ErrorInternalError, detail: err.Error()}
+		}
+		l.stateLock.Lock()
+		delete(l.enabling, name)
+		l.stateLock.Unlock()
+	case <-time.After(moduleStartTimeout):
+		err = &EnableError{ModuleName: name, Code: ErrorStartTimeout,
+			detail: moduleStartTimeout.String()}
+		l.stateLock.Lock()
+		delete(l.enabling, name)
+		l.starting[name] = struct{}{}
+		l.stateLock.Unlock()
+		go l.waitTimeoutModule(name, startTime, errCh)
+	}
+	duration := time.Since(startTime)
+	l.setLastError(name, err)
+	if err != nil {
+		l.log.Warningf("enable module %s failed: %v, cost %s", name, err, duration)
+		return duration, err
+	}
+	l.log.Info("enable module", name, "done, cost", duration)
+	return duration, nil
+}
+
+// waitTimeoutModule waits for the Start of a timed out module to return, the
+// module is not regarded as starting after that.
+func (l *Loader) waitTimeoutModule(name string, startTime time.Time, errCh <-chan error) {
+	err := <-errCh
+	duration := time.Since(startTime)
+	if err != nil {
+		l.log.Warningf("enable module %s failed after timeout: %v, cost %s", name, err, duration)
+		err = &EnableError{ModuleName: name, Code: ErrorInternalError, detail: err.Error()}
+	} else {
+		l.log.Infof("enable module %s done after timeout, cost %s", name, duration)
+	}
+
+	l.stateLock.Lock()
+	delete(l.starting, name)
+	l.lastErrors[name] = err
+	l.stateLock.Unlock()
+}
+
+// isStarting reports whether the Start of the module timed out and has not
+// returned yet. The state of such a module must not be accessed.
+func (l *Loader) isStarting(name string) bool {
+	l.stateLock.Lock()
+	_, ok := l.starting[name]
+	l.stateLock.Unlock()
+	return ok
+}
+
+func (l *Loader) setLastError(name string, err error) {
+	l.stateLock.Lock()
+	l.lastErrors[name] = err
+	l.stateLock.Unlock()
+}
+
+func (l *Loader) getLastError(name string) error {
+	l.stateLock.Lock()
+	defer l.stateLock.Unlock()
+	return l.lastErrors[name]
+}
+
+// logStartReport logs the cost of each module, the slowest first.
+func (l *Loader) logStartReport(total time.Duration, results []startResult) {
+	if len(results) == 0 {
+		return
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].cost > results[j].cost
+	})
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "start %d modules, cost %s", len(results), total)
+	for _, result := range results {
+		fmt.Fprintf(&buf, "\n  %-20s %10s", result.name, result.cost)
+		if result.err != nil {
+			fmt.Fprintf(&buf, "  %v", result.err)
+		}
+	}
+	l.log.Info(buf.String())
+}
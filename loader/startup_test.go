@@ -0,0 +1,273 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package loader
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pkg.deepin.io/lib/log"
+)
+
+type testModule struct {
+	*ModuleBase
+	dependencies []string
+	start        func() error
+}
+
+func (m *testModule) GetDependencies() []string {
+	return m.dependencies
+}
+
+func (m *testModule) Start() error {
+	if m.start != nil {
+		return m.start()
+	}
+	return nil
+}
+
+func (m *testModule) Stop() error {
+	return nil
+}
+
+var testLogger = log.NewLogger("daemon/loader/test")
+
+func newTestModule(name string, start func() error, dependencies ...string) *testModule {
+	m := &testModule{
+		dependencies: dependencies,
+		start:        start,
+	}
+	m.ModuleBase = NewModuleBase(name, m, testLogger)
+	return m
+}
+
+func newTestLoader(modules ...Module) *Loader {
+	return &Loader{
+		modules:    modules,
+		log:        testLogger,
+		lastErrors: make(map[string]error),
+		enabling:   make(map[string]struct{}),
+		starting:   make(map[string]struct{}),
+	}
+}
+
+type startRecorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *startRecorder) start(name string, delay time.Duration) func() error {
+	return func() error {
+		time.Sleep(delay)
+		r.mu.Lock()
+		r.order = append(r.order, name)
+		r.mu.Unlock()
+		return nil
+	}
+}
+
+func (r *startRecorder) index(name string) int {
+	for idx, n := range r.order {
+		if n == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+func TestStartModulesOrder(t *testing.T) {
+	var r startRecorder
+	// d depends on b and c, b and c depend on a, e depends on nothing
+	a := newTestModule("a", r.start("a", 20*time.Millisecond))
+	b := newTestModule("b", r.start("b", 30*time.Millisecond), "a")
+	c := newTestModule("c", r.start("c", 10*time.Millisecond), "a")
+	d := newTestModule("d", r.start("d", 0), "b", "c")
+	e := newTestModule("e", r.start("e", 0))
+	l := newTestLoader(a, b, c, d, e)
+
+	l.startModules([]Module{a, b, c, d, e})
+
+	assert.Len(t, r.order, 5)
+	for _, m := range []Module{a, b, c, d, e} {
+		assert.True(t, m.IsEnable(), m.Name())
+		assert.Nil(t, l.getLastError(m.Name()))
+	}
+	assert.True(t, r.index("a") < r.index("b"))
+	assert.True(t, r.index("a") < r.index("c"))
+	assert.True(t, r.index("b") < r.index("d"))
+	assert.True(t, r.index("c") < r.index("d"))
+	// e does not wait for others
+	assert.Equal(t, 0, r.index("e"))
+}
+
+func TestStartModulesFailed(t *testing.T) {
+	a := newTestModule("a", func() error {
+		return errors.New("failed")
+	})
+	b := newTestModule("b", nil, "a")
+	c := newTestModule("c", nil, "b")
+	l := newTestLoader(a, b, c)
+
+	l.startModules([]Module{a, b, c})
+
+	for _, m := range []Module{a, b, c} {
+		assert.False(t, m.IsEnable(), m.Name())
+	}
+	assert.Equal(t, ErrorInternalError, l.getLastError("a").(*EnableError).Code)
+	assert.Equal(t, ErrorNoDependencies, l.getLastError("b").(*EnableError).Code)
+	assert.Equal(t, ErrorNoDependencies, l.getLastError("c").(*EnableError).Code)
+}
+
+func TestStartModulesTimeout(t *testing.T) {
+	timeout := moduleStartTimeout
+	moduleStartTimeout = 50 * time.Millisecond
+	defer func() {
+		moduleStartTimeout = timeout
+	}()
+
+	release := make(chan struct{})
+	a := newTestModule("a", func() error {
+		<-release
+		return nil
+	})
+	b := newTestModule("b", nil, "a")
+	l := newTestLoader(a, b)
+
+	l.startModules([]Module{a, b})
+
+	// the dependents of a timed out module are not enabled
+	assert.True(t, l.isStarting("a"))
+	assert.Equal(t, ErrorStartTimeout, l.getLastError("a").(*EnableError).Code)
+	assert.Equal(t, ErrorNoDependencies, l.getLastError("b").(*EnableError).Code)
+	assert.False(t, b.IsEnable())
+	states := make(map[string]string)
+	for _, status := range l.ModuleStatuses() {
+		assert.False(t, status.Enabled, status.Name)
+		states[status.Name] = status.State
+	}
+	assert.Equal(t, ModuleStateStartTimeout, states["a"])
+	assert.Equal(t, ModuleStateFailed, states["b"])
+
+	// a still starting module can not be a dependency
+	l.startModules([]Module{b})
+	assert.False(t, b.IsEnable())
+	_, err := l.DisableModule("a")
+	assert.NotNil(t, err)
+
+	close(release)
+	for i := 0; i < 100 && l.isStarting("a"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, l.isStarting("a"))
+	assert.True(t, a.IsEnable())
+	assert.Nil(t, l.getLastError("a"))
+
+	l.startModules([]Module{b})
+	assert.True(t, b.IsEnable())
+	assert.Nil(t, l.getLastError("b"))
+}
+
+func TestDependents(t *testing.T) {
+	a := newTestModule("a", nil)
+	b := newTestModule("b", nil, "a")
+	c := newTestModule("c", nil, "a")
+	d := newTestModule("d", nil, "b", "c")
+	e := newTestModule("e", nil)
+	l := newTestLoader(a, b, c, d, e)
+
+	builder := NewDAGBuilder(l, l.modules.List(), nil, EnableFlagNone)
+	names, err := builder.Dependents("a")
+	assert.Nil(t, err)
+	assert.Len(t, names, 3)
+	assert.ElementsMatch(t, []string{"b", "c", "d"}, names)
+	assert.Equal(t, "d", names[2])
+
+	builder = NewDAGBuilder(l, l.modules.List(), nil, EnableFlagNone)
+	names, err = builder.Dependents("d")
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+
+	builder = NewDAGBuilder(l, l.modules.List(), nil, EnableFlagNone)
+	_, err = builder.Dependents("x")
+	assert.NotNil(t, err)
+}
+
+func getModuleStates(l *Loader) map[string]string {
+	states := make(map[string]string)
+	for _, status := range l.ModuleStatuses() {
+		states[status.Name] = status.State
+	}
+	return states
+}
+
+func TestModuleStates(t *testing.T) {
+	release := make(chan struct{})
+	a := newTestModule("a", func() error {
+		<-release
+		return nil
+	})
+	b := newTestModule("b", nil)
+	l := newTestLoader(a, b)
+
+	done := make(chan struct{})
+	go func() {
+		l.startModules([]Module{a})
+		close(done)
+	}()
+	for i := 0; i < 100 && getModuleStates(l)["a"] != ModuleStateStarting; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, ModuleStateStarting, getModuleStates(l)["a"])
+	assert.Equal(t, ModuleStateDisabled, getModuleStates(l)["b"])
+
+	close(release)
+	<-done
+	assert.Equal(t, ModuleStateRunning, getModuleStates(l)["a"])
+
+	_, err := l.DisableModule("a")
+	assert.Nil(t, err)
+	assert.Equal(t, ModuleStateDisabled, getModuleStates(l)["a"])
+}
+
+func TestDisableModule(t *testing.T) {
+	a := newTestModule("a", nil)
+	b := newTestModule("b", nil, "a")
+	c := newTestModule("c", nil, "a")
+	// not enabled, its missing dependency does not matter
+	x := newTestModule("x", nil, "a", "missing")
+	l := newTestLoader(a, b, c, x)
+
+	l.startModules([]Module{a, b})
+
+	err := l.RestartModule("a")
+	assert.Nil(t, err)
+	assert.True(t, a.IsEnable())
+	assert.True(t, b.IsEnable())
+	assert.False(t, c.IsEnable())
+
+	names, err := l.DisableModule("a")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+	assert.False(t, a.IsEnable())
+	assert.False(t, b.IsEnable())
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"errors"
+
+	sessionmanager "github.com/linuxdeepin/go-dbus-factory/com.deepin.sessionmanager"
+	"pkg.deepin.io/lib/appinfo/desktopappinfo"
+	dbus "pkg.deepin.io/lib/dbus1"
+	. "pkg.deepin.io/lib/gettext"
+	"pkg.deepin.io/lib/mime"
+)
+
+const mimeTypeBrowser = "x-scheme-handler/http"
+
+// probeCaptivePortal asks com.deepin.system.Network to request its
+// ConnectivityCheckUrl, loginUrl is the page to login if portal is true.
+var probeCaptivePortal = func() (portal bool, loginUrl string, err error) {
+	sysBus, err := dbus.SystemBus()
+	if err != nil {
+		return
+	}
+	err = sysBus.Object("com.deepin.system.Network", "/com/deepin/system/Network").
+		Call("com.deepin.system.Network.CheckCaptivePortal", 0).Store(&portal, &loginUrl)
+	return
+}
+
+// openCaptivePortalLogin notifies the user and opens the login page
+var openCaptivePortalLogin = func(loginUrl string) {
+	notify(notifyIconWirelessLocal, Tr("Network"),
+		Tr("Please login to the network in the browser to access the Internet."))
+	err := openUrlByDefaultBrowser(loginUrl)
+	if err != nil {
+		logger.Warning("failed to open login page:", err)
+	}
+}
+
+// openUrlByDefaultBrowser launches the default browser set by mime module
+func openUrlByDefaultBrowser(url string) error {
+	appId, err := mime.GetDefaultApp(mimeTypeBrowser, false)
+	if err != nil {
+		return err
+	}
+	appInfo := desktopappinfo.NewDesktopAppInfo(appId)
+	if appInfo == nil {
+		return errors.New("failed to get desktop app info of " + appId)
+	}
+
+	sessionBus, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	startManager := sessionmanager.NewStartManager(sessionBus)
+	return startManager.LaunchApp(0, appInfo.GetFileName(), 0, []string{url})
+}
+
+// startCheckCaptivePortal probes the captive portal found by NetworkManager
+// to get the login page, it is opened once for each captive portal. Nothing
+// is probed if NetworkManager does not check connectivity itself.
+func (m *Manager) startCheckCaptivePortal() {
+	m.portalMu.Lock()
+	if m.portalLoginOpened {
+		m.portalMu.Unlock()
+		return
+	}
+	// the results of the probes started before are ignored
+	m.portalGeneration++
+	generation := m.portalGeneration
+	m.portalMu.Unlock()
+
+	// the probe blocks for a while
+	go m.checkCaptivePortal(generation)
+}
+
+func (m *Manager) checkCaptivePortal(generation uint64) {
+	portal, loginUrl, err := probeCaptivePortal()
+	if err != nil {
+		logger.Warning("failed to probe captive portal:", err)
+		return
+	}
+
+	m.portalMu.Lock()
+	if generation != m.portalGeneration || m.portalLoginOpened || !portal {
+		m.portalMu.Unlock()
+		return
+	}
+	m.portalLoginOpened = true
+	m.portalMu.Unlock()
+
+	logger.Info("captive portal detected, login url:", loginUrl)
+	openCaptivePortalLogin(loginUrl)
+}
+
+func (m *Manager) stopCheckCaptivePortal() {
+	m.portalMu.Lock()
+	m.portalGeneration++
+	m.portalLoginOpened = false
+	m.portalMu.Unlock()
+}
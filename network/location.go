@@ -0,0 +1,716 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pkg.deepin.io/dde/daemon/network/nm"
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+	"pkg.deepin.io/lib/xdg/basedir"
+)
+
+const (
+	// the active connections change several times while connecting, detect
+	// the location after they become stable
+	locationDetectDelay = 2 * time.Second
+
+	// the gateway may be not resolved yet when the connection is activated
+	locationArpRetry         = 3
+	locationArpRetryInterval = 500 * time.Millisecond
+)
+
+var (
+	locationsLocker    sync.Mutex
+	locationsFile      = filepath.Join(basedir.GetUserConfigDir(), "deepin/dde-daemon/network/locations.json")
+	locationBackupFile = filepath.Join(basedir.GetUserConfigDir(), "deepin/dde-daemon/network/location-backup.json")
+	arpFile            = "/proc/net/arp"
+)
+
+// Location is a bundle of settings applied when the computer is connected to
+// a known network. It is matched if any of the active connections matches
+// any of Ssids, GatewayMacs or Identities.
+type Location struct {
+	Ssids       []string
+	GatewayMacs []string
+	Identities  []string // 802.1x identity of the connections
+
+	Proxy    *LocationProxy
+	Dns      []string // ipv4 dns servers override the ones from dhcp
+	Vpns     []string // uuid of vpn connections to be activated
+	Printer  string   // default printer, applied by the printer module
+	Timezone string   // applied by the timedate module
+}
+
+type LocationProxy struct {
+	Method      string            // "none", "manual" or "auto"
+	Auto        string            // PAC file url
+	IgnoreHosts string            // separated by ","
+	Servers     map[string]string // proxy type -> "host:port"
+}
+
+// locationEnv is the information of the network used to match locations
+type locationEnv struct {
+	ssids       []string
+	gatewayMacs []string
+	identities  []string
+}
+
+func loadLocationsNoLock() (map[string]*Location, error) {
+	locations := make(map[string]*Location)
+	content, err := ioutil.ReadFile(locationsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return locations, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(content, &locations)
+	if err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+func loadLocations() (map[string]*Location, error) {
+	locationsLocker.Lock()
+	defer locationsLocker.Unlock()
+	return loadLocationsNoLock()
+}
+
+// updateLocations loads the locations, modifies them by fn and saves them
+// under one lock. Nothing is saved if the file can not be loaded.
+func updateLocations(fn func(locations map[string]*Location) error) error {
+	locationsLocker.Lock()
+	defer locationsLocker.Unlock()
+
+	locations, err := loadLocationsNoLock()
+	if err != nil {
+		return err
+	}
+	err = fn(locations)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(locations)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(locationsFile), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(locationsFile, content, 0644)
+}
+
+func checkLocation(l *Location) error {
+	if len(l.Ssids) == 0 && len(l.GatewayMacs) == 0 && len(l.Identities) == 0 {
+		return errors.New("location matches nothing")
+	}
+	for _, mac := range l.GatewayMacs {
+		if _, err := net.ParseMAC(mac); err != nil {
+			return fmt.Errorf("invalid gateway mac address %q", mac)
+		}
+	}
+	for _, dns := range l.Dns {
+		if _, err := convertIpv4AddressToUint32Check(dns); err != nil {
+			return err
+		}
+	}
+	for _, uuid := range l.Vpns {
+		if uuid == "" {
+			return errors.New("vpn uuid is empty")
+		}
+	}
+	if l.Proxy != nil {
+		err := checkProxyMethod(l.Proxy.Method)
+		if err != nil {
+			return err
+		}
+		for proxyType, server := range l.Proxy.Servers {
+			_, err = getProxyChildSettings(proxyType)
+			if err != nil {
+				return err
+			}
+			_, _, err = net.SplitHostPort(server)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Location) match(env *locationEnv) bool {
+	// ssids are case sensitive, "Corp" and "corp" may be different networks
+	for _, ssid := range env.ssids {
+		if isStringInArray(ssid, l.Ssids) {
+			return true
+		}
+	}
+	for _, mac := range env.gatewayMacs {
+		if containsFold(l.GatewayMacs, mac) {
+			return true
+		}
+	}
+	for _, identity := range env.identities {
+		if containsFold(l.Identities, identity) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLocation returns the name of the first matched location in
+// alphabetical order.
+func matchLocation(locations map[string]*Location, env *locationEnv) string {
+	names := make([]string, 0, len(locations))
+	for name := range locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if locations[name].match(env) {
+			return name
+		}
+	}
+	return ""
+}
+
+// getArpHwAddress finds the hardware address of ip in the content of
+// /proc/net/arp.
+func getArpHwAddress(content []byte, ip string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[0] != ip {
+			continue
+		}
+		// flags 0x0 means the entry is incomplete
+		if fields[2] == "0x0" || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		return strings.ToUpper(fields[3])
+	}
+	return ""
+}
+
+// triggerArp sends an udp packet to the discard port of ip, so that the
+// kernel resolves the hardware address of ip.
+func triggerArp(ip string) {
+	conn, err := net.Dial("udp", net.JoinHostPort(ip, "9"))
+	if err != nil {
+		logger.Debug(err)
+		return
+	}
+	_, err = conn.Write([]byte{0})
+	if err != nil {
+		logger.Debug(err)
+	}
+	conn.Close()
+}
+
+// getGatewayHwAddress returns the hardware address of the gateway, the arp
+// request is triggered and the arp table is read again if it is not found.
+func getGatewayHwAddress(gateway string) string {
+	for i := 0; ; i++ {
+		arp, err := ioutil.ReadFile(arpFile)
+		if err != nil {
+			logger.Warning(err)
+			return ""
+		}
+		if mac := getArpHwAddress(arp, gateway); mac != "" {
+			return mac
+		}
+		if i == locationArpRetry {
+			logger.Debugf("hardware address of gateway %s not found", gateway)
+			return ""
+		}
+		if i == 0 {
+			triggerArp(gateway)
+		}
+		time.Sleep(locationArpRetryInterval)
+	}
+}
+
+func getLocationEnv() *locationEnv {
+	env := &locationEnv{}
+	for _, devPath := range nmGetDevices() {
+		if !isDeviceStateActivated(nmGetDeviceState(devPath)) {
+			continue
+		}
+		data, err := nmGetDeviceActiveConnectionData(devPath)
+		if err != nil {
+			continue
+		}
+		switch getSettingConnectionType(data) {
+		case nm.NM_SETTING_WIRELESS_SETTING_NAME:
+			env.ssids = append(env.ssids, decodeSsid(getSettingWirelessSsid(data)))
+		case nm.NM_SETTING_VPN_SETTING_NAME:
+			continue
+		}
+		if isSetting8021xIdentityExists(data) {
+			env.identities = append(env.identities, getSetting8021xIdentity(data))
+		}
+
+		aConn, err := nmNewActiveConnection(nmGetDeviceActiveConnection(devPath))
+		if err != nil {
+			continue
+		}
+		ip4Path, _ := aConn.Ip4Config().Get(0)
+		if !isNmObjectPathValid(ip4Path) {
+			continue
+		}
+		_, _, gateways, _ := nmGetIp4ConfigInfo(ip4Path)
+		for _, gateway := range gateways {
+			if gateway == "" || gateway == "0.0.0.0" {
+				continue
+			}
+			if mac := getGatewayHwAddress(gateway); mac != "" {
+				env.gatewayMacs = append(env.gatewayMacs, mac)
+			}
+		}
+	}
+	return env
+}
+
+func (m *Manager) scheduleDetectLocation() {
+	m.locationMu.Lock()
+	defer m.locationMu.Unlock()
+	if m.locationTimer != nil {
+		m.locationTimer.Stop()
+	}
+	m.locationTimer = time.AfterFunc(locationDetectDelay, m.detectLocation)
+}
+
+func (m *Manager) stopDetectLocation() {
+	m.locationMu.Lock()
+	defer m.locationMu.Unlock()
+	if m.locationTimer != nil {
+		m.locationTimer.Stop()
+		m.locationTimer = nil
+	}
+}
+
+// locationBackup is what a location overrode, it is restored when leaving
+// the location. It is saved to locationBackupFile, so that the original
+// settings are not lost if the daemon exits inside the location.
+type locationBackup struct {
+	Location string // name of the location which overrode the settings
+	Proxy    *LocationProxy
+	// device path -> the dns of its applied connection
+	Dns  map[dbus.ObjectPath]locationDnsBackup
+	Vpns []string // uuid of vpn connections activated by the location
+}
+
+type locationDnsBackup struct {
+	Uuid          string // uuid of the applied connection
+	Dns           []uint32
+	IgnoreAutoDns bool
+}
+
+func loadLocationBackup() (*locationBackup, error) {
+	content, err := ioutil.ReadFile(locationBackupFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backup locationBackup
+	err = json.Unmarshal(content, &backup)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Dns == nil {
+		backup.Dns = make(map[dbus.ObjectPath]locationDnsBackup)
+	}
+	return &backup, nil
+}
+
+// saveLocationBackup saves the backup, the file is removed if backup is nil.
+func saveLocationBackup(backup *locationBackup) error {
+	if backup == nil {
+		err := os.Remove(locationBackupFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	content, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(locationBackupFile), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(locationBackupFile, content, 0644)
+}
+
+// detectLocation finds the location matching the active connections and
+// applies it. The settings overridden by the previous location are restored
+// when leaving it.
+func (m *Manager) detectLocation() {
+	m.locationApplyMu.Lock()
+	defer m.locationApplyMu.Unlock()
+
+	locations, err := loadLocations()
+	if err != nil {
+		logger.Warning(err)
+		return
+	}
+	name := matchLocation(locations, getLocationEnv())
+
+	m.locationMu.Lock()
+	changed := name != m.locationName
+	m.locationName = name
+	m.locationMu.Unlock()
+
+	if changed {
+		logger.Info("location changed:", name)
+		// the backup loaded at startup is restored too, since the location
+		// may be changed while the daemon is not running
+		if m.locationBackup != nil {
+			m.restoreLocation(m.locationBackup)
+			m.locationBackup = nil
+			m.saveLocationBackup()
+		}
+	}
+
+	location := &Location{}
+	if name != "" {
+		location = locations[name]
+		if m.locationBackup == nil {
+			m.locationBackup = &locationBackup{
+				Location: name,
+				Dns:      make(map[dbus.ObjectPath]locationDnsBackup),
+			}
+		}
+		// dns is overridden per activation, apply it to new connections even
+		// if the location is not changed
+		if m.applyLocationDns(location.Dns, m.locationBackup) {
+			m.saveLocationBackup()
+		}
+	}
+	if !changed {
+		return
+	}
+	if name != "" {
+		m.applyLocation(location, m.locationBackup)
+		m.saveLocationBackup()
+	}
+
+	m.PropsMu.Lock()
+	m.CurrentLocation = name
+	m.PropsMu.Unlock()
+	err = m.service.EmitPropertyChanged(m, "CurrentLocation", name)
+	if err != nil {
+		logger.Warning(err)
+	}
+	err = m.service.Emit(m, "LocationChanged", name, location.Printer, location.Timezone)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+func (m *Manager) saveLocationBackup() {
+	err := saveLocationBackup(m.locationBackup)
+	if err != nil {
+		logger.Warning("failed to save location backup:", err)
+	}
+}
+
+func (m *Manager) applyLocation(l *Location, backup *locationBackup) {
+	if l.Proxy != nil {
+		backup.Proxy = m.getLocationProxy(l.Proxy)
+		m.applyLocationProxy(l.Proxy)
+	}
+
+	for _, uuid := range l.Vpns {
+		if _, err := nmGetActiveConnectionByUuid(uuid); err == nil {
+			continue
+		}
+		_, err := m.activateConnection(uuid, "/")
+		if err != nil {
+			logger.Warningf("failed to activate vpn %s: %v", uuid, err)
+			continue
+		}
+		backup.Vpns = append(backup.Vpns, uuid)
+	}
+}
+
+func (m *Manager) restoreLocation(backup *locationBackup) {
+	if backup.Proxy != nil {
+		m.applyLocationProxy(backup.Proxy)
+	}
+	m.restoreLocationDns(backup.Dns)
+	for _, uuid := range backup.Vpns {
+		err := m.deactivateConnection(uuid)
+		if err != nil {
+			logger.Warningf("failed to deactivate vpn %s: %v", uuid, err)
+		}
+	}
+}
+
+// getLocationProxy returns the current values of the proxy settings which
+// are overridden by p.
+func (m *Manager) getLocationProxy(p *LocationProxy) *LocationProxy {
+	method, _ := m.GetProxyMethod()
+	result := &LocationProxy{
+		Method:  method,
+		Servers: make(map[string]string, len(p.Servers)),
+	}
+	if p.Auto != "" {
+		result.Auto, _ = m.GetAutoProxy()
+	}
+	if p.IgnoreHosts != "" {
+		result.IgnoreHosts, _ = m.GetProxyIgnoreHosts()
+	}
+	for proxyType := range p.Servers {
+		host, port, err := m.GetProxy(proxyType)
+		if err != nil {
+			continue
+		}
+		result.Servers[proxyType] = net.JoinHostPort(host, port)
+	}
+	return result
+}
+
+func (m *Manager) applyLocationProxy(p *LocationProxy) {
+	for proxyType, server := range p.Servers {
+		host, port, err := net.SplitHostPort(server)
+		if err == nil {
+			err = m.setProxy(proxyType, host, port)
+		}
+		if err != nil {
+			logger.Warning(err)
+		}
+	}
+	if p.Auto != "" {
+		err := m.setAutoProxy(p.Auto)
+		if err != nil {
+			logger.Warning(err)
+		}
+	}
+	if p.IgnoreHosts != "" {
+		err := m.setProxyIgnoreHosts(p.IgnoreHosts)
+		if err != nil {
+			logger.Warning(err)
+		}
+	}
+	err := m.setProxyMethod(p.Method)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+// applyLocationDns overrides the dns servers of the activated devices by
+// reapplying their connections, the saved connections are not modified. The
+// original dns of each device is saved in backup, it returns true if backup
+// is changed.
+func (m *Manager) applyLocationDns(dnses []string, backup *locationBackup) (changed bool) {
+	if len(dnses) == 0 {
+		return
+	}
+	var dnsValue []uint32
+	for _, dns := range dnses {
+		dnsValue = append(dnsValue, convertIpv4AddressToUint32(dns))
+	}
+
+	for _, devPath := range nmGetDevices() {
+		if !isDeviceStateActivated(nmGetDeviceState(devPath)) {
+			continue
+		}
+		dev, err := nmNewDevice(devPath)
+		if err != nil {
+			continue
+		}
+		var data connectionData
+		data, versionId, err := dev.GetAppliedConnection(0, 0)
+		if err != nil {
+			logger.Debug(err)
+			continue
+		}
+		if getSettingConnectionType(data) == nm.NM_SETTING_VPN_SETTING_NAME ||
+			!isSettingExists(data, nm.NM_SETTING_IP4_CONFIG_SETTING_NAME) ||
+			getSettingIP4ConfigMethod(data) == nm.NM_SETTING_IP4_CONFIG_METHOD_DISABLED {
+			continue
+		}
+		if getSettingIP4ConfigIgnoreAutoDns(data) &&
+			isUint32SliceEqual(getSettingIP4ConfigDns(data), dnsValue) {
+			continue
+		}
+		uuid := getSettingConnectionUuid(data)
+		if dnsBackup, ok := backup.Dns[devPath]; !ok || dnsBackup.Uuid != uuid {
+			backup.Dns[devPath] = locationDnsBackup{
+				Uuid:          uuid,
+				Dns:           getSettingIP4ConfigDns(data),
+				IgnoreAutoDns: getSettingIP4ConfigIgnoreAutoDns(data),
+			}
+			changed = true
+		}
+		setSettingIP4ConfigDns(data, dnsValue)
+		setSettingIP4ConfigIgnoreAutoDns(data, true)
+		err = dev.Reapply(0, data, versionId, 0)
+		if err != nil {
+			logger.Warningf("failed to reapply dns of %s: %v", devPath, err)
+		}
+	}
+}
+
+// restoreLocationDns reapplies the original dns of the devices which are
+// still activated, the others get their dns from the saved connections when
+// activated again.
+func (m *Manager) restoreLocationDns(backup map[dbus.ObjectPath]locationDnsBackup) {
+	for devPath, dnsBackup := range backup {
+		if !isDeviceStateActivated(nmGetDeviceState(devPath)) {
+			continue
+		}
+		dev, err := nmNewDevice(devPath)
+		if err != nil {
+			continue
+		}
+		var data connectionData
+		data, versionId, err := dev.GetAppliedConnection(0, 0)
+		if err != nil {
+			logger.Debug(err)
+			continue
+		}
+		// another connection is activated on the device
+		if getSettingConnectionUuid(data) != dnsBackup.Uuid ||
+			!isSettingExists(data, nm.NM_SETTING_IP4_CONFIG_SETTING_NAME) {
+			continue
+		}
+		setSettingIP4ConfigDns(data, dnsBackup.Dns)
+		setSettingIP4ConfigIgnoreAutoDns(data, dnsBackup.IgnoreAutoDns)
+		err = dev.Reapply(0, data, versionId, 0)
+		if err != nil {
+			logger.Warningf("failed to restore dns of %s: %v", devPath, err)
+		}
+	}
+}
+
+func isUint32SliceEqual(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetLocations returns all locations marshaled by json.
+func (m *Manager) GetLocations() (locationsJSON string, busErr *dbus.Error) {
+	locations, err := loadLocations()
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	locationsJSON, err = marshalJSON(locations)
+	return locationsJSON, dbusutil.ToError(err)
+}
+
+// SetLocation adds or replaces a location.
+func (m *Manager) SetLocation(name, locationJSON string) *dbus.Error {
+	err := m.setLocation(name, locationJSON)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) setLocation(name, locationJSON string) error {
+	if name == "" {
+		return errors.New("location name is empty")
+	}
+	var location Location
+	err := json.Unmarshal([]byte(locationJSON), &location)
+	if err != nil {
+		return err
+	}
+	err = checkLocation(&location)
+	if err != nil {
+		return err
+	}
+
+	err = updateLocations(func(locations map[string]*Location) error {
+		locations[name] = &location
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.locationMu.Lock()
+	if m.locationName == name {
+		// apply the new settings
+		m.locationName = ""
+	}
+	m.locationMu.Unlock()
+	m.scheduleDetectLocation()
+	return nil
+}
+
+// DeleteLocation deletes a location, the settings overridden by it are
+// restored if it is the current location.
+func (m *Manager) DeleteLocation(name string) *dbus.Error {
+	err := m.deleteLocation(name)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) deleteLocation(name string) error {
+	err := updateLocations(func(locations map[string]*Location) error {
+		if _, ok := locations[name]; !ok {
+			return fmt.Errorf("location %q not found", name)
+		}
+		delete(locations, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.scheduleDetectLocation()
+	return nil
+}
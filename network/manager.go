@@ -56,6 +56,10 @@ type Manager struct {
 	State        uint32 // global networking state
 	Connectivity uint32
 
+	portalMu          sync.Mutex
+	portalGeneration  uint64
+	portalLoginOpened bool
+
 	NetworkingEnabled bool `prop:"access:rw"` // airplane mode for NetworkManager
 	VpnEnabled        bool `prop:"access:rw"`
 
@@ -82,6 +86,14 @@ type Manager struct {
 	activeConnections     map[dbus.ObjectPath]*activeConnection
 	ActiveConnections     string // array of connections that activated and marshaled by json
 
+	// update by location.go
+	locationMu      sync.Mutex
+	locationTimer   *time.Timer
+	locationName    string
+	locationApplyMu sync.Mutex // serializes detectLocation
+	locationBackup  *locationBackup
+	CurrentLocation string // name of the location matched by active connections
+
 	secretAgent        *SecretAgent
 	stateHandler       *stateHandler
 	proxyChainsManager *proxychains.Manager
@@ -97,6 +109,11 @@ type Manager struct {
 			devPath string
 			enabled bool
 		}
+		// emitted when CurrentLocation changed, for other modules to apply
+		// the default printer and timezone
+		LocationChanged struct {
+			name, printer, timezone string
+		}
 	}
 
 	methods *struct {
@@ -104,25 +121,36 @@ type Manager struct {
 		ActivateConnection           func() `in:"uuid,devPath" out:"cPath"`
 		DeactivateConnection         func() `in:"uuid"`
 		DeleteConnection             func() `in:"uuid"`
+		DeleteLocation               func() `in:"name"`
 		DisableWirelessHotspotMode   func() `in:"devPath"`
 		DisconnectDevice             func() `in:"devPath"`
 		EnableDevice                 func() `in:"devPath,enabled"`
 		EnableWirelessHotspotMode    func() `in:"devPath"`
+		ExportConnection             func() `in:"uuid,file"`
+		GenerateWireguardKeyPair     func() `out:"privateKey,publicKey"`
 		GetAccessPoints              func() `in:"path" out:"apsJSON"`
 		GetActiveConnectionInfo      func() `out:"acInfosJSON"`
 		GetAutoProxy                 func() `out:"proxyAuto"`
+		GetLocations                 func() `out:"locationsJSON"`
 		GetProxy                     func() `in:"proxyType" out:"host,port"`
 		GetProxyIgnoreHosts          func() `out:"ignoreHosts"`
 		GetProxyMethod               func() `out:"proxyMode"`
 		GetSupportedConnectionTypes  func() `out:"types"`
+		GetWireguardPeers            func() `in:"uuid" out:"peersJSON"`
+		GetWireguardPublicKey        func() `in:"privateKey" out:"publicKey"`
+		ImportConnection             func() `in:"file" out:"uuid"`
+		ImportWireguardConnection    func() `in:"file" out:"uuid"`
 		IsDeviceEnabled              func() `in:"devPath" out:"enabled"`
 		IsWirelessHotspotModeEnabled func() `in:"devPath" out:"enabled"`
 		ListDeviceConnections        func() `in:"devPath" out:"connections"`
 		SetAutoProxy                 func() `in:"proxyAuto"`
 		SetDeviceManaged             func() `in:"devPathOrIfc,managed"`
+		SetLocation                  func() `in:"name,locationJSON"`
 		SetProxy                     func() `in:"proxyType,host,port"`
 		SetProxyIgnoreHosts          func() `in:"ignoreHosts"`
 		SetProxyMethod               func() `in:"proxyMode"`
+		SetWireguardPeers            func() `in:"uuid,peersJSON"`
+		SetWireguardPrivateKey       func() `in:"uuid,privateKey"`
 	}
 }
 
@@ -196,6 +224,12 @@ func (m *Manager) init() {
 		}
 	}()
 
+	// the settings overridden by the location before the daemon exits
+	m.locationBackup, err = loadLocationBackup()
+	if err != nil {
+		logger.Warning(err)
+	}
+
 	// initialize device and connection handlers
 	m.initConnectionManage()
 	m.initDeviceManage()
@@ -251,10 +285,12 @@ func (m *Manager) destroy() {
 	m.sysNetwork.RemoveHandler(proxy.RemoveAllHandlers)
 	destroyDbusObjects()
 	destroyStateHandler(m.stateHandler)
+	m.stopCheckCaptivePortal()
 	m.clearDevices()
 	m.clearAccessPoints()
 	m.clearConnections()
 	m.clearActiveConnections()
+	m.stopDetectLocation()
 
 	// reset dbus properties
 	m.setPropNetworkingEnabled(false)
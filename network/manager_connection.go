@@ -20,14 +20,20 @@
 package network
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"sort"
+	"strings"
 
 	nmdbus "github.com/linuxdeepin/go-dbus-factory/org.freedesktop.networkmanager"
 	"pkg.deepin.io/dde/daemon/network/nm"
 	dbus "pkg.deepin.io/lib/dbus1"
 	"pkg.deepin.io/lib/dbusutil"
 	. "pkg.deepin.io/lib/gettext"
+	"pkg.deepin.io/lib/utils"
 )
 
 type connectionSlice []*connection
@@ -584,3 +590,259 @@ func (m *Manager) doDisconnectDevice(devPath dbus.ObjectPath) (err error) {
 	}
 	return
 }
+
+// GenerateWireguardKeyPair generates a private key and the related public
+// key for wireguard connection.
+func (m *Manager) GenerateWireguardKeyPair() (privateKey, publicKey string, busErr *dbus.Error) {
+	privateKey, publicKey, err := genWireguardKeyPair()
+	busErr = dbusutil.ToError(err)
+	return
+}
+
+// GetWireguardPublicKey returns the public key of the private key.
+func (m *Manager) GetWireguardPublicKey(privateKey string) (publicKey string, busErr *dbus.Error) {
+	publicKey, err := getWireguardPublicKey(privateKey)
+	busErr = dbusutil.ToError(err)
+	return
+}
+
+// ImportWireguardConnection creates a wireguard connection from the
+// configuration file of wg-quick.
+func (m *Manager) ImportWireguardConnection(file string) (uuid string, busErr *dbus.Error) {
+	uuid, err := m.importWireguardConnection(file)
+	busErr = dbusutil.ToError(err)
+	return
+}
+
+func (m *Manager) importWireguardConnection(file string) (uuid string, err error) {
+	uuid = utils.GenUuid()
+	data, err := newWireguardConnectionDataFromFile(file, uuid, getConnectionIfcNames())
+	if err != nil {
+		return "", err
+	}
+	logger.Infof("import wireguard connection, id=%s, uuid=%s", getSettingConnectionId(data), uuid)
+	_, err = nmAddConnection(data)
+	if err != nil {
+		return "", err
+	}
+	return uuid, nil
+}
+
+// getWireguardConnectionData returns the data of the wireguard connection,
+// including the private key and the preshared keys of peers.
+func getWireguardConnectionData(uuid string) (cpath dbus.ObjectPath, data connectionData, err error) {
+	cpath, err = nmGetConnectionByUuid(uuid)
+	if err != nil {
+		return
+	}
+	data, err = nmGetConnectionData(cpath)
+	if err != nil {
+		return
+	}
+	if getCustomConnectionType(data) != connectionWireguard {
+		err = fmt.Errorf("connection %s is not a wireguard connection", uuid)
+		return
+	}
+
+	secrets, err := nmGetConnectionSecrets(cpath, nm.NM_SETTING_WIREGUARD_SETTING_NAME)
+	if err != nil {
+		return
+	}
+	if isSettingWireguardPrivateKeyExists(secrets) {
+		setSettingWireguardPrivateKey(data, getSettingWireguardPrivateKey(secrets))
+	}
+	presharedKeys := make(map[string]string)
+	for _, peer := range wrapWireguardPeers(getSettingWireguardPeers(secrets)) {
+		presharedKeys[peer.PublicKey] = peer.PresharedKey
+	}
+	peers := getSettingWireguardPeers(data)
+	for _, peer := range peers {
+		publicKey, _ := peer[nm.NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY].Value().(string)
+		if key := presharedKeys[publicKey]; key != "" {
+			peer[nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY] = dbus.MakeVariant(key)
+		}
+	}
+	setSettingWireguardPeers(data, peers)
+	return
+}
+
+// GetWireguardPeers returns the peers of the wireguard connection, preshared
+// keys are not included.
+func (m *Manager) GetWireguardPeers(uuid string) (peersJSON string, busErr *dbus.Error) {
+	_, data, err := getWireguardConnectionData(uuid)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	peers := wrapWireguardPeers(getSettingWireguardPeers(data))
+	for i := range peers {
+		peers[i].PresharedKey = ""
+	}
+	peersJSON, err = marshalJSON(peers)
+	busErr = dbusutil.ToError(err)
+	return
+}
+
+// SetWireguardPeers replaces the peers of the wireguard connection. The
+// preshared key of a peer is kept if it is empty and the public key is not
+// changed, since GetWireguardPeers does not return preshared keys, set
+// ClearPresharedKey of the peer to remove it.
+func (m *Manager) SetWireguardPeers(uuid string, peersJSON string) *dbus.Error {
+	err := m.setWireguardPeers(uuid, peersJSON)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) setWireguardPeers(uuid string, peersJSON string) (err error) {
+	var wrapPeers []wireguardPeer
+	err = json.Unmarshal([]byte(peersJSON), &wrapPeers)
+	if err != nil {
+		return
+	}
+
+	cpath, data, err := getWireguardConnectionData(uuid)
+	if err != nil {
+		return
+	}
+	keepWireguardPresharedKeys(wrapPeers, wrapWireguardPeers(getSettingWireguardPeers(data)))
+	peers, err := unwrapWireguardPeers(wrapPeers)
+	if err != nil {
+		return
+	}
+	setSettingWireguardPeers(data, peers)
+	return nmUpdateConnection(cpath, data)
+}
+
+// SetWireguardPrivateKey sets the private key of the wireguard connection.
+func (m *Manager) SetWireguardPrivateKey(uuid string, privateKey string) *dbus.Error {
+	err := m.setWireguardPrivateKey(uuid, privateKey)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) setWireguardPrivateKey(uuid string, privateKey string) (err error) {
+	_, err = decodeWireguardKey(privateKey)
+	if err != nil {
+		return
+	}
+
+	cpath, data, err := getWireguardConnectionData(uuid)
+	if err != nil {
+		return
+	}
+	setSettingWireguardPrivateKey(data, privateKey)
+	return nmUpdateConnection(cpath, data)
+}
+
+// ImportConnection creates a connection from the file, which can be the
+// configuration file of OpenVPN(.ovpn), the configuration file of wg-quick or
+// the keyfile of NetworkManager.
+func (m *Manager) ImportConnection(file string) (uuid string, busErr *dbus.Error) {
+	uuid, err := m.importConnection(file)
+	busErr = dbusutil.ToError(err)
+	return
+}
+
+func (m *Manager) importConnection(file string) (uuid string, err error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	var data connectionData
+	switch {
+	case bytes.Contains(content, []byte("[connection]")):
+		data, err = newConnectionDataFromKeyfile(file)
+		if err != nil {
+			return
+		}
+		uuid = getSettingConnectionUuid(data)
+		if uuid == "" {
+			uuid = utils.GenUuid()
+		} else if _, err := nmGetConnectionByUuid(uuid); err == nil {
+			logger.Infof("connection %s already exists, use a new uuid", uuid)
+			uuid = utils.GenUuid()
+		}
+		setSettingConnectionUuid(data, uuid)
+	case bytes.Contains(bytes.ToLower(content), []byte("[interface]")):
+		return m.importWireguardConnection(file)
+	default:
+		uuid = utils.GenUuid()
+		data, err = newOpenvpnConnectionDataFromFile(file, uuid)
+		if err != nil {
+			return
+		}
+	}
+
+	logger.Infof("import connection, id=%s, uuid=%s", getSettingConnectionId(data), uuid)
+	_, err = nmAddConnection(data)
+	if err != nil {
+		if getSettingVpnServiceType(data) == nm.NM_DBUS_SERVICE_OPENVPN {
+			removeOpenvpnInlineFiles(uuid)
+		}
+		return "", err
+	}
+	return uuid, nil
+}
+
+// ExportConnection saves the connection to the file, including the
+// secrets. OpenVPN connections are saved as .ovpn with inline certificates
+// if the file name ends with ".ovpn", otherwise the keyfile of
+// NetworkManager is saved.
+func (m *Manager) ExportConnection(uuid, file string) *dbus.Error {
+	err := m.exportConnection(uuid, file)
+	return dbusutil.ToError(err)
+}
+
+// getConnectionDataWithSecrets returns the data of the connection, the
+// secrets which are saved are merged into it.
+func getConnectionDataWithSecrets(uuid string) (data connectionData, err error) {
+	cpath, err := nmGetConnectionByUuid(uuid)
+	if err != nil {
+		return
+	}
+	data, err = nmGetConnectionData(cpath)
+	if err != nil {
+		return
+	}
+	if getCustomConnectionType(data) == connectionWireguard {
+		_, data, err = getWireguardConnectionData(uuid)
+		return
+	}
+
+	for settingName := range data {
+		if _, ok := secretSettingKeys[settingName]; !ok &&
+			settingName != nm.NM_SETTING_VPN_SETTING_NAME {
+			continue
+		}
+		secrets, err := nmGetConnectionSecrets(cpath, settingName)
+		if err != nil {
+			logger.Warningf("failed to get secrets of %s: %v", settingName, err)
+			continue
+		}
+		for key, value := range secrets[settingName] {
+			data[settingName][key] = value
+		}
+	}
+	return
+}
+
+func (m *Manager) exportConnection(uuid, file string) (err error) {
+	data, err := getConnectionDataWithSecrets(uuid)
+	if err != nil {
+		return
+	}
+
+	var content []byte
+	if strings.EqualFold(filepath.Ext(file), ".ovpn") {
+		if getCustomConnectionType(data) != connectionVpnOpenvpn {
+			return fmt.Errorf("connection %s is not an openvpn connection", uuid)
+		}
+		content, err = genOpenvpnConfig(data)
+		if err != nil {
+			return
+		}
+	} else {
+		content = genKeyfile(data)
+	}
+	logger.Infof("export connection %s to %s", uuid, file)
+	// the file may contain secrets
+	return ioutil.WriteFile(file, content, 0600)
+}
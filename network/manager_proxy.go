@@ -147,13 +147,17 @@ func (m *Manager) GetAutoProxy() (proxyAuto string, err *dbus.Error) {
 	return
 }
 
-func (m *Manager) SetAutoProxy(proxyAuto string) (busErr *dbus.Error) {
+func (m *Manager) SetAutoProxy(proxyAuto string) *dbus.Error {
+	err := m.setAutoProxy(proxyAuto)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) setAutoProxy(proxyAuto string) (err error) {
 	logger.Debug("set autoconfig-url for proxy", proxyAuto)
 	ok := proxySettings.SetString(gkeyProxyAuto, proxyAuto)
 	if !ok {
-		err := fmt.Errorf("set autoconfig-url proxy through gsettings failed %s", proxyAuto)
+		err = fmt.Errorf("set autoconfig-url proxy through gsettings failed %s", proxyAuto)
 		logger.Error(err)
-		busErr = dbusutil.ToError(err)
 	}
 	return
 }
@@ -165,15 +169,19 @@ func (m *Manager) GetProxyIgnoreHosts() (ignoreHosts string, err *dbus.Error) {
 	ignoreHosts = strings.Join(array, ", ")
 	return
 }
-func (m *Manager) SetProxyIgnoreHosts(ignoreHosts string) (busErr *dbus.Error) {
+func (m *Manager) SetProxyIgnoreHosts(ignoreHosts string) *dbus.Error {
+	err := m.setProxyIgnoreHosts(ignoreHosts)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) setProxyIgnoreHosts(ignoreHosts string) (err error) {
 	logger.Debug("set ignore-hosts for proxy", ignoreHosts)
 	ignoreHostsFixed := strings.Replace(ignoreHosts, " ", "", -1)
 	array := strings.Split(ignoreHostsFixed, ",")
 	ok := proxySettings.SetStrv(gkeyProxyIgnoreHosts, array)
 	if !ok {
-		err := fmt.Errorf("set automatic proxy through gsettings failed %s", ignoreHosts)
+		err = fmt.Errorf("set automatic proxy through gsettings failed %s", ignoreHosts)
 		logger.Error(err)
-		busErr = dbusutil.ToError(err)
 	}
 	return
 }
@@ -76,6 +76,7 @@ func (m *Manager) setPropVpnEnabled(value bool) {
 func (m *Manager) updatePropActiveConnections() {
 	m.ActiveConnections, _ = marshalJSON(m.activeConnections)
 	m.service.EmitPropertyChanged(m, "ActiveConnections", m.ActiveConnections)
+	m.scheduleDetectLocation()
 }
 
 func (m *Manager) updatePropState() {
@@ -83,9 +84,27 @@ func (m *Manager) updatePropState() {
 	m.service.EmitPropertyChanged(m, "State", m.State)
 }
 
+// updatePropConnectivity updates property Connectivity from NetworkManager,
+// the login page is opened if a captive portal is found.
 func (m *Manager) updatePropConnectivity() {
-	m.Connectivity, _ = nmManager.Connectivity().Get(0)
-	m.service.EmitPropertyChanged(m, "Connectivity", m.Connectivity)
+	connectivity, _ := nmManager.Connectivity().Get(0)
+	m.setPropConnectivity(connectivity)
+	if connectivity == nm.NM_CONNECTIVITY_PORTAL {
+		m.startCheckCaptivePortal()
+	} else {
+		m.stopCheckCaptivePortal()
+	}
+}
+
+func (m *Manager) setPropConnectivity(value uint32) {
+	m.PropsMu.Lock()
+	if m.Connectivity == value {
+		m.PropsMu.Unlock()
+		return
+	}
+	m.Connectivity = value
+	m.PropsMu.Unlock()
+	m.service.EmitPropertyChanged(m, "Connectivity", value)
 }
 
 func (m *Manager) updatePropDevices() {
@@ -2010,3 +2010,15 @@ const (
 	NM_SETTING_VPN_VPNC_KEY_DPD_IDLE_TIMEOUT      = "DPD idle timeout (our side)"
 	NM_SETTING_VPN_VPNC_KEY_CISCO_UDP_ENCAPS_PORT = "Cisco UDP Encapsulation Port"
 )
+
+// Setting SettingWireGuard
+const NM_SETTING_WIREGUARD_SETTING_NAME = "wireguard"
+const (
+	NM_SETTING_WIREGUARD_FWMARK            = "fwmark"
+	NM_SETTING_WIREGUARD_LISTEN_PORT       = "listen-port"
+	NM_SETTING_WIREGUARD_MTU               = "mtu"
+	NM_SETTING_WIREGUARD_PEER_ROUTES       = "peer-routes"
+	NM_SETTING_WIREGUARD_PEERS             = "peers"
+	NM_SETTING_WIREGUARD_PRIVATE_KEY       = "private-key"
+	NM_SETTING_WIREGUARD_PRIVATE_KEY_FLAGS = "private-key-flags"
+)
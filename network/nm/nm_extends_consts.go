@@ -159,3 +159,17 @@ const (
 	NM_VPNC_SECRET_FLAG_ASK    = 3
 	NM_VPNC_SECRET_FLAG_UNUSED = 5
 )
+
+// WireGuard, supported since NetworkManager 1.16
+const (
+	NM_DEVICE_TYPE_WIREGUARD = 29
+)
+
+const (
+	NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY           = "public-key"
+	NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY        = "preshared-key"
+	NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY_FLAGS  = "preshared-key-flags"
+	NM_SETTING_WIREGUARD_PEER_ENDPOINT             = "endpoint"
+	NM_SETTING_WIREGUARD_PEER_ALLOWED_IPS          = "allowed-ips"
+	NM_SETTING_WIREGUARD_PEER_PERSISTENT_KEEPALIVE = "persistent-keepalive"
+)
@@ -45,6 +45,7 @@ const (
 	deviceGeneric    = "generic"
 	deviceTeam       = "team"
 	deviceTun        = "tun"
+	deviceWireguard  = "wireguard"
 )
 
 func getCustomDeviceType(devType uint32) (customDevType string) {
@@ -81,6 +82,8 @@ func getCustomDeviceType(devType uint32) (customDevType string) {
 		return deviceTeam
 	case nm.NM_DEVICE_TYPE_TUN:
 		return deviceTun
+	case nm.NM_DEVICE_TYPE_WIREGUARD:
+		return deviceWireguard
 	case nm.NM_DEVICE_TYPE_UNKNOWN:
 	default:
 		logger.Error("unknown device type", devType)
@@ -104,6 +107,7 @@ const (
 	connectionVpnStrongswan   = "vpn-strongswan"
 	connectionVpnPptp         = "vpn-pptp"
 	connectionVpnVpnc         = "vpn-vpnc"
+	connectionWireguard       = "wireguard"
 )
 
 // wrapper for custom connection types
@@ -128,6 +132,7 @@ var supportedConnectionTypes = []string{
 	connectionVpnPptp,
 	connectionVpnStrongswan,
 	connectionVpnVpnc,
+	connectionWireguard,
 }
 
 func getCustomConnectionTypeForUuid(uuid string) (connType string) {
@@ -183,6 +188,8 @@ func getCustomConnectionType(data connectionData) (connType string) {
 		case nm.NM_DBUS_SERVICE_VPNC:
 			connType = connectionVpnVpnc
 		}
+	case nm.NM_SETTING_WIREGUARD_SETTING_NAME:
+		connType = connectionWireguard
 	}
 	if len(connType) == 0 {
 		connType = connectionUnknown
@@ -209,7 +216,7 @@ func isCreatedManuallyConnection(data connectionData) (isCreateManual bool) {
 		return true
 	}
 	switch getCustomConnectionType(data) {
-	case connectionPppoe:
+	case connectionPppoe, connectionWireguard:
 		return true
 	}
 	return false
@@ -251,6 +258,8 @@ func genConnectionId(connType string) (id string) {
 		idPrefix = Tr("VPN StrongSwan")
 	case connectionVpnVpnc:
 		idPrefix = Tr("VPN VPNC")
+	case connectionWireguard:
+		idPrefix = Tr("WireGuard Connection")
 	}
 	allIds := nmGetConnectionIds()
 	for i := 1; ; i++ {
@@ -30,6 +30,7 @@ const (
 	nmConstsYamlFile             = "./nm_consts_gen.yml"
 	nmConstsKeysOverrideYamlFile = "./nm_consts_keys_override.yml"
 	nmVpnAliasSettingsYamlFile   = "./nm_vpn_alias_settings.yml"
+	nmExtraSettingsYamlFile      = "./nm_extra_settings.yml"
 	nmVirtualSettingYamlFile     = "./nm_virtual_sections.yml"
 	nmLogicSetKeysYamlFile       = "./nm_logicset_keys.yml"
 )
@@ -81,6 +82,8 @@ type nmSettingKey struct {
 
 var nmVpnAliasSettings []nmSetting
 
+var nmExtraSettings []nmSetting
+
 var nmVirtualSections []nmVirtualSection
 
 type nmVirtualSection struct {
@@ -153,6 +156,11 @@ func main() {
 		nmConsts.NMSettings = append(nmConsts.NMSettings, setting)
 	}
 
+	yamlUnmarshalFile(nmExtraSettingsYamlFile, &nmExtraSettings)
+	for _, setting := range nmExtraSettings {
+		nmConsts.NMSettings = append(nmConsts.NMSettings, setting)
+	}
+
 	yamlUnmarshalFile(nmVirtualSettingYamlFile, &nmVirtualSections)
 	yamlUnmarshalFile(nmLogicSetKeysYamlFile, &nmLogicSetKeys)
 	yamlUnmarshalFile(nmLogicSetKeysYamlFile, &nmLogicSetKeys)
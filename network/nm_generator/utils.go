@@ -181,6 +181,8 @@ func getSettingKeyFixedDefaultValue(ktype, defaultValueYAML string) (fixedDefaul
 		fixedDefaultValue = fixedValue
 	case "ktypeIpv6Addresses", "ktypeIpv6Routes", "ktypeWrapperIpv6Addresses", "ktypeWrapperIpv6Routes":
 		// ignore the combined structure here and it will be filled in GetKeyDefaultValue
+	case "ktypeWireguardPeers":
+		// same as above
 	}
 	return
 }
@@ -233,6 +235,8 @@ func doGetKeyDefaultValue(fixedDefaultValue interface{}, name, ktype string) (go
 		gocode = `make(ipv6Addresses, 0)`
 	case "ktypeIpv6Routes", "ktypeWrapperIpv6Routes":
 		gocode = `make(ipv6Routes, 0)`
+	case "ktypeWireguardPeers":
+		gocode = `make(wireguardPeers, 0)`
 	}
 	return
 }
@@ -278,6 +282,8 @@ func GetKeyTypeGoSyntax(ktype string) (goSyntax string) {
 		goSyntax = "ipv6Addresses"
 	case "ktypeIpv6Routes", "ktypeWrapperIpv6Routes":
 		goSyntax = "ipv6Routes"
+	case "ktypeWireguardPeers":
+		goSyntax = "wireguardPeers"
 	}
 	return
 }
@@ -318,6 +324,8 @@ func GetKeyTypeGoIfcConverterFunc(ktype string) (converter string) {
 		converter = "interfaceToIpv6Addresses"
 	case "ktypeIpv6Routes", "ktypeWrapperIpv6Routes":
 		converter = "interfaceToIpv6Routes"
+	case "ktypeWireguardPeers":
+		converter = "interfaceToWireguardPeers"
 	}
 	return
 }
@@ -358,6 +366,8 @@ func IfNeedCheckValueLength(ktype string) (need string) {
 		need = "t"
 	case "ktypeIpv6Routes":
 		need = "t"
+	case "ktypeWireguardPeers":
+		need = "t"
 	case "ktypeWrapperString":
 		need = "t"
 	case "ktypeWrapperMacAddress":
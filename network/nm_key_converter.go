@@ -19,6 +19,10 @@
 
 package network
 
+import (
+	"pkg.deepin.io/lib/dbus1"
+)
+
 // Convert dbus variant's value to other data type
 
 func interfaceToString(v interface{}) (d string) {
@@ -238,6 +242,24 @@ func interfaceToIpv6Routes(v interface{}) (d ipv6Routes) {
 	return
 }
 
+func interfaceToWireguardPeers(v interface{}) (d wireguardPeers) {
+	if isInterfaceNil(v) {
+		return
+	}
+
+	// try convert interface to []map[string]dbus.Variant and wireguardPeers
+	tmpData, ok := v.([]map[string]dbus.Variant)
+	if !ok {
+		d, ok = v.(wireguardPeers)
+		if !ok {
+			logger.Errorf("interfaceToWireguardPeers() failed: %#v", v)
+		}
+		return
+	}
+	d = wireguardPeers(tmpData)
+	return
+}
+
 // Wrappers
 
 func wrapIpv4Dns(data []uint32) (wrapData []string) {
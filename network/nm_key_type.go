@@ -19,6 +19,10 @@
 
 package network
 
+import (
+	"pkg.deepin.io/lib/dbus1"
+)
+
 type ipv4AddressesWrapper []ipv4AddressWrapper
 type ipv4AddressWrapper struct {
 	Address string
@@ -68,3 +72,7 @@ type ipv6Route struct {
 	Metric  uint32
 }
 type ipv6Routes []ipv6Route
+
+// wireguardPeers is an array of peer dictionaries, see
+// NM_SETTING_WIREGUARD_PEER_* for the keys
+type wireguardPeers []map[string]dbus.Variant
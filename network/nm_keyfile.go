@@ -0,0 +1,620 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"pkg.deepin.io/dde/daemon/network/nm"
+)
+
+// NetworkManager keyfile, see nm-settings-keyfile(5)
+
+// keyfileSettingAliases are the setting names used in keyfile instead of the
+// names used in D-Bus.
+var keyfileSettingAliases = map[string]string{
+	"ethernet":      nm.NM_SETTING_WIRED_SETTING_NAME,
+	"wifi":          nm.NM_SETTING_WIRELESS_SETTING_NAME,
+	"wifi-security": nm.NM_SETTING_WIRELESS_SECURITY_SETTING_NAME,
+}
+
+const (
+	keyfileVpnSecretsSection     = "vpn-secrets"
+	keyfileWireguardPeerPrefix   = "wireguard-peer."
+	keyfileWireguardPeerKeyFlags = "preshared-key-flags"
+)
+
+var keyfileIpKeyRegexp = regexp.MustCompile(`^(address|addresses|route|routes)(\d*)$|^dns$`)
+
+// vpnSettingKeys are the real keys of vpn setting, other keys in the [vpn]
+// section of keyfile are the items of vpn.data.
+var vpnSettingKeys = []string{
+	nm.NM_SETTING_VPN_SERVICE_TYPE, nm.NM_SETTING_VPN_USER_NAME,
+	nm.NM_SETTING_VPN_PERSISTENT, nm.NM_SETTING_VPN_TIMEOUT,
+}
+
+var keyfileMacKeys = []string{"mac-address", "cloned-mac-address", "bssid", "bdaddr"}
+
+var keyfileCertKeys = []string{"ca-cert", "client-cert", "private-key",
+	"phase2-ca-cert", "phase2-client-cert", "phase2-private-key"}
+
+func getKeyfileSettingName(name string) string {
+	if setting, ok := keyfileSettingAliases[name]; ok {
+		return setting
+	}
+	return name
+}
+
+func getKeyfileSectionName(setting string) string {
+	for name, value := range keyfileSettingAliases {
+		if value == setting {
+			return name
+		}
+	}
+	return setting
+}
+
+func unescapeKeyfileValue(value string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			buf.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 's':
+			buf.WriteByte(' ')
+		case 'n':
+			buf.WriteByte('\n')
+		case 't':
+			buf.WriteByte('\t')
+		case 'r':
+			buf.WriteByte('\r')
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	return buf.String()
+}
+
+func escapeKeyfileValue(value string) string {
+	var buf bytes.Buffer
+	for i, r := range value {
+		switch {
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == ' ' && i == 0:
+			buf.WriteString(`\s`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// splitKeyfileList splits the list separated by ";", "\;" is not a separator
+func splitKeyfileList(value string) (result []string) {
+	var buf bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i < len(value)-1:
+			buf.WriteByte(value[i])
+			i++
+			buf.WriteByte(value[i])
+		case value[i] == ';':
+			if buf.Len() > 0 {
+				result = append(result, unescapeKeyfileValue(buf.String()))
+			}
+			buf.Reset()
+		default:
+			buf.WriteByte(value[i])
+		}
+	}
+	if buf.Len() > 0 {
+		result = append(result, unescapeKeyfileValue(buf.String()))
+	}
+	return
+}
+
+func joinKeyfileList(list []string) string {
+	var buf bytes.Buffer
+	for _, item := range list {
+		buf.WriteString(strings.Replace(escapeKeyfileValue(item), ";", `\;`, -1))
+		buf.WriteByte(';')
+	}
+	return buf.String()
+}
+
+func parseKeyfileUintList(value string, bitSize int) (result []uint64, err error) {
+	for _, item := range splitKeyfileList(value) {
+		var v uint64
+		v, err = strconv.ParseUint(strings.TrimSpace(item), 10, bitSize)
+		if err != nil {
+			return
+		}
+		result = append(result, v)
+	}
+	return
+}
+
+// parseKeyfileIpAddress parses the address "ip/prefix,gateway" or the route
+// "ip/prefix,next-hop,metric".
+func parseKeyfileIpAddress(value string) (ip net.IP, prefix uint32, gateway net.IP,
+	metric uint32, err error) {
+	parts := strings.Split(value, ",")
+	ip, prefix, err = parseIpPrefix(parts[0])
+	if err != nil {
+		return
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		gateway = net.ParseIP(parts[1])
+		if gateway == nil {
+			err = fmt.Errorf("invalid gateway %q", value)
+			return
+		}
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		var v uint64
+		v, err = strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			return
+		}
+		metric = uint32(v)
+	}
+	return
+}
+
+func checkKeyfileIpFamily(setting string, ips ...net.IP) error {
+	isIpv4 := setting == nm.NM_SETTING_IP4_CONFIG_SETTING_NAME
+	for _, ip := range ips {
+		if ip != nil && (ip.To4() != nil) != isIpv4 {
+			return fmt.Errorf("ip address %s does not belong to %s", ip, setting)
+		}
+	}
+	return nil
+}
+
+// setKeyfileIpKey sets the keys of ipv4 and ipv6 which contain addresses,
+// i.e. addresses, routes and dns servers.
+func setKeyfileIpKey(data connectionData, setting, key, value string) error {
+	isIpv4 := setting == nm.NM_SETTING_IP4_CONFIG_SETTING_NAME
+	if key == "dns" {
+		for _, item := range splitKeyfileList(value) {
+			ip := net.ParseIP(strings.TrimSpace(item))
+			if ip == nil {
+				return fmt.Errorf("invalid dns server %q", item)
+			}
+			if err := checkKeyfileIpFamily(setting, ip); err != nil {
+				return err
+			}
+			if isIpv4 {
+				addSettingIP4ConfigDnsServer(data, ip)
+			} else {
+				addSettingIP6ConfigDnsServer(data, ip)
+			}
+		}
+		return nil
+	}
+
+	ip, prefix, gateway, metric, err := parseKeyfileIpAddress(value)
+	if err != nil {
+		return err
+	}
+	if err = checkKeyfileIpFamily(setting, ip, gateway); err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(key, "route") && isIpv4:
+		addSettingIP4ConfigRoute(data, ip, prefix, gateway, metric)
+	case strings.HasPrefix(key, "route"):
+		addSettingIP6ConfigRoute(data, ip, prefix, gateway, metric)
+	case isIpv4:
+		addSettingIP4ConfigAddress(data, ip, prefix, gateway)
+	default:
+		addSettingIP6ConfigAddress(data, ip, prefix, gateway)
+	}
+	return nil
+}
+
+var keyfileByteListRegexp = regexp.MustCompile(`^(\d+;)+\d*$`)
+
+func parseKeyfileBytes(key, value string) ([]byte, error) {
+	switch {
+	case isStringInArray(key, keyfileMacKeys):
+		return convertMacAddressToArrayByteCheck(value)
+	case isStringInArray(key, keyfileCertKeys):
+		return strToByteArrayPath(toUriPathFor8021x(value)), nil
+	case !keyfileByteListRegexp.MatchString(value):
+		// ssid can be saved as string
+		return []byte(unescapeKeyfileValue(value)), nil
+	}
+	list, err := parseKeyfileUintList(value, 8)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, len(list))
+	for i, v := range list {
+		result[i] = byte(v)
+	}
+	return result, nil
+}
+
+// parseKeyfileValue converts the value to the type of the key
+func parseKeyfileValue(setting, key, value string) (result interface{}, err error) {
+	switch defValue := generalGetSettingDefaultValue(setting, key); defValue.(type) {
+	case string:
+		result = unescapeKeyfileValue(value)
+	case bool:
+		result, err = strconv.ParseBool(value)
+	case byte:
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 8)
+		result = byte(v)
+	case uint32:
+		var v uint64
+		v, err = strconv.ParseUint(value, 0, 32)
+		result = uint32(v)
+	case int32:
+		var v int64
+		v, err = strconv.ParseInt(value, 10, 32)
+		result = int32(v)
+	case uint64:
+		result, err = strconv.ParseUint(value, 10, 64)
+	case int64:
+		result, err = strconv.ParseInt(value, 10, 64)
+	case []string:
+		result = splitKeyfileList(value)
+	case []byte:
+		result, err = parseKeyfileBytes(key, value)
+	case []uint32:
+		var list []uint64
+		list, err = parseKeyfileUintList(value, 32)
+		items := make([]uint32, len(list))
+		for i, v := range list {
+			items[i] = uint32(v)
+		}
+		result = items
+	default:
+		return nil, fmt.Errorf("unsupported key %s.%s", setting, key)
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid value of %s.%s: %v", setting, key, err)
+	}
+	return
+}
+
+// newConnectionDataFromKeyfile builds the connection from the keyfile of
+// NetworkManager.
+func newConnectionDataFromKeyfile(file string) (data connectionData, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	data = make(connectionData)
+	vpnData := make(map[string]string)
+	vpnSecrets := make(map[string]string)
+	var peers []wireguardPeer
+	var section, setting string
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			setting = getKeyfileSettingName(section)
+			switch {
+			case section == keyfileVpnSecretsSection:
+			case strings.HasPrefix(section, keyfileWireguardPeerPrefix):
+				peers = append(peers, wireguardPeer{
+					PublicKey: strings.TrimPrefix(section, keyfileWireguardPeerPrefix),
+				})
+			default:
+				addSetting(data, setting)
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || section == "" {
+			return nil, fmt.Errorf("line %d: invalid line %q", lineNum, line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		switch {
+		case section == keyfileVpnSecretsSection:
+			vpnSecrets[key] = unescapeKeyfileValue(value)
+		case strings.HasPrefix(section, keyfileWireguardPeerPrefix):
+			peer := &peers[len(peers)-1]
+			switch key {
+			case keyfileWireguardPeerKeyFlags:
+				// the preshared key is saved with the private key
+			case "allowed-ips":
+				peer.AllowedIPs = splitKeyfileList(value)
+			default:
+				err = setWgQuickPeerKey(peer, strings.Replace(key, "-", "", -1), value)
+			}
+		case setting == nm.NM_SETTING_VPN_SETTING_NAME && !isStringInArray(key, vpnSettingKeys):
+			vpnData[key] = unescapeKeyfileValue(value)
+		case (setting == nm.NM_SETTING_IP4_CONFIG_SETTING_NAME ||
+			setting == nm.NM_SETTING_IP6_CONFIG_SETTING_NAME) &&
+			keyfileIpKeyRegexp.MatchString(key):
+			err = setKeyfileIpKey(data, setting, key, value)
+		default:
+			var v interface{}
+			v, err = parseKeyfileValue(setting, key, value)
+			if err == nil {
+				setSettingKey(data, setting, key, v)
+			} else if strings.HasPrefix(err.Error(), "unsupported key") {
+				logger.Warningf("line %d: %v", lineNum, err)
+				err = nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+
+	if !isSettingExists(data, nm.NM_SETTING_CONNECTION_SETTING_NAME) ||
+		getSettingConnectionType(data) == "" {
+		return nil, fmt.Errorf("%s is not a keyfile of NetworkManager", file)
+	}
+	if getSettingConnectionId(data) == "" {
+		return nil, fmt.Errorf("connection id is not specified")
+	}
+	if isSettingExists(data, nm.NM_SETTING_VPN_SETTING_NAME) {
+		setSettingVpnData(data, vpnData)
+		if len(vpnSecrets) > 0 {
+			setSettingVpnSecrets(data, vpnSecrets)
+		}
+	}
+	if len(peers) > 0 {
+		var wgPeers wireguardPeers
+		wgPeers, err = unwrapWireguardPeers(peers)
+		if err != nil {
+			return nil, err
+		}
+		setSettingWireguardPeers(data, wgPeers)
+	}
+	return
+}
+
+func formatKeyfileIpv4Address(item []uint32) string {
+	if len(item) < 2 {
+		return ""
+	}
+	value := fmt.Sprintf("%s/%d", convertIpv4AddressToString(item[0]), item[1])
+	if len(item) > 2 && item[2] != 0 {
+		value += "," + convertIpv4AddressToString(item[2])
+	}
+	return value
+}
+
+func formatKeyfileIpv6Address(address []byte, prefix uint32, gateway []byte) string {
+	value := fmt.Sprintf("%s/%d", convertIpv6AddressToString(address), prefix)
+	if !isIpv6AddressZero(gateway) {
+		value += "," + convertIpv6AddressToString(gateway)
+	}
+	return value
+}
+
+// getKeyfileIpValues returns the values of the keys of ipv4 and ipv6 which
+// contain structures.
+func getKeyfileIpValues(data connectionData, setting string) (values map[string]string) {
+	values = make(map[string]string)
+	if setting == nm.NM_SETTING_IP4_CONFIG_SETTING_NAME {
+		for i, item := range getSettingIP4ConfigAddresses(data) {
+			values["address"+strconv.Itoa(i+1)] = formatKeyfileIpv4Address(item)
+		}
+		for i, item := range getSettingIP4ConfigRoutes(data) {
+			value := formatKeyfileIpv4Address(item)
+			if len(item) > 3 {
+				if item[2] == 0 {
+					value += ","
+				}
+				value += "," + strconv.FormatUint(uint64(item[3]), 10)
+			}
+			values["route"+strconv.Itoa(i+1)] = value
+		}
+		return
+	}
+
+	for i, item := range getSettingIP6ConfigAddresses(data) {
+		values["address"+strconv.Itoa(i+1)] = formatKeyfileIpv6Address(item.Address,
+			item.Prefix, item.Gateway)
+	}
+	for i, item := range getSettingIP6ConfigRoutes(data) {
+		value := formatKeyfileIpv6Address(item.Address, item.Prefix, item.NextHop)
+		if isIpv6AddressZero(item.NextHop) {
+			value += ","
+		}
+		values["route"+strconv.Itoa(i+1)] = value + "," + strconv.FormatUint(uint64(item.Metric), 10)
+	}
+	if dns := getSettingIP6ConfigDns(data); len(dns) > 0 {
+		values["dns"] = joinKeyfileList(wrapIpv6Dns(dns))
+	}
+	return
+}
+
+func formatKeyfileBytes(key string, value []byte) string {
+	switch {
+	case isStringInArray(key, keyfileMacKeys):
+		return convertMacAddressToString(value)
+	case isStringInArray(key, keyfileCertKeys):
+		return toLocalPathFor8021x(byteArrayToStrPath(value))
+	case key == "ssid" && utf8.Valid(value) && !bytes.ContainsAny(value, ";\x00"):
+		return escapeKeyfileValue(string(value))
+	}
+	var buf bytes.Buffer
+	for _, b := range value {
+		buf.WriteString(strconv.Itoa(int(b)))
+		buf.WriteByte(';')
+	}
+	return buf.String()
+}
+
+// formatKeyfileValue converts the value to keyfile format, ok is false if
+// the type is not supported.
+func formatKeyfileValue(setting, key string, value interface{}) (result string, ok bool) {
+	ok = true
+	switch v := value.(type) {
+	case string:
+		result = escapeKeyfileValue(v)
+	case bool:
+		result = strconv.FormatBool(v)
+	case byte, uint32, int32, uint64, int64:
+		result = fmt.Sprint(v)
+	case []string:
+		result = joinKeyfileList(v)
+	case []byte:
+		result = formatKeyfileBytes(key, v)
+	case []uint32:
+		if setting == nm.NM_SETTING_IP4_CONFIG_SETTING_NAME && key == "dns" {
+			result = joinKeyfileList(wrapIpv4Dns(v))
+			break
+		}
+		var list []string
+		for _, item := range v {
+			list = append(list, strconv.FormatUint(uint64(item), 10))
+		}
+		result = joinKeyfileList(list)
+	default:
+		ok = false
+	}
+	return
+}
+
+func writeKeyfileSection(buf *bytes.Buffer, section string, values map[string]string) {
+	if len(values) == 0 && section != nm.NM_SETTING_CONNECTION_SETTING_NAME {
+		// keep empty sections of settings, e.g. [ethernet]
+		fmt.Fprintf(buf, "[%s]\n\n", section)
+		return
+	}
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(buf, "[%s]\n", section)
+	for _, key := range keys {
+		fmt.Fprintf(buf, "%s=%s\n", key, values[key])
+	}
+	buf.WriteByte('\n')
+}
+
+// genKeyfile converts the connection to the keyfile of NetworkManager
+func genKeyfile(data connectionData) []byte {
+	var settings []string
+	for setting := range data {
+		if setting != nm.NM_SETTING_CONNECTION_SETTING_NAME {
+			settings = append(settings, setting)
+		}
+	}
+	sort.Strings(settings)
+	settings = append([]string{nm.NM_SETTING_CONNECTION_SETTING_NAME}, settings...)
+
+	var buf bytes.Buffer
+	for _, setting := range settings {
+		values := make(map[string]string)
+		if setting == nm.NM_SETTING_IP4_CONFIG_SETTING_NAME ||
+			setting == nm.NM_SETTING_IP6_CONFIG_SETTING_NAME {
+			values = getKeyfileIpValues(data, setting)
+		}
+
+		var vpnSecrets map[string]string
+		var peers []wireguardPeer
+		for key, variant := range data[setting] {
+			if _, ok := values[key]; ok {
+				continue
+			}
+			switch {
+			case setting == nm.NM_SETTING_VPN_SETTING_NAME && key == nm.NM_SETTING_VPN_DATA:
+				for k, v := range getSettingVpnData(data) {
+					values[k] = escapeKeyfileValue(v)
+				}
+				continue
+			case setting == nm.NM_SETTING_VPN_SETTING_NAME && key == nm.NM_SETTING_VPN_SECRETS:
+				vpnSecrets = getSettingVpnSecrets(data)
+				continue
+			case setting == nm.NM_SETTING_WIREGUARD_SETTING_NAME &&
+				key == nm.NM_SETTING_WIREGUARD_PEERS:
+				peers = wrapWireguardPeers(getSettingWireguardPeers(data))
+				continue
+			}
+			value, ok := formatKeyfileValue(setting, key, variant.Value())
+			if !ok {
+				logger.Debugf("ignore key %s.%s of type %T", setting, key, variant.Value())
+				continue
+			}
+			values[key] = value
+		}
+		writeKeyfileSection(&buf, getKeyfileSectionName(setting), values)
+
+		if len(vpnSecrets) > 0 {
+			values = make(map[string]string)
+			for k, v := range vpnSecrets {
+				values[k] = escapeKeyfileValue(v)
+			}
+			writeKeyfileSection(&buf, keyfileVpnSecretsSection, values)
+		}
+		for _, peer := range peers {
+			values = make(map[string]string)
+			if peer.Endpoint != "" {
+				values["endpoint"] = peer.Endpoint
+			}
+			if len(peer.AllowedIPs) > 0 {
+				values["allowed-ips"] = joinKeyfileList(peer.AllowedIPs)
+			}
+			if peer.PersistentKeepalive != 0 {
+				values["persistent-keepalive"] = strconv.FormatUint(uint64(peer.PersistentKeepalive), 10)
+			}
+			if peer.PresharedKey != "" {
+				values["preshared-key"] = peer.PresharedKey
+				values[keyfileWireguardPeerKeyFlags] = "0"
+			}
+			writeKeyfileSection(&buf, keyfileWireguardPeerPrefix+peer.PublicKey, values)
+		}
+	}
+	return buf.Bytes()
+}
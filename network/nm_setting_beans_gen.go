@@ -1216,6 +1216,25 @@ func generalGetSettingDefaultValue(setting, key string) (defvalue interface{}) {
 		case "Cisco UDP Encapsulation Port":
 			defvalue = uint32(0x0)
 		}
+	case "wireguard":
+		switch key {
+		default:
+			logger.Error("invalid key:", setting, key)
+		case "fwmark":
+			defvalue = uint32(0x0)
+		case "listen-port":
+			defvalue = uint32(0x0)
+		case "mtu":
+			defvalue = uint32(0x0)
+		case "peer-routes":
+			defvalue = true
+		case "peers":
+			defvalue = make(wireguardPeers, 0)
+		case "private-key":
+			defvalue = ""
+		case "private-key-flags":
+			defvalue = uint32(0x0)
+		}
 	}
 	return
 }
@@ -2634,6 +2653,27 @@ func isSettingVpnVpncKeyDpdIdleTimeoutExists(data connectionData) bool {
 func isSettingVpnVpncKeyCiscoUdpEncapsPortExists(data connectionData) bool {
 	return isSettingKeyExists(data, "alias-vpn-vpnc-advanced", "Cisco UDP Encapsulation Port")
 }
+func isSettingWireguardFwmarkExists(data connectionData) bool {
+	return isSettingKeyExists(data, "wireguard", "fwmark")
+}
+func isSettingWireguardListenPortExists(data connectionData) bool {
+	return isSettingKeyExists(data, "wireguard", "listen-port")
+}
+func isSettingWireguardMtuExists(data connectionData) bool {
+	return isSettingKeyExists(data, "wireguard", "mtu")
+}
+func isSettingWireguardPeerRoutesExists(data connectionData) bool {
+	return isSettingKeyExists(data, "wireguard", "peer-routes")
+}
+func isSettingWireguardPeersExists(data connectionData) bool {
+	return isSettingKeyExists(data, "wireguard", "peers")
+}
+func isSettingWireguardPrivateKeyExists(data connectionData) bool {
+	return isSettingKeyExists(data, "wireguard", "private-key")
+}
+func isSettingWireguardPrivateKeyFlagsExists(data connectionData) bool {
+	return isSettingKeyExists(data, "wireguard", "private-key-flags")
+}
 
 // Getter
 func getSetting8021xAltsubjectMatches(data connectionData) (value []string) {
@@ -4991,6 +5031,41 @@ func getSettingVpnVpncKeyCiscoUdpEncapsPort(data connectionData) (value uint32)
 	value = interfaceToUint32(ivalue)
 	return
 }
+func getSettingWireguardFwmark(data connectionData) (value uint32) {
+	ivalue := getSettingKey(data, "wireguard", "fwmark")
+	value = interfaceToUint32(ivalue)
+	return
+}
+func getSettingWireguardListenPort(data connectionData) (value uint32) {
+	ivalue := getSettingKey(data, "wireguard", "listen-port")
+	value = interfaceToUint32(ivalue)
+	return
+}
+func getSettingWireguardMtu(data connectionData) (value uint32) {
+	ivalue := getSettingKey(data, "wireguard", "mtu")
+	value = interfaceToUint32(ivalue)
+	return
+}
+func getSettingWireguardPeerRoutes(data connectionData) (value bool) {
+	ivalue := getSettingKey(data, "wireguard", "peer-routes")
+	value = interfaceToBoolean(ivalue)
+	return
+}
+func getSettingWireguardPeers(data connectionData) (value wireguardPeers) {
+	ivalue := getSettingKey(data, "wireguard", "peers")
+	value = interfaceToWireguardPeers(ivalue)
+	return
+}
+func getSettingWireguardPrivateKey(data connectionData) (value string) {
+	ivalue := getSettingKey(data, "wireguard", "private-key")
+	value = interfaceToString(ivalue)
+	return
+}
+func getSettingWireguardPrivateKeyFlags(data connectionData) (value uint32) {
+	ivalue := getSettingKey(data, "wireguard", "private-key-flags")
+	value = interfaceToUint32(ivalue)
+	return
+}
 
 // Setter
 func setSetting8021xAltsubjectMatches(data connectionData, value []string) {
@@ -6406,6 +6481,27 @@ func setSettingVpnVpncKeyDpdIdleTimeout(data connectionData, value uint32) {
 func setSettingVpnVpncKeyCiscoUdpEncapsPort(data connectionData, value uint32) {
 	setSettingKey(data, "alias-vpn-vpnc-advanced", "Cisco UDP Encapsulation Port", value)
 }
+func setSettingWireguardFwmark(data connectionData, value uint32) {
+	setSettingKey(data, "wireguard", "fwmark", value)
+}
+func setSettingWireguardListenPort(data connectionData, value uint32) {
+	setSettingKey(data, "wireguard", "listen-port", value)
+}
+func setSettingWireguardMtu(data connectionData, value uint32) {
+	setSettingKey(data, "wireguard", "mtu", value)
+}
+func setSettingWireguardPeerRoutes(data connectionData, value bool) {
+	setSettingKey(data, "wireguard", "peer-routes", value)
+}
+func setSettingWireguardPeers(data connectionData, value wireguardPeers) {
+	setSettingKey(data, "wireguard", "peers", value)
+}
+func setSettingWireguardPrivateKey(data connectionData, value string) {
+	setSettingKey(data, "wireguard", "private-key", value)
+}
+func setSettingWireguardPrivateKeyFlags(data connectionData, value uint32) {
+	setSettingKey(data, "wireguard", "private-key-flags", value)
+}
 
 // Remover
 func removeSetting8021xAltsubjectMatches(data connectionData) {
@@ -7821,3 +7917,24 @@ func removeSettingVpnVpncKeyDpdIdleTimeout(data connectionData) {
 func removeSettingVpnVpncKeyCiscoUdpEncapsPort(data connectionData) {
 	removeSettingKey(data, "alias-vpn-vpnc-advanced", "Cisco UDP Encapsulation Port")
 }
+func removeSettingWireguardFwmark(data connectionData) {
+	removeSettingKey(data, "wireguard", "fwmark")
+}
+func removeSettingWireguardListenPort(data connectionData) {
+	removeSettingKey(data, "wireguard", "listen-port")
+}
+func removeSettingWireguardMtu(data connectionData) {
+	removeSettingKey(data, "wireguard", "mtu")
+}
+func removeSettingWireguardPeerRoutes(data connectionData) {
+	removeSettingKey(data, "wireguard", "peer-routes")
+}
+func removeSettingWireguardPeers(data connectionData) {
+	removeSettingKey(data, "wireguard", "peers")
+}
+func removeSettingWireguardPrivateKey(data connectionData) {
+	removeSettingKey(data, "wireguard", "private-key")
+}
+func removeSettingWireguardPrivateKeyFlags(data connectionData) {
+	removeSettingKey(data, "wireguard", "private-key-flags")
+}
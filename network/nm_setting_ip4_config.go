@@ -20,6 +20,8 @@
 package network
 
 import (
+	"net"
+
 	"pkg.deepin.io/dde/daemon/network/nm"
 )
 
@@ -28,3 +30,30 @@ func initSettingSectionIpv4(data connectionData) {
 	setSettingIP4ConfigMethod(data, nm.NM_SETTING_IP4_CONFIG_METHOD_AUTO)
 	setSettingIP4ConfigNeverDefault(data, false)
 }
+
+// The helpers below are shared by the importers of connection files, the ip
+// must be an ipv4 address, a nil gateway or next hop means none.
+
+func addSettingIP4ConfigAddress(data connectionData, ip net.IP, prefix uint32, gateway net.IP) {
+	var gw uint32
+	if gateway != nil {
+		gw = convertIpv4AddressToUint32(gateway.To4().String())
+	}
+	setSettingIP4ConfigAddresses(data, append(getSettingIP4ConfigAddresses(data),
+		[]uint32{convertIpv4AddressToUint32(ip.To4().String()), prefix, gw}))
+}
+
+func addSettingIP4ConfigRoute(data connectionData, ip net.IP, prefix uint32, nextHop net.IP,
+	metric uint32) {
+	var nh uint32
+	if nextHop != nil {
+		nh = convertIpv4AddressToUint32(nextHop.To4().String())
+	}
+	setSettingIP4ConfigRoutes(data, append(getSettingIP4ConfigRoutes(data),
+		[]uint32{convertIpv4AddressToUint32(ip.To4().String()), prefix, nh, metric}))
+}
+
+func addSettingIP4ConfigDnsServer(data connectionData, ip net.IP) {
+	setSettingIP4ConfigDns(data, append(getSettingIP4ConfigDns(data),
+		convertIpv4AddressToUint32(ip.To4().String())))
+}
@@ -20,6 +20,8 @@
 package network
 
 import (
+	"net"
+
 	"pkg.deepin.io/dde/daemon/network/nm"
 )
 
@@ -27,3 +29,35 @@ func initSettingSectionIpv6(data connectionData) {
 	addSetting(data, nm.NM_SETTING_IP6_CONFIG_SETTING_NAME)
 	setSettingIP6ConfigMethod(data, nm.NM_SETTING_IP6_CONFIG_METHOD_AUTO)
 }
+
+// The helpers below are shared by the importers of connection files, a nil
+// gateway or next hop means none.
+
+func getIpv6Bytes(ip net.IP) []byte {
+	if ip == nil {
+		return make([]byte, net.IPv6len)
+	}
+	return []byte(ip.To16())
+}
+
+func addSettingIP6ConfigAddress(data connectionData, ip net.IP, prefix uint32, gateway net.IP) {
+	setSettingIP6ConfigAddresses(data, append(getSettingIP6ConfigAddresses(data), ipv6Address{
+		Address: getIpv6Bytes(ip),
+		Prefix:  prefix,
+		Gateway: getIpv6Bytes(gateway),
+	}))
+}
+
+func addSettingIP6ConfigRoute(data connectionData, ip net.IP, prefix uint32, nextHop net.IP,
+	metric uint32) {
+	setSettingIP6ConfigRoutes(data, append(getSettingIP6ConfigRoutes(data), ipv6Route{
+		Address: getIpv6Bytes(ip),
+		Prefix:  prefix,
+		NextHop: getIpv6Bytes(nextHop),
+		Metric:  metric,
+	}))
+}
+
+func addSettingIP6ConfigDnsServer(data connectionData, ip net.IP) {
+	setSettingIP6ConfigDns(data, append(getSettingIP6ConfigDns(data), getIpv6Bytes(ip)))
+}
@@ -0,0 +1,510 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pkg.deepin.io/dde/daemon/network/nm"
+	"pkg.deepin.io/lib/xdg/basedir"
+)
+
+// getOpenvpnCertDir returns the directory to store the certificates
+// extracted from the imported configuration file, same as nm-applet.
+func getOpenvpnCertDir() string {
+	return filepath.Join(basedir.GetUserHomeDir(), ".cert", "nm-openvpn")
+}
+
+// NetworkManager-openvpn stores ns-cert-type separately from remote-cert-tls,
+// the key is not in nm_consts_gen.go.
+const nmOpenvpnKeyNsCertType = "ns-cert-type"
+
+// openvpnInlineTags are the tags of inline files in .ovpn, and the suffixes
+// of the files to store them.
+var openvpnInlineTags = map[string]string{
+	"ca":        "ca.pem",
+	"cert":      "cert.pem",
+	"key":       "key.pem",
+	"tls-auth":  "tls-auth.pem",
+	"tls-crypt": "tls-crypt.pem",
+	"secret":    "static.key",
+}
+
+func newOpenvpnConnectionData(id, uuid string) (data connectionData) {
+	data = make(connectionData)
+
+	addSetting(data, nm.NM_SETTING_CONNECTION_SETTING_NAME)
+	setSettingConnectionId(data, id)
+	setSettingConnectionUuid(data, uuid)
+	setSettingConnectionType(data, nm.NM_SETTING_VPN_SETTING_NAME)
+	setSettingConnectionAutoconnect(data, false)
+
+	addSetting(data, nm.NM_SETTING_VPN_SETTING_NAME)
+	setSettingVpnServiceType(data, nm.NM_DBUS_SERVICE_OPENVPN)
+
+	initSettingSectionIpv4(data)
+	initSettingSectionIpv6(data)
+	return
+}
+
+// getOpenvpnInlineFile returns the file to store the inline file, the uuid of
+// the connection is used so that the files imported from .ovpn files with the
+// same name do not overwrite each other.
+func getOpenvpnInlineFile(uuid, tag string) string {
+	return filepath.Join(getOpenvpnCertDir(), uuid+"-"+openvpnInlineTags[tag])
+}
+
+// saveOpenvpnInlineFiles writes the contents of the inline files to the cert
+// directory, the private keys are readable only by the user. Nothing is left
+// if any of them fails.
+func saveOpenvpnInlineFiles(uuid string, inlines map[string]string) (err error) {
+	if len(inlines) == 0 {
+		return
+	}
+	err = os.MkdirAll(getOpenvpnCertDir(), 0700)
+	if err != nil {
+		return
+	}
+	for tag, content := range inlines {
+		err = ioutil.WriteFile(getOpenvpnInlineFile(uuid, tag), []byte(content), 0600)
+		if err != nil {
+			removeOpenvpnInlineFiles(uuid)
+			return
+		}
+	}
+	return
+}
+
+// removeOpenvpnInlineFiles removes the inline files saved for the
+// connection, e.g. when the connection fails to be added.
+func removeOpenvpnInlineFiles(uuid string) {
+	for tag := range openvpnInlineTags {
+		err := os.Remove(getOpenvpnInlineFile(uuid, tag))
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warning(err)
+		}
+	}
+}
+
+// splitOpenvpnArgs splits the line into the directive and its arguments,
+// arguments can be quoted.
+func splitOpenvpnArgs(line string) (args []string) {
+	var buf bytes.Buffer
+	var quote rune
+	inArg := false
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				buf.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, buf.String())
+				buf.Reset()
+				inArg = false
+			}
+		default:
+			buf.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, buf.String())
+	}
+	return
+}
+
+type openvpnConfig struct {
+	remotes    []string
+	port       string
+	proto      string
+	files      map[string]string // ca, cert, key, tls-auth, tls-crypt and secret
+	keyDir     string
+	authUser   bool
+	options    map[string]string // options stored in vpn.data as they are
+	compLzo    bool
+	remoteCert string
+}
+
+func (cfg *openvpnConfig) setOption(lineNum int, args []string, baseDir string) error {
+	getPath := func(file string) string {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(baseDir, file)
+		}
+		return file
+	}
+
+	switch args[0] {
+	case "remote":
+		if len(args) < 2 {
+			return fmt.Errorf("line %d: remote requires a host", lineNum)
+		}
+		cfg.remotes = append(cfg.remotes, strings.Join(args[1:], ":"))
+	case "port", "rport":
+		if len(args) > 1 {
+			cfg.port = args[1]
+		}
+	case "proto":
+		if len(args) > 1 {
+			cfg.proto = args[1]
+		}
+	case "dev", "dev-type":
+		if len(args) > 1 && strings.HasPrefix(args[1], "tap") {
+			cfg.options[nm.NM_SETTING_VPN_OPENVPN_KEY_TAP_DEV] = "yes"
+		}
+	case "ca", "cert", "key", "tls-crypt":
+		if len(args) > 1 && args[1] != "[inline]" {
+			cfg.files[args[0]] = getPath(args[1])
+		}
+	case "tls-auth", "secret":
+		if len(args) > 1 && args[1] != "[inline]" {
+			cfg.files[args[0]] = getPath(args[1])
+		}
+		if len(args) > 2 {
+			cfg.keyDir = args[2]
+		}
+	case "key-direction":
+		if len(args) > 1 {
+			cfg.keyDir = args[1]
+		}
+	case "auth-user-pass":
+		cfg.authUser = true
+	case "comp-lzo":
+		cfg.compLzo = len(args) < 2 || args[1] != "no"
+	case "remote-cert-tls":
+		if len(args) > 1 {
+			cfg.remoteCert = args[1]
+		}
+	case "ns-cert-type":
+		if len(args) > 1 {
+			cfg.options[nmOpenvpnKeyNsCertType] = args[1]
+		}
+	case "ifconfig":
+		if len(args) > 2 {
+			cfg.options[nm.NM_SETTING_VPN_OPENVPN_KEY_LOCAL_IP] = args[1]
+			cfg.options[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_IP] = args[2]
+		}
+	case "remote-random":
+		cfg.options[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_RANDOM] = "yes"
+	case "mssfix":
+		cfg.options[nm.NM_SETTING_VPN_OPENVPN_KEY_MSSFIX] = "yes"
+	case "cipher", "auth", "reneg-sec", "tun-mtu", "fragment":
+		if len(args) < 2 {
+			return fmt.Errorf("line %d: %s requires an argument", lineNum, args[0])
+		}
+		key := map[string]string{
+			"cipher":    nm.NM_SETTING_VPN_OPENVPN_KEY_CIPHER,
+			"auth":      nm.NM_SETTING_VPN_OPENVPN_KEY_AUTH,
+			"reneg-sec": nm.NM_SETTING_VPN_OPENVPN_KEY_RENEG_SECONDS,
+			"tun-mtu":   nm.NM_SETTING_VPN_OPENVPN_KEY_TUNNEL_MTU,
+			"fragment":  nm.NM_SETTING_VPN_OPENVPN_KEY_FRAGMENT_SIZE,
+		}[args[0]]
+		cfg.options[key] = args[1]
+	default:
+		logger.Debugf("ignore openvpn option at line %d: %s", lineNum, args[0])
+	}
+	return nil
+}
+
+// toVpnData converts the options to vpn.data of NetworkManager-openvpn
+func (cfg *openvpnConfig) toVpnData() (vpnData map[string]string, err error) {
+	if len(cfg.remotes) == 0 {
+		return nil, fmt.Errorf("remote is not specified")
+	}
+	vpnData = cfg.options
+
+	remotes := make([]string, len(cfg.remotes))
+	for i, remote := range cfg.remotes {
+		// host[:port[:proto]], the port and proto of remote take precedence
+		parts := strings.Split(remote, ":")
+		if len(parts) == 1 && cfg.port != "" {
+			parts = append(parts, cfg.port)
+		}
+		if len(parts) == 2 && cfg.proto != "" {
+			parts = append(parts, cfg.proto)
+		}
+		remotes[i] = strings.Join(parts, ":")
+	}
+	vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE] = strings.Join(remotes, ", ")
+	if strings.HasPrefix(cfg.proto, "tcp") {
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_PROTO_TCP] = "yes"
+	}
+	if cfg.port != "" {
+		_, err = strconv.ParseUint(cfg.port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", cfg.port)
+		}
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_PORT] = cfg.port
+	}
+	if cfg.compLzo {
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_COMP_LZO] = "yes"
+	}
+	if cfg.remoteCert != "" {
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_CERT_TLS] = cfg.remoteCert
+	}
+
+	if file, ok := cfg.files["secret"]; ok {
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_CONNECTION_TYPE] = nm.NM_OPENVPN_CONTYPE_STATIC_KEY
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_STATIC_KEY] = file
+		if cfg.keyDir != "" {
+			vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_STATIC_KEY_DIRECTION] = cfg.keyDir
+		}
+		return vpnData, nil
+	}
+
+	var connType string
+	_, hasCert := cfg.files["cert"]
+	switch {
+	case cfg.authUser && hasCert:
+		connType = nm.NM_OPENVPN_CONTYPE_PASSWORD_TLS
+	case cfg.authUser:
+		connType = nm.NM_OPENVPN_CONTYPE_PASSWORD
+	default:
+		connType = nm.NM_OPENVPN_CONTYPE_TLS
+	}
+	vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_CONNECTION_TYPE] = connType
+	if cfg.authUser {
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_PASSWORD_FLAGS] = secretFlagAgentOwnedStr
+	}
+
+	for tag, key := range map[string]string{
+		"ca":   nm.NM_SETTING_VPN_OPENVPN_KEY_CA,
+		"cert": nm.NM_SETTING_VPN_OPENVPN_KEY_CERT,
+		"key":  nm.NM_SETTING_VPN_OPENVPN_KEY_KEY,
+	} {
+		if file, ok := cfg.files[tag]; ok {
+			vpnData[key] = file
+		}
+	}
+	if file, ok := cfg.files["tls-auth"]; ok {
+		vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_TA] = file
+		if cfg.keyDir != "" {
+			vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_TA_DIR] = cfg.keyDir
+		}
+	}
+	if file, ok := cfg.files["tls-crypt"]; ok {
+		vpnData["tls-crypt"] = file
+	}
+	return vpnData, nil
+}
+
+// newOpenvpnConnectionDataFromFile builds the connection from the .ovpn
+// file, inline files are saved to the cert directory after the file is
+// parsed successfully.
+func newOpenvpnConnectionDataFromFile(file, uuid string) (data connectionData, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	cfg := &openvpnConfig{
+		files:   make(map[string]string),
+		options: make(map[string]string),
+	}
+
+	inlines := make(map[string]string)
+	var inlineTag string
+	var inlineContent bytes.Buffer
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if inlineTag != "" {
+			if line == "</"+inlineTag+">" {
+				inlines[inlineTag] = inlineContent.String()
+				cfg.files[inlineTag] = getOpenvpnInlineFile(uuid, inlineTag)
+				inlineTag = ""
+				inlineContent.Reset()
+			} else {
+				inlineContent.WriteString(line)
+				inlineContent.WriteByte('\n')
+			}
+			continue
+		}
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "<") && strings.HasSuffix(line, ">") {
+			inlineTag = line[1 : len(line)-1]
+			if _, ok := openvpnInlineTags[inlineTag]; !ok {
+				return nil, fmt.Errorf("line %d: unsupported inline file %q", lineNum, line)
+			}
+			continue
+		}
+
+		args := splitOpenvpnArgs(line)
+		if len(args) == 0 {
+			continue
+		}
+		args[0] = strings.TrimPrefix(args[0], "--")
+		err = cfg.setOption(lineNum, args, filepath.Dir(file))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+	if inlineTag != "" {
+		return nil, fmt.Errorf("inline file <%s> is not closed", inlineTag)
+	}
+
+	vpnData, err := cfg.toVpnData()
+	if err != nil {
+		return
+	}
+	err = saveOpenvpnInlineFiles(uuid, inlines)
+	if err != nil {
+		return
+	}
+	data = newOpenvpnConnectionData(id, uuid)
+	setSettingVpnData(data, vpnData)
+	return
+}
+
+// writeOpenvpnInlineFile appends the file as an inline file
+func writeOpenvpnInlineFile(buf *bytes.Buffer, tag, file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "<%s>\n", tag)
+	buf.Write(bytes.TrimSpace(content))
+	fmt.Fprintf(buf, "\n</%s>\n", tag)
+	return nil
+}
+
+// genOpenvpnConfig converts the openvpn connection to the content of .ovpn,
+// the certificates are inlined so that the file can be used alone.
+func genOpenvpnConfig(data connectionData) (content []byte, err error) {
+	vpnData := getSettingVpnData(data)
+	var buf bytes.Buffer
+	buf.WriteString("client\n")
+
+	if vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_TAP_DEV] == "yes" {
+		buf.WriteString("dev tap\n")
+	} else {
+		buf.WriteString("dev tun\n")
+	}
+	if vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_PROTO_TCP] == "yes" {
+		buf.WriteString("proto tcp-client\n")
+	} else {
+		buf.WriteString("proto udp\n")
+	}
+	for _, remote := range strings.Split(vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE], ",") {
+		remote = strings.TrimSpace(remote)
+		if remote == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "remote %s\n", strings.Join(strings.Split(remote, ":"), " "))
+	}
+	if port := vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_PORT]; port != "" {
+		fmt.Fprintf(&buf, "port %s\n", port)
+	}
+
+	options := map[string]string{
+		nm.NM_SETTING_VPN_OPENVPN_KEY_CIPHER:          "cipher",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_AUTH:            "auth",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_RENEG_SECONDS:   "reneg-sec",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_TUNNEL_MTU:      "tun-mtu",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_FRAGMENT_SIZE:   "fragment",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_CERT_TLS: "remote-cert-tls",
+		nmOpenvpnKeyNsCertType:                        "ns-cert-type",
+	}
+	var keys []string
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value := vpnData[key]; value != "" {
+			fmt.Fprintf(&buf, "%s %s\n", options[key], value)
+		}
+	}
+	if vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_COMP_LZO] == "yes" {
+		buf.WriteString("comp-lzo\n")
+	}
+	if vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_MSSFIX] == "yes" {
+		buf.WriteString("mssfix\n")
+	}
+	if vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_RANDOM] == "yes" {
+		buf.WriteString("remote-random\n")
+	}
+
+	connType := vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_CONNECTION_TYPE]
+	if connType == nm.NM_OPENVPN_CONTYPE_STATIC_KEY {
+		localIp := vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_LOCAL_IP]
+		remoteIp := vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_IP]
+		if localIp != "" && remoteIp != "" {
+			fmt.Fprintf(&buf, "ifconfig %s %s\n", localIp, remoteIp)
+		}
+		if dir := vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_STATIC_KEY_DIRECTION]; dir != "" {
+			fmt.Fprintf(&buf, "key-direction %s\n", dir)
+		}
+		err = writeOpenvpnInlineFile(&buf, "secret", vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_STATIC_KEY])
+		if err != nil {
+			return
+		}
+		return buf.Bytes(), nil
+	}
+
+	buf.WriteString("tls-client\n")
+	if connType == nm.NM_OPENVPN_CONTYPE_PASSWORD || connType == nm.NM_OPENVPN_CONTYPE_PASSWORD_TLS {
+		buf.WriteString("auth-user-pass\n")
+	}
+	if dir := vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_TA_DIR]; dir != "" {
+		fmt.Fprintf(&buf, "key-direction %s\n", dir)
+	}
+	for _, item := range []struct{ tag, key string }{
+		{"ca", nm.NM_SETTING_VPN_OPENVPN_KEY_CA},
+		{"cert", nm.NM_SETTING_VPN_OPENVPN_KEY_CERT},
+		{"key", nm.NM_SETTING_VPN_OPENVPN_KEY_KEY},
+		{"tls-auth", nm.NM_SETTING_VPN_OPENVPN_KEY_TA},
+		{"tls-crypt", "tls-crypt"},
+	} {
+		file := vpnData[item.key]
+		if file == "" {
+			continue
+		}
+		err = writeOpenvpnInlineFile(&buf, item.tag, file)
+		if err != nil {
+			return
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,444 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"pkg.deepin.io/dde/daemon/network/nm"
+	"pkg.deepin.io/lib/dbus1"
+)
+
+const wireguardKeyLen = 32
+
+// wireguardPeer is the wrapper of the peer dictionary for front-end.
+// ClearPresharedKey removes the preshared key of the peer, an empty
+// PresharedKey keeps the old one.
+type wireguardPeer struct {
+	PublicKey           string
+	PresharedKey        string
+	ClearPresharedKey   bool
+	Endpoint            string
+	AllowedIPs          []string
+	PersistentKeepalive uint32
+}
+
+var wireguardIfcNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_=+.-]{1,15}$`)
+
+func newWireguardConnectionData(id, uuid, ifcName string) (data connectionData) {
+	data = make(connectionData)
+
+	addSetting(data, nm.NM_SETTING_CONNECTION_SETTING_NAME)
+	setSettingConnectionId(data, id)
+	setSettingConnectionUuid(data, uuid)
+	setSettingConnectionType(data, nm.NM_SETTING_WIREGUARD_SETTING_NAME)
+	setSettingConnectionInterfaceName(data, ifcName)
+	setSettingConnectionAutoconnect(data, false)
+
+	addSetting(data, nm.NM_SETTING_WIREGUARD_SETTING_NAME)
+
+	// wireguard does not support dhcp, the addresses must be configured manually
+	addSetting(data, nm.NM_SETTING_IP4_CONFIG_SETTING_NAME)
+	setSettingIP4ConfigMethod(data, nm.NM_SETTING_IP4_CONFIG_METHOD_DISABLED)
+	addSetting(data, nm.NM_SETTING_IP6_CONFIG_SETTING_NAME)
+	setSettingIP6ConfigMethod(data, nm.NM_SETTING_IP6_CONFIG_METHOD_IGNORE)
+	return
+}
+
+func getConnectionIfcNames() (ifcNames []string) {
+	for _, cpath := range nmGetConnectionList() {
+		data, err := nmGetConnectionData(cpath)
+		if err != nil {
+			continue
+		}
+		ifcNames = append(ifcNames, getSettingConnectionInterfaceName(data))
+	}
+	return
+}
+
+// genWireguardIfcName returns the first interface name of wg0, wg1... which
+// is not in ifcNames.
+func genWireguardIfcName(ifcNames []string) string {
+	for i := 0; ; i++ {
+		name := "wg" + strconv.Itoa(i)
+		if !isStringInArray(name, ifcNames) {
+			return name
+		}
+	}
+}
+
+func decodeWireguardKey(key string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(data) != wireguardKeyLen {
+		return nil, fmt.Errorf("invalid wireguard key %q", key)
+	}
+	return data, nil
+}
+
+// genWireguardKeyPair generates a private key and the related public key,
+// both are base64 encoded as the wg command.
+func genWireguardKeyPair() (privateKey, publicKey string, err error) {
+	var priv [wireguardKeyLen]byte
+	_, err = rand.Read(priv[:])
+	if err != nil {
+		return
+	}
+	// clamp the private key for curve25519
+	priv[0] &= 248
+	priv[31] &= 127
+	priv[31] |= 64
+
+	privateKey = base64.StdEncoding.EncodeToString(priv[:])
+	publicKey, err = getWireguardPublicKey(privateKey)
+	return
+}
+
+func getWireguardPublicKey(privateKey string) (string, error) {
+	data, err := decodeWireguardKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	var priv, pub [wireguardKeyLen]byte
+	copy(priv[:], data)
+	curve25519.ScalarBaseMult(&pub, &priv)
+	return base64.StdEncoding.EncodeToString(pub[:]), nil
+}
+
+// Wrappers
+
+func wrapWireguardPeers(data wireguardPeers) (wrapData []wireguardPeer) {
+	for _, d := range data {
+		var peer wireguardPeer
+		peer.PublicKey, _ = d[nm.NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY].Value().(string)
+		peer.PresharedKey, _ = d[nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY].Value().(string)
+		peer.Endpoint, _ = d[nm.NM_SETTING_WIREGUARD_PEER_ENDPOINT].Value().(string)
+		peer.AllowedIPs, _ = d[nm.NM_SETTING_WIREGUARD_PEER_ALLOWED_IPS].Value().([]string)
+		peer.PersistentKeepalive, _ = d[nm.NM_SETTING_WIREGUARD_PEER_PERSISTENT_KEEPALIVE].Value().(uint32)
+		wrapData = append(wrapData, peer)
+	}
+	return
+}
+
+func checkWireguardPeer(peer *wireguardPeer) error {
+	_, err := decodeWireguardKey(peer.PublicKey)
+	if err != nil {
+		return err
+	}
+	if peer.PresharedKey != "" {
+		if peer.ClearPresharedKey {
+			return errors.New("preshared key is set and cleared at the same time")
+		}
+		_, err = decodeWireguardKey(peer.PresharedKey)
+		if err != nil {
+			return err
+		}
+	}
+	if peer.Endpoint != "" {
+		_, _, err = net.SplitHostPort(peer.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint %q", peer.Endpoint)
+		}
+	}
+	for _, allowedIP := range peer.AllowedIPs {
+		_, _, err = net.ParseCIDR(allowedIP)
+		if err != nil {
+			return fmt.Errorf("invalid allowed ip %q", allowedIP)
+		}
+	}
+	return nil
+}
+
+// keepWireguardPresharedKeys fills the empty preshared keys of peers with the
+// ones of oldPeers which have the same public keys, unless the peers clear
+// them.
+func keepWireguardPresharedKeys(peers, oldPeers []wireguardPeer) {
+	presharedKeys := make(map[string]string, len(oldPeers))
+	for _, peer := range oldPeers {
+		presharedKeys[peer.PublicKey] = peer.PresharedKey
+	}
+	for i := range peers {
+		if peers[i].PresharedKey == "" && !peers[i].ClearPresharedKey {
+			peers[i].PresharedKey = presharedKeys[peers[i].PublicKey]
+		}
+	}
+}
+
+// wireguardPeerSecret is the preshared key of a peer for the secret agent.
+type wireguardPeerSecret struct {
+	key        string
+	publicKey  string
+	value      string
+	agentOwned bool
+}
+
+// getWireguardPeerSecrets returns the preshared keys of the peers, they are
+// in the peers array instead of the wireguard setting. The key of a peer
+// secret is same as the hint of NetworkManager, e.g.
+// "peers.<public-key>.preshared-key".
+func getWireguardPeerSecrets(data connectionData) (secrets []wireguardPeerSecret) {
+	for _, peer := range getSettingWireguardPeers(data) {
+		var secret wireguardPeerSecret
+		secret.publicKey, _ = peer[nm.NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY].Value().(string)
+		if secret.publicKey == "" {
+			continue
+		}
+		secret.key = nm.NM_SETTING_WIREGUARD_PEERS + "." + secret.publicKey + "." +
+			nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY
+		secret.value, _ = peer[nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY].Value().(string)
+		flags, _ := peer[nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY_FLAGS].Value().(uint32)
+		secret.agentOwned = flags == secretFlagAgentOwned
+		secrets = append(secrets, secret)
+	}
+	return
+}
+
+func unwrapWireguardPeers(wrapData []wireguardPeer) (data wireguardPeers, err error) {
+	data = make(wireguardPeers, 0, len(wrapData))
+	for i := range wrapData {
+		peer := &wrapData[i]
+		err = checkWireguardPeer(peer)
+		if err != nil {
+			return nil, err
+		}
+		d := map[string]dbus.Variant{
+			nm.NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY: dbus.MakeVariant(peer.PublicKey),
+		}
+		if peer.PresharedKey != "" {
+			d[nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY] = dbus.MakeVariant(peer.PresharedKey)
+			d[nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY_FLAGS] = dbus.MakeVariant(uint32(0))
+		}
+		if peer.Endpoint != "" {
+			d[nm.NM_SETTING_WIREGUARD_PEER_ENDPOINT] = dbus.MakeVariant(peer.Endpoint)
+		}
+		if len(peer.AllowedIPs) > 0 {
+			d[nm.NM_SETTING_WIREGUARD_PEER_ALLOWED_IPS] = dbus.MakeVariant(peer.AllowedIPs)
+		}
+		if peer.PersistentKeepalive > 0 {
+			d[nm.NM_SETTING_WIREGUARD_PEER_PERSISTENT_KEEPALIVE] = dbus.MakeVariant(peer.PersistentKeepalive)
+		}
+		data = append(data, d)
+	}
+	return
+}
+
+// Import wg-quick configuration file
+
+func splitWgQuickList(value string) (result []string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return
+}
+
+// parseWgQuickUint32 parses the value which can be "off", e.g. ListenPort,
+// FwMark and PersistentKeepalive.
+func parseWgQuickUint32(value string) (uint32, error) {
+	if value == "off" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(value, 0, 32)
+	return uint32(v), err
+}
+
+func addWgQuickAddress(data connectionData, value string) error {
+	ip, prefix, err := parseIpPrefix(value)
+	if err != nil {
+		return err
+	}
+	if ip.To4() != nil {
+		addSettingIP4ConfigAddress(data, ip, prefix, nil)
+		setSettingIP4ConfigMethod(data, nm.NM_SETTING_IP4_CONFIG_METHOD_MANUAL)
+	} else {
+		addSettingIP6ConfigAddress(data, ip, prefix, nil)
+		setSettingIP6ConfigMethod(data, nm.NM_SETTING_IP6_CONFIG_METHOD_MANUAL)
+	}
+	return nil
+}
+
+// addWgQuickDns adds the dns server, the item which is not an ip address is
+// a search domain.
+func addWgQuickDns(data connectionData, value string) {
+	ip := net.ParseIP(value)
+	switch {
+	case ip == nil:
+		setSettingIP4ConfigDnsSearch(data, append(getSettingIP4ConfigDnsSearch(data), value))
+	case ip.To4() != nil:
+		addSettingIP4ConfigDnsServer(data, ip)
+	default:
+		addSettingIP6ConfigDnsServer(data, ip)
+	}
+}
+
+func setWgQuickInterfaceKey(data connectionData, key, value string) (err error) {
+	switch key {
+	case "privatekey":
+		_, err = decodeWireguardKey(value)
+		if err == nil {
+			setSettingWireguardPrivateKey(data, value)
+		}
+	case "listenport":
+		var port uint32
+		port, err = parseWgQuickUint32(value)
+		if err == nil {
+			setSettingWireguardListenPort(data, port)
+		}
+	case "fwmark":
+		var mark uint32
+		mark, err = parseWgQuickUint32(value)
+		if err == nil {
+			setSettingWireguardFwmark(data, mark)
+		}
+	case "mtu":
+		var mtu uint32
+		mtu, err = parseWgQuickUint32(value)
+		if err == nil {
+			setSettingWireguardMtu(data, mtu)
+		}
+	case "address":
+		for _, item := range splitWgQuickList(value) {
+			err = addWgQuickAddress(data, item)
+			if err != nil {
+				return
+			}
+		}
+	case "dns":
+		for _, item := range splitWgQuickList(value) {
+			addWgQuickDns(data, item)
+		}
+	case "table":
+		// wg-quick does not add routes for the peers when table is off
+		setSettingWireguardPeerRoutes(data, value != "off")
+	default:
+		// the keys only used by wg-quick, such as PreUp and SaveConfig
+		logger.Debug("ignore wg-quick interface key:", key)
+	}
+	return
+}
+
+func setWgQuickPeerKey(peer *wireguardPeer, key, value string) (err error) {
+	switch key {
+	case "publickey":
+		peer.PublicKey = value
+	case "presharedkey":
+		peer.PresharedKey = value
+	case "endpoint":
+		peer.Endpoint = value
+	case "allowedips":
+		peer.AllowedIPs = append(peer.AllowedIPs, splitWgQuickList(value)...)
+	case "persistentkeepalive":
+		peer.PersistentKeepalive, err = parseWgQuickUint32(value)
+	default:
+		logger.Debug("ignore wg-quick peer key:", key)
+	}
+	return
+}
+
+// newWireguardConnectionDataFromFile converts the configuration file of
+// wg-quick to connection data, the name of the file is used as the interface
+// name if possible, as wg-quick does. ifcNames are the interface names used
+// by the existing connections.
+func newWireguardConnectionDataFromFile(file, uuid string, ifcNames []string) (data connectionData, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	ifcName := name
+	if !wireguardIfcNameRegexp.MatchString(ifcName) ||
+		isStringInArray(ifcName, ifcNames) {
+		ifcName = genWireguardIfcName(ifcNames)
+	}
+	data = newWireguardConnectionData(name, uuid, ifcName)
+
+	var section string
+	var peers []wireguardPeer
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(line[1 : len(line)-1])
+			switch section {
+			case "interface":
+			case "peer":
+				peers = append(peers, wireguardPeer{})
+			default:
+				return nil, fmt.Errorf("line %d: invalid section %q", lineNum, line)
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: invalid line %q", lineNum, line)
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch section {
+		case "interface":
+			err = setWgQuickInterfaceKey(data, key, value)
+		case "peer":
+			err = setWgQuickPeerKey(&peers[len(peers)-1], key, value)
+		default:
+			err = errors.New("key outside of section")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if getSettingWireguardPrivateKey(data) == "" {
+		return nil, errors.New("private key is not found")
+	}
+	peersData, err := unwrapWireguardPeers(peers)
+	if err != nil {
+		return nil, err
+	}
+	setSettingWireguardPeers(data, peersData)
+	return data, nil
+}
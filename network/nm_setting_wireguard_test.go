@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	C "gopkg.in/check.v1"
+	"pkg.deepin.io/dde/daemon/network/nm"
+	"pkg.deepin.io/lib/dbus1"
+)
+
+const (
+	testWgPrivateKey   = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testWgPublicKey    = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	testWgPresharedKey = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+)
+
+const testWgQuickConfig = `# comment
+[Interface]
+PrivateKey = ` + testWgPrivateKey + `
+ListenPort = 51820
+Address = 10.192.122.1/24, fd00::1/64
+DNS = 10.192.122.2, example.com
+Table = off
+PostUp = echo up
+
+[Peer]
+PublicKey = ` + testWgPublicKey + `
+PresharedKey = ` + testWgPresharedKey + `
+Endpoint = 192.95.5.67:1234
+AllowedIPs = 10.192.122.3/32, 10.192.124.1/24
+AllowedIPs = fd00::3/128
+PersistentKeepalive = 25
+`
+
+func writeWgQuickConfig(c *C.C, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	c.Assert(ioutil.WriteFile(file, []byte(content), 0644), C.IsNil)
+	return file
+}
+
+func (*testWrapper) TestNewWireguardConnectionDataFromFile(c *C.C) {
+	dir, err := ioutil.TempDir("", "wireguard")
+	c.Assert(err, C.IsNil)
+	defer os.RemoveAll(dir)
+
+	file := writeWgQuickConfig(c, dir, "wg-office.conf", testWgQuickConfig)
+	data, err := newWireguardConnectionDataFromFile(file, "uuid", nil)
+	c.Assert(err, C.IsNil)
+	c.Check(getSettingConnectionId(data), C.Equals, "wg-office")
+	c.Check(getSettingConnectionUuid(data), C.Equals, "uuid")
+	c.Check(getSettingConnectionInterfaceName(data), C.Equals, "wg-office")
+	c.Check(getSettingWireguardPrivateKey(data), C.Equals, testWgPrivateKey)
+	c.Check(getSettingWireguardListenPort(data), C.Equals, uint32(51820))
+	c.Check(getSettingWireguardPeerRoutes(data), C.Equals, false)
+	c.Check(getSettingIP4ConfigMethod(data), C.Equals, nm.NM_SETTING_IP4_CONFIG_METHOD_MANUAL)
+	c.Check(getSettingIP4ConfigAddresses(data), C.HasLen, 1)
+	c.Check(getSettingIP6ConfigMethod(data), C.Equals, nm.NM_SETTING_IP6_CONFIG_METHOD_MANUAL)
+	c.Check(getSettingIP6ConfigAddresses(data), C.HasLen, 1)
+	c.Check(getSettingIP4ConfigDns(data), C.HasLen, 1)
+	c.Check(getSettingIP4ConfigDnsSearch(data), C.DeepEquals, []string{"example.com"})
+	c.Check(wrapWireguardPeers(getSettingWireguardPeers(data)), C.DeepEquals, []wireguardPeer{{
+		PublicKey:           testWgPublicKey,
+		PresharedKey:        testWgPresharedKey,
+		Endpoint:            "192.95.5.67:1234",
+		AllowedIPs:          []string{"10.192.122.3/32", "10.192.124.1/24", "fd00::3/128"},
+		PersistentKeepalive: 25,
+	}})
+
+	// the interface name is generated if the name of the file is used or
+	// invalid
+	data, err = newWireguardConnectionDataFromFile(file, "uuid", []string{"wg-office", "wg0"})
+	c.Assert(err, C.IsNil)
+	c.Check(getSettingConnectionInterfaceName(data), C.Equals, "wg1")
+	file = writeWgQuickConfig(c, dir, "my office vpn.conf", testWgQuickConfig)
+	data, err = newWireguardConnectionDataFromFile(file, "uuid", nil)
+	c.Assert(err, C.IsNil)
+	c.Check(getSettingConnectionId(data), C.Equals, "my office vpn")
+	c.Check(getSettingConnectionInterfaceName(data), C.Equals, "wg0")
+
+	invalidConfigs := []struct {
+		content string
+		err     string
+	}{
+		{"[Interface]\nListenPort = 51820\n", "private key is not found"},
+		{"PrivateKey = " + testWgPrivateKey + "\n", "line 1: key outside of section"},
+		{"[Interface]\nPrivateKey = " + testWgPrivateKey + "\n[Server]\n",
+			`line 3: invalid section "\[Server\]"`},
+		{"[Interface]\nPrivateKey\n", `line 2: invalid line "PrivateKey"`},
+		{"[Interface]\nPrivateKey = abc\n", `line 2: invalid wireguard key "abc"`},
+		{"[Interface]\nPrivateKey = " + testWgPrivateKey + "\nListenPort = port\n",
+			"line 3: .*invalid syntax"},
+		{"[Interface]\nPrivateKey = " + testWgPrivateKey + "\n[Peer]\nPublicKey = abc\n",
+			`invalid wireguard key "abc"`},
+		{"[Interface]\nPrivateKey = " + testWgPrivateKey + "\n[Peer]\nPublicKey = " +
+			testWgPublicKey + "\nAllowedIPs = 10.0.0.1\n", `invalid allowed ip "10.0.0.1"`},
+	}
+	for _, test := range invalidConfigs {
+		file = writeWgQuickConfig(c, dir, "wg0.conf", test.content)
+		_, err = newWireguardConnectionDataFromFile(file, "uuid", nil)
+		c.Check(err, C.ErrorMatches, test.err)
+	}
+}
+
+func (*testWrapper) TestKeepWireguardPresharedKeys(c *C.C) {
+	oldPeers := []wireguardPeer{
+		{PublicKey: testWgPublicKey, PresharedKey: testWgPresharedKey},
+	}
+	peers := []wireguardPeer{
+		{PublicKey: testWgPublicKey},
+		{PublicKey: testWgPrivateKey},
+	}
+	keepWireguardPresharedKeys(peers, oldPeers)
+	c.Check(peers[0].PresharedKey, C.Equals, testWgPresharedKey)
+	c.Check(peers[1].PresharedKey, C.Equals, "")
+
+	peers = []wireguardPeer{{PublicKey: testWgPublicKey, PresharedKey: testWgPrivateKey}}
+	keepWireguardPresharedKeys(peers, oldPeers)
+	c.Check(peers[0].PresharedKey, C.Equals, testWgPrivateKey)
+
+	peers = []wireguardPeer{{PublicKey: testWgPublicKey, ClearPresharedKey: true}}
+	keepWireguardPresharedKeys(peers, oldPeers)
+	c.Check(peers[0].PresharedKey, C.Equals, "")
+	data, err := unwrapWireguardPeers(peers)
+	c.Assert(err, C.IsNil)
+	c.Check(wrapWireguardPeers(data)[0].PresharedKey, C.Equals, "")
+
+	peers = []wireguardPeer{{PublicKey: testWgPublicKey, PresharedKey: testWgPresharedKey,
+		ClearPresharedKey: true}}
+	_, err = unwrapWireguardPeers(peers)
+	c.Check(err, C.ErrorMatches, "preshared key is set and cleared at the same time")
+}
+
+func (*testWrapper) TestGetWireguardPeerSecrets(c *C.C) {
+	data := newWireguardConnectionData("wg", "uuid", "wg0")
+	setSettingWireguardPeers(data, wireguardPeers{
+		{
+			nm.NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY:          dbus.MakeVariant(testWgPublicKey),
+			nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY:       dbus.MakeVariant(testWgPresharedKey),
+			nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY_FLAGS: dbus.MakeVariant(uint32(secretFlagAgentOwned)),
+		},
+		{
+			nm.NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY: dbus.MakeVariant(testWgPrivateKey),
+		},
+	})
+
+	secrets := getWireguardPeerSecrets(data)
+	c.Assert(secrets, C.HasLen, 2)
+	c.Check(secrets[0], C.Equals, wireguardPeerSecret{
+		key:        "peers." + testWgPublicKey + ".preshared-key",
+		publicKey:  testWgPublicKey,
+		value:      testWgPresharedKey,
+		agentOwned: true,
+	})
+	c.Check(secrets[1].key, C.Equals, "peers."+testWgPrivateKey+".preshared-key")
+	c.Check(secrets[1].agentOwned, C.Equals, false)
+}
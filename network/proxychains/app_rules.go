@@ -0,0 +1,445 @@
+/*
+ * Copyright (C) 2017 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package proxychains
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"pkg.deepin.io/lib/appinfo/desktopappinfo"
+	"pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+	"pkg.deepin.io/lib/strv"
+	dutils "pkg.deepin.io/lib/utils"
+	"pkg.deepin.io/lib/xdg/basedir"
+)
+
+const (
+	ProfileTypeDirect = "direct"
+	ProfileTypeHttp   = "http"
+	ProfileTypeSocks5 = "socks5"
+	ProfileTypePac    = "pac"
+)
+
+// the apps in the list of launcher are wrapped by proxychains with the
+// global proxy when they are launched.
+const (
+	gsSchemaLauncher  = "com.deepin.dde.launcher"
+	gsKeyAppsUseProxy = "apps-use-proxy"
+)
+
+// Profile is a proxy used by the applications matched by rules
+type Profile struct {
+	Type     string
+	IP       string
+	Port     uint32
+	User     string
+	Password string
+	PacUrl   string
+}
+
+// appRules maps applications to profiles, the key of Rules is the desktop id
+// without suffix ".desktop" or the absolute path of the binary.
+type appRules struct {
+	Profiles map[string]*Profile
+	Rules    map[string]string
+}
+
+var profileNameReg = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func getRulesFile() string {
+	return filepath.Join(basedir.GetUserConfigDir(), "deepin", "proxychains-rules.json")
+}
+
+func getProfileConfDir() string {
+	return filepath.Join(basedir.GetUserConfigDir(), "deepin", "proxychains")
+}
+
+func getProfileConfFile(name string) string {
+	return filepath.Join(getProfileConfDir(), name+".conf")
+}
+
+func loadAppRules(file string) (*appRules, error) {
+	rules := &appRules{}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, rules)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rules.Profiles == nil {
+		rules.Profiles = make(map[string]*Profile)
+	}
+	if rules.Rules == nil {
+		rules.Rules = make(map[string]string)
+	}
+	return rules, nil
+}
+
+func (rules *appRules) save(file string) error {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	// contains passwords of profiles
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+func normalizeAppId(app string) string {
+	if filepath.IsAbs(app) {
+		return filepath.Clean(app)
+	}
+	return strings.TrimSuffix(app, ".desktop")
+}
+
+func checkProfile(p *Profile) error {
+	switch p.Type {
+	case ProfileTypeDirect:
+		return nil
+	case ProfileTypeHttp, ProfileTypeSocks5:
+		if !validIPv4(p.IP) {
+			return InvalidParamError{"IP"}
+		}
+		if p.Port == 0 || p.Port > 65535 {
+			return InvalidParamError{"Port"}
+		}
+		if !validUser(p.User) {
+			return InvalidParamError{"User"}
+		}
+		if !validPassword(p.Password) {
+			return InvalidParamError{"Password"}
+		}
+		if (p.User == "") != (p.Password == "") {
+			return errors.New("user and password are not provided at the same time")
+		}
+		return nil
+	case ProfileTypePac:
+		u, err := url.Parse(p.PacUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			return InvalidParamError{"PacUrl"}
+		}
+		return nil
+	default:
+		return InvalidParamError{"Type"}
+	}
+}
+
+// writeProfileConf writes the proxychains config file of http and socks5
+// profiles.
+func writeProfileConf(name string, p *Profile) error {
+	file := getProfileConfFile(name)
+	if p.Type != ProfileTypeHttp && p.Type != ProfileTypeSocks5 {
+		err := os.Remove(file)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	err := os.MkdirAll(getProfileConfDir(), 0700)
+	if err != nil {
+		return err
+	}
+	proxy := fmt.Sprintf("%s\t%s\t%v", p.Type, p.IP, p.Port)
+	if p.User != "" && p.Password != "" {
+		proxy += fmt.Sprintf("\t%s\t%s", p.User, p.Password)
+	}
+	return ioutil.WriteFile(file, []byte(confHead+proxy+"\n"), 0600)
+}
+
+// cmdPrefixes returns the command prepended to the command line of
+// applications to use the profile. The pac file of pac profile is resolved
+// to a proxy and the config file of the profile is written for it.
+func (p *Profile) cmdPrefixes(name string) ([]string, error) {
+	switch p.Type {
+	case ProfileTypeDirect:
+		return []string{"env", "-u", "http_proxy", "-u", "https_proxy", "-u", "ftp_proxy",
+			"-u", "all_proxy", "-u", "HTTP_PROXY", "-u", "HTTPS_PROXY", "-u", "ALL_PROXY",
+			"no_proxy=*"}, nil
+	case ProfileTypeHttp, ProfileTypeSocks5:
+		return []string{"proxychains4", "-q", "-f", getProfileConfFile(name)}, nil
+	case ProfileTypePac:
+		resolved, err := resolvePacProfile(p.PacUrl)
+		if err != nil {
+			return nil, err
+		}
+		err = writeProfileConf(name, resolved)
+		if err != nil {
+			return nil, err
+		}
+		return resolved.cmdPrefixes(name)
+	}
+	return nil, nil
+}
+
+// removeAppUseProxy removes the app from the list of launcher to use the
+// global proxy, otherwise the app is wrapped by proxychains twice.
+func removeAppUseProxy(app string) {
+	settings, err := dutils.CheckAndNewGSettings(gsSchemaLauncher)
+	if err != nil {
+		logger.Warning(err)
+		return
+	}
+	defer settings.Unref()
+
+	apps, changed := strv.Strv(settings.GetStrv(gsKeyAppsUseProxy)).Delete(app)
+	if changed && !settings.SetStrv(gsKeyAppsUseProxy, apps) {
+		logger.Warningf("gsettings set %s failed", gsKeyAppsUseProxy)
+	}
+}
+
+func (rules *appRules) getAppProfile(appId, execPath string) (string, *Profile) {
+	name, ok := rules.Rules[normalizeAppId(appId)]
+	if !ok && execPath != "" {
+		name, ok = rules.Rules[filepath.Clean(execPath)]
+	}
+	if !ok {
+		return "", nil
+	}
+	return name, rules.Profiles[name]
+}
+
+// GetAppProfile returns the name of the profile used by the application,
+// it is empty if no rule matches. It can be used by other modules.
+func GetAppProfile(appId, execPath string) (string, error) {
+	rules, err := loadAppRules(getRulesFile())
+	if err != nil {
+		return "", err
+	}
+	name, _ := rules.getAppProfile(appId, execPath)
+	return name, nil
+}
+
+// GetAppCmdPrefixes returns the command to launch the application through
+// the proxy of its rule, appId is the desktop id and execPath is the
+// absolute path of the binary, either can be empty. It can be used by other
+// modules.
+func GetAppCmdPrefixes(appId, execPath string) ([]string, error) {
+	rules, err := loadAppRules(getRulesFile())
+	if err != nil {
+		return nil, err
+	}
+	name, profile := rules.getAppProfile(appId, execPath)
+	if profile == nil {
+		return nil, nil
+	}
+	return profile.cmdPrefixes(name)
+}
+
+// GetDesktopAppCmdPrefixes is same as GetAppCmdPrefixes, the desktop id and
+// the binary are got from the desktop file. It can be used by the modules
+// which launch applications, e.g. dock and launcher.
+func GetDesktopAppCmdPrefixes(desktopFile string) ([]string, error) {
+	appId := strings.TrimSuffix(filepath.Base(desktopFile), ".desktop")
+	var execPath string
+	ai, err := desktopappinfo.NewDesktopAppInfoFromFile(desktopFile)
+	if err == nil {
+		execPath, _ = exec.LookPath(ai.GetExecutable())
+	}
+	return GetAppCmdPrefixes(appId, execPath)
+}
+
+// GetProfiles returns the profiles in JSON, passwords are not included.
+func (m *Manager) GetProfiles() (string, *dbus.Error) {
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+
+	rules, err := loadAppRules(getRulesFile())
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	profiles := make(map[string]*Profile, len(rules.Profiles))
+	for name, profile := range rules.Profiles {
+		p := *profile
+		p.Password = ""
+		profiles[name] = &p
+	}
+	data, err := json.Marshal(profiles)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return string(data), nil
+}
+
+// SetProfile adds or changes the profile, profileJSON is the JSON of Profile.
+// The password of the profile is kept if it is empty and the user is not
+// changed, since GetProfiles does not return passwords.
+func (m *Manager) SetProfile(name, profileJSON string) *dbus.Error {
+	err := m.setProfile(name, profileJSON)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) setProfile(name, profileJSON string) error {
+	if !profileNameReg.MatchString(name) {
+		return InvalidParamError{"Name"}
+	}
+	var profile Profile
+	err := json.Unmarshal([]byte(profileJSON), &profile)
+	if err != nil {
+		return err
+	}
+
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+
+	file := getRulesFile()
+	rules, err := loadAppRules(file)
+	if err != nil {
+		return err
+	}
+	if old, ok := rules.Profiles[name]; ok && profile.Password == "" &&
+		profile.User != "" && profile.User == old.User {
+		profile.Password = old.Password
+	}
+	err = checkProfile(&profile)
+	if err != nil {
+		return err
+	}
+	rules.Profiles[name] = &profile
+	err = writeProfileConf(name, &profile)
+	if err != nil {
+		return err
+	}
+	return rules.save(file)
+}
+
+// DeleteProfile deletes the profile and the rules using it.
+func (m *Manager) DeleteProfile(name string) *dbus.Error {
+	err := m.deleteProfile(name)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) deleteProfile(name string) error {
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+
+	file := getRulesFile()
+	rules, err := loadAppRules(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := rules.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+	delete(rules.Profiles, name)
+	for app, profile := range rules.Rules {
+		if profile == name {
+			delete(rules.Rules, app)
+		}
+	}
+	err = writeProfileConf(name, &Profile{Type: ProfileTypeDirect})
+	if err != nil {
+		logger.Warning(err)
+	}
+	return rules.save(file)
+}
+
+func (m *Manager) GetAppRules() (string, *dbus.Error) {
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+
+	rules, err := loadAppRules(getRulesFile())
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	data, err := json.Marshal(rules.Rules)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return string(data), nil
+}
+
+// SetAppRule sets the profile used by the application, app is the desktop id
+// or the absolute path of the binary, the rule is removed if profile is
+// empty.
+func (m *Manager) SetAppRule(app, profile string) *dbus.Error {
+	err := m.setAppRule(app, profile)
+	return dbusutil.ToError(err)
+}
+
+func (m *Manager) setAppRule(app, profile string) error {
+	app = normalizeAppId(app)
+	if app == "" {
+		return InvalidParamError{"App"}
+	}
+
+	m.rulesMu.Lock()
+	defer m.rulesMu.Unlock()
+
+	file := getRulesFile()
+	rules, err := loadAppRules(file)
+	if err != nil {
+		return err
+	}
+	if profile == "" {
+		delete(rules.Rules, app)
+		return rules.save(file)
+	}
+
+	if _, ok := rules.Profiles[profile]; !ok {
+		return fmt.Errorf("profile %q not found", profile)
+	}
+	rules.Rules[app] = profile
+	err = rules.save(file)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(app) {
+		removeAppUseProxy(app)
+	}
+	return nil
+}
+
+// ValidateProfile tests the profile by connecting to the endpoint
+// "host:port" through it, e.g. a local server. It is a blocked operation.
+func (m *Manager) ValidateProfile(name, endpoint string) *dbus.Error {
+	m.rulesMu.Lock()
+	rules, err := loadAppRules(getRulesFile())
+	m.rulesMu.Unlock()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+
+	profile, ok := rules.Profiles[name]
+	if !ok {
+		return dbusutil.ToError(fmt.Errorf("profile %q not found", name))
+	}
+	err = testProfile(profile, endpoint)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2017 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package proxychains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Applications are not aware of pac files, so the pac file is evaluated by
+// pactester of pacparser when the application is launched, and the
+// application uses the proxy returned for pacResolveUrl.
+const (
+	pacTesterBin  = "pactester"
+	pacResolveUrl = "http://www.deepin.org/"
+)
+
+func getPacContent(pacUrl string) ([]byte, error) {
+	if strings.HasPrefix(pacUrl, "file://") {
+		return ioutil.ReadFile(strings.TrimPrefix(pacUrl, "file://"))
+	}
+
+	client := &http.Client{Timeout: validateTimeout}
+	resp, err := client.Get(pacUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get pac file: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func checkPacUrl(pacUrl string) error {
+	content, err := getPacContent(pacUrl)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(content), "FindProxyForURL") {
+		return errors.New("FindProxyForURL is not defined in pac file")
+	}
+	return nil
+}
+
+// resolvePacProfile returns the profile of the proxy which the pac file
+// returns for pacResolveUrl.
+func resolvePacProfile(pacUrl string) (*Profile, error) {
+	content, err := getPacContent(pacUrl)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile("", "proxychains-pac-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, pacTesterBin, "-p", file.Name(),
+		"-u", pacResolveUrl).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate pac file: %v", err)
+	}
+	return parsePacResult(string(out))
+}
+
+// parsePacResult returns the profile of the first usable proxy in the result
+// of FindProxyForURL, e.g. "PROXY 10.0.0.1:3128; DIRECT". Proxies of other
+// types than http and socks5 are skipped.
+func parsePacResult(result string) (*Profile, error) {
+	for _, item := range strings.Split(result, ";") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var typ string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return &Profile{Type: ProfileTypeDirect}, nil
+		case "PROXY", "HTTP":
+			typ = ProfileTypeHttp
+		case "SOCKS5":
+			typ = ProfileTypeSocks5
+		default:
+			continue
+		}
+		if len(fields) != 2 {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(fields[1])
+		if err != nil {
+			continue
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || port == 0 {
+			continue
+		}
+		ip, err := lookupIPv4(host)
+		if err != nil {
+			logger.Warning(err)
+			continue
+		}
+		return &Profile{Type: typ, IP: ip, Port: uint32(port)}, nil
+	}
+	return nil, fmt.Errorf("no usable proxy in pac result %q", strings.TrimSpace(result))
+}
+
+// lookupIPv4 returns the IPv4 address of host, proxychains only accepts
+// numeric addresses of proxies.
+func lookupIPv4(host string) (string, error) {
+	if validIPv4(host) {
+		return host, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address of %q", host)
+}
@@ -50,8 +50,17 @@ type Manager struct {
 	jsonFile string
 	confFile string
 
+	// rules of applications to use proxy profiles
+	rulesMu sync.Mutex
+
 	methods *struct {
-		Set func() `in:"type0,ip,port,user,password"`
+		Set             func() `in:"type0,ip,port,user,password"`
+		GetProfiles     func() `out:"profilesJSON"`
+		SetProfile      func() `in:"name,profileJSON"`
+		DeleteProfile   func() `in:"name"`
+		GetAppRules     func() `out:"rulesJSON"`
+		SetAppRule      func() `in:"app,profile"`
+		ValidateProfile func() `in:"name,endpoint"`
 	}
 }
 
@@ -254,8 +263,7 @@ func (m *Manager) set(type0, ip string, port uint32, user, password string) erro
 	return m.writeConf()
 }
 
-func (m *Manager) writeConf() error {
-	const head = `# Written by ` + dbusInterface + `
+const confHead = `# Written by ` + dbusInterface + `
 strict_chain
 quiet_mode
 proxy_dns
@@ -266,11 +274,13 @@ localnet 127.0.0.0/255.0.0.0
 
 [ProxyList]
 `
+
+func (m *Manager) writeConf() error {
 	fh, err := os.Create(m.confFile)
 	if err != nil {
 		return err
 	}
-	_, err = fh.WriteString(head)
+	_, err = fh.WriteString(confHead)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (C) 2017 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package proxychains
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const validateTimeout = 5 * time.Second
+
+// testProfile connects to the endpoint "host:port" through the proxy of the
+// profile, the pac file is downloaded and checked for pac profile.
+func testProfile(p *Profile, endpoint string) error {
+	switch p.Type {
+	case ProfileTypeDirect:
+		conn, err := net.DialTimeout("tcp", endpoint, validateTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProfileTypeHttp, ProfileTypeSocks5:
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(p.IP,
+			strconv.Itoa(int(p.Port))), validateTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(validateTimeout))
+		if p.Type == ProfileTypeHttp {
+			return httpConnect(conn, p, endpoint)
+		}
+		return socks5Connect(conn, p, endpoint)
+	case ProfileTypePac:
+		return checkPacUrl(p.PacUrl)
+	}
+	return InvalidParamError{"Type"}
+}
+
+func httpConnect(conn net.Conn, p *Profile, endpoint string) error {
+	req, err := http.NewRequest(http.MethodConnect, "http://"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = endpoint
+	if p.User != "" {
+		req.SetBasicAuth(p.User, p.Password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	err = req.Write(conn)
+	if err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy responded %s", resp.Status)
+	}
+	return nil
+}
+
+// socks5Connect does the handshake of RFC 1928, the username/password
+// authentication is defined in RFC 1929.
+func socks5Connect(conn net.Conn, p *Profile, endpoint string) error {
+	const (
+		version       = 5
+		methodNone    = 0
+		methodUserPwd = 2
+		cmdConnect    = 1
+		atypIPv4      = 1
+		atypDomain    = 3
+		atypIPv6      = 4
+	)
+
+	method := byte(methodNone)
+	if p.User != "" {
+		method = methodUserPwd
+	}
+	_, err := conn.Write([]byte{version, 1, method})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return err
+	}
+	if buf[0] != version || buf[1] != method {
+		return errors.New("socks5 proxy rejected the authentication method")
+	}
+
+	if method == methodUserPwd {
+		if len(p.User) > 255 || len(p.Password) > 255 {
+			return InvalidParamError{"User"}
+		}
+		req := []byte{1, byte(len(p.User))}
+		req = append(req, p.User...)
+		req = append(req, byte(len(p.Password)))
+		req = append(req, p.Password...)
+		_, err = conn.Write(req)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return errors.New("socks5 proxy authentication failed")
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+	req := []byte{version, cmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long", host)
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, atypIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, atypIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP, the bound address is ignored
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("socks5 proxy failed to connect, reply code %d", reply[1])
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright (C) 2017 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package proxychains
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	C "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { C.TestingT(t) }
+
+type testWrapper struct{}
+
+var _ = C.Suite(&testWrapper{})
+
+// serveOnce accepts one connection and handles it in background, the error
+// of handle is sent to the returned channel.
+func serveOnce(c *C.C, handle func(conn net.Conn) error) (net.Conn, <-chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, C.IsNil)
+	errCh := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		errCh <- handle(conn)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, C.IsNil)
+	return conn, errCh
+}
+
+// socks5Server handles the handshake of socks5Connect, the connect request
+// is replied with code.
+func socks5Server(user, password string, code byte) func(conn net.Conn) error {
+	return func(conn net.Conn) error {
+		buf := make([]byte, 3)
+		_, err := io.ReadFull(conn, buf)
+		if err != nil {
+			return err
+		}
+		if user == "" {
+			_, err = conn.Write([]byte{5, 0})
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err = conn.Write([]byte{5, 2})
+			if err != nil {
+				return err
+			}
+			head := make([]byte, 2)
+			_, err = io.ReadFull(conn, head)
+			if err != nil {
+				return err
+			}
+			u := make([]byte, head[1])
+			_, err = io.ReadFull(conn, u)
+			if err != nil {
+				return err
+			}
+			_, err = io.ReadFull(conn, head[:1])
+			if err != nil {
+				return err
+			}
+			p := make([]byte, head[0])
+			_, err = io.ReadFull(conn, p)
+			if err != nil {
+				return err
+			}
+			status := byte(0)
+			if string(u) != user || string(p) != password {
+				status = 1
+			}
+			_, err = conn.Write([]byte{1, status})
+			if err != nil || status != 0 {
+				return err
+			}
+		}
+
+		// VER CMD RSV ATYP, then ipv4 address and port
+		req := make([]byte, 10)
+		_, err = io.ReadFull(conn, req)
+		if err != nil {
+			return err
+		}
+		if req[3] != 1 || binary.BigEndian.Uint16(req[8:]) != 8080 {
+			return fmt.Errorf("unexpected request %v", req)
+		}
+		_, err = conn.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	}
+}
+
+func (*testWrapper) TestSocks5Connect(c *C.C) {
+	conn, errCh := serveOnce(c, socks5Server("", "", 0))
+	c.Check(socks5Connect(conn, &Profile{}, "127.0.0.1:8080"), C.IsNil)
+	conn.Close()
+	c.Check(<-errCh, C.IsNil)
+
+	conn, errCh = serveOnce(c, socks5Server("user", "secret", 0))
+	c.Check(socks5Connect(conn, &Profile{User: "user", Password: "secret"}, "127.0.0.1:8080"),
+		C.IsNil)
+	conn.Close()
+	c.Check(<-errCh, C.IsNil)
+
+	conn, errCh = serveOnce(c, socks5Server("user", "secret", 0))
+	c.Check(socks5Connect(conn, &Profile{User: "user", Password: "wrong"}, "127.0.0.1:8080"),
+		C.ErrorMatches, ".*authentication failed")
+	conn.Close()
+	<-errCh
+
+	// connection refused
+	conn, errCh = serveOnce(c, socks5Server("", "", 5))
+	c.Check(socks5Connect(conn, &Profile{}, "127.0.0.1:8080"), C.ErrorMatches, ".*reply code 5")
+	conn.Close()
+	<-errCh
+}
+
+func httpProxyServer(auth string, status int) func(conn net.Conn) error {
+	return func(conn net.Conn) error {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return err
+		}
+		if req.Method != http.MethodConnect || req.Host != "127.0.0.1:8080" {
+			return fmt.Errorf("unexpected request %s %s", req.Method, req.Host)
+		}
+		if req.Header.Get("Proxy-Authorization") != auth {
+			status = http.StatusProxyAuthRequired
+		}
+		_, err = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+		return err
+	}
+}
+
+func (*testWrapper) TestHttpConnect(c *C.C) {
+	conn, errCh := serveOnce(c, httpProxyServer("", http.StatusOK))
+	c.Check(httpConnect(conn, &Profile{}, "127.0.0.1:8080"), C.IsNil)
+	conn.Close()
+	c.Check(<-errCh, C.IsNil)
+
+	// "user:secret" in base64
+	conn, errCh = serveOnce(c, httpProxyServer("Basic dXNlcjpzZWNyZXQ=", http.StatusOK))
+	c.Check(httpConnect(conn, &Profile{User: "user", Password: "secret"}, "127.0.0.1:8080"),
+		C.IsNil)
+	conn.Close()
+	c.Check(<-errCh, C.IsNil)
+
+	conn, errCh = serveOnce(c, httpProxyServer("Basic dXNlcjpzZWNyZXQ=", http.StatusOK))
+	c.Check(httpConnect(conn, &Profile{}, "127.0.0.1:8080"), C.ErrorMatches,
+		"proxy responded 407.*")
+	conn.Close()
+	c.Check(<-errCh, C.IsNil)
+}
+
+func (*testWrapper) TestCheckPacUrl(c *C.C) {
+	const pac = "function FindProxyForURL(url, host) { return \"DIRECT\"; }"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/proxy.pac":
+			fmt.Fprint(w, pac)
+		case "/index.html":
+			fmt.Fprint(w, "<html></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c.Check(checkPacUrl(server.URL+"/proxy.pac"), C.IsNil)
+	c.Check(checkPacUrl(server.URL+"/index.html"), C.NotNil)
+	c.Check(checkPacUrl(server.URL+"/missing.pac"), C.ErrorMatches, ".*404.*")
+
+	dir, err := ioutil.TempDir("", "proxychains")
+	c.Assert(err, C.IsNil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "proxy.pac")
+	c.Assert(ioutil.WriteFile(file, []byte(pac), 0644), C.IsNil)
+	c.Check(checkPacUrl("file://"+file), C.IsNil)
+	c.Check(checkPacUrl("file://"+filepath.Join(dir, "missing.pac")), C.NotNil)
+}
+
+func (*testWrapper) TestParsePacResult(c *C.C) {
+	p, err := parsePacResult("PROXY 10.0.0.1:3128; DIRECT\n")
+	c.Assert(err, C.IsNil)
+	c.Check(*p, C.Equals, Profile{Type: ProfileTypeHttp, IP: "10.0.0.1", Port: 3128})
+
+	p, err = parsePacResult("HTTPS 10.0.0.1:443; socks5 10.0.0.2:1080")
+	c.Assert(err, C.IsNil)
+	c.Check(*p, C.Equals, Profile{Type: ProfileTypeSocks5, IP: "10.0.0.2", Port: 1080})
+
+	p, err = parsePacResult("SOCKS 10.0.0.1:1080; PROXY 10.0.0.1:0; DIRECT")
+	c.Assert(err, C.IsNil)
+	c.Check(*p, C.Equals, Profile{Type: ProfileTypeDirect})
+
+	_, err = parsePacResult("SOCKS4 10.0.0.1:1080")
+	c.Check(err, C.NotNil)
+	_, err = parsePacResult("")
+	c.Check(err, C.NotNil)
+}
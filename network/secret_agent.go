@@ -419,6 +419,11 @@ func isMustAsk(data connectionData, settingName, secretKey string) bool {
 			}
 		}
 
+	case nm.NM_SETTING_WIREGUARD_SETTING_NAME:
+		if secretKey == "private-key" {
+			return true
+		}
+
 	}
 
 	return false
@@ -561,6 +566,23 @@ func (sa *SecretAgent) getSecrets(connectionData map[string]map[string]dbus.Vari
 				setting[key] = dbus.MakeVariant(value)
 			}
 		}
+
+		if settingName == nm.NM_SETTING_WIREGUARD_SETTING_NAME {
+			var peers []map[string]dbus.Variant
+			for _, secret := range getWireguardPeerSecrets(connectionData) {
+				value, ok := resultSaved[secret.key]
+				if !secret.agentOwned || !ok {
+					continue
+				}
+				peers = append(peers, map[string]dbus.Variant{
+					nm.NM_SETTING_WIREGUARD_PEER_PUBLIC_KEY:    dbus.MakeVariant(secret.publicKey),
+					nm.NM_SETTING_WIREGUARD_PEER_PRESHARED_KEY: dbus.MakeVariant(value),
+				})
+			}
+			if len(peers) > 0 {
+				setting[nm.NM_SETTING_WIREGUARD_PEERS] = dbus.MakeVariant(peers)
+			}
+		}
 	}
 	return
 }
@@ -664,9 +686,10 @@ var secretSettingKeys = map[string][]string{
 		"client-cert-password", "phase2-ca-cert-password", "phase2-client-cert-password",
 		"private-key-password", "phase2-private-key-password", "pin"},
 	// temporarily not supported password-raw
-	"pppoe": {"password"},
-	"gsm":   {"password", "pin"},
-	"cdma":  {"password"},
+	"pppoe":     {"password"},
+	"gsm":       {"password", "pin"},
+	"cdma":      {"password"},
+	"wireguard": {"private-key"}, // preshared keys of peers: getWireguardPeerSecrets
 }
 
 var vpnSecretKeys = []string{
@@ -765,6 +788,18 @@ func (sa *SecretAgent) saveSecrets(connectionData map[string]map[string]dbus.Var
 				}
 			}
 		}
+
+		if settingName == nm.NM_SETTING_WIREGUARD_SETTING_NAME {
+			for _, secret := range getWireguardPeerSecrets(connectionData) {
+				if secret.agentOwned && secret.value != "" {
+					arr = append(arr, settingItem{
+						settingName: settingName,
+						settingKey:  secret.key,
+						value:       secret.value,
+					})
+				}
+			}
+		}
 	}
 
 	for _, item := range arr {
@@ -787,6 +822,11 @@ func (sa *SecretAgent) saveSecrets(connectionData map[string]map[string]dbus.Var
 			}
 		}
 	}
+	for _, secret := range getWireguardPeerSecrets(connectionData) {
+		if !secret.agentOwned {
+			sa.delete(connUUID, nm.NM_SETTING_WIREGUARD_SETTING_NAME, secret.key)
+		}
+	}
 
 	vpnData, ok := getConnectionData(connectionData, "vpn", "data")
 	if ok {
@@ -940,3 +940,29 @@ func (m *Manager) nmRunOnceUntilDeviceAvailable(devPath dbus.ObjectPath, cb func
 		})
 	}
 }
+
+func nmGetConnectionSecrets(cpath dbus.ObjectPath, settingName string) (secrets connectionData, err error) {
+	nmConn, err := nmNewSettingsConnection(cpath)
+	if err != nil {
+		return
+	}
+
+	secrets, err = nmConn.GetSecrets(0, settingName)
+	if err != nil {
+		logger.Error(err)
+	}
+	return
+}
+
+func nmUpdateConnection(cpath dbus.ObjectPath, data connectionData) (err error) {
+	nmConn, err := nmNewSettingsConnection(cpath)
+	if err != nil {
+		return
+	}
+
+	err = nmConn.Update(0, data)
+	if err != nil {
+		logger.Error(err)
+	}
+	return
+}
@@ -21,6 +21,7 @@ package network
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -169,6 +170,36 @@ func convertIpv4NetMaskToPrefixCheck(maskAddress string) (prefix uint32, err err
 	return
 }
 
+// "10.0.0.1/24" -> 10.0.0.1, 24, the prefix is the full length of the address
+// if omitted, e.g. "10.0.0.1" -> 10.0.0.1, 32
+func parseIpPrefix(value string) (ip net.IP, prefix uint32, err error) {
+	addr := value
+	idx := strings.Index(value, "/")
+	if idx != -1 {
+		addr = value[:idx]
+	}
+	ip = net.ParseIP(addr)
+	if ip == nil {
+		err = fmt.Errorf("ip address is invalid %s", value)
+		return
+	}
+	bits := uint64(net.IPv6len * 8)
+	if ip.To4() != nil {
+		bits = net.IPv4len * 8
+	}
+	prefix = uint32(bits)
+	if idx != -1 {
+		var v uint64
+		v, err = strconv.ParseUint(value[idx+1:], 10, 8)
+		if err != nil || v > bits {
+			err = fmt.Errorf("ip prefix is invalid %s", value)
+			return
+		}
+		prefix = uint32(v)
+	}
+	return
+}
+
 // []byte{0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0} -> "0000:0000:0000:0000:0000:0000:0000:0000"
 func convertIpv6AddressToString(v []byte) (ipv6Addr string) {
 	ipv6Addr, err := convertIpv6AddressToStringCheck(v)
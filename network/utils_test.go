@@ -20,10 +20,16 @@
 package network
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	C "gopkg.in/check.v1"
 	"pkg.deepin.io/dde/daemon/network/nm"
+	dbus "pkg.deepin.io/lib/dbus1"
 )
 
 func Test(t *testing.T) { C.TestingT(t) }
@@ -427,3 +433,357 @@ func (*testWrapper) TestFixupDeviceDesc(c *C.C) {
 		c.Check(fixupDeviceDesc(d.desc), C.Equals, d.fixedDesc)
 	}
 }
+
+func (*testWrapper) TestWireguardKey(c *C.C) {
+	// test vector of RFC 7748
+	publicKey, err := getWireguardPublicKey("dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=")
+	c.Check(err, C.IsNil)
+	c.Check(publicKey, C.Equals, "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=")
+
+	_, err = getWireguardPublicKey("invalid")
+	c.Check(err, C.NotNil)
+
+	privateKey, publicKey, err := genWireguardKeyPair()
+	c.Check(err, C.IsNil)
+	publicKey1, err := getWireguardPublicKey(privateKey)
+	c.Check(err, C.IsNil)
+	c.Check(publicKey1, C.Equals, publicKey)
+}
+
+func (*testWrapper) TestWireguardPeers(c *C.C) {
+	peers := []wireguardPeer{
+		{
+			PublicKey:           "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=",
+			Endpoint:            "vpn.example.com:51820",
+			AllowedIPs:          []string{"10.0.0.0/24", "fd00::/64"},
+			PersistentKeepalive: 25,
+		},
+	}
+	data, err := unwrapWireguardPeers(peers)
+	c.Check(err, C.IsNil)
+	c.Check(wrapWireguardPeers(data), C.DeepEquals, peers)
+
+	peers[0].AllowedIPs = []string{"10.0.0.1"}
+	_, err = unwrapWireguardPeers(peers)
+	c.Check(err, C.NotNil)
+}
+
+func (*testWrapper) TestWgQuickInterfaceKey(c *C.C) {
+	data := newWireguardConnectionData("wg0", "8e2f9aa2-42b8-47d5-b040-ae82c53fa1f2", "wg0")
+	c.Check(setWgQuickInterfaceKey(data, "address", "10.0.0.2/24, fd00::2/64"), C.IsNil)
+	c.Check(setWgQuickInterfaceKey(data, "dns", "10.0.0.1, example.com"), C.IsNil)
+	c.Check(setWgQuickInterfaceKey(data, "listenport", "51820"), C.IsNil)
+	c.Check(setWgQuickInterfaceKey(data, "fwmark", "off"), C.IsNil)
+	c.Check(setWgQuickInterfaceKey(data, "privatekey", "invalid"), C.NotNil)
+
+	c.Check(getSettingIP4ConfigMethod(data), C.Equals, nm.NM_SETTING_IP4_CONFIG_METHOD_MANUAL)
+	c.Check(getSettingIP4ConfigAddresses(data), C.DeepEquals,
+		[][]uint32{{convertIpv4AddressToUint32("10.0.0.2"), 24, 0}})
+	c.Check(getSettingIP6ConfigMethod(data), C.Equals, nm.NM_SETTING_IP6_CONFIG_METHOD_MANUAL)
+	c.Check(getSettingIP4ConfigDns(data), C.DeepEquals, []uint32{convertIpv4AddressToUint32("10.0.0.1")})
+	c.Check(getSettingIP4ConfigDnsSearch(data), C.DeepEquals, []string{"example.com"})
+	c.Check(getSettingWireguardListenPort(data), C.Equals, uint32(51820))
+	c.Check(getSettingWireguardFwmark(data), C.Equals, uint32(0))
+}
+
+func (*testWrapper) TestKeyfileList(c *C.C) {
+	c.Check(splitKeyfileList(`8.8.8.8;1.1.1.1;`), C.DeepEquals, []string{"8.8.8.8", "1.1.1.1"})
+	c.Check(splitKeyfileList(`a\;b;c\sd;`), C.DeepEquals, []string{"a;b", "c d"})
+	c.Check(joinKeyfileList([]string{"a;b", "c"}), C.Equals, `a\;b;c;`)
+	c.Check(unescapeKeyfileValue(escapeKeyfileValue(" a\\b\n")), C.Equals, " a\\b\n")
+}
+
+func (*testWrapper) TestKeyfileValue(c *C.C) {
+	v, err := parseKeyfileValue("connection", "autoconnect", "false")
+	c.Check(err, C.IsNil)
+	c.Check(v, C.Equals, false)
+	v, err = parseKeyfileValue("802-11-wireless", "ssid", "deepin")
+	c.Check(err, C.IsNil)
+	c.Check(v, C.DeepEquals, []byte("deepin"))
+	v, err = parseKeyfileValue("802-11-wireless", "ssid", "100;101;")
+	c.Check(err, C.IsNil)
+	c.Check(v, C.DeepEquals, []byte("de"))
+	data := make(connectionData)
+	initSettingSectionIpv4(data)
+	initSettingSectionIpv6(data)
+	c.Check(setKeyfileIpKey(data, "ipv4", "dns", "8.8.8.8;"), C.IsNil)
+	c.Check(getSettingIP4ConfigDns(data), C.DeepEquals, []uint32{convertIpv4AddressToUint32("8.8.8.8")})
+	c.Check(setKeyfileIpKey(data, "ipv6", "dns", "fd00::1;"), C.IsNil)
+	c.Check(getSettingIP6ConfigDns(data), C.DeepEquals, [][]byte{[]byte(net.ParseIP("fd00::1"))})
+	c.Check(setKeyfileIpKey(data, "ipv4", "dns", "fd00::1;"), C.NotNil)
+	c.Check(setKeyfileIpKey(data, "ipv4", "address1", "10.0.0.2/24,10.0.0.1"), C.IsNil)
+	c.Check(getSettingIP4ConfigAddresses(data), C.DeepEquals, [][]uint32{{
+		convertIpv4AddressToUint32("10.0.0.2"), 24, convertIpv4AddressToUint32("10.0.0.1")}})
+	c.Check(setKeyfileIpKey(data, "ipv6", "route1", "fd01::/64,,100"), C.IsNil)
+	c.Check(getSettingIP6ConfigRoutes(data), C.DeepEquals, ipv6Routes{{
+		Address: []byte(net.ParseIP("fd01::")),
+		Prefix:  64,
+		NextHop: make([]byte, net.IPv6len),
+		Metric:  100,
+	}})
+	c.Check(setKeyfileIpKey(data, "ipv6", "address1", "10.0.0.2/24"), C.NotNil)
+
+	s, ok := formatKeyfileValue("ipv4", "dns", []uint32{convertIpv4AddressToUint32("8.8.8.8")})
+	c.Check(ok, C.Equals, true)
+	c.Check(s, C.Equals, "8.8.8.8;")
+	s, ok = formatKeyfileValue("802-3-ethernet", "mac-address", []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55})
+	c.Check(ok, C.Equals, true)
+	c.Check(s, C.Equals, "00:11:22:33:44:55")
+}
+
+func (*testWrapper) TestParseIpPrefix(c *C.C) {
+	ip, prefix, err := parseIpPrefix("10.0.0.2/24")
+	c.Check(err, C.IsNil)
+	c.Check(ip.String(), C.Equals, "10.0.0.2")
+	c.Check(prefix, C.Equals, uint32(24))
+	_, prefix, err = parseIpPrefix("fd00::2")
+	c.Check(err, C.IsNil)
+	c.Check(prefix, C.Equals, uint32(128))
+	_, _, err = parseIpPrefix("10.0.0.2/33")
+	c.Check(err, C.NotNil)
+	_, _, err = parseIpPrefix("10.0.0/24")
+	c.Check(err, C.NotNil)
+}
+
+func (*testWrapper) TestOpenvpnConfig(c *C.C) {
+	c.Check(splitOpenvpnArgs(`ca "my ca.crt"`), C.DeepEquals, []string{"ca", "my ca.crt"})
+
+	cfg := &openvpnConfig{
+		files:   make(map[string]string),
+		options: make(map[string]string),
+	}
+	for _, line := range []string{"remote vpn.example.com 1194", "proto tcp", "dev tun",
+		"ca ca.crt", "cert client.crt", "key client.key", "auth-user-pass",
+		"remote-cert-tls server", "cipher AES-256-CBC"} {
+		c.Check(cfg.setOption(1, splitOpenvpnArgs(line), "/etc/openvpn"), C.IsNil)
+	}
+	vpnData, err := cfg.toVpnData()
+	c.Check(err, C.IsNil)
+	c.Check(vpnData, C.DeepEquals, map[string]string{
+		nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE:          "vpn.example.com:1194:tcp",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_PROTO_TCP:       "yes",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_CERT_TLS: "server",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_CIPHER:          "AES-256-CBC",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_CONNECTION_TYPE: nm.NM_OPENVPN_CONTYPE_PASSWORD_TLS,
+		nm.NM_SETTING_VPN_OPENVPN_KEY_PASSWORD_FLAGS:  "1",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_CA:              "/etc/openvpn/ca.crt",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_CERT:            "/etc/openvpn/client.crt",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_KEY:             "/etc/openvpn/client.key",
+	})
+}
+
+func (*testWrapper) TestOpenvpnNsCertType(c *C.C) {
+	cfg := &openvpnConfig{
+		files:   make(map[string]string),
+		options: make(map[string]string),
+	}
+	for _, line := range []string{"remote vpn.example.com 1194", "ns-cert-type server"} {
+		c.Check(cfg.setOption(1, splitOpenvpnArgs(line), "/etc/openvpn"), C.IsNil)
+	}
+	vpnData, err := cfg.toVpnData()
+	c.Check(err, C.IsNil)
+	c.Check(vpnData[nmOpenvpnKeyNsCertType], C.Equals, "server")
+	c.Check(vpnData[nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE_CERT_TLS], C.Equals, "")
+
+	data := newOpenvpnConnectionData("vpn", "8e2f9aa2-42b8-47d5-b040-ae82c53fa1f2")
+	setSettingVpnData(data, vpnData)
+	content, err := genOpenvpnConfig(data)
+	c.Check(err, C.IsNil)
+	c.Check(strings.Contains(string(content), "ns-cert-type server\n"), C.Equals, true)
+	c.Check(strings.Contains(string(content), "remote-cert-tls"), C.Equals, false)
+}
+
+func (*testWrapper) TestOpenvpnStaticKeyExport(c *C.C) {
+	data := newOpenvpnConnectionData("vpn", "8e2f9aa2-42b8-47d5-b040-ae82c53fa1f2")
+	setSettingVpnData(data, map[string]string{
+		nm.NM_SETTING_VPN_OPENVPN_KEY_REMOTE:          "vpn.example.com:1194",
+		nm.NM_SETTING_VPN_OPENVPN_KEY_CONNECTION_TYPE: nm.NM_OPENVPN_CONTYPE_STATIC_KEY,
+		nm.NM_SETTING_VPN_OPENVPN_KEY_STATIC_KEY:      "testdata/ca.key",
+	})
+	content, err := genOpenvpnConfig(data)
+	c.Check(err, C.IsNil)
+	c.Check(strings.Contains(string(content), "ifconfig"), C.Equals, false)
+	c.Check(strings.Contains(string(content), "<secret>\n"), C.Equals, true)
+}
+
+func (*testWrapper) TestOpenvpnInlineFiles(c *C.C) {
+	dir, err := ioutil.TempDir("", "network-openvpn")
+	c.Assert(err, C.IsNil)
+	defer os.RemoveAll(dir)
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	defer os.Setenv("HOME", oldHome)
+
+	const uuid = "8e2f9aa2-42b8-47d5-b040-ae82c53fa1f2"
+	file := filepath.Join(dir, "client.ovpn")
+	inline := "<ca>\n-----BEGIN CERTIFICATE-----\n</ca>\n"
+
+	// the inline files are not saved if the config is invalid
+	c.Assert(ioutil.WriteFile(file, []byte("proto udp\n"+inline), 0600), C.IsNil)
+	_, err = newOpenvpnConnectionDataFromFile(file, uuid)
+	c.Check(err, C.NotNil)
+	_, err = os.Stat(getOpenvpnInlineFile(uuid, "ca"))
+	c.Check(os.IsNotExist(err), C.Equals, true)
+
+	c.Assert(ioutil.WriteFile(file, []byte("remote vpn.example.com 1194\n"+inline), 0600), C.IsNil)
+	data, err := newOpenvpnConnectionDataFromFile(file, uuid)
+	c.Check(err, C.IsNil)
+	caFile := filepath.Join(dir, ".cert", "nm-openvpn", uuid+"-ca.pem")
+	c.Check(getSettingVpnData(data)[nm.NM_SETTING_VPN_OPENVPN_KEY_CA], C.Equals, caFile)
+	content, err := ioutil.ReadFile(caFile)
+	c.Check(err, C.IsNil)
+	c.Check(string(content), C.Equals, "-----BEGIN CERTIFICATE-----\n")
+
+	removeOpenvpnInlineFiles(uuid)
+	_, err = os.Stat(caFile)
+	c.Check(os.IsNotExist(err), C.Equals, true)
+}
+
+func (*testWrapper) TestArpHwAddress(c *C.C) {
+	content := []byte(`IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        wlan0
+192.168.1.20     0x1         0x0         00:00:00:00:00:00     *        wlan0
+`)
+	c.Check(getArpHwAddress(content, "192.168.1.1"), C.Equals, "AA:BB:CC:DD:EE:FF")
+	c.Check(getArpHwAddress(content, "192.168.1.20"), C.Equals, "")
+	c.Check(getArpHwAddress(content, "10.0.0.1"), C.Equals, "")
+}
+
+func (*testWrapper) TestMatchLocation(c *C.C) {
+	locations := map[string]*Location{
+		"office": {Ssids: []string{"Corp"}, Identities: []string{"alice"}},
+		"home":   {GatewayMacs: []string{"aa:bb:cc:dd:ee:ff"}},
+	}
+	c.Check(matchLocation(locations, &locationEnv{ssids: []string{"Corp"}}), C.Equals, "office")
+	// ssids are case sensitive
+	c.Check(matchLocation(locations, &locationEnv{ssids: []string{"corp"}}), C.Equals, "")
+	c.Check(matchLocation(locations, &locationEnv{identities: []string{"alice"}}), C.Equals, "office")
+	c.Check(matchLocation(locations, &locationEnv{gatewayMacs: []string{"AA:BB:CC:DD:EE:FF"}}),
+		C.Equals, "home")
+	c.Check(matchLocation(locations, &locationEnv{ssids: []string{"Cafe"}}), C.Equals, "")
+
+	c.Check(checkLocation(&Location{}), C.NotNil)
+	c.Check(checkLocation(&Location{Ssids: []string{"Corp"}, Dns: []string{"10.0.0.1"}}), C.IsNil)
+	c.Check(checkLocation(&Location{Ssids: []string{"Corp"}, Dns: []string{"10.0.0"}}), C.NotNil)
+}
+
+func (*testWrapper) TestUpdateLocations(c *C.C) {
+	dir, err := ioutil.TempDir("", "network-location")
+	c.Assert(err, C.IsNil)
+	defer os.RemoveAll(dir)
+	oldLocationsFile := locationsFile
+	locationsFile = filepath.Join(dir, "locations.json")
+	defer func() {
+		locationsFile = oldLocationsFile
+	}()
+
+	err = updateLocations(func(locations map[string]*Location) error {
+		locations["office"] = &Location{Ssids: []string{"Corp"}}
+		return nil
+	})
+	c.Check(err, C.IsNil)
+	locations, err := loadLocations()
+	c.Check(err, C.IsNil)
+	c.Check(locations["office"].Ssids, C.DeepEquals, []string{"Corp"})
+
+	// a broken file is not overwritten
+	c.Assert(ioutil.WriteFile(locationsFile, []byte("{"), 0644), C.IsNil)
+	err = updateLocations(func(locations map[string]*Location) error {
+		locations["home"] = &Location{Ssids: []string{"Home"}}
+		return nil
+	})
+	c.Check(err, C.NotNil)
+	content, err := ioutil.ReadFile(locationsFile)
+	c.Check(err, C.IsNil)
+	c.Check(string(content), C.Equals, "{")
+}
+
+func (*testWrapper) TestLocationBackup(c *C.C) {
+	dir, err := ioutil.TempDir("", "network-location")
+	c.Assert(err, C.IsNil)
+	defer os.RemoveAll(dir)
+	oldBackupFile := locationBackupFile
+	locationBackupFile = filepath.Join(dir, "location-backup.json")
+	defer func() {
+		locationBackupFile = oldBackupFile
+	}()
+
+	backup, err := loadLocationBackup()
+	c.Check(err, C.IsNil)
+	c.Check(backup, C.IsNil)
+
+	backup = &locationBackup{
+		Location: "office",
+		Proxy:    &LocationProxy{Method: "none", Servers: map[string]string{}},
+		Dns: map[dbus.ObjectPath]locationDnsBackup{
+			"/org/freedesktop/NetworkManager/Devices/2": {Uuid: "uuid", Dns: []uint32{16843009}},
+		},
+		Vpns: []string{"vpn-uuid"},
+	}
+	c.Check(saveLocationBackup(backup), C.IsNil)
+	loaded, err := loadLocationBackup()
+	c.Check(err, C.IsNil)
+	c.Check(loaded, C.DeepEquals, backup)
+
+	c.Check(saveLocationBackup(nil), C.IsNil)
+	c.Check(saveLocationBackup(nil), C.IsNil)
+	backup, err = loadLocationBackup()
+	c.Check(err, C.IsNil)
+	c.Check(backup, C.IsNil)
+}
+
+func (*testWrapper) TestGetGatewayHwAddress(c *C.C) {
+	dir, err := ioutil.TempDir("", "network-location")
+	c.Assert(err, C.IsNil)
+	defer os.RemoveAll(dir)
+	oldArpFile := arpFile
+	arpFile = filepath.Join(dir, "arp")
+	defer func() {
+		arpFile = oldArpFile
+	}()
+
+	c.Assert(ioutil.WriteFile(arpFile, []byte(`IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        wlan0
+`), 0644), C.IsNil)
+	c.Check(getGatewayHwAddress("192.168.1.1"), C.Equals, "AA:BB:CC:DD:EE:FF")
+
+	os.Remove(arpFile)
+	c.Check(getGatewayHwAddress("192.168.1.1"), C.Equals, "")
+}
+
+func (*testWrapper) TestCheckCaptivePortal(c *C.C) {
+	oldProbe, oldOpen := probeCaptivePortal, openCaptivePortalLogin
+	defer func() {
+		probeCaptivePortal, openCaptivePortalLogin = oldProbe, oldOpen
+	}()
+	var probed int
+	probeCaptivePortal = func() (bool, string, error) {
+		probed++
+		return true, "http://portal.example.com/login", nil
+	}
+	var opened []string
+	openCaptivePortalLogin = func(loginUrl string) {
+		opened = append(opened, loginUrl)
+	}
+
+	m := &Manager{}
+	// the probe started before stopping is ignored
+	m.portalGeneration = 1
+	m.stopCheckCaptivePortal()
+	m.checkCaptivePortal(1)
+	c.Check(opened, C.HasLen, 0)
+	c.Check(m.portalLoginOpened, C.Equals, false)
+
+	m.checkCaptivePortal(m.portalGeneration)
+	c.Check(opened, C.DeepEquals, []string{"http://portal.example.com/login"})
+	c.Check(m.portalLoginOpened, C.Equals, true)
+
+	// the login page is opened once for each captive portal
+	m.startCheckCaptivePortal()
+	c.Check(probed, C.Equals, 2)
+	m.checkCaptivePortal(m.portalGeneration)
+	c.Check(opened, C.HasLen, 1)
+
+	m.stopCheckCaptivePortal()
+	c.Check(m.portalLoginOpened, C.Equals, false)
+}
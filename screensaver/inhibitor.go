@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package screensaver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+	"pkg.deepin.io/lib/procfs"
+)
+
+const (
+	dbusInhibitorPath      = "/com/deepin/daemon/ScreenSaver/Inhibitor"
+	dbusInhibitorInterface = "com.deepin.daemon.ScreenSaver.Inhibitor"
+)
+
+var errCallerNotAllowed = errors.New("caller is not allowed")
+
+// InhibitorManager 是 deepin 私有的接口，只供 dde-session-daemon 中的电源模块
+// 列出 org.freedesktop.ScreenSaver 的抑制和设置黑名单。
+type InhibitorManager struct {
+	ss *ScreenSaver
+
+	methods *struct {
+		GetInhibitors         func() `out:"inhibitors"`
+		SetInhibitorBlacklist func() `in:"apps"`
+	}
+}
+
+type InhibitorInfo struct {
+	Cookie  uint32
+	Sender  string
+	Name    string
+	Reason  string
+	App     string
+	Ignored bool
+}
+
+func (*InhibitorManager) GetInterfaceName() string {
+	return dbusInhibitorInterface
+}
+
+func isInhibitorBlacklisted(app, name string, blacklist []string) bool {
+	for _, item := range blacklist {
+		if item == "" {
+			continue
+		}
+		if strings.EqualFold(item, app) || strings.EqualFold(item, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 返回调用者可执行文件的名称
+func (ss *ScreenSaver) getSenderApp(sender dbus.Sender) string {
+	pid, err := ss.service.GetConnPID(string(sender))
+	if err != nil {
+		logger.Warning(err)
+		return ""
+	}
+	exe, err := procfs.Process(pid).Exe()
+	if err != nil {
+		logger.Debug(err)
+		return ""
+	}
+	return filepath.Base(exe)
+}
+
+// 只允许同一进程中的模块调用
+func (im *InhibitorManager) checkCaller(sender dbus.Sender) error {
+	pid, err := im.ss.service.GetConnPID(string(sender))
+	if err != nil {
+		return err
+	}
+	if pid != uint32(os.Getpid()) {
+		logger.Warningf("caller %s (pid %d) is not allowed", sender, pid)
+		return errCallerNotAllowed
+	}
+	return nil
+}
+
+// 返回所有的抑制操作，按 id 排序
+func (im *InhibitorManager) GetInhibitors(sender dbus.Sender) ([]InhibitorInfo, *dbus.Error) {
+	err := im.checkCaller(sender)
+	if err != nil {
+		return nil, dbusutil.ToError(err)
+	}
+
+	ss := im.ss
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	result := make([]InhibitorInfo, 0, len(ss.inhibitors))
+	for _, inhibitor := range ss.inhibitors {
+		result = append(result, InhibitorInfo{
+			Cookie:  inhibitor.cookie,
+			Sender:  string(inhibitor.sender),
+			Name:    inhibitor.name,
+			Reason:  inhibitor.reason,
+			App:     inhibitor.app,
+			Ignored: inhibitor.ignored,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Cookie < result[j].Cookie
+	})
+	return result, nil
+}
+
+// 设置黑名单，黑名单中程序的抑制被忽略，已有的抑制也会重新检查
+func (im *InhibitorManager) SetInhibitorBlacklist(sender dbus.Sender, apps []string) *dbus.Error {
+	err := im.checkCaller(sender)
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+
+	ss := im.ss
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	wasInhibited := ss.isInhibited()
+	ss.blacklist = apps
+	for cookie, inhibitor := range ss.inhibitors {
+		ignored := isInhibitorBlacklisted(inhibitor.app, inhibitor.name, apps)
+		if ignored != inhibitor.ignored {
+			logger.Infof("inhibitor of %s %q ignored: %v", inhibitor.sender, inhibitor.name, ignored)
+			inhibitor.ignored = ignored
+			ss.inhibitors[cookie] = inhibitor
+		}
+	}
+	ss.updateInhibitState(wasInhibited)
+	return nil
+}
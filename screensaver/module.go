@@ -31,6 +31,7 @@ func init() {
 
 type Module struct {
 	sSaver     *ScreenSaver
+	inhibitors *InhibitorManager
 	syncConfig *dsync.Config
 	*loader.ModuleBase
 }
@@ -76,6 +77,12 @@ func (m *Module) Start() error {
 		return err
 	}
 
+	m.inhibitors = &InhibitorManager{ss: m.sSaver}
+	err = service.Export(dbusInhibitorPath, m.inhibitors)
+	if err != nil {
+		return err
+	}
+
 	m.syncConfig = dsync.NewConfig("screensaver", &syncConfig{}, m.sSaver.sigLoop, dScreenSaverPath, logger)
 	err = service.Export(dScreenSaverPath, m.syncConfig)
 	if err != nil {
@@ -110,6 +117,11 @@ func (m *Module) Stop() error {
 	if err != nil {
 		logger.Warning(err)
 	}
+	err = service.StopExport(m.inhibitors)
+	if err != nil {
+		logger.Warning(err)
+	}
+	m.inhibitors = nil
 	m.sSaver.destroy()
 	m.sSaver = nil
 
@@ -42,6 +42,9 @@ type inhibitor struct {
 	cookie uint32
 	name   string
 	reason string
+	app    string // 可执行文件的名称
+	// 在黑名单中的程序的抑制被忽略，不会影响 Idle 计时器
+	ignored bool
 }
 
 type ScreenSaver struct {
@@ -56,6 +59,7 @@ type ScreenSaver struct {
 
 	inhibitors map[uint32]inhibitor
 	counter    uint32
+	blacklist  []string
 	mu         sync.Mutex
 
 	//Inhibit state, we need save the SetTimeout value,
@@ -94,23 +98,30 @@ type timeoutVals struct {
 // ret0: 此次操作对应的 id，用来取消抑制
 func (ss *ScreenSaver) Inhibit(sender dbus.Sender, name, reason string) (uint32,
 	*dbus.Error) {
+	app := ss.getSenderApp(sender)
+
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
 
 	ss.counter++
 
+	wasInhibited := ss.isInhibited()
 	ss.inhibitors[ss.counter] = inhibitor{
-		cookie: ss.counter,
-		name:   name,
-		reason: reason,
-		sender: sender,
+		cookie:  ss.counter,
+		name:    name,
+		reason:  reason,
+		sender:  sender,
+		app:     app,
+		ignored: isInhibitorBlacklisted(app, name, ss.blacklist),
 	}
 
-	if len(ss.inhibitors) == 1 {
-		ss.setTimeout(0, 0, false)
+	if ss.inhibitors[ss.counter].ignored {
+		logger.Infof("ignore inhibit of sender %s %q in blacklist", sender, name)
+	} else {
+		logger.Infof("sender %s %q want system enter inhibit, because: %q",
+			sender, name, reason)
 	}
-	logger.Infof("sender %s %q want system enter inhibit, because: %q",
-		sender, name, reason)
+	ss.updateInhibitState(wasInhibited)
 
 	return ss.counter, nil
 }
@@ -143,17 +154,40 @@ func (ss *ScreenSaver) UnInhibit(sender dbus.Sender, cookie uint32) *dbus.Error
 }
 
 func (ss *ScreenSaver) unInhibit(cookie uint32) {
+	wasInhibited := ss.isInhibited()
 	delete(ss.inhibitors, cookie)
-	if len(ss.inhibitors) == 0 {
-		logger.Info("Enter un-inhibit state")
-		if ss.lastVals != nil {
-			logger.Info("recover from ", ss.lastVals)
-			ss.setTimeout(ss.lastVals.seconds, ss.lastVals.interval, ss.lastVals.blank)
-			ss.lastVals = nil
-		} else {
-			ss.setTimeout(ss.idleTime, ss.idleInterval, ss.blank == 1)
+	ss.updateInhibitState(wasInhibited)
+}
+
+// 是否有未被忽略的抑制
+func (ss *ScreenSaver) isInhibited() bool {
+	for _, inhibitor := range ss.inhibitors {
+		if !inhibitor.ignored {
+			return true
 		}
 	}
+	return false
+}
+
+// 抑制的状态改变时，停止或恢复 Idle 计时器
+func (ss *ScreenSaver) updateInhibitState(wasInhibited bool) {
+	inhibited := ss.isInhibited()
+	if inhibited == wasInhibited {
+		return
+	}
+	if inhibited {
+		ss.setTimeout(0, 0, false)
+		return
+	}
+
+	logger.Info("Enter un-inhibit state")
+	if ss.lastVals != nil {
+		logger.Info("recover from ", ss.lastVals)
+		ss.setTimeout(ss.lastVals.seconds, ss.lastVals.interval, ss.lastVals.blank)
+		ss.lastVals = nil
+	} else {
+		ss.setTimeout(ss.idleTime, ss.idleInterval, ss.blank == 1)
+	}
 }
 
 // 设置 Idle 的定时器超时时间
@@ -167,7 +201,7 @@ func (ss *ScreenSaver) SetTimeout(seconds, interval uint32, blank bool) *dbus.Er
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
 
-	if len(ss.inhibitors) > 0 {
+	if ss.isInhibited() {
 		ss.lastVals = &timeoutVals{seconds, interval, blank}
 		logger.Info("Current is inhibit state, the value", ss.lastVals, "will apply when in unhibit state")
 	} else {
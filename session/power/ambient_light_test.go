@@ -2,6 +2,7 @@ package power
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -29,3 +30,73 @@ func Test_calcBrWithLightLevel(t *testing.T) {
 		}
 	})
 }
+
+func Test_brightnessCurve(t *testing.T) {
+	Convey("learn brightness curve", t, func(c C) {
+		var points []BrightnessPoint
+		c.So(calcBrWithCurve(points, 17), ShouldAlmostEqual, float64(29)/255)
+
+		points = addBrightnessPoint(points, BrightnessPoint{Lux: 100, Brightness: 0.5})
+		c.So(calcBrWithCurve(points, 100), ShouldAlmostEqual, 0.5)
+		c.So(calcBrWithCurve(points, 5000), ShouldEqual, 1)
+
+		points = addBrightnessPoint(points, BrightnessPoint{Lux: 1000, Brightness: 0.6})
+		points = addBrightnessPoint(points, BrightnessPoint{Lux: 10, Brightness: 0.3})
+		c.So(points, ShouldResemble, []BrightnessPoint{
+			{Lux: 10, Brightness: 0.3},
+			{Lux: 100, Brightness: 0.5},
+			{Lux: 1000, Brightness: 0.6},
+		})
+		c.So(calcBrWithCurve(points, 10), ShouldAlmostEqual, 0.3)
+		c.So(calcBrWithCurve(points, 1000), ShouldAlmostEqual, 0.6)
+		br := calcBrWithCurve(points, 300)
+		c.So(br, ShouldBeGreaterThan, 0.5)
+		c.So(br, ShouldBeLessThan, 0.6)
+
+		// 替换照度相近的调节点，删除矛盾的调节点
+		points = addBrightnessPoint(points, BrightnessPoint{Lux: 120, Brightness: 0.2})
+		c.So(points, ShouldResemble, []BrightnessPoint{
+			{Lux: 120, Brightness: 0.2},
+			{Lux: 1000, Brightness: 0.6},
+		})
+	})
+}
+
+func Test_brightnessLearner(t *testing.T) {
+	Convey("filter brightness set by power", t, func(c C) {
+		const output = "eDP-1"
+		now := time.Now()
+		l := &brightnessLearner{
+			// 不读取配置文件
+			curves: make(map[string][]BrightnessPoint),
+		}
+		defer l.stop()
+
+		// 没有照度时不学习
+		l.learn(output, 0.6, now)
+		c.So(l.pending, ShouldBeNil)
+
+		l.setLightLevel(100)
+		l.markSetByPowerNoLock(output, 0.5, now)
+		// 设置后不久背光逐步变化
+		l.learn(output, 0.45, now.Add(time.Second))
+		c.So(l.pending, ShouldBeNil)
+		// 量化后与设置的亮度相同
+		l.learn(output, 0.501, now.Add(5*time.Second))
+		c.So(l.pending, ShouldBeNil)
+
+		l.learn(output, 0.6, now.Add(5*time.Second))
+		c.So(l.pending, ShouldResemble, &BrightnessPoint{Lux: 100, Brightness: 0.6})
+		// 学习前计算亮度也使用用户调节的亮度
+		c.So(l.calcBrightness(output, 100), ShouldAlmostEqual, 0.6)
+		c.So(l.calcBrightness("HDMI-1", 100), ShouldAlmostEqual, float64(50)/255)
+
+		l.setCurrent(map[string]float64{output: 0.6})
+		c.So(l.isCurrent(output, 0.601), ShouldBeTrue)
+		c.So(l.isCurrent(output, 0.5), ShouldBeFalse)
+
+		l.stop()
+		c.So(l.pending, ShouldBeNil)
+		c.So(l.calcBrightness(output, 100), ShouldAlmostEqual, float64(50)/255)
+	})
+}
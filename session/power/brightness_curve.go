@@ -0,0 +1,355 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package power
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+)
+
+const (
+	// 用户停止调节亮度后，等待这个时间再学习
+	brightnessLearnDelay = 3 * time.Second
+	// 照度相差小于这个倍数的旧调节点会被新的替换
+	brightnessPointLuxRatio = 1.5
+	// 亮度按百分比量化后再比较，背光的级数有限，读回的亮度与设置的会略有差别
+	brightnessQuantizeSteps = 100
+	// 电源模块设置亮度后，在这段时间内的亮度变化不认为是用户调节的，
+	// 背光可能逐步变化到设置的亮度
+	brightnessSetByPowerWindow = 2 * time.Second
+)
+
+// BrightnessPoint 是用户手动调节亮度时的环境光照度和亮度
+type BrightnessPoint struct {
+	Lux        float64
+	Brightness float64 // 0 ~ 1
+}
+
+func luxDistance(lux1, lux2 float64) float64 {
+	return math.Abs(math.Log1p(lux1) - math.Log1p(lux2))
+}
+
+// addBrightnessPoint 添加一个调节点，删除照度相近的旧调节点，以及与之矛盾的旧调节点，
+// 即照度更低但亮度更高，或者照度更高但亮度更低的，保证曲线单调递增。
+func addBrightnessPoint(points []BrightnessPoint, point BrightnessPoint) []BrightnessPoint {
+	result := make([]BrightnessPoint, 0, len(points)+1)
+	for _, p := range points {
+		if luxDistance(p.Lux, point.Lux) < math.Log(brightnessPointLuxRatio) {
+			continue
+		}
+		if (p.Lux < point.Lux && p.Brightness > point.Brightness) ||
+			(p.Lux > point.Lux && p.Brightness < point.Brightness) {
+			continue
+		}
+		result = append(result, p)
+	}
+	result = append(result, point)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Lux < result[j].Lux
+	})
+	return result
+}
+
+// calcBrWithCurve 计算照度对应的亮度。以 calcBrWithLightLevel 为基础，
+// 加上用户调节点与它的差值，调节点之间的差值按照度的对数插值。
+func calcBrWithCurve(points []BrightnessPoint, lightLevel float64) float64 {
+	defaultBr := func(lux float64) float64 {
+		return float64(calcBrWithLightLevel(lux)) / 255
+	}
+	br := defaultBr(lightLevel)
+	if len(points) == 0 {
+		return br
+	}
+
+	offset := func(p BrightnessPoint) float64 {
+		return p.Brightness - defaultBr(p.Lux)
+	}
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].Lux >= lightLevel
+	})
+	switch {
+	case i == 0:
+		br += offset(points[0])
+	case i == len(points):
+		br += offset(points[i-1])
+	default:
+		p1, p2 := points[i-1], points[i]
+		x1, x2 := math.Log1p(p1.Lux), math.Log1p(p2.Lux)
+		ratio := (math.Log1p(lightLevel) - x1) / (x2 - x1)
+		br += offset(p1) + ratio*(offset(p2)-offset(p1))
+	}
+
+	if br < 0 {
+		br = 0
+	} else if br > 1 {
+		br = 1
+	}
+	return br
+}
+
+func quantizeBrightness(brightness float64) int {
+	return int(math.Floor(brightness*brightnessQuantizeSteps + 0.5))
+}
+
+type brightnessSetByPower struct {
+	brightness float64
+	time       time.Time
+}
+
+type brightnessLearner struct {
+	mu sync.Mutex
+	// 电源模块设置的亮度和时间，用于区分用户手动调节的亮度
+	setByPower map[string]brightnessSetByPower
+	// 显示器当前的亮度
+	current    map[string]float64
+	lightLevel float64
+	// 配置中各显示器的曲线的缓存，为 nil 表示还没有读取
+	curves map[string][]BrightnessPoint
+	// 等待学习的调节点，在学习前计算亮度时也要用到，避免用旧的曲线覆盖用户调节的亮度
+	pendingOutput string
+	pending       *BrightnessPoint
+	timer         *time.Timer
+}
+
+func (l *brightnessLearner) markSetByPower(output string, brightness float64) {
+	l.mu.Lock()
+	l.markSetByPowerNoLock(output, brightness, time.Now())
+	l.mu.Unlock()
+}
+
+func (l *brightnessLearner) markSetByPowerNoLock(output string, brightness float64, now time.Time) {
+	if l.setByPower == nil {
+		l.setByPower = make(map[string]brightnessSetByPower)
+	}
+	l.setByPower[output] = brightnessSetByPower{brightness: brightness, time: now}
+}
+
+// isSetByPowerNoLock 判断亮度是否是电源模块设置的
+func (l *brightnessLearner) isSetByPowerNoLock(output string, brightness float64, now time.Time) bool {
+	s, ok := l.setByPower[output]
+	if !ok {
+		return false
+	}
+	return now.Sub(s.time) < brightnessSetByPowerWindow ||
+		quantizeBrightness(s.brightness) == quantizeBrightness(brightness)
+}
+
+// getCurvesNoLock 返回缓存的曲线，第一次调用时从配置读取
+func (l *brightnessLearner) getCurvesNoLock() map[string][]BrightnessPoint {
+	if l.curves == nil {
+		cfg, err := loadConfig()
+		if err != nil {
+			logger.Warning(err)
+			return nil
+		}
+		l.curves = cfg.BrightnessCurves
+		if l.curves == nil {
+			l.curves = make(map[string][]BrightnessPoint)
+		}
+	}
+	return l.curves
+}
+
+// getCurve 返回已保存的用户调节点
+func (l *brightnessLearner) getCurve(output string) []BrightnessPoint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.getCurvesNoLock()[output]
+}
+
+// calcBrightness 计算照度对应的亮度，包括等待学习的调节点
+func (l *brightnessLearner) calcBrightness(output string, lightLevel float64) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	points := l.getCurvesNoLock()[output]
+	if l.pending != nil && l.pendingOutput == output {
+		points = addBrightnessPoint(points, *l.pending)
+	}
+	return calcBrWithCurve(points, lightLevel)
+}
+
+func (l *brightnessLearner) setCurrent(brightnessTable map[string]float64) {
+	l.mu.Lock()
+	l.current = brightnessTable
+	l.mu.Unlock()
+}
+
+// isCurrent 判断亮度是否与显示器当前的亮度相同，用于避免重复设置
+func (l *brightnessLearner) isCurrent(output string, brightness float64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current, ok := l.current[output]
+	return ok && quantizeBrightness(current) == quantizeBrightness(brightness)
+}
+
+func (l *brightnessLearner) setLightLevel(lightLevel float64) {
+	l.mu.Lock()
+	l.lightLevel = lightLevel
+	l.mu.Unlock()
+}
+
+func (l *brightnessLearner) stop() {
+	l.mu.Lock()
+	l.stopNoLock()
+	l.mu.Unlock()
+}
+
+func (l *brightnessLearner) stopNoLock() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.pending = nil
+}
+
+// reset 取消等待学习的调节点，下次使用时重新读取配置中的曲线
+func (l *brightnessLearner) reset() {
+	l.mu.Lock()
+	l.stopNoLock()
+	l.curves = nil
+	l.mu.Unlock()
+}
+
+// learn 在用户停止调节 brightnessLearnDelay 后，把调节点保存到配置中
+func (l *brightnessLearner) learn(output string, brightness float64, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.isSetByPowerNoLock(output, brightness, now) {
+		return
+	}
+	if l.lightLevel <= 0 {
+		return
+	}
+
+	point := &BrightnessPoint{Lux: l.lightLevel, Brightness: brightness}
+	l.stopNoLock()
+	l.pendingOutput = output
+	l.pending = point
+	l.timer = time.AfterFunc(brightnessLearnDelay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.pending != point {
+			// 已被新的调节点替换或被取消
+			return
+		}
+		l.pending = nil
+		l.timer = nil
+
+		logger.Debugf("learn brightness %v at %v lux for %s", point.Brightness, point.Lux, output)
+		var points []BrightnessPoint
+		err := updateConfig(func(cfg *config) bool {
+			if cfg.BrightnessCurves == nil {
+				cfg.BrightnessCurves = make(map[string][]BrightnessPoint)
+			}
+			points = addBrightnessPoint(cfg.BrightnessCurves[output], *point)
+			cfg.BrightnessCurves[output] = points
+			return true
+		})
+		if err != nil {
+			logger.Warning(err)
+			return
+		}
+		if l.curves != nil {
+			l.curves[output] = points
+		}
+		l.markSetByPowerNoLock(output, point.Brightness, time.Now())
+	})
+}
+
+// handleBrightnessChanged 在自动调节亮度时，把用户手动调节的亮度记录到曲线中
+func (m *Manager) handleBrightnessChanged(brightnessTable map[string]float64) {
+	m.brightnessLearner.setCurrent(brightnessTable)
+	m.PropsMu.RLock()
+	claimed := m.ambientLightClaimed
+	m.PropsMu.RUnlock()
+	if !claimed || !m.AmbientLightAdjustBrightness.Get() {
+		return
+	}
+
+	output := m.getBuiltinOutputName()
+	br, ok := brightnessTable[output]
+	if !ok {
+		return
+	}
+	m.brightnessLearner.learn(output, br, time.Now())
+}
+
+// GetBrightnessCurve 返回显示器自动调节亮度的曲线，output 为空表示内置显示器。
+// 返回 JSON 格式，Points 为用户的调节点，Curve 为各照度对应的亮度。
+func (m *Manager) GetBrightnessCurve(output string) (string, *dbus.Error) {
+	if output == "" {
+		output = m.getBuiltinOutputName()
+	}
+	points := m.brightnessLearner.getCurve(output)
+	if points == nil {
+		points = []BrightnessPoint{}
+	}
+	curve := make([]BrightnessPoint, 0, len(lightLevelBrTable))
+	for _, item := range lightLevelBrTable {
+		lux := float64(item.lightLevel)
+		curve = append(curve, BrightnessPoint{
+			Lux:        lux,
+			Brightness: calcBrWithCurve(points, lux),
+		})
+	}
+
+	content, err := json.Marshal(struct {
+		Points []BrightnessPoint
+		Curve  []BrightnessPoint
+	}{points, curve})
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return string(content), nil
+}
+
+// ResetBrightnessCurve 删除用户的调节点，恢复默认曲线，output 为空表示所有显示器。
+func (m *Manager) ResetBrightnessCurve(output string) *dbus.Error {
+	m.brightnessLearner.stop()
+	err := updateConfig(func(cfg *config) bool {
+		if output == "" {
+			cfg.BrightnessCurves = nil
+		} else {
+			delete(cfg.BrightnessCurves, output)
+		}
+		return true
+	})
+	m.brightnessLearner.reset()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+
+	m.PropsMu.RLock()
+	claimed := m.ambientLightClaimed
+	m.PropsMu.RUnlock()
+	if claimed {
+		lightLevel, err := m.helper.SensorProxy.LightLevel().Get(0)
+		if err == nil {
+			go m.handleLightLevelChanged(lightLevel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package power
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"pkg.deepin.io/lib/xdg/basedir"
+)
+
+var (
+	configLocker sync.Mutex
+	configFile   = filepath.Join(basedir.GetUserConfigDir(),
+		"deepin/dde-daemon/power/config.json")
+)
+
+// config 是不适合放在 gsettings 中的电源配置
+type config struct {
+	// 程序名称，忽略这些程序的抑制
+	InhibitorBlacklist []string
+	Schedules          []*ScheduleRule
+	// 各显示器自动调节亮度时用户的调节点
+	BrightnessCurves map[string][]BrightnessPoint `json:",omitempty"`
+}
+
+func loadConfigNoLock() (*config, error) {
+	var cfg config
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(content, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func loadConfig() (*config, error) {
+	configLocker.Lock()
+	defer configLocker.Unlock()
+	return loadConfigNoLock()
+}
+
+// updateConfig 读取配置，由 fn 修改后保存，fn 返回 false 则不保存。
+// 配置无法解析时返回错误，不能用空的配置覆盖。
+func updateConfig(fn func(cfg *config) bool) error {
+	configLocker.Lock()
+	defer configLocker.Unlock()
+
+	cfg, err := loadConfigNoLock()
+	if err != nil {
+		return err
+	}
+	if !fn(cfg) {
+		return nil
+	}
+	return saveConfigNoLock(cfg)
+}
+
+func saveConfigNoLock(cfg *config) error {
+	content, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(configFile), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(configFile, content, 0644)
+}
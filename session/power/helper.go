@@ -94,6 +94,8 @@ func (h *Helper) initSignalExt(systemSigLoop, sessionSigLoop *dbusutil.SignalLoo
 	h.SensorProxy.InitSignalExt(systemSigLoop, true)
 
 	// session
+	h.Notifications.InitSignalExt(sessionSigLoop, true)
+	h.Display.InitSignalExt(sessionSigLoop, true)
 	h.ScreenSaver.InitSignalExt(sessionSigLoop, true)
 	h.SessionWatcher.InitSignalExt(sessionSigLoop, true)
 }
@@ -104,6 +106,8 @@ func (h *Helper) Destroy() {
 	h.Power.RemoveHandler(proxy.RemoveAllHandlers)
 	h.SensorProxy.RemoveHandler(proxy.RemoveAllHandlers)
 
+	h.Notifications.RemoveHandler(proxy.RemoveAllHandlers)
+	h.Display.RemoveHandler(proxy.RemoveAllHandlers)
 	h.ScreenSaver.RemoveHandler(proxy.RemoveAllHandlers)
 	h.SessionWatcher.RemoveHandler(proxy.RemoveAllHandlers)
 
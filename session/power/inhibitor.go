@@ -0,0 +1,348 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package power
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+	. "pkg.deepin.io/lib/gettext"
+	"pkg.deepin.io/lib/procfs"
+)
+
+const submoduleInhibitorMonitor = "InhibitorMonitor"
+
+func init() {
+	submoduleList = append(submoduleList, newInhibitorMonitor)
+}
+
+const (
+	inhibitorSourceLogind      = "logind"
+	inhibitorSourceScreenSaver = "screensaver"
+
+	inhibitorCheckInterval = time.Minute
+	// 使用电池时，阻止待机超过这个时间就发送通知
+	inhibitorBlockTimeout = 30 * time.Minute
+)
+
+// InhibitorInfo 是 logind 或 org.freedesktop.ScreenSaver 的一个抑制
+type InhibitorInfo struct {
+	Source  string // logind 或 screensaver
+	What    string // logind 为 sleep:idle 等，screensaver 为 idle
+	Who     string
+	Why     string
+	Mode    string // block 或 delay
+	App     string // 可执行文件的名称
+	Pid     uint32
+	Ignored bool // 是否在黑名单中
+}
+
+type logindInhibitor struct {
+	What string
+	Who  string
+	Why  string
+	Mode string
+	UID  uint32
+	PID  uint32
+}
+
+// screenSaverInhibitor 是 com.deepin.daemon.ScreenSaver.Inhibitor 返回的抑制
+type screenSaverInhibitor struct {
+	Cookie  uint32
+	Sender  string
+	Name    string
+	Reason  string
+	App     string
+	Ignored bool
+}
+
+func getAppNameByPid(pid uint32) string {
+	if pid == 0 {
+		return ""
+	}
+	exe, err := procfs.Process(pid).Exe()
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(exe)
+}
+
+func isInhibitorBlacklisted(info *InhibitorInfo, blacklist []string) bool {
+	for _, app := range blacklist {
+		if app == "" {
+			continue
+		}
+		if strings.EqualFold(app, info.App) || strings.EqualFold(app, info.Who) {
+			return true
+		}
+	}
+	return false
+}
+
+// 阻止空闲时待机的抑制
+func (info *InhibitorInfo) isBlockingSleep() bool {
+	if info.Ignored || info.Pid == uint32(os.Getpid()) {
+		return false
+	}
+	if info.Source == inhibitorSourceScreenSaver {
+		return true
+	}
+	return info.Mode == "block" &&
+		(strings.Contains(info.What, "sleep") || strings.Contains(info.What, "idle"))
+}
+
+func (m *Manager) listLogindInhibitors() ([]InhibitorInfo, error) {
+	var list []logindInhibitor
+	err := m.systemSigLoop.Conn().Object("org.freedesktop.login1", "/org/freedesktop/login1").
+		Call("org.freedesktop.login1.Manager.ListInhibitors", 0).Store(&list)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]InhibitorInfo, 0, len(list))
+	for _, item := range list {
+		result = append(result, InhibitorInfo{
+			Source: inhibitorSourceLogind,
+			What:   item.What,
+			Who:    item.Who,
+			Why:    item.Why,
+			Mode:   item.Mode,
+			App:    getAppNameByPid(item.PID),
+			Pid:    item.PID,
+		})
+	}
+	return result, nil
+}
+
+func (m *Manager) getScreenSaverInhibitorObject() dbus.BusObject {
+	return m.service.Conn().Object("com.deepin.daemon.ScreenSaver",
+		"/com/deepin/daemon/ScreenSaver/Inhibitor")
+}
+
+// setScreenSaverInhibitorBlacklist 把黑名单交给屏保模块，由它在 Inhibit 时忽略
+// 黑名单中程序的抑制
+func (m *Manager) setScreenSaverInhibitorBlacklist(apps []string) error {
+	if apps == nil {
+		apps = []string{}
+	}
+	return m.getScreenSaverInhibitorObject().Call(
+		"com.deepin.daemon.ScreenSaver.Inhibitor.SetInhibitorBlacklist", 0, apps).Err
+}
+
+func (m *Manager) listScreenSaverInhibitors() ([]InhibitorInfo, error) {
+	var list []screenSaverInhibitor
+	err := m.getScreenSaverInhibitorObject().Call(
+		"com.deepin.daemon.ScreenSaver.Inhibitor.GetInhibitors", 0).Store(&list)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]InhibitorInfo, 0, len(list))
+	for _, item := range list {
+		pid, err := m.service.GetConnPID(item.Sender)
+		if err != nil {
+			logger.Debug(err)
+		}
+		result = append(result, InhibitorInfo{
+			Source: inhibitorSourceScreenSaver,
+			What:   "idle",
+			Who:    item.Name,
+			Why:    item.Reason,
+			Mode:   "block",
+			App:    item.App,
+			Pid:    pid,
+		})
+	}
+	return result, nil
+}
+
+// listInhibitors 返回 logind 和 org.freedesktop.ScreenSaver 的所有抑制
+func (m *Manager) listInhibitors() []InhibitorInfo {
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Warning(err)
+		cfg = &config{}
+	}
+
+	var result []InhibitorInfo
+	logindInhibitors, err := m.listLogindInhibitors()
+	if err != nil {
+		logger.Warning("failed to list logind inhibitors:", err)
+	}
+	result = append(result, logindInhibitors...)
+	ssInhibitors, err := m.listScreenSaverInhibitors()
+	if err != nil {
+		logger.Warning("failed to list screensaver inhibitors:", err)
+	}
+	result = append(result, ssInhibitors...)
+
+	for idx := range result {
+		result[idx].Ignored = isInhibitorBlacklisted(&result[idx], cfg.InhibitorBlacklist)
+	}
+	return result
+}
+
+// ListInhibitors 返回所有抑制待机、空闲的程序，JSON 格式的 InhibitorInfo 数组
+func (m *Manager) ListInhibitors() (string, *dbus.Error) {
+	inhibitors := m.listInhibitors()
+	if inhibitors == nil {
+		inhibitors = []InhibitorInfo{}
+	}
+	content, err := json.Marshal(inhibitors)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return string(content), nil
+}
+
+func (m *Manager) GetInhibitorBlacklist() ([]string, *dbus.Error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, dbusutil.ToError(err)
+	}
+	return cfg.InhibitorBlacklist, nil
+}
+
+// SetInhibitorBlacklist 设置黑名单，黑名单中程序的 org.freedesktop.ScreenSaver
+// 抑制会被忽略，logind 的抑制无法取消，只是不再为它发送通知。
+func (m *Manager) SetInhibitorBlacklist(apps []string) *dbus.Error {
+	err := updateConfig(func(cfg *config) bool {
+		cfg.InhibitorBlacklist = apps
+		return true
+	})
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = m.setScreenSaverInhibitorBlacklist(apps)
+	if err != nil {
+		logger.Warning("failed to set screensaver inhibitor blacklist:", err)
+	}
+
+	if monitor, ok := m.submodules[submoduleInhibitorMonitor].(*inhibitorMonitor); ok {
+		go monitor.check()
+	}
+	return nil
+}
+
+type inhibitorMonitor struct {
+	manager *Manager
+	exit    chan struct{}
+
+	mu sync.Mutex
+	// key 为抑制的 pid 和 who，value 为开始阻止待机的时间
+	blockSince map[string]time.Time
+	notified   map[string]bool
+}
+
+func newInhibitorMonitor(manager *Manager) (string, submodule, error) {
+	monitor := &inhibitorMonitor{
+		manager:    manager,
+		blockSince: make(map[string]time.Time),
+		notified:   make(map[string]bool),
+	}
+	return submoduleInhibitorMonitor, monitor, nil
+}
+
+func (monitor *inhibitorMonitor) Start() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Warning(err)
+	} else {
+		err = monitor.manager.setScreenSaverInhibitorBlacklist(cfg.InhibitorBlacklist)
+		if err != nil {
+			logger.Warning("failed to set screensaver inhibitor blacklist:", err)
+		}
+	}
+
+	monitor.exit = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(inhibitorCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				monitor.check()
+			case <-monitor.exit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (monitor *inhibitorMonitor) Destroy() {
+	if monitor.exit != nil {
+		close(monitor.exit)
+		monitor.exit = nil
+	}
+}
+
+func (monitor *inhibitorMonitor) check() {
+	m := monitor.manager
+	inhibitors := m.listInhibitors()
+
+	m.PropsMu.RLock()
+	onBattery := m.OnBattery
+	m.PropsMu.RUnlock()
+
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+
+	if !onBattery || m.BatterySleepDelay.Get() <= 0 {
+		monitor.blockSince = make(map[string]time.Time)
+		monitor.notified = make(map[string]bool)
+		return
+	}
+
+	now := time.Now()
+	blocking := make(map[string]bool)
+	for _, info := range inhibitors {
+		if !info.isBlockingSleep() {
+			continue
+		}
+		key := fmt.Sprintf("%d/%s", info.Pid, info.Who)
+		blocking[key] = true
+		since, ok := monitor.blockSince[key]
+		if !ok {
+			monitor.blockSince[key] = now
+			continue
+		}
+		if now.Sub(since) >= inhibitorBlockTimeout && !monitor.notified[key] {
+			monitor.notified[key] = true
+			name := info.App
+			if name == "" {
+				name = info.Who
+			}
+			m.sendNotify(iconBatteryLow, "", fmt.Sprintf(
+				Tr("%s has prevented the computer from sleeping on battery for a long time"), name))
+		}
+	}
+	for key := range monitor.blockSince {
+		if !blocking[key] {
+			delete(monitor.blockSince, key)
+			delete(monitor.notified, key)
+		}
+	}
+}
@@ -102,6 +102,7 @@ type Manager struct {
 	AmbientLightAdjustBrightness gsprop.Bool `prop:"access:rw"`
 	ambientLightClaimed          bool
 	lightLevelUnit               string
+	brightnessLearner            brightnessLearner
 	lidSwitchState               uint
 	sessionActive                bool
 
@@ -225,6 +226,16 @@ func (m *Manager) init() {
 		logger.Warning(err)
 	}
 
+	err = m.helper.Display.Brightness().ConnectChanged(func(hasValue bool, value map[string]float64) {
+		if !hasValue {
+			return
+		}
+		m.handleBrightnessChanged(value)
+	})
+	if err != nil {
+		logger.Warning(err)
+	}
+
 	_, err = m.helper.SysDBusDaemon.ConnectNameOwnerChanged(
 		func(name string, oldOwner string, newOwner string) {
 			serviceName := m.helper.SensorProxy.ServiceName_()
@@ -301,6 +312,7 @@ func (m *Manager) isX11SessionActive() (bool, error) {
 func (m *Manager) destroy() {
 	m.destroySubmodules()
 	m.releaseAmbientLight()
+	m.brightnessLearner.stop()
 	m.permitLogind()
 
 	if m.helper != nil {
@@ -85,35 +85,40 @@ func (m *Manager) handleLightLevelChanged(lightLevel float64) {
 	}
 	logger.Debug("light level changed to", lightLevel)
 
-	display := m.helper.Display
-	outputNames, err := display.ListOutputNames(0)
-	if err != nil {
-		logger.Warning(err)
-		return
-	}
-
-	//outputNames
-	var builtinOutputName string
-	for _, name := range outputNames {
-		if isBuiltinOutput(name) {
-			builtinOutputName = name
-			break
-		}
-	}
-
+	m.brightnessLearner.setLightLevel(lightLevel)
+	builtinOutputName := m.getBuiltinOutputName()
 	if builtinOutputName == "" {
 		// not found builtin output
 		return
 	}
 
-	br := float64(calcBrWithLightLevel(lightLevel)) / 255
+	br := m.brightnessLearner.calcBrightness(builtinOutputName, lightLevel)
+	if m.brightnessLearner.isCurrent(builtinOutputName, br) {
+		return
+	}
 	logger.Debugf("auto set brightness to %v\n", br)
-	err = display.SetBrightness(0, builtinOutputName, br)
+	m.brightnessLearner.markSetByPower(builtinOutputName, br)
+	err := m.helper.Display.SetBrightness(0, builtinOutputName, br)
 	if err != nil {
 		logger.Warning("failed to set brightness:", err)
 	}
 }
 
+func (m *Manager) getBuiltinOutputName() string {
+	outputNames, err := m.helper.Display.ListOutputNames(0)
+	if err != nil {
+		logger.Warning(err)
+		return ""
+	}
+
+	for _, name := range outputNames {
+		if isBuiltinOutput(name) {
+			return name
+		}
+	}
+	return ""
+}
+
 type lightLevelBr struct {
 	lightLevel int // unit lux
 	brightness byte
@@ -20,7 +20,9 @@
 package power
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -125,3 +127,166 @@ func TestMetaTasksMin(t *testing.T) {
 		c.So(tasks.min(), ShouldEqual, 10)
 	})
 }
+
+func Test_inhibitorPolicy(t *testing.T) {
+	Convey("inhibitor blacklist and blocking", t, func(c C) {
+		blacklist := []string{"Chrome", "", "deepin-movie"}
+
+		info := &InhibitorInfo{
+			Source: inhibitorSourceLogind,
+			What:   "sleep:idle",
+			Who:    "chrome",
+			Mode:   "block",
+			App:    "chrome",
+			Pid:    1,
+		}
+		c.So(isInhibitorBlacklisted(info, blacklist), ShouldBeTrue)
+		c.So(info.isBlockingSleep(), ShouldBeTrue)
+		info.Ignored = true
+		c.So(info.isBlockingSleep(), ShouldBeFalse)
+
+		info = &InhibitorInfo{
+			Source: inhibitorSourceLogind,
+			What:   "handle-power-key:handle-lid-switch",
+			Who:    "com.deepin.daemon.Power",
+			Mode:   "block",
+			Pid:    1,
+		}
+		c.So(isInhibitorBlacklisted(info, blacklist), ShouldBeFalse)
+		c.So(info.isBlockingSleep(), ShouldBeFalse)
+
+		info = &InhibitorInfo{
+			Source: inhibitorSourceLogind,
+			What:   "sleep",
+			Who:    "NetworkManager",
+			Mode:   "delay",
+			Pid:    1,
+		}
+		c.So(info.isBlockingSleep(), ShouldBeFalse)
+
+		info = &InhibitorInfo{
+			Source: inhibitorSourceScreenSaver,
+			What:   "idle",
+			Who:    "Deepin Movie",
+			App:    "deepin-movie",
+			Pid:    1,
+		}
+		c.So(isInhibitorBlacklisted(info, blacklist), ShouldBeTrue)
+		c.So(info.isBlockingSleep(), ShouldBeTrue)
+	})
+}
+
+func Test_parseCron(t *testing.T) {
+	Convey("parse cron expression and get next time", t, func(c C) {
+		base := time.Date(2019, 3, 15, 10, 30, 20, 0, time.Local) // 周五
+
+		sched, err := parseCron("*/15 * * * *")
+		c.So(err, ShouldBeNil)
+		next, ok := sched.next(base)
+		c.So(ok, ShouldBeTrue)
+		c.So(next, ShouldEqual, time.Date(2019, 3, 15, 10, 45, 0, 0, time.Local))
+
+		sched, err = parseCron("30 8 * * 1-5")
+		c.So(err, ShouldBeNil)
+		next, _ = sched.next(base)
+		c.So(next, ShouldEqual, time.Date(2019, 3, 18, 8, 30, 0, 0, time.Local))
+
+		sched, err = parseCron("0 8 * * 7")
+		c.So(err, ShouldBeNil)
+		next, _ = sched.next(base)
+		c.So(next, ShouldEqual, time.Date(2019, 3, 17, 8, 0, 0, 0, time.Local))
+
+		// 日和周都有限制时满足其一即可
+		sched, err = parseCron("0 12 20 * 5")
+		c.So(err, ShouldBeNil)
+		next, _ = sched.next(base)
+		c.So(next, ShouldEqual, time.Date(2019, 3, 15, 12, 0, 0, 0, time.Local))
+
+		sched, err = parseCron("0 0 30 2 *")
+		c.So(err, ShouldBeNil)
+		_, ok = sched.next(base)
+		c.So(ok, ShouldBeFalse)
+
+		for _, expr := range []string{"60 * * * *", "* * *", "1-x * * * *", "5-1 * * * *", "*/0 * * * *"} {
+			_, err = parseCron(expr)
+			c.So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func Test_scheduleRule(t *testing.T) {
+	Convey("schedule rule check", t, func(c C) {
+		now := time.Date(2019, 3, 15, 10, 30, 20, 0, time.Local)
+		const bootId = "boot-1"
+		processStartTime := func(pid uint32) (uint64, error) {
+			if pid == 42 {
+				return 1000, nil
+			}
+			return 0, errors.New("no such process")
+		}
+
+		rule := &ScheduleRule{Action: scheduleActionSuspend, Cron: "30 10 * * *", Enabled: true}
+		fire, done := rule.check(now.Add(-scheduleCheckInterval), now, bootId, processStartTime)
+		c.So(fire, ShouldBeTrue)
+		c.So(done, ShouldBeFalse)
+		// 待机期间错过的任务不再执行
+		fire, _ = rule.check(now.Add(-time.Hour), now.Add(5*time.Minute), bootId, processStartTime)
+		c.So(fire, ShouldBeFalse)
+		rule.Enabled = false
+		fire, _ = rule.check(now.Add(-scheduleCheckInterval), now, bootId, processStartTime)
+		c.So(fire, ShouldBeFalse)
+
+		rule = &ScheduleRule{Action: scheduleActionShutdown, Time: now.Add(-10 * time.Second).Unix(), Enabled: true}
+		fire, done = rule.check(now.Add(-scheduleCheckInterval), now, bootId, processStartTime)
+		c.So(fire, ShouldBeTrue)
+		c.So(done, ShouldBeTrue)
+
+		rule.Time = now.Add(-time.Hour).Unix()
+		fire, done = rule.check(now.Add(-scheduleCheckInterval), now, bootId, processStartTime)
+		c.So(fire, ShouldBeFalse)
+		c.So(done, ShouldBeTrue)
+
+		rule.Pid = 42
+		rule.BootId = bootId
+		rule.PidStartTime = 1000
+		fire, done = rule.check(now.Add(-scheduleCheckInterval), now, bootId, processStartTime)
+		c.So(fire, ShouldBeFalse)
+		c.So(done, ShouldBeFalse)
+		rule.Pid = 43
+		fire, done = rule.check(now.Add(-scheduleCheckInterval), now, bootId, processStartTime)
+		c.So(fire, ShouldBeTrue)
+		c.So(done, ShouldBeTrue)
+		// pid 被其他进程重用
+		rule.Pid = 42
+		rule.PidStartTime = 900
+		fire, done = rule.check(now.Add(-scheduleCheckInterval), now, bootId, processStartTime)
+		c.So(fire, ShouldBeTrue)
+		c.So(done, ShouldBeTrue)
+		// 重启前添加的任务不再执行
+		rule.PidStartTime = 1000
+		fire, done = rule.check(now.Add(-scheduleCheckInterval), now, "boot-0", processStartTime)
+		c.So(fire, ShouldBeFalse)
+		c.So(done, ShouldBeTrue)
+
+		startTime, err := parseProcessStartTime("1234 (a (b) c) S 1 1234 1234 0 -1 4194560 " +
+			"100 0 0 0 1 2 0 0 20 0 1 0 5678 10000 200 18446744073709551615")
+		c.So(err, ShouldBeNil)
+		c.So(startTime, ShouldEqual, 5678)
+		_, err = parseProcessStartTime("1234 (a) S 1")
+		c.So(err, ShouldNotBeNil)
+
+		c.So((&ScheduleRule{Action: scheduleActionWake, Cron: "0 7 * * *"}).validate(), ShouldBeNil)
+		c.So((&ScheduleRule{Action: scheduleActionWake, Cron: "0 7 * * *", Pid: 42}).validate(), ShouldNotBeNil)
+		c.So((&ScheduleRule{Action: "reboot", Cron: "0 7 * * *"}).validate(), ShouldNotBeNil)
+		c.So((&ScheduleRule{Action: scheduleActionShutdown}).validate(), ShouldNotBeNil)
+
+		rules := []*ScheduleRule{
+			{Action: scheduleActionWake, Cron: "0 7 * * *", Enabled: true},
+			{Action: scheduleActionWake, Time: now.Add(time.Hour).Unix(), Enabled: true},
+			{Action: scheduleActionWake, Time: now.Add(time.Minute).Unix()},
+			{Action: scheduleActionSuspend, Time: now.Add(time.Minute).Unix(), Enabled: true},
+		}
+		c.So(getNextWakeTime(rules, now), ShouldEqual, now.Add(time.Hour).Unix())
+		c.So(getNextWakeTime(rules[2:], now), ShouldEqual, 0)
+	})
+}
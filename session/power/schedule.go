@@ -0,0 +1,695 @@
+/*
+ * Copyright (C) 2014 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package power
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+	. "pkg.deepin.io/lib/gettext"
+	"pkg.deepin.io/lib/utils"
+)
+
+const submoduleScheduler = "Scheduler"
+
+func init() {
+	submoduleList = append(submoduleList, newScheduler)
+}
+
+const (
+	scheduleActionSuspend   = "suspend"
+	scheduleActionHibernate = "hibernate"
+	scheduleActionShutdown  = "shutdown"
+	scheduleActionWake      = "wake"
+
+	scheduleCheckInterval = 30 * time.Second
+	// 错过超过这个时间的任务不再执行，比如待机期间错过的任务
+	scheduleMissTolerance = 2 * time.Minute
+	// 执行前先发送通知，在这段时间内可以取消
+	scheduleNotifyDelay = time.Minute
+
+	bootIdFile = "/proc/sys/kernel/random/boot_id"
+)
+
+// ScheduleRule 是一个定时任务，Cron 不为空时按 cron 表达式重复执行，否则在 Time
+// 执行一次。Pid 不为 0 时，一次性任务还要等到这个进程退出才执行，比如下载完成后关机。
+type ScheduleRule struct {
+	Id      string
+	Action  string // suspend, hibernate, shutdown 或 wake
+	Cron    string // 分 时 日 月 周
+	Time    int64  // 一次性任务的 unix 时间
+	Pid     uint32
+	Enabled bool
+
+	// 添加任务时记录，重启后或 pid 被重用时不再等待原来的进程
+	BootId       string `json:",omitempty"`
+	PidStartTime uint64 `json:",omitempty"`
+}
+
+func (rule *ScheduleRule) validate() error {
+	switch rule.Action {
+	case scheduleActionSuspend, scheduleActionHibernate, scheduleActionShutdown:
+	case scheduleActionWake:
+		if rule.Pid != 0 {
+			return errors.New("wake rule can not wait for process")
+		}
+	default:
+		return fmt.Errorf("invalid action %q", rule.Action)
+	}
+
+	if rule.Cron != "" {
+		_, err := parseCron(rule.Cron)
+		return err
+	}
+	if rule.Time <= 0 && rule.Pid == 0 {
+		return errors.New("neither cron nor time is set")
+	}
+	if rule.Action == scheduleActionWake && rule.Time <= time.Now().Unix() {
+		return errors.New("wake time is not in the future")
+	}
+	return nil
+}
+
+// nextTime 返回 after 之后下次执行的时间
+func (rule *ScheduleRule) nextTime(after time.Time) (time.Time, bool) {
+	if rule.Cron != "" {
+		sched, err := parseCron(rule.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return sched.next(after)
+	}
+	t := time.Unix(rule.Time, 0)
+	if !t.After(after) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func getBootId() (string, error) {
+	content, err := ioutil.ReadFile(bootIdFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// parseProcessStartTime 从 /proc/<pid>/stat 的内容中取出进程的启动时间
+func parseProcessStartTime(stat string) (uint64, error) {
+	// 第 2 个字段是括号中的程序名，可能包含空格和括号
+	idx := strings.LastIndexByte(stat, ')')
+	if idx == -1 {
+		return 0, errors.New("invalid stat")
+	}
+	// starttime 是第 22 个字段，fields 从第 3 个字段开始
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 20 {
+		return 0, errors.New("invalid stat")
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// getProcessStartTime 返回进程的启动时间，单位是系统启动后的时钟滴答数
+func getProcessStartTime(pid uint32) (uint64, error) {
+	content, err := ioutil.ReadFile("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	return parseProcessStartTime(string(content))
+}
+
+// check 检查 last 到 now 之间任务是否到期，done 为 true 表示一次性任务已结束，
+// 应被删除。
+func (rule *ScheduleRule) check(last, now time.Time, bootId string,
+	processStartTime func(pid uint32) (uint64, error)) (fire, done bool) {
+	if !rule.Enabled {
+		return false, false
+	}
+
+	begin := now.Add(-scheduleMissTolerance)
+	if last.After(begin) {
+		begin = last
+	}
+
+	if rule.Cron != "" {
+		sched, err := parseCron(rule.Cron)
+		if err != nil {
+			return false, false
+		}
+		next, ok := sched.next(begin)
+		return ok && !next.After(now), false
+	}
+
+	t := time.Unix(rule.Time, 0)
+	if t.After(now) {
+		return false, false
+	}
+	if rule.Pid != 0 {
+		if rule.BootId != bootId {
+			// 等待的进程在重启前，已经不存在了，重启后不应执行
+			logger.Infof("schedule %s dropped, process %d is from the last boot", rule.Id, rule.Pid)
+			return false, true
+		}
+		startTime, err := processStartTime(rule.Pid)
+		if err == nil && startTime == rule.PidStartTime {
+			return false, false
+		}
+		// 进程已退出，pid 可能已被其他进程重用
+		return true, true
+	}
+	if !t.After(begin) {
+		logger.Infof("schedule %s missed at %v", rule.Id, t)
+		return false, true
+	}
+	return true, true
+}
+
+// cronSchedule 是解析后的 cron 表达式，每个字段是允许值的位图
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domAny bool
+	dowAny bool
+}
+
+// parseCronField 解析 cron 表达式的一个字段，支持 *、列表、范围和步长，如 1-5,10/2。
+func parseCronField(field string, min, max int) (uint64, error) {
+	var result uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart := part
+		step := 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:idx]
+		}
+
+		var begin, end int
+		if rangePart == "*" {
+			begin, end = min, max
+		} else if idx := strings.Index(rangePart, "-"); idx != -1 {
+			var err1, err2 error
+			begin, err1 = strconv.Atoi(rangePart[:idx])
+			end, err2 = strconv.Atoi(rangePart[idx+1:])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		} else {
+			var err error
+			begin, err = strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			end = begin
+			if step != 1 {
+				// 5/10 表示从 5 开始每隔 10
+				end = max
+			}
+		}
+
+		if begin < min || end > max || begin > end {
+			return 0, fmt.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+		for i := begin; i <= end; i += step {
+			result |= 1 << uint(i)
+		}
+	}
+	return result, nil
+}
+
+// parseCron 解析 "分 时 日 月 周" 格式的 cron 表达式，周的 0 和 7 都表示周日。
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q", expr)
+	}
+
+	var sched cronSchedule
+	var err error
+	sched.minute, err = parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, err
+	}
+	sched.hour, err = parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, err
+	}
+	sched.dom, err = parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, err
+	}
+	sched.month, err = parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, err
+	}
+	sched.dow, err = parseCronField(fields[4], 0, 7)
+	if err != nil {
+		return nil, err
+	}
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1
+	}
+	sched.domAny = fields[2] == "*"
+	sched.dowAny = fields[4] == "*"
+	return &sched, nil
+}
+
+func (sched *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := sched.dom&(1<<uint(t.Day())) != 0
+	dowMatch := sched.dow&(1<<uint(t.Weekday())) != 0
+	// 同 cron，日和周都有限制时满足其一即可
+	if !sched.domAny && !sched.dowAny {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// next 返回 after 之后第一个满足的时间，精确到分钟
+func (sched *cronSchedule) next(after time.Time) (time.Time, bool) {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// 最多找 5 年，比如 2 月 30 日永远不会满足
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if sched.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !sched.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if sched.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if sched.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+type pendingAction struct {
+	rule     ScheduleRule
+	notifyId uint32
+	timer    *time.Timer
+}
+
+type scheduler struct {
+	manager *Manager
+	exit    chan struct{}
+	bootId  string
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	pending   *pendingAction
+	// 已设置的 RTC 唤醒时间
+	wakeAlarm int64
+}
+
+func newScheduler(manager *Manager) (string, submodule, error) {
+	bootId, err := getBootId()
+	if err != nil {
+		return submoduleScheduler, nil, err
+	}
+	s := &scheduler{
+		manager: manager,
+		bootId:  bootId,
+	}
+	return submoduleScheduler, s, nil
+}
+
+func (s *scheduler) Start() error {
+	notifications := s.manager.helper.Notifications
+	_, err := notifications.ConnectActionInvoked(func(id uint32, actionKey string) {
+		if actionKey != "cancel" {
+			return
+		}
+		s.mu.Lock()
+		if s.pending != nil && s.pending.notifyId == id {
+			logger.Info("cancel scheduled action", s.pending.rule.Action)
+			s.pending.timer.Stop()
+			s.pending = nil
+		}
+		s.mu.Unlock()
+	})
+	if err != nil {
+		logger.Warning(err)
+	}
+
+	s.mu.Lock()
+	s.lastCheck = time.Now()
+	s.mu.Unlock()
+	go s.updateWakeAlarm()
+
+	s.exit = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(scheduleCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.check()
+			case <-s.exit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *scheduler) Destroy() {
+	if s.exit != nil {
+		close(s.exit)
+		s.exit = nil
+	}
+	s.mu.Lock()
+	if s.pending != nil {
+		s.pending.timer.Stop()
+		s.pending = nil
+	}
+	s.mu.Unlock()
+}
+
+func (s *scheduler) check() {
+	now := time.Now()
+	s.mu.Lock()
+	last := s.lastCheck
+	s.lastCheck = now
+	s.mu.Unlock()
+
+	var dueRules []ScheduleRule
+	err := updateConfig(func(cfg *config) bool {
+		changed := false
+		rules := cfg.Schedules[:0]
+		for _, rule := range cfg.Schedules {
+			fire, done := rule.check(last, now, s.bootId, getProcessStartTime)
+			if fire {
+				dueRules = append(dueRules, *rule)
+			}
+			if done {
+				changed = true
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		cfg.Schedules = rules
+		return changed
+	})
+	if err != nil {
+		logger.Warning(err)
+	}
+
+	for _, rule := range dueRules {
+		s.fire(rule)
+	}
+	s.updateWakeAlarm()
+}
+
+func getScheduleNotifyBody(action string) string {
+	seconds := int(scheduleNotifyDelay / time.Second)
+	switch action {
+	case scheduleActionSuspend:
+		return fmt.Sprintf(Tr("The computer will suspend in %d seconds"), seconds)
+	case scheduleActionHibernate:
+		return fmt.Sprintf(Tr("The computer will hibernate in %d seconds"), seconds)
+	default:
+		return fmt.Sprintf(Tr("The computer will shut down in %d seconds"), seconds)
+	}
+}
+
+func (s *scheduler) fire(rule ScheduleRule) {
+	m := s.manager
+	logger.Infof("schedule %s fired, action: %s", rule.Id, rule.Action)
+	if rule.Action == scheduleActionWake {
+		// 由 RTC 唤醒后点亮屏幕
+		err := m.helper.ScreenSaver.SimulateUserActivity(0)
+		if err != nil {
+			logger.Warning(err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		logger.Infof("ignore schedule %s, action %s is pending", rule.Id, s.pending.rule.Action)
+		return
+	}
+
+	actions := []string{"cancel", Tr("Cancel")}
+	nid, err := m.helper.Notifications.Notify(0, "dde-control-center", 0,
+		"preferences-system", Tr("Scheduled Power Action"), getScheduleNotifyBody(rule.Action),
+		actions, nil, int32(scheduleNotifyDelay/time.Millisecond))
+	if err != nil {
+		logger.Warning(err)
+	}
+
+	action := &pendingAction{
+		rule:     rule,
+		notifyId: nid,
+	}
+	action.timer = time.AfterFunc(scheduleNotifyDelay, func() {
+		s.mu.Lock()
+		if s.pending != action {
+			s.mu.Unlock()
+			return
+		}
+		s.pending = nil
+		s.mu.Unlock()
+		s.doAction(action.rule.Action)
+	})
+	s.pending = action
+}
+
+func (s *scheduler) doAction(action string) {
+	m := s.manager
+	var err error
+	switch action {
+	case scheduleActionSuspend:
+		m.doSuspend()
+	case scheduleActionHibernate:
+		logger.Debug("hibernate")
+		err = m.helper.SessionManager.RequestHibernate(0)
+	case scheduleActionShutdown:
+		logger.Debug("shutdown")
+		err = m.helper.SessionManager.RequestShutdown(0)
+	}
+	if err != nil {
+		logger.Warningf("failed to %s: %v", action, err)
+	}
+}
+
+// cancelPending 取消即将执行的任务，id 不为空时只在是这个任务时取消
+func (s *scheduler) cancelPending(id string) {
+	s.mu.Lock()
+	action := s.pending
+	if action == nil || (id != "" && action.rule.Id != id) {
+		s.mu.Unlock()
+		return
+	}
+	s.pending = nil
+	s.mu.Unlock()
+
+	action.timer.Stop()
+	err := s.manager.helper.Notifications.CloseNotification(0, action.notifyId)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+// getNextWakeTime 返回所有唤醒任务中最早的唤醒时间，没有则返回 0
+func getNextWakeTime(rules []*ScheduleRule, now time.Time) int64 {
+	var result int64
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Action != scheduleActionWake {
+			continue
+		}
+		t, ok := rule.nextTime(now)
+		if !ok {
+			continue
+		}
+		if result == 0 || t.Unix() < result {
+			result = t.Unix()
+		}
+	}
+	return result
+}
+
+// updateWakeAlarm 通过系统电源服务设置 RTC 唤醒时间，只在最早的唤醒时间变化时设置
+func (s *scheduler) updateWakeAlarm() {
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Warning(err)
+		return
+	}
+	wakeTime := getNextWakeTime(cfg.Schedules, time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if wakeTime == s.wakeAlarm {
+		return
+	}
+	logger.Info("set wake alarm", wakeTime)
+	err = s.manager.systemSigLoop.Conn().Object("com.deepin.system.Power", "/com/deepin/system/Power").
+		Call("com.deepin.system.Power.SetWakeAlarm", 0, wakeTime).Err
+	if err != nil {
+		logger.Warning("failed to set wake alarm:", err)
+		return
+	}
+	s.wakeAlarm = wakeTime
+}
+
+func (m *Manager) getScheduler() *scheduler {
+	s, _ := m.submodules[submoduleScheduler].(*scheduler)
+	return s
+}
+
+// GetSchedules 返回所有定时任务，JSON 格式的 ScheduleRule 数组
+func (m *Manager) GetSchedules() (string, *dbus.Error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	rules := cfg.Schedules
+	if rules == nil {
+		rules = []*ScheduleRule{}
+	}
+	content, err := json.Marshal(rules)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return string(content), nil
+}
+
+// AddSchedule 添加 JSON 格式的定时任务，Id 会被忽略，返回新任务的 Id
+func (m *Manager) AddSchedule(ruleJSON string) (string, *dbus.Error) {
+	var rule ScheduleRule
+	err := json.Unmarshal([]byte(ruleJSON), &rule)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	err = rule.validate()
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	rule.Id = utils.GenUuid()
+	rule.BootId = ""
+	rule.PidStartTime = 0
+	if rule.Pid != 0 {
+		rule.BootId, err = getBootId()
+		if err != nil {
+			return "", dbusutil.ToError(err)
+		}
+		rule.PidStartTime, err = getProcessStartTime(rule.Pid)
+		if err != nil {
+			return "", dbusutil.ToError(fmt.Errorf("process %d not found", rule.Pid))
+		}
+	}
+
+	err = updateConfig(func(cfg *config) bool {
+		cfg.Schedules = append(cfg.Schedules, &rule)
+		return true
+	})
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	if s := m.getScheduler(); s != nil {
+		go s.updateWakeAlarm()
+	}
+	return rule.Id, nil
+}
+
+func (m *Manager) RemoveSchedule(id string) *dbus.Error {
+	found := false
+	err := updateConfig(func(cfg *config) bool {
+		for idx, rule := range cfg.Schedules {
+			if rule.Id == id {
+				cfg.Schedules = append(cfg.Schedules[:idx], cfg.Schedules[idx+1:]...)
+				found = true
+				return true
+			}
+		}
+		return false
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("schedule %q not found", id)
+	}
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if s := m.getScheduler(); s != nil {
+		s.cancelPending(id)
+		go s.updateWakeAlarm()
+	}
+	return nil
+}
+
+func (m *Manager) SetScheduleEnabled(id string, enabled bool) *dbus.Error {
+	found := false
+	err := updateConfig(func(cfg *config) bool {
+		for _, rule := range cfg.Schedules {
+			if rule.Id == id {
+				rule.Enabled = enabled
+				found = true
+				return true
+			}
+		}
+		return false
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("schedule %q not found", id)
+	}
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if s := m.getScheduler(); s != nil {
+		if !enabled {
+			s.cancelPending(id)
+		}
+		go s.updateWakeAlarm()
+	}
+	return nil
+}
+
+// CancelScheduledAction 取消即将执行的定时任务，同通知中的取消按钮
+func (m *Manager) CancelScheduledAction() *dbus.Error {
+	if s := m.getScheduler(); s != nil {
+		s.cancelPending("")
+	}
+	return nil
+}
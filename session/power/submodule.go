@@ -53,7 +53,8 @@ func (m *Manager) _startSubmodule(name string) error {
 }
 
 func (m *Manager) startSubmodules() {
-	startOrder := []string{"PowerSavePlan", "LidSwitchHandler"}
+	startOrder := []string{"PowerSavePlan", "LidSwitchHandler", submoduleInhibitorMonitor,
+		submoduleScheduler}
 	for _, name := range startOrder {
 		logger.Infof("submodule %v start", name)
 		err := m._startSubmodule(name)
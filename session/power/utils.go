@@ -193,6 +193,7 @@ func (m *Manager) setDisplayBrightness(brightnessTable map[string]float64) {
 	display := m.helper.Display
 	for output, brightness := range brightnessTable {
 		logger.Infof("Change output %q brightness to %.2f", output, brightness)
+		m.brightnessLearner.markSetByPower(output, brightness)
 		err := display.SetBrightness(0, output, brightness)
 		if err != nil {
 			logger.Warningf("Change output %q brightness to %.2f failed: %v", output, brightness, err)
@@ -206,6 +207,7 @@ func (m *Manager) setAndSaveDisplayBrightness(brightnessTable map[string]float64
 	display := m.helper.Display
 	for output, brightness := range brightnessTable {
 		logger.Infof("Change output %q brightness to %.2f", output, brightness)
+		m.brightnessLearner.markSetByPower(output, brightness)
 		err := display.SetAndSaveBrightness(0, output, brightness)
 		if err != nil {
 			logger.Warningf("Change output %q brightness to %.2f failed: %v", output, brightness, err)
@@ -10,6 +10,9 @@ import (
 type Config struct {
 	VpnEnabled bool
 	Devices    map[string]*DeviceConfig
+	// ConnectivityCheckUrl is used to detect captive portals, the default
+	// one is used if it is empty.
+	ConnectivityCheckUrl string `json:",omitempty"`
 }
 
 type DeviceConfig struct {
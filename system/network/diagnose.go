@@ -0,0 +1,468 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+)
+
+const (
+	diagnoseStageCarrier       = "carrier"
+	diagnoseStageDhcp          = "dhcp"
+	diagnoseStageDefaultRoute  = "default-route"
+	diagnoseStageGateway       = "gateway"
+	diagnoseStageDns           = "dns"
+	diagnoseStageCaptivePortal = "captive-portal"
+)
+
+// the check page of NetworkManager, the content is "NetworkManager is online"
+const (
+	defaultConnectivityCheckUrl      = "http://nmcheck.gnome.org/check_network_status.txt"
+	defaultConnectivityCheckResponse = "NetworkManager is online"
+)
+
+const (
+	diagnoseDnsTimeout  = 5 * time.Second
+	diagnoseHttpTimeout = 10 * time.Second
+)
+
+type DiagnoseStage struct {
+	Name   string
+	Ok     bool
+	Detail string
+}
+
+// DiagnoseReport is the result of Diagnose, the stages after the failed
+// stage are not checked.
+type DiagnoseReport struct {
+	Interface   string
+	Gateway     string
+	FailedStage string
+	Stages      []DiagnoseStage
+}
+
+func (r *DiagnoseReport) addStage(name string, err error, detail string) bool {
+	stage := DiagnoseStage{Name: name, Ok: err == nil, Detail: detail}
+	if err != nil {
+		stage.Detail = err.Error()
+		r.FailedStage = name
+	}
+	r.Stages = append(r.Stages, stage)
+	return err == nil
+}
+
+// diagnoser checks the connectivity stage by stage. The files and hosts can
+// be replaced, so that it works with veth pairs in network namespaces.
+type diagnoser struct {
+	sysNetDir   string // /sys/class/net
+	routeFile   string // /proc/net/route
+	route6File  string // /proc/net/ipv6_route
+	checkUrl    string
+	checkResp   string
+	ping        func(host string) error
+	lookupHost  func(ctx context.Context, host string) ([]string, error)
+	getIfcAddrs func(ifc string) ([]net.Addr, error)
+}
+
+func newDiagnoser(checkUrl string) *diagnoser {
+	d := &diagnoser{
+		sysNetDir:  "/sys/class/net",
+		routeFile:  "/proc/net/route",
+		route6File: "/proc/net/ipv6_route",
+		checkUrl:   defaultConnectivityCheckUrl,
+		checkResp:  defaultConnectivityCheckResponse,
+		ping:       ping,
+		lookupHost: net.DefaultResolver.LookupHost,
+		getIfcAddrs: func(ifc string) ([]net.Addr, error) {
+			netIfc, err := net.InterfaceByName(ifc)
+			if err != nil {
+				return nil, err
+			}
+			return netIfc.Addrs()
+		},
+	}
+	if checkUrl != "" {
+		// the response of custom url is unknown, only check the status
+		d.checkUrl = checkUrl
+		d.checkResp = ""
+	}
+	return d
+}
+
+type defaultRoute struct {
+	ifc     string
+	gateway net.IP
+	metric  int
+}
+
+// getDefaultRoute returns the ipv4 default route with the lowest metric, or
+// the ipv6 one if there is no ipv4 default route, e.g. ipv6 only networks.
+func (d *diagnoser) getDefaultRoute() (*defaultRoute, error) {
+	route, err := d.getIpv4DefaultRoute()
+	if err != nil {
+		logger.Warning(err)
+	}
+	if route != nil {
+		return route, nil
+	}
+	route6, err6 := d.getIpv6DefaultRoute()
+	if err6 != nil {
+		logger.Warning(err6)
+	}
+	if route6 != nil {
+		return route6, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, err6
+}
+
+func (d *diagnoser) getIpv4DefaultRoute() (*defaultRoute, error) {
+	f, err := os.Open(d.routeFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	const rtfUp = 0x1
+	var result *defaultRoute
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // skip header
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&rtfUp == 0 {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil {
+			continue
+		}
+		if result != nil && result.metric <= metric {
+			continue
+		}
+		gateway := make(net.IP, net.IPv4len)
+		// in host byte order, which is little endian on supported architectures
+		binary.LittleEndian.PutUint32(gateway, uint32(gw))
+		result = &defaultRoute{ifc: fields[0], gateway: gateway, metric: metric}
+	}
+	return result, scanner.Err()
+}
+
+func (d *diagnoser) getIpv6DefaultRoute() (*defaultRoute, error) {
+	f, err := os.Open(d.route6File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	const (
+		rtfUp     = 0x1
+		rtfReject = 0x200
+	)
+	var result *defaultRoute
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Destination PrefixLen Source PrefixLen NextHop Metric RefCnt Use Flags Iface
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[1] != "00" || fields[9] == "lo" ||
+			strings.Trim(fields[0], "0") != "" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil || flags&rtfUp == 0 || flags&rtfReject != 0 {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[4])
+		if err != nil || len(gateway) != net.IPv6len {
+			continue
+		}
+		metric, err := strconv.ParseUint(fields[5], 16, 32)
+		if err != nil {
+			continue
+		}
+		if result != nil && result.metric <= int(metric) {
+			continue
+		}
+		result = &defaultRoute{ifc: fields[9], gateway: net.IP(gateway), metric: int(metric)}
+	}
+	return result, scanner.Err()
+}
+
+// getInterfaces returns the physical interfaces, loopback and virtual
+// interfaces are ignored.
+func (d *diagnoser) getInterfaces() (ifcs []string) {
+	fileInfos, err := ioutil.ReadDir(d.sysNetDir)
+	if err != nil {
+		logger.Warning(err)
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		name := fileInfo.Name()
+		if name == "lo" {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(d.sysNetDir, name, "device"))
+		if err == nil {
+			ifcs = append(ifcs, name)
+		}
+	}
+	return
+}
+
+func (d *diagnoser) hasCarrier(ifc string) bool {
+	content, err := ioutil.ReadFile(filepath.Join(d.sysNetDir, ifc, "carrier"))
+	if err != nil {
+		// reading carrier fails if the interface is down
+		return false
+	}
+	return strings.TrimSpace(string(content)) == "1"
+}
+
+// chooseInterface returns the interface of the default route, or the first
+// interface with carrier.
+func (d *diagnoser) chooseInterface(route *defaultRoute) string {
+	if route != nil {
+		return route.ifc
+	}
+	ifcs := d.getInterfaces()
+	for _, ifc := range ifcs {
+		if d.hasCarrier(ifc) {
+			return ifc
+		}
+	}
+	if len(ifcs) > 0 {
+		return ifcs[0]
+	}
+	return ""
+}
+
+func (d *diagnoser) checkCarrier(ifc string) error {
+	if ifc == "" {
+		return errors.New("no network interface")
+	}
+	if !d.hasCarrier(ifc) {
+		return fmt.Errorf("no carrier on %s", ifc)
+	}
+	return nil
+}
+
+// checkAddress checks whether the interface got an address, from dhcp or
+// configured manually. The ipv4 link-local address is assigned when dhcp
+// fails, and the gateway of the default route must be in the subnet of an
+// address.
+func (d *diagnoser) checkAddress(ifc string, route *defaultRoute) (string, error) {
+	addrs, err := d.getIfcAddrs(ifc)
+	if err != nil {
+		return "", err
+	}
+	var globalAddrs, linkLocalAddrs []string
+	var ipNets []*net.IPNet
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		switch {
+		case ipNet.IP.IsGlobalUnicast():
+			globalAddrs = append(globalAddrs, ipNet.String())
+			ipNets = append(ipNets, ipNet)
+		case ipNet.IP.IsLinkLocalUnicast() && ipNet.IP.To4() != nil:
+			linkLocalAddrs = append(linkLocalAddrs, ipNet.String())
+		}
+	}
+	if len(globalAddrs) == 0 {
+		if len(linkLocalAddrs) > 0 {
+			return "", fmt.Errorf("dhcp failed on %s, only link-local address %s", ifc,
+				strings.Join(linkLocalAddrs, ", "))
+		}
+		return "", fmt.Errorf("no ip address on %s", ifc)
+	}
+	detail := strings.Join(globalAddrs, ", ")
+
+	// the ipv6 gateway is usually a link-local address
+	if route != nil && route.ifc == ifc && route.gateway.To4() != nil &&
+		!route.gateway.Equal(net.IPv4zero) {
+		for _, ipNet := range ipNets {
+			if ipNet.Contains(route.gateway) {
+				return detail, nil
+			}
+		}
+		return "", fmt.Errorf("gateway %s is not in the subnet of %s", route.gateway, detail)
+	}
+	return detail, nil
+}
+
+func (d *diagnoser) getCheckHost() (string, error) {
+	u, err := url.Parse(d.checkUrl)
+	if err != nil {
+		return "", err
+	}
+	return u.Hostname(), nil
+}
+
+func (d *diagnoser) checkDns() (string, error) {
+	host, err := d.getCheckHost()
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseDnsTimeout)
+	defer cancel()
+	addrs, err := d.lookupHost(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s: %s", host, strings.Join(addrs, ", ")), nil
+}
+
+// probeCaptivePortal requests the check url, it is redirected or the
+// response is changed by captive portals. loginUrl is the page to login if
+// portal is true.
+func (d *diagnoser) probeCaptivePortal() (portal bool, loginUrl string, err error) {
+	client := &http.Client{
+		Timeout: diagnoseHttpTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(d.checkUrl)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		loginUrl = resp.Header.Get("Location")
+		if loginUrl == "" {
+			loginUrl = d.checkUrl
+		}
+		return true, loginUrl, nil
+	}
+	if resp.StatusCode >= 400 {
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+		return
+	}
+	if d.checkResp == "" {
+		return false, "", nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return
+	}
+	if !strings.HasPrefix(string(body), d.checkResp) {
+		// the portal page is returned directly, open the check url to get it
+		return true, d.checkUrl, nil
+	}
+	return false, "", nil
+}
+
+func (d *diagnoser) checkCaptivePortal() error {
+	portal, loginUrl, err := d.probeCaptivePortal()
+	if err != nil {
+		return err
+	}
+	if portal {
+		return fmt.Errorf("login to the captive portal at %s", loginUrl)
+	}
+	return nil
+}
+
+func (d *diagnoser) diagnose() *DiagnoseReport {
+	report := &DiagnoseReport{}
+	route, err := d.getDefaultRoute()
+	if err != nil {
+		logger.Warning(err)
+	}
+	ifc := d.chooseInterface(route)
+	report.Interface = ifc
+
+	if !report.addStage(diagnoseStageCarrier, d.checkCarrier(ifc), "") {
+		return report
+	}
+	addr, err := d.checkAddress(ifc, route)
+	if !report.addStage(diagnoseStageDhcp, err, addr) {
+		return report
+	}
+	if route == nil {
+		report.addStage(diagnoseStageDefaultRoute, errors.New("no default route"), "")
+		return report
+	}
+	report.Gateway = route.gateway.String()
+	report.addStage(diagnoseStageDefaultRoute, nil, fmt.Sprintf("via %s dev %s",
+		report.Gateway, route.ifc))
+
+	// routes of point-to-point links have no gateway, and ping only supports
+	// ipv4
+	if route.gateway.To4() != nil && !route.gateway.Equal(net.IPv4zero) {
+		if !report.addStage(diagnoseStageGateway, d.ping(report.Gateway), "") {
+			return report
+		}
+	}
+	detail, err := d.checkDns()
+	if !report.addStage(diagnoseStageDns, err, detail) {
+		return report
+	}
+	report.addStage(diagnoseStageCaptivePortal, d.checkCaptivePortal(), d.checkUrl)
+	return report
+}
+
+func (n *Network) newDiagnoser() *diagnoser {
+	n.configMu.Lock()
+	checkUrl := n.config.ConnectivityCheckUrl
+	n.configMu.Unlock()
+	return newDiagnoser(checkUrl)
+}
+
+// CheckCaptivePortal requests ConnectivityCheckUrl of the config, portal is
+// true if it is intercepted by a captive portal, and loginUrl is the page to
+// login. It is a blocked operation.
+func (n *Network) CheckCaptivePortal() (portal bool, loginUrl string, busErr *dbus.Error) {
+	portal, loginUrl, err := n.newDiagnoser().probeCaptivePortal()
+	if err != nil {
+		return false, "", dbusutil.ToError(err)
+	}
+	return portal, loginUrl, nil
+}
+
+// Diagnose checks the connectivity stage by stage: carrier of the link, ip
+// address, default route, ping the gateway, dns resolution and captive
+// portal, the report in JSON tells which stage failed. It is a blocked
+// operation.
+func (n *Network) Diagnose() (string, *dbus.Error) {
+	report := n.newDiagnoser().diagnose()
+	logger.Debugf("diagnose report: %+v", report)
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", dbusutil.ToError(err)
+	}
+	return string(data), nil
+}
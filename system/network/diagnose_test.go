@@ -0,0 +1,272 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRouteHeader = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+
+// ens33 via 192.168.1.1 metric 100, wlan0 via 10.0.0.1 metric 600
+const testRouteFile = testRouteHeader +
+	"wlan0\t00000000\t0100000A\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+	"ens33\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+	"ens33\t0001A8C0\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n"
+
+// ens33 via fe80::1 metric 1024, the reject route of lo is ignored
+const testRoute6File = "" +
+	"00000000000000000000000000000000 00 00000000000000000000000000000000 00 " +
+	"00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo\n" +
+	"20010db8000000000000000000000000 40 00000000000000000000000000000000 00 " +
+	"00000000000000000000000000000000 00000100 00000001 00000000 00000001    ens33\n" +
+	"00000000000000000000000000000000 00 00000000000000000000000000000000 00 " +
+	"fe800000000000000000000000000001 00000400 00000001 00000000 00000003    ens33\n"
+
+type testIfc struct {
+	name    string
+	device  bool
+	carrier string // empty if the interface is down
+	addrs   []string
+}
+
+type testEnv struct {
+	dir    string
+	server *httptest.Server
+	d      *diagnoser
+}
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	ip, ipNet, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+	ipNet.IP = ip
+	return ipNet
+}
+
+// newTestEnv creates a fake /sys/class/net tree and route files, the check
+// url is served by handler.
+func newTestEnv(t *testing.T, ifcs []testIfc, route, route6 string,
+	handler http.HandlerFunc) *testEnv {
+	dir, err := ioutil.TempDir("", "diagnose")
+	require.NoError(t, err)
+
+	sysNetDir := filepath.Join(dir, "class/net")
+	addrs := make(map[string][]net.Addr)
+	for _, ifc := range ifcs {
+		ifcDir := filepath.Join(sysNetDir, ifc.name)
+		require.NoError(t, os.MkdirAll(ifcDir, 0755))
+		if ifc.device {
+			require.NoError(t, os.Mkdir(filepath.Join(ifcDir, "device"), 0755))
+		}
+		if ifc.carrier != "" {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(ifcDir, "carrier"),
+				[]byte(ifc.carrier+"\n"), 0644))
+		}
+		for _, addr := range ifc.addrs {
+			addrs[ifc.name] = append(addrs[ifc.name], mustParseCIDR(t, addr))
+		}
+	}
+
+	routeFile := filepath.Join(dir, "route")
+	require.NoError(t, ioutil.WriteFile(routeFile, []byte(route), 0644))
+	route6File := filepath.Join(dir, "ipv6_route")
+	require.NoError(t, ioutil.WriteFile(route6File, []byte(route6), 0644))
+
+	server := httptest.NewServer(handler)
+	serverUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	d := newDiagnoser("")
+	d.sysNetDir = sysNetDir
+	d.routeFile = routeFile
+	d.route6File = route6File
+	// the host is not an ip, so that it is looked up in the dns stage
+	d.checkUrl = "http://localhost:" + serverUrl.Port() + "/check_network_status.txt"
+	d.ping = func(host string) error {
+		return nil
+	}
+	d.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+	d.getIfcAddrs = func(ifc string) ([]net.Addr, error) {
+		return addrs[ifc], nil
+	}
+	return &testEnv{dir: dir, server: server, d: d}
+}
+
+func (env *testEnv) close() {
+	env.server.Close()
+	os.RemoveAll(env.dir)
+}
+
+func onlineHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, defaultConnectivityCheckResponse)
+}
+
+func portalHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "http://portal.example.com/login", http.StatusFound)
+}
+
+func portalPageHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "<html>login</html>")
+}
+
+func TestGetDefaultRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		route6  string
+		ifc     string
+		gateway string
+	}{
+		{"lowest metric", testRouteFile, testRoute6File, "ens33", "192.168.1.1"},
+		{"ipv6 only", testRouteHeader, testRoute6File, "ens33", "fe80::1"},
+		{"no default route", testRouteHeader, "", "", ""},
+	}
+	for _, test := range tests {
+		env := newTestEnv(t, nil, test.route, test.route6, onlineHandler)
+		route, err := env.d.getDefaultRoute()
+		assert.NoError(t, err, test.name)
+		if test.ifc == "" {
+			assert.Nil(t, route, test.name)
+		} else if assert.NotNil(t, route, test.name) {
+			assert.Equal(t, test.ifc, route.ifc, test.name)
+			assert.Equal(t, test.gateway, route.gateway.String(), test.name)
+		}
+		env.close()
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	route := &defaultRoute{ifc: "ens33", gateway: net.ParseIP("192.168.1.1").To4()}
+	route6 := &defaultRoute{ifc: "ens33", gateway: net.ParseIP("fe80::1")}
+	tests := []struct {
+		name   string
+		addrs  []string
+		route  *defaultRoute
+		detail string
+		err    string
+	}{
+		{"ipv4", []string{"192.168.1.10/24", "fe80::2/64"}, route, "192.168.1.10/24", ""},
+		{"ipv4 and ipv6", []string{"192.168.1.10/24", "2001:db8::2/64"}, route,
+			"192.168.1.10/24, 2001:db8::2/64", ""},
+		{"ipv6 only", []string{"2001:db8::2/64", "fe80::2/64"}, route6, "2001:db8::2/64", ""},
+		{"no route", []string{"192.168.1.10/24"}, nil, "192.168.1.10/24", ""},
+		{"no address", []string{"fe80::2/64"}, route, "", "no ip address on ens33"},
+		{"link-local", []string{"169.254.3.4/16"}, nil, "",
+			"dhcp failed on ens33, only link-local address 169.254.3.4/16"},
+		{"wrong subnet", []string{"10.1.1.10/24"}, route, "",
+			"gateway 192.168.1.1 is not in the subnet of 10.1.1.10/24"},
+	}
+	for _, test := range tests {
+		env := newTestEnv(t, []testIfc{{"ens33", true, "1", test.addrs}}, testRouteHeader, "",
+			onlineHandler)
+		detail, err := env.d.checkAddress("ens33", test.route)
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+		assert.Equal(t, test.detail, detail, test.name)
+		env.close()
+	}
+}
+
+func TestProbeCaptivePortal(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		portal   bool
+		loginUrl string
+	}{
+		{"online", onlineHandler, false, ""},
+		{"redirect", portalHandler, true, "http://portal.example.com/login"},
+		{"portal page", portalPageHandler, true, "/check_network_status.txt"},
+	}
+	for _, test := range tests {
+		env := newTestEnv(t, nil, testRouteHeader, "", test.handler)
+		portal, loginUrl, err := env.d.probeCaptivePortal()
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.portal, portal, test.name)
+		if test.loginUrl != "" {
+			assert.Contains(t, loginUrl, test.loginUrl, test.name)
+		} else {
+			assert.Empty(t, loginUrl, test.name)
+		}
+		env.close()
+	}
+
+	env := newTestEnv(t, nil, testRouteHeader, "", http.NotFound)
+	_, _, err := env.d.probeCaptivePortal()
+	assert.Error(t, err)
+	env.close()
+}
+
+func TestDiagnose(t *testing.T) {
+	ens33 := testIfc{"ens33", true, "1", []string{"192.168.1.10/24"}}
+	tests := []struct {
+		name        string
+		ifcs        []testIfc
+		route       string
+		route6      string
+		handler     http.HandlerFunc
+		ping        error
+		lookup      error
+		ifc         string
+		failedStage string
+		stages      int
+	}{
+		{"online", []testIfc{ens33}, testRouteFile, "", onlineHandler, nil, nil,
+			"ens33", "", 6},
+		{"no interface", []testIfc{{"virbr0", false, "1", nil}}, testRouteHeader, "",
+			onlineHandler, nil, nil, "", diagnoseStageCarrier, 1},
+		{"cable unplugged", []testIfc{{"ens33", true, "0", nil}, {"wlan0", true, "", nil}},
+			testRouteHeader, "", onlineHandler, nil, nil, "ens33", diagnoseStageCarrier, 1},
+		{"choose interface with carrier", []testIfc{{"ens33", true, "0", nil},
+			{"wlan0", true, "1", nil}}, testRouteHeader, "", onlineHandler, nil, nil,
+			"wlan0", diagnoseStageDhcp, 2},
+		{"dhcp failed", []testIfc{{"ens33", true, "1", []string{"169.254.3.4/16"}}},
+			testRouteHeader, "", onlineHandler, nil, nil, "ens33", diagnoseStageDhcp, 2},
+		{"no default route", []testIfc{ens33}, testRouteHeader, "", onlineHandler, nil, nil,
+			"ens33", diagnoseStageDefaultRoute, 3},
+		{"gateway unreachable", []testIfc{ens33}, testRouteFile, "", onlineHandler,
+			errors.New("timeout"), nil, "ens33", diagnoseStageGateway, 4},
+		{"dns failed", []testIfc{ens33}, testRouteFile, "", onlineHandler, nil,
+			errors.New("no such host"), "ens33", diagnoseStageDns, 5},
+		{"captive portal", []testIfc{ens33}, testRouteFile, "", portalHandler, nil, nil,
+			"ens33", diagnoseStageCaptivePortal, 6},
+		// the gateway stage is skipped for ipv6 routes
+		{"ipv6 only", []testIfc{{"ens33", true, "1", []string{"2001:db8::2/64"}}},
+			testRouteHeader, testRoute6File, onlineHandler, errors.New("timeout"), nil,
+			"ens33", "", 5},
+	}
+	for _, test := range tests {
+		env := newTestEnv(t, test.ifcs, test.route, test.route6, test.handler)
+		pingErr, lookupErr := test.ping, test.lookup
+		env.d.ping = func(host string) error {
+			return pingErr
+		}
+		env.d.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+			if lookupErr != nil {
+				return nil, lookupErr
+			}
+			return []string{"127.0.0.1"}, nil
+		}
+
+		report := env.d.diagnose()
+		assert.Equal(t, test.ifc, report.Interface, test.name)
+		assert.Equal(t, test.failedStage, report.FailedStage, test.name)
+		assert.Len(t, report.Stages, test.stages, test.name)
+		env.close()
+	}
+}
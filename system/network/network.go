@@ -110,6 +110,8 @@ type Network struct {
 	nmSettings     *networkmanager.Settings
 	sigLoop        *dbusutil.SignalLoop
 	methods        *struct {
+		CheckCaptivePortal    func() `out:"portal,loginUrl"`
+		Diagnose              func() `out:"report"`
 		IsDeviceEnabled       func() `in:"pathOrIface" out:"enabled"`
 		EnableDevice          func() `in:"pathOrIface,enabled"`
 		Ping                  func() `in:"host"`
@@ -245,26 +245,30 @@ func handleICMPReply(icmp *ICMP) error {
 
 // Ping ping remote host, blocked operation.
 func (n *Network) Ping(host string) *dbus.Error {
-	conn, err := newICMPConn(host)
+	err := ping(host)
 	if err != nil {
 		return dbusutil.ToError(err)
 	}
+	return nil
+}
+
+func ping(host string) error {
+	conn, err := newICMPConn(host)
+	if err != nil {
+		return err
+	}
 	defer conn.Close()
 
 	err = sendEchoRequest(conn)
 	if err != nil {
-		return dbusutil.ToError(err)
+		return err
 	}
 
 	icmp, err := recvEchoReply(conn)
 	if err != nil {
-		return dbusutil.ToError(err)
+		return err
 	}
 
 	logger.Debugf("Reply: %#v", icmp)
-	err = handleICMPReply(icmp)
-	if err != nil {
-		return dbusutil.ToError(err)
-	}
-	return nil
+	return handleICMPReply(icmp)
 }
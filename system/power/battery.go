@@ -58,17 +58,29 @@ type Battery struct {
 	Voltage     float64
 	Percentage  float64
 	Capacity    float64
+	CycleCount  uint32
 	Status      battery.Status
 	TimeToEmpty uint64
 	TimeToFull  uint64
 	UpdateTime  int64
 
+	ChargeThresholdSupported bool
+	// in percentage, 0 if only the end threshold is supported
+	ChargeStartThreshold uint32
+	ChargeEndThreshold   uint32
+	// thresholds are ignored until the battery is full
+	FullChargeOnce bool
+
 	timeToFullHistory []uint64
+	history           *batteryHistory
 
-	refreshDone func()
+	refreshDone             func()
+	chargeThresholdsChanged func(name string, thresholds *ChargeThresholds)
 
 	methods *struct {
-		Debug func() `in:"cmd"`
+		Debug               func() `in:"cmd"`
+		SetChargeThresholds func() `in:"start,end"`
+		SetFullChargeOnce   func() `in:"enabled"`
 	}
 }
 
@@ -88,7 +100,11 @@ func newBattery(manager *Manager, device *gudev.Device) *Battery {
 		service:     manager.service,
 		gudevClient: manager.gudevClient,
 		SysfsPath:   sysfsPath,
+
+		chargeThresholdsChanged: manager.saveChargeThresholds,
 	}
+	bat.history = newBatteryHistory(bat.getConfigName())
+	bat.initChargeThresholds(manager.getChargeThresholds(bat.getConfigName()))
 	ok := bat.refresh(device)
 	if !ok {
 		return nil
@@ -184,6 +200,7 @@ func (bat *Battery) refresh(dev *gudev.Device) (ok bool) {
 			logger.Warning(err)
 		}
 	}
+	bat.finishFullChargeOnce(batInfo.Status)
 	ok = true
 	return
 }
@@ -199,7 +216,10 @@ func (bat *Battery) _refresh(info *battery.BatteryInfo, setTimeToFull bool) {
 		now := time.Now()
 		updateTime = now.Unix()
 		logger.Debugf("now %v updateTime %v", now, updateTime)
+		bat.recordHistory(info)
 	}
+	// not all batteries report it
+	cycleCount, _ := readSysfsUint(bat.SysfsPath, "cycle_count")
 
 	logger.Debug("Name", info.Name)
 	logger.Debug("Technology", info.Technology)
@@ -235,6 +255,7 @@ func (bat *Battery) _refresh(info *battery.BatteryInfo, setTimeToFull bool) {
 	bat.setPropVoltage(info.Voltage)
 	bat.setPropPercentage(info.Percentage)
 	bat.setPropCapacity(info.Capacity)
+	bat.setPropCycleCount(cycleCount)
 	bat.setPropStatus(info.Status)
 	bat.setPropTimeToEmpty(info.TimeToEmpty)
 	if setTimeToFull {
@@ -294,4 +315,5 @@ func (bat *Battery) destroy() {
 		close(bat.exit)
 		bat.exit = nil
 	}
+	bat.history.save()
 }
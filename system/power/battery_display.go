@@ -40,7 +40,9 @@ func (m *Manager) refreshBatteryDisplay() {
 	if batteryCount == 0 {
 		m.resetBatteryDisplay()
 		return
-	} else if batteryCount == 1 {
+	}
+	sum := m.sumBatteryEnergy()
+	if batteryCount == 1 {
 		var bat0 *Battery
 		for _, bat := range m.batteries {
 			bat0 = bat
@@ -55,28 +57,18 @@ func (m *Manager) refreshBatteryDisplay() {
 		timeToFull = bat0.TimeToFull
 		bat0.PropsMu.RUnlock()
 	} else {
-		var energyTotal, energyFullTotal, energyRateTotal float64
-		statusSlice := make([]battery.Status, 0, batteryCount)
-		for _, bat := range m.batteries {
-			bat.PropsMu.RLock()
-			energyTotal += bat.Energy
-			energyFullTotal += bat.EnergyFull
-			energyRateTotal += bat.EnergyRate
-			statusSlice = append(statusSlice, bat.Status)
-			bat.PropsMu.RUnlock()
-		}
-		logger.Debugf("energyTotal: %v", energyTotal)
-		logger.Debugf("energyFullTotal: %v", energyFullTotal)
-		logger.Debugf("energyRateTotal: %v", energyRateTotal)
+		logger.Debugf("energyTotal: %v", sum.energy)
+		logger.Debugf("energyFullTotal: %v", sum.energyFull)
+		logger.Debugf("energyRateTotal: %v", sum.energyRate)
 
-		percentage = rightPercentage(energyTotal / energyFullTotal * 100.0)
-		status = battery.GetDisplayStatus(statusSlice)
+		percentage = rightPercentage(sum.energy / sum.energyFull * 100.0)
+		status = battery.GetDisplayStatus(sum.statuses)
 
-		if energyRateTotal > 0 {
+		if sum.energyRate > 0 {
 			if status == battery.StatusDischarging {
-				timeToEmpty = uint64(3600 * (energyTotal / energyRateTotal))
+				timeToEmpty = uint64(3600 * (sum.energy / sum.energyRate))
 			} else if status == battery.StatusCharging {
-				timeToFull = uint64(3600 * ((energyFullTotal - energyTotal) / energyRateTotal))
+				timeToFull = uint64(3600 * ((sum.energyFull - sum.energy) / sum.energyRate))
 			}
 		}
 
@@ -99,6 +91,8 @@ func (m *Manager) refreshBatteryDisplay() {
 	m.setPropBatteryTimeToFull(timeToFull)
 	m.PropsMu.Unlock()
 
+	m.recordDisplayHistory(percentage, status, &sum)
+
 	logger.Debugf("percentage: %.1f%%", percentage)
 	logger.Debug("status:", status, uint32(status))
 	logger.Debugf("timeToEmpty %v (%vs), timeToFull %v (%vs)",
@@ -108,6 +102,29 @@ func (m *Manager) refreshBatteryDisplay() {
 		timeToFull)
 }
 
+// batteryEnergySum is the sum of the energy properties of all batteries.
+type batteryEnergySum struct {
+	energy           float64
+	energyFull       float64
+	energyFullDesign float64
+	energyRate       float64
+	statuses         []battery.Status
+}
+
+func (m *Manager) sumBatteryEnergy() (sum batteryEnergySum) {
+	sum.statuses = make([]battery.Status, 0, len(m.batteries))
+	for _, bat := range m.batteries {
+		bat.PropsMu.RLock()
+		sum.energy += bat.Energy
+		sum.energyFull += bat.EnergyFull
+		sum.energyFullDesign += bat.EnergyFullDesign
+		sum.energyRate += bat.EnergyRate
+		sum.statuses = append(sum.statuses, bat.Status)
+		bat.PropsMu.RUnlock()
+	}
+	return
+}
+
 func (m *Manager) resetBatteryDisplay() {
 	m.PropsMu.Lock()
 	m.setPropHasBattery(false)
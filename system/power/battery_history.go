@@ -0,0 +1,281 @@
+/*
+ * Copyright (C) 2016 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package power
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pkg.deepin.io/dde/api/powersupply/battery"
+)
+
+const (
+	historyKindPercentage = "percentage"
+	historyKindEnergy     = "energy"
+	historyKindRate       = "rate"
+	historyKindCapacity   = "capacity"
+
+	// name of the history of the battery display
+	historyNameDisplay = "display"
+
+	historyMaxAge = 7 * 24 * time.Hour
+	// the capacity changes slowly, its samples are kept longer for the wear
+	// statistics, and downsampled to one per historyDownsampleInterval after
+	// historyMaxAge
+	historyCapacityMaxAge     = 366 * 24 * time.Hour
+	historyDownsampleInterval = 24 * time.Hour
+	// a sample is recorded at least every historyMinInterval even if the
+	// value is not changed
+	historyMinInterval  = 10 * time.Minute
+	historySaveInterval = 10 * time.Minute
+)
+
+var historyDir = "/var/lib/dde-daemon/power/history"
+
+var historyKinds = []string{
+	historyKindPercentage,
+	historyKindEnergy,
+	historyKindRate,
+	historyKindCapacity,
+}
+
+type HistoryItem struct {
+	Time   int64 // unix time
+	Value  float64
+	Status uint32
+}
+
+// batteryHistory records the samples of the battery, the samples older than
+// historyMaxAge are dropped, except the capacity samples, see rotateHistory.
+type batteryHistory struct {
+	name    string
+	mu      sync.Mutex
+	items   map[string][]HistoryItem
+	dirty   bool
+	savedAt time.Time
+}
+
+func isHistoryKindValid(kind string) bool {
+	for _, k := range historyKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func getHistoryFile(name, kind string) string {
+	return filepath.Join(historyDir, fmt.Sprintf("%s-%s.dat", name, kind))
+}
+
+// parseHistory parses the content of history file, every line is
+// "time\tvalue\tstatus".
+func parseHistory(content []byte) []HistoryItem {
+	var result []HistoryItem
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		t, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		status, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		result = append(result, HistoryItem{Time: t, Value: value, Status: uint32(status)})
+	}
+	return result
+}
+
+func formatHistory(items []HistoryItem) []byte {
+	var buf bytes.Buffer
+	for _, item := range items {
+		fmt.Fprintf(&buf, "%d\t%.3f\t%d\n", item.Time, item.Value, item.Status)
+	}
+	return buf.Bytes()
+}
+
+func newBatteryHistory(name string) *batteryHistory {
+	h := &batteryHistory{
+		name:    name,
+		items:   make(map[string][]HistoryItem),
+		savedAt: time.Now(),
+	}
+	for _, kind := range historyKinds {
+		content, err := ioutil.ReadFile(getHistoryFile(name, kind))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.Warning(err)
+			}
+			continue
+		}
+		h.items[kind] = parseHistory(content)
+	}
+	return h
+}
+
+func (h *batteryHistory) add(kind string, value float64, status battery.Status, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	items := h.items[kind]
+	if len(items) > 0 {
+		last := items[len(items)-1]
+		if math.Abs(last.Value-value) < 0.01 && last.Status == uint32(status) &&
+			now.Unix()-last.Time < int64(historyMinInterval/time.Second) {
+			return
+		}
+	}
+	items = append(items, HistoryItem{
+		Time:   now.Unix(),
+		Value:  value,
+		Status: uint32(status),
+	})
+
+	h.items[kind] = rotateHistory(kind, items, now)
+	h.dirty = true
+
+	if now.Sub(h.savedAt) >= historySaveInterval {
+		h.saveNoLock(now)
+	}
+}
+
+// rotateHistory drops the samples older than historyMaxAge. The capacity
+// samples are kept for historyCapacityMaxAge, and only the first sample of
+// every historyDownsampleInterval is kept after historyMaxAge.
+func rotateHistory(kind string, items []HistoryItem, now time.Time) []HistoryItem {
+	maxAge := historyMaxAge
+	if kind == historyKindCapacity {
+		maxAge = historyCapacityMaxAge
+	}
+	oldest := now.Add(-maxAge).Unix()
+	idx := 0
+	for idx < len(items) && items[idx].Time < oldest {
+		idx++
+	}
+	items = items[idx:]
+	if kind != historyKindCapacity {
+		return items
+	}
+
+	recent := now.Add(-historyMaxAge).Unix()
+	interval := int64(historyDownsampleInterval / time.Second)
+	result := items[:0]
+	for _, item := range items {
+		if item.Time < recent && len(result) > 0 &&
+			result[len(result)-1].Time/interval == item.Time/interval {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// get returns the samples in the last span.
+func (h *batteryHistory) get(kind string, span time.Duration, now time.Time) []HistoryItem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	since := now.Add(-span).Unix()
+	var result []HistoryItem
+	for _, item := range h.items[kind] {
+		if item.Time >= since {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getFirst returns the oldest sample.
+func (h *batteryHistory) getFirst(kind string) (HistoryItem, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	items := h.items[kind]
+	if len(items) == 0 {
+		return HistoryItem{}, false
+	}
+	return items[0], true
+}
+
+func (h *batteryHistory) save() {
+	h.mu.Lock()
+	h.saveNoLock(time.Now())
+	h.mu.Unlock()
+}
+
+func (h *batteryHistory) saveNoLock(now time.Time) {
+	if !h.dirty {
+		return
+	}
+	h.savedAt = now
+	err := os.MkdirAll(historyDir, 0755)
+	if err != nil {
+		logger.Warning(err)
+		return
+	}
+	for kind, items := range h.items {
+		err = ioutil.WriteFile(getHistoryFile(h.name, kind), formatHistory(items), 0644)
+		if err != nil {
+			logger.Warning(err)
+			return
+		}
+	}
+	h.dirty = false
+}
+
+func (bat *Battery) recordHistory(info *battery.BatteryInfo) {
+	now := time.Now()
+	bat.history.add(historyKindPercentage, info.Percentage, info.Status, now)
+	bat.history.add(historyKindEnergy, info.Energy, info.Status, now)
+	bat.history.add(historyKindRate, info.EnergyRate, info.Status, now)
+	bat.history.add(historyKindCapacity, info.Capacity, info.Status, now)
+}
+
+// recordDisplayHistory records the history of all batteries as one, like the
+// battery display properties.
+func (m *Manager) recordDisplayHistory(percentage float64, status battery.Status,
+	sum *batteryEnergySum) {
+	now := time.Now()
+	m.displayHistory.add(historyKindPercentage, percentage, status, now)
+	m.displayHistory.add(historyKindEnergy, sum.energy, status, now)
+	m.displayHistory.add(historyKindRate, sum.energyRate, status, now)
+	if sum.energyFullDesign > 0 {
+		m.displayHistory.add(historyKindCapacity,
+			rightPercentage(sum.energyFull/sum.energyFullDesign*100.0), status, now)
+	}
+}
@@ -20,9 +20,14 @@
 package power
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"pkg.deepin.io/dde/api/powersupply/battery"
 )
 
 func Test_checkTimeStabilized(t *testing.T) {
@@ -51,3 +56,154 @@ func Test_checkTimeStabilized(t *testing.T) {
 		})
 	})
 }
+
+func newFakeBatterySysfs(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "power-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_chargeThresholds(t *testing.T) {
+	Convey("chargeThresholds", t, func(c C) {
+		c.Convey("not supported", func() {
+			dir := newFakeBatterySysfs(t, map[string]string{"capacity": "80\n"})
+			defer os.RemoveAll(dir)
+			c.So(isChargeThresholdSupported(dir), ShouldBeFalse)
+		})
+
+		c.Convey("start and end", func() {
+			dir := newFakeBatterySysfs(t, map[string]string{
+				chargeStartThresholdFile: "0\n",
+				chargeEndThresholdFile:   "100\n",
+			})
+			defer os.RemoveAll(dir)
+			c.So(isChargeThresholdSupported(dir), ShouldBeTrue)
+
+			c.So(checkChargeThresholds(dir, 40, 80), ShouldBeNil)
+			c.So(checkChargeThresholds(dir, 80, 80), ShouldNotBeNil)
+			c.So(checkChargeThresholds(dir, 0, 101), ShouldNotBeNil)
+			c.So(checkChargeThresholds(dir, 0, 0), ShouldNotBeNil)
+
+			c.So(writeChargeThresholds(dir, 40, 80), ShouldBeNil)
+			thresholds, err := readChargeThresholds(dir)
+			c.So(err, ShouldBeNil)
+			c.So(*thresholds, ShouldResemble, ChargeThresholds{Start: 40, End: 80})
+
+			// the start threshold is raised above the current end threshold
+			c.So(writeChargeThresholds(dir, 85, 95), ShouldBeNil)
+			thresholds, err = readChargeThresholds(dir)
+			c.So(err, ShouldBeNil)
+			c.So(*thresholds, ShouldResemble, ChargeThresholds{Start: 85, End: 95})
+		})
+
+		c.Convey("end only", func() {
+			dir := newFakeBatterySysfs(t, map[string]string{
+				chargeEndThresholdFile: "100\n",
+			})
+			defer os.RemoveAll(dir)
+			c.So(checkChargeThresholds(dir, 40, 80), ShouldNotBeNil)
+			c.So(checkChargeThresholds(dir, 0, 80), ShouldBeNil)
+
+			c.So(writeChargeThresholds(dir, 0, 80), ShouldBeNil)
+			thresholds, err := readChargeThresholds(dir)
+			c.So(err, ShouldBeNil)
+			c.So(*thresholds, ShouldResemble, ChargeThresholds{End: 80})
+		})
+
+		c.Convey("restore full charge once", func() {
+			dir := newFakeBatterySysfs(t, map[string]string{
+				chargeStartThresholdFile: "40\n",
+				chargeEndThresholdFile:   "80\n",
+			})
+			defer os.RemoveAll(dir)
+
+			bat := &Battery{SysfsPath: dir}
+			bat.initChargeThresholds(&ChargeThresholds{Start: 40, End: 80, FullChargeOnce: true})
+			c.So(bat.FullChargeOnce, ShouldBeTrue)
+			c.So(bat.ChargeStartThreshold, ShouldEqual, 40)
+			c.So(bat.ChargeEndThreshold, ShouldEqual, 80)
+			thresholds, err := readChargeThresholds(dir)
+			c.So(err, ShouldBeNil)
+			c.So(*thresholds, ShouldResemble, ChargeThresholds{Start: 0, End: 100})
+		})
+	})
+}
+
+func Test_batteryHistory(t *testing.T) {
+	Convey("batteryHistory", t, func(c C) {
+		dir, err := ioutil.TempDir("", "power-history")
+		c.So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		oldHistoryDir := historyDir
+		historyDir = dir
+		defer func() {
+			historyDir = oldHistoryDir
+		}()
+
+		now := time.Unix(1500000000, 0)
+		h := newBatteryHistory("BAT0")
+		h.add(historyKindPercentage, 80, battery.StatusDischarging, now.Add(-8*24*time.Hour))
+		h.add(historyKindPercentage, 60, battery.StatusDischarging, now.Add(-2*time.Hour))
+		// not changed
+		h.add(historyKindPercentage, 60, battery.StatusDischarging, now.Add(-2*time.Hour+time.Minute))
+		h.add(historyKindPercentage, 50, battery.StatusDischarging, now.Add(-time.Hour))
+		h.add(historyKindPercentage, 50, battery.StatusDischarging, now)
+
+		// the sample older than 7 days is dropped
+		c.So(h.get(historyKindPercentage, 30*24*time.Hour, now), ShouldResemble, []HistoryItem{
+			{Time: now.Add(-2 * time.Hour).Unix(), Value: 60, Status: uint32(battery.StatusDischarging)},
+			{Time: now.Add(-time.Hour).Unix(), Value: 50, Status: uint32(battery.StatusDischarging)},
+			{Time: now.Unix(), Value: 50, Status: uint32(battery.StatusDischarging)},
+		})
+		c.So(h.get(historyKindPercentage, 90*time.Minute, now), ShouldHaveLength, 2)
+
+		h.save()
+		h2 := newBatteryHistory("BAT0")
+		c.So(h2.get(historyKindPercentage, 30*24*time.Hour, now), ShouldResemble,
+			h.get(historyKindPercentage, 30*24*time.Hour, now))
+		first, ok := h2.getFirst(historyKindPercentage)
+		c.So(ok, ShouldBeTrue)
+		c.So(first.Value, ShouldEqual, 60)
+	})
+}
+
+func Test_rotateHistory(t *testing.T) {
+	Convey("rotateHistory", t, func(c C) {
+		now := time.Unix(1500000000, 0)
+		day := int64(24 * time.Hour / time.Second)
+		// a sample every 12 hours in the last 400 days
+		var items []HistoryItem
+		for ts := now.Unix() - 400*day; ts <= now.Unix(); ts += day / 2 {
+			items = append(items, HistoryItem{Time: ts, Value: 90})
+		}
+
+		percentages := rotateHistory(historyKindPercentage, append([]HistoryItem(nil), items...), now)
+		c.So(percentages[0].Time, ShouldBeGreaterThanOrEqualTo, now.Unix()-7*day)
+		c.So(percentages, ShouldHaveLength, 15)
+
+		capacities := rotateHistory(historyKindCapacity, items, now)
+		c.So(capacities[0].Time, ShouldBeGreaterThanOrEqualTo, now.Unix()-366*day)
+		c.So(capacities[len(capacities)-1].Time, ShouldEqual, now.Unix())
+		// one per day before the last 7 days
+		recent := 0
+		for i, item := range capacities {
+			if item.Time >= now.Unix()-7*day {
+				recent++
+				continue
+			}
+			if i > 0 {
+				c.So(item.Time/day, ShouldNotEqual, capacities[i-1].Time/day)
+			}
+		}
+		c.So(recent, ShouldEqual, 15)
+		c.So(len(capacities)-recent, ShouldBeBetweenOrEqual, 358, 360)
+	})
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (C) 2016 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package power
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"pkg.deepin.io/dde/api/powersupply/battery"
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+)
+
+// https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-class-power
+const (
+	chargeStartThresholdFile = "charge_control_start_threshold"
+	chargeEndThresholdFile   = "charge_control_end_threshold"
+
+	chargeThresholdsActionId = "com.deepin.daemon.power.set-charge-thresholds"
+)
+
+type ChargeThresholds struct {
+	Start uint32
+	End   uint32
+	// saved in config so that a full charge is not abandoned after reboot
+	FullChargeOnce bool `json:",omitempty"`
+}
+
+func isSysfsFileExists(sysfsPath, name string) bool {
+	_, err := os.Stat(filepath.Join(sysfsPath, name))
+	return err == nil
+}
+
+func isChargeThresholdSupported(sysfsPath string) bool {
+	return isSysfsFileExists(sysfsPath, chargeEndThresholdFile)
+}
+
+func readSysfsUint(sysfsPath, name string) (uint32, error) {
+	content, err := ioutil.ReadFile(filepath.Join(sysfsPath, name))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 32)
+	return uint32(v), err
+}
+
+func writeSysfsUint(sysfsPath, name string, value uint32) error {
+	return ioutil.WriteFile(filepath.Join(sysfsPath, name),
+		[]byte(strconv.FormatUint(uint64(value), 10)), 0644)
+}
+
+// readChargeThresholds reads the thresholds of the battery, start is 0 if the
+// battery only supports the end threshold.
+func readChargeThresholds(sysfsPath string) (*ChargeThresholds, error) {
+	var result ChargeThresholds
+	var err error
+	if isSysfsFileExists(sysfsPath, chargeStartThresholdFile) {
+		result.Start, err = readSysfsUint(sysfsPath, chargeStartThresholdFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.End, err = readSysfsUint(sysfsPath, chargeEndThresholdFile)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func checkChargeThresholds(sysfsPath string, start, end uint32) error {
+	if end == 0 || end > 100 {
+		return fmt.Errorf("invalid end threshold %d", end)
+	}
+	if start >= end {
+		return fmt.Errorf("start threshold %d is not less than end threshold %d", start, end)
+	}
+	if start != 0 && !isSysfsFileExists(sysfsPath, chargeStartThresholdFile) {
+		return errors.New("start threshold is not supported")
+	}
+	return nil
+}
+
+// writeChargeThresholds writes the thresholds to sysfs. Some drivers refuse
+// the start threshold not less than the end threshold, so the order of writing
+// depends on the current end threshold.
+func writeChargeThresholds(sysfsPath string, start, end uint32) error {
+	hasStart := isSysfsFileExists(sysfsPath, chargeStartThresholdFile)
+	curEnd, err := readSysfsUint(sysfsPath, chargeEndThresholdFile)
+	if err != nil {
+		return err
+	}
+
+	if hasStart && start < curEnd {
+		err = writeSysfsUint(sysfsPath, chargeStartThresholdFile, start)
+		if err != nil {
+			return err
+		}
+		hasStart = false
+	}
+	err = writeSysfsUint(sysfsPath, chargeEndThresholdFile, end)
+	if err != nil {
+		return err
+	}
+	if hasStart {
+		err = writeSysfsUint(sysfsPath, chargeStartThresholdFile, start)
+	}
+	return err
+}
+
+func (bat *Battery) getConfigName() string {
+	return filepath.Base(bat.SysfsPath)
+}
+
+// initChargeThresholds restores the thresholds saved in config, which may be
+// reset by firmware after reboot.
+func (bat *Battery) initChargeThresholds(saved *ChargeThresholds) {
+	if !isChargeThresholdSupported(bat.SysfsPath) {
+		return
+	}
+	bat.ChargeThresholdSupported = true
+	if saved != nil && saved.FullChargeOnce {
+		err := writeChargeThresholds(bat.SysfsPath, 0, 100)
+		if err != nil {
+			logger.Warning("failed to restore full charge once:", err)
+		} else {
+			bat.ChargeStartThreshold = saved.Start
+			bat.ChargeEndThreshold = saved.End
+			bat.FullChargeOnce = true
+			return
+		}
+	}
+	if saved != nil {
+		err := writeChargeThresholds(bat.SysfsPath, saved.Start, saved.End)
+		if err != nil {
+			logger.Warning("failed to restore charge thresholds:", err)
+		}
+	}
+	thresholds, err := readChargeThresholds(bat.SysfsPath)
+	if err != nil {
+		logger.Warning(err)
+		return
+	}
+	bat.ChargeStartThreshold = thresholds.Start
+	bat.ChargeEndThreshold = thresholds.End
+}
+
+func (bat *Battery) SetChargeThresholds(sender dbus.Sender, start, end uint32) *dbus.Error {
+	err := checkAuthorization(chargeThresholdsActionId, string(sender))
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = bat.setChargeThresholds(start, end)
+	return dbusutil.ToError(err)
+}
+
+func (bat *Battery) setChargeThresholds(start, end uint32) error {
+	if !bat.ChargeThresholdSupported {
+		return errors.New("charge thresholds are not supported")
+	}
+	err := checkChargeThresholds(bat.SysfsPath, start, end)
+	if err != nil {
+		return err
+	}
+
+	bat.mutex.Lock()
+	defer bat.mutex.Unlock()
+
+	bat.PropsMu.RLock()
+	fullChargeOnce := bat.FullChargeOnce
+	bat.PropsMu.RUnlock()
+	// applied after the battery is fully charged
+	if !fullChargeOnce {
+		err = writeChargeThresholds(bat.SysfsPath, start, end)
+		if err != nil {
+			return err
+		}
+	}
+
+	bat.PropsMu.Lock()
+	bat.setPropChargeStartThreshold(start)
+	bat.setPropChargeEndThreshold(end)
+	bat.PropsMu.Unlock()
+
+	bat.notifyChargeThresholdsChanged(start, end, fullChargeOnce)
+	return nil
+}
+
+func (bat *Battery) notifyChargeThresholdsChanged(start, end uint32, fullChargeOnce bool) {
+	if bat.chargeThresholdsChanged != nil {
+		bat.chargeThresholdsChanged(bat.getConfigName(), &ChargeThresholds{
+			Start:          start,
+			End:            end,
+			FullChargeOnce: fullChargeOnce,
+		})
+	}
+}
+
+// SetFullChargeOnce charges the battery to 100% ignoring the thresholds, the
+// thresholds are restored once the battery is full.
+func (bat *Battery) SetFullChargeOnce(sender dbus.Sender, enabled bool) *dbus.Error {
+	err := checkAuthorization(chargeThresholdsActionId, string(sender))
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = bat.setFullChargeOnce(enabled)
+	return dbusutil.ToError(err)
+}
+
+func (bat *Battery) setFullChargeOnce(enabled bool) error {
+	if !bat.ChargeThresholdSupported {
+		return errors.New("charge thresholds are not supported")
+	}
+
+	bat.mutex.Lock()
+	defer bat.mutex.Unlock()
+
+	bat.PropsMu.RLock()
+	start := bat.ChargeStartThreshold
+	end := bat.ChargeEndThreshold
+	bat.PropsMu.RUnlock()
+
+	var err error
+	if enabled {
+		err = writeChargeThresholds(bat.SysfsPath, 0, 100)
+	} else {
+		err = writeChargeThresholds(bat.SysfsPath, start, end)
+	}
+	if err != nil {
+		return err
+	}
+
+	bat.PropsMu.Lock()
+	bat.setPropFullChargeOnce(enabled)
+	bat.PropsMu.Unlock()
+
+	bat.notifyChargeThresholdsChanged(start, end, enabled)
+	return nil
+}
+
+// finishFullChargeOnce restores the thresholds once the battery is full.
+func (bat *Battery) finishFullChargeOnce(status battery.Status) {
+	bat.PropsMu.RLock()
+	fullChargeOnce := bat.FullChargeOnce
+	bat.PropsMu.RUnlock()
+	if !fullChargeOnce || status != battery.StatusFull {
+		return
+	}
+	logger.Info("battery is fully charged, restore charge thresholds")
+	err := bat.setFullChargeOnce(false)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
@@ -54,6 +54,12 @@ type Manager struct {
 	ac          *AC
 	gudevClient *gudev.Client
 
+	// saved charge thresholds, key is the name of battery
+	chargeThresholdsMu sync.Mutex
+	chargeThresholds   map[string]ChargeThresholds
+
+	displayHistory *batteryHistory
+
 	PropsMu      sync.RWMutex
 	OnBattery    bool
 	HasLidSwitch bool
@@ -68,8 +74,11 @@ type Manager struct {
 	PowerSavingModeAuto    bool `prop:"access:rw"`
 
 	methods *struct {
-		GetBatteries func() `out:"batteries"`
-		Debug        func() `in:"cmd"`
+		GetBatteries  func() `out:"batteries"`
+		GetHistory    func() `in:"batteryPath,kind,span" out:"history"`
+		GetStatistics func() `in:"batteryPath" out:"statistics"`
+		SetWakeAlarm  func() `in:"timestamp"`
+		Debug         func() `in:"cmd"`
 	}
 
 	signals *struct {
@@ -170,6 +179,15 @@ func (m *Manager) init() error {
 		return errors.New("gudevClient is nil")
 	}
 
+	// the charge thresholds are restored when batteries are added
+	cfg := loadConfigSafe()
+	m.chargeThresholds = cfg.ChargeThresholds
+	if m.chargeThresholds == nil {
+		m.chargeThresholds = make(map[string]ChargeThresholds)
+	}
+
+	m.displayHistory = newBatteryHistory(historyNameDisplay)
+
 	m.initLidSwitch()
 	devices := powersupply.GetDevices(m.gudevClient)
 	m.initAC(devices)
@@ -180,7 +198,6 @@ func (m *Manager) init() error {
 
 	m.gudevClient.Connect("uevent", m.handleUEvent)
 
-	cfg := loadConfigSafe()
 	m.PowerSavingModeEnabled = cfg.PowerSavingModeEnabled
 	m.PowerSavingModeAuto = cfg.PowerSavingModeAuto
 
@@ -344,6 +361,10 @@ func (m *Manager) destroy() {
 	m.batteries = nil
 	m.batteriesMu.Unlock()
 
+	if m.displayHistory != nil {
+		m.displayHistory.save()
+	}
+
 	if m.gudevClient != nil {
 		m.gudevClient.Unref()
 		m.gudevClient = nil
@@ -355,6 +376,7 @@ const configFile = "/var/lib/dde-daemon/power/config.json"
 type Config struct {
 	PowerSavingModeEnabled bool
 	PowerSavingModeAuto    bool
+	ChargeThresholds       map[string]ChargeThresholds `json:",omitempty"`
 }
 
 func loadConfig() (*Config, error) {
@@ -395,6 +417,12 @@ func (m *Manager) saveConfig() error {
 	cfg.PowerSavingModeAuto = m.PowerSavingModeAuto
 	cfg.PowerSavingModeEnabled = m.PowerSavingModeEnabled
 	m.PropsMu.RUnlock()
+	m.chargeThresholdsMu.Lock()
+	cfg.ChargeThresholds = make(map[string]ChargeThresholds, len(m.chargeThresholds))
+	for name, thresholds := range m.chargeThresholds {
+		cfg.ChargeThresholds[name] = thresholds
+	}
+	m.chargeThresholdsMu.Unlock()
 
 	dir := filepath.Dir(configFile)
 	err := os.MkdirAll(dir, 0755)
@@ -408,3 +436,24 @@ func (m *Manager) saveConfig() error {
 	}
 	return ioutil.WriteFile(configFile, content, 0644)
 }
+
+func (m *Manager) getChargeThresholds(name string) *ChargeThresholds {
+	m.chargeThresholdsMu.Lock()
+	defer m.chargeThresholdsMu.Unlock()
+	thresholds, ok := m.chargeThresholds[name]
+	if !ok {
+		return nil
+	}
+	return &thresholds
+}
+
+func (m *Manager) saveChargeThresholds(name string, thresholds *ChargeThresholds) {
+	m.chargeThresholdsMu.Lock()
+	m.chargeThresholds[name] = *thresholds
+	m.chargeThresholdsMu.Unlock()
+
+	err := m.saveConfig()
+	if err != nil {
+		logger.Warning(err)
+	}
+}
@@ -20,7 +20,11 @@
 package power
 
 import (
+	"fmt"
+	"time"
+
 	"pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
 )
 
 const (
@@ -47,6 +51,71 @@ func (m *Manager) GetBatteries() ([]dbus.ObjectPath, *dbus.Error) {
 	return result, nil
 }
 
+func (m *Manager) getBatteryByPath(path dbus.ObjectPath) *Battery {
+	m.batteriesMu.Lock()
+	defer m.batteriesMu.Unlock()
+	for _, bat := range m.batteries {
+		if bat.getObjPath() == path {
+			return bat
+		}
+	}
+	return nil
+}
+
+// GetHistory returns the samples of kind in the last span seconds, kind is
+// one of "percentage", "energy", "rate" and "capacity". The history of the
+// battery display is returned if batteryPath is the path of Manager.
+func (m *Manager) GetHistory(batteryPath dbus.ObjectPath, kind string, span uint32) ([]HistoryItem, *dbus.Error) {
+	if !isHistoryKindValid(kind) {
+		return nil, dbusutil.ToError(fmt.Errorf("invalid history kind %q", kind))
+	}
+	history := m.displayHistory
+	if batteryPath != dbusPath {
+		bat := m.getBatteryByPath(batteryPath)
+		if bat == nil {
+			return nil, dbusutil.ToError(fmt.Errorf("battery %q not found", batteryPath))
+		}
+		history = bat.history
+	}
+	result := history.get(kind, time.Duration(span)*time.Second, time.Now())
+	if result == nil {
+		result = []HistoryItem{}
+	}
+	return result, nil
+}
+
+// BatteryStatistics is the wear statistics of the battery.
+type BatteryStatistics struct {
+	EnergyFull       float64
+	EnergyFullDesign float64
+	Capacity         float64 // EnergyFull / EnergyFullDesign in percentage
+	CycleCount       uint32
+	// the oldest capacity recorded in history and the time of it
+	HistoryCapacity     float64
+	HistoryCapacityTime int64
+}
+
+func (m *Manager) GetStatistics(batteryPath dbus.ObjectPath) (BatteryStatistics, *dbus.Error) {
+	var stats BatteryStatistics
+	bat := m.getBatteryByPath(batteryPath)
+	if bat == nil {
+		return stats, dbusutil.ToError(fmt.Errorf("battery %q not found", batteryPath))
+	}
+
+	bat.PropsMu.RLock()
+	stats.EnergyFull = bat.EnergyFull
+	stats.EnergyFullDesign = bat.EnergyFullDesign
+	stats.Capacity = bat.Capacity
+	stats.CycleCount = bat.CycleCount
+	bat.PropsMu.RUnlock()
+
+	if item, ok := bat.history.getFirst(historyKindCapacity); ok {
+		stats.HistoryCapacity = item.Value
+		stats.HistoryCapacityTime = item.Time
+	}
+	return stats, nil
+}
+
 func (m *Manager) refreshBatteries() {
 	logger.Debug("RefreshBatteries")
 	m.batteriesMu.Lock()
@@ -305,6 +305,19 @@ func (v *Battery) emitPropChangedCapacity(value float64) error {
 	return v.service.EmitPropertyChanged(v, "Capacity", value)
 }
 
+func (v *Battery) setPropCycleCount(value uint32) (changed bool) {
+	if v.CycleCount != value {
+		v.CycleCount = value
+		v.emitPropChangedCycleCount(value)
+		return true
+	}
+	return false
+}
+
+func (v *Battery) emitPropChangedCycleCount(value uint32) error {
+	return v.service.EmitPropertyChanged(v, "CycleCount", value)
+}
+
 func (v *Battery) setPropStatus(value battery.Status) (changed bool) {
 	if v.Status != value {
 		v.Status = value
@@ -356,3 +369,55 @@ func (v *Battery) setPropUpdateTime(value int64) (changed bool) {
 func (v *Battery) emitPropChangedUpdateTime(value int64) error {
 	return v.service.EmitPropertyChanged(v, "UpdateTime", value)
 }
+
+func (v *Battery) setPropChargeThresholdSupported(value bool) (changed bool) {
+	if v.ChargeThresholdSupported != value {
+		v.ChargeThresholdSupported = value
+		v.emitPropChangedChargeThresholdSupported(value)
+		return true
+	}
+	return false
+}
+
+func (v *Battery) emitPropChangedChargeThresholdSupported(value bool) error {
+	return v.service.EmitPropertyChanged(v, "ChargeThresholdSupported", value)
+}
+
+func (v *Battery) setPropChargeStartThreshold(value uint32) (changed bool) {
+	if v.ChargeStartThreshold != value {
+		v.ChargeStartThreshold = value
+		v.emitPropChangedChargeStartThreshold(value)
+		return true
+	}
+	return false
+}
+
+func (v *Battery) emitPropChangedChargeStartThreshold(value uint32) error {
+	return v.service.EmitPropertyChanged(v, "ChargeStartThreshold", value)
+}
+
+func (v *Battery) setPropChargeEndThreshold(value uint32) (changed bool) {
+	if v.ChargeEndThreshold != value {
+		v.ChargeEndThreshold = value
+		v.emitPropChangedChargeEndThreshold(value)
+		return true
+	}
+	return false
+}
+
+func (v *Battery) emitPropChangedChargeEndThreshold(value uint32) error {
+	return v.service.EmitPropertyChanged(v, "ChargeEndThreshold", value)
+}
+
+func (v *Battery) setPropFullChargeOnce(value bool) (changed bool) {
+	if v.FullChargeOnce != value {
+		v.FullChargeOnce = value
+		v.emitPropChangedFullChargeOnce(value)
+		return true
+	}
+	return false
+}
+
+func (v *Battery) emitPropChangedFullChargeOnce(value bool) error {
+	return v.service.EmitPropertyChanged(v, "FullChargeOnce", value)
+}
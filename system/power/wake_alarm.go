@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2016 ~ 2018 Deepin Technology Co., Ltd.
+ *
+ * Author:     jouyouyun <jouyouwen717@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package power
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	dbus "pkg.deepin.io/lib/dbus1"
+	"pkg.deepin.io/lib/dbusutil"
+)
+
+const (
+	wakeAlarmActionId = "com.deepin.daemon.power.set-wake-alarm"
+	wakeAlarmFile     = "/sys/class/rtc/rtc0/wakealarm"
+)
+
+// writeWakeAlarm sets the RTC wake alarm, timestamp 0 clears it. The alarm
+// must be cleared before being changed.
+func writeWakeAlarm(file string, timestamp int64) error {
+	err := ioutil.WriteFile(file, []byte("0"), 0644)
+	if err != nil {
+		return err
+	}
+	if timestamp == 0 {
+		return nil
+	}
+	return ioutil.WriteFile(file, []byte(strconv.FormatInt(timestamp, 10)), 0644)
+}
+
+// SetWakeAlarm sets the unix time to wake up the computer from suspend, 0 to
+// cancel it.
+func (m *Manager) SetWakeAlarm(sender dbus.Sender, timestamp int64) *dbus.Error {
+	err := checkAuthorization(wakeAlarmActionId, string(sender))
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	if timestamp < 0 || (timestamp != 0 && timestamp <= time.Now().Unix()) {
+		return dbusutil.ToError(errors.New("invalid wake alarm time"))
+	}
+
+	logger.Info("set wake alarm", timestamp)
+	err = writeWakeAlarm(wakeAlarmFile, timestamp)
+	return dbusutil.ToError(err)
+}